@@ -0,0 +1,247 @@
+package assertion
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Signature algorithms supported for signing assertions
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Authentication method references (RFC 8176) of the factors a user authenticated with
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	//Not registered by RFC 8176
+	AMRKerberos = "kerberos"
+	//The first factor was verified by the calling application rather than the MFA server
+	AMRDelegated = "delegated"
+)
+
+const minRSAKeyBits = 2048
+
+// Key is a private key used to sign assertions.
+type Key struct {
+	ID      string
+	Alg     string
+	Private crypto.Signer
+}
+
+// Claims of an assertion that a user has completed two factor authentication.
+type Claims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience string   `json:"aud"`
+	Domain   string   `json:"domain"`
+	AuthTime int64    `json:"auth_time"`
+	IssuedAt int64    `json:"iat"`
+	Expires  int64    `json:"exp"`
+	AMR      []string `json:"amr"`
+	ID       string   `json:"jti"`
+	Nonce    string   `json:"nonce,omitempty"`
+}
+
+// NewClaims creates the claims for a user authenticated now, with the authentication methods given, that expire after
+// the lifetime. The subject is qualified with the domain as the same username can exist in more than one domain.
+func NewClaims(iss, aud, d, u string, amr []string, lifetime time.Duration) Claims {
+	now := time.Now().UTC()
+	b := make([]byte, 16)
+	rand.Read(b)
+	return Claims{
+		Issuer:   iss,
+		Subject:  u + "@" + d,
+		Audience: aud,
+		Domain:   d,
+		AuthTime: now.Unix(),
+		IssuedAt: now.Unix(),
+		Expires:  now.Add(lifetime).Unix(),
+		AMR:      amr,
+		ID:       base64.RawURLEncoding.EncodeToString(b),
+	}
+}
+
+// NewKey determines the signing algorithm for the private key and identifies it by its JWK thumbprint (RFC 7638).
+func NewKey(p crypto.Signer) (*Key, error) {
+	k := &Key{Private: p}
+	switch v := p.(type) {
+	case *rsa.PrivateKey:
+		if v.N.BitLen() < minRSAKeyBits {
+			return nil, errors.New(fmt.Sprintf("RSA keys must be at least %d bits", minRSAKeyBits))
+		}
+		k.Alg = AlgRS256
+	case *ecdsa.PrivateKey:
+		if v.Curve != elliptic.P256() {
+			return nil, errors.New("Only P-256 ECDSA keys are supported")
+		}
+		k.Alg = AlgES256
+	case ed25519.PrivateKey:
+		k.Alg = AlgEdDSA
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported private key type %T", p))
+	}
+	tp, err := thumbprint(k.JWK())
+	if err != nil {
+		return nil, err
+	}
+	k.ID = tp
+	return k, nil
+}
+
+// LoadKey reads a PEM encoded RSA, ECDSA P-256 or Ed25519 private key from the file.
+func LoadKey(path string) (*Key, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("Could not read signing key file " + path + ": " + err.Error())
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil, errors.New("No PEM encoded key found in " + path)
+	}
+	var p interface{}
+	switch blk.Type {
+	case "RSA PRIVATE KEY":
+		p, err = x509.ParsePKCS1PrivateKey(blk.Bytes)
+	case "EC PRIVATE KEY":
+		p, err = x509.ParseECPrivateKey(blk.Bytes)
+	default:
+		p, err = x509.ParsePKCS8PrivateKey(blk.Bytes)
+	}
+	if err != nil {
+		return nil, errors.New("Could not parse signing key in " + path + ": " + err.Error())
+	}
+	s, ok := p.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("Key in " + path + " cannot be used for signing")
+	}
+	return NewKey(s)
+}
+
+// Sign encodes the claims as a JSON Web Token (RFC 7519) signed with the key.
+func Sign(k *Key, claims interface{}) (string, error) {
+	h, err := json.Marshal(map[string]string{"alg": k.Alg, "kid": k.ID, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.New("Could not encode assertion claims: " + err.Error())
+	}
+	in := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig, err := k.sign([]byte(in))
+	if err != nil {
+		return "", errors.New("Could not sign assertion: " + err.Error())
+	}
+	return in + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func (k *Key) sign(in []byte) ([]byte, error) {
+	switch k.Alg {
+	case AlgEdDSA:
+		return k.Private.Sign(rand.Reader, in, crypto.Hash(0))
+	case AlgES256:
+		d := sha256.Sum256(in)
+		r, s, err := ecdsa.Sign(rand.Reader, k.Private.(*ecdsa.PrivateKey), d[:])
+		if err != nil {
+			return nil, err
+		}
+		//JWS uses the fixed length concatenation of r and s rather than ASN.1
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	default:
+		d := sha256.Sum256(in)
+		return k.Private.Sign(rand.Reader, d[:], crypto.SHA256)
+	}
+}
+
+// Verify checks the token was signed by one of the public keys of the set and decodes its claims into v.
+// The expiry of the token is checked if it has an "exp" claim.
+func Verify(token string, keys JSONWebKeySet, v interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("Assertion is not in the JWS compact format")
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return errors.New("Assertion header could not be decoded: " + err.Error())
+	}
+	var h struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(hb, &h); err != nil {
+		return errors.New("Assertion header could not be decoded: " + err.Error())
+	}
+	jwk, ok := keys.Key(h.Kid)
+	if !ok {
+		return errors.New("Assertion is signed with an unknown key " + h.Kid)
+	}
+	if jwk.Alg != h.Alg {
+		return errors.New("Assertion algorithm does not match the key")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.New("Assertion signature could not be decoded: " + err.Error())
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return err
+	}
+	if !verifySignature(h.Alg, pub, []byte(parts[0]+"."+parts[1]), sig) {
+		return errors.New("Assertion signature is not valid")
+	}
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errors.New("Assertion claims could not be decoded: " + err.Error())
+	}
+	var exp struct {
+		Expires *int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(cb, &exp); err != nil {
+		return errors.New("Assertion claims could not be decoded: " + err.Error())
+	}
+	if exp.Expires != nil && time.Now().UTC().Unix() >= *exp.Expires {
+		return errors.New("Assertion has expired")
+	}
+	return json.Unmarshal(cb, v)
+}
+
+func verifySignature(alg string, pub crypto.PublicKey, in, sig []byte) bool {
+	switch alg {
+	case AlgRS256:
+		p, ok := pub.(*rsa.PublicKey)
+		d := sha256.Sum256(in)
+		return ok && rsa.VerifyPKCS1v15(p, crypto.SHA256, d[:], sig) == nil
+	case AlgES256:
+		p, ok := pub.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		d := sha256.Sum256(in)
+		return ecdsa.Verify(p, d[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case AlgEdDSA:
+		p, ok := pub.(ed25519.PublicKey)
+		return ok && ed25519.Verify(p, in, sig)
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package assertion
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKeys(t *testing.T) []*Key {
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edk, _ := ed25519.GenerateKey(rand.Reader)
+	var keys []*Key
+	for _, p := range []crypto.Signer{rk, ek, edk} {
+		k, err := NewKey(p)
+		if err != nil {
+			t.Fatalf("Error creating key: %v", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestSignVerify(t *testing.T) {
+	keys := testKeys(t)
+	jwks := NewJSONWebKeySet(keys)
+	assert.Equal(t, AlgRS256, keys[0].Alg)
+	assert.Equal(t, AlgES256, keys[1].Alg)
+	assert.Equal(t, AlgEdDSA, keys[2].Alg)
+
+	for _, k := range keys {
+		claims := NewClaims("mfaserver", "testapp", "testdom", "validuser", []string{AMRPassword, AMROTP}, time.Minute)
+		tok, err := Sign(k, claims)
+		if err != nil {
+			t.Fatalf("Error signing assertion with %s: %v", k.Alg, err)
+		}
+		var vc Claims
+		err = Verify(tok, jwks, &vc)
+		assert.NoError(t, err, "Assertion signed with %s should verify", k.Alg)
+		assert.Equal(t, claims, vc, "Verified claims not as signed with %s", k.Alg)
+		assert.Equal(t, []string{"pwd", "otp"}, vc.AMR, "Authentication methods not as expected")
+		assert.Equal(t, "validuser@testdom", vc.Subject, "Subject should be qualified with the domain")
+
+		//Tamper with the claims keeping the original signature
+		p := strings.Split(tok, ".")
+		claims.Subject = "validadmin"
+		tt, _ := Sign(k, claims)
+		err = Verify(strings.Split(tt, ".")[0]+"."+strings.Split(tt, ".")[1]+"."+p[2], jwks, &vc)
+		assert.Error(t, err, "Tampered assertion signed with %s should not verify", k.Alg)
+
+		//Keys that are no longer published can no longer be used to verify
+		err = Verify(tok, NewJSONWebKeySet([]*Key{}), &vc)
+		assert.Error(t, err, "Assertion should not verify against a key set without the key")
+	}
+
+	expired, _ := Sign(keys[0], NewClaims("mfaserver", "testapp", "testdom", "validuser", []string{AMRPassword, AMROTP}, -time.Minute))
+	var vc Claims
+	assert.Error(t, Verify(expired, jwks, &vc), "Expired assertion should not verify")
+	assert.Error(t, Verify("not.a.jwt", jwks, &vc), "Malformed assertion should not verify")
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	//Example from RFC 7638 section 3.1
+	j := JSONWebKey{
+		KeyType: "RSA",
+		N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:       "AQAB",
+	}
+	tp, err := thumbprint(j)
+	assert.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", tp, "JWK thumbprint not as expected")
+}
+
+func TestLoadKey(t *testing.T) {
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b, _ := x509.MarshalPKCS8PrivateKey(ek)
+	f, _ := ioutil.TempFile(os.TempDir(), "signingkey")
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: b})
+	f.Close()
+
+	k, err := LoadKey(f.Name())
+	if err != nil {
+		t.Fatalf("Error loading key: %v", err)
+	}
+	assert.Equal(t, AlgES256, k.Alg, "Algorithm for key not as expected")
+	assert.NotEmpty(t, k.ID, "Key ID not set")
+
+	_, err = LoadKey(f.Name() + "missing")
+	assert.Error(t, err, "Should have errored loading a missing file")
+
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	_, err = NewKey(small)
+	assert.Error(t, err, "Should have errored for a small RSA key")
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, err = NewKey(p384)
+	assert.Error(t, err, "Should have errored for an unsupported curve")
+}
@@ -0,0 +1,146 @@
+package assertion
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// JSONWebKey is the public part of a signing key as defined by RFC 7517.
+type JSONWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid,omitempty"`
+	Use     string `json:"use,omitempty"`
+	Alg     string `json:"alg,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet publishes the public keys that assertions may be verified with.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// NewJSONWebKeySet creates the set of the public parts of the keys.
+func NewJSONWebKeySet(keys []*Key) JSONWebKeySet {
+	s := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, k := range keys {
+		s.Keys = append(s.Keys, k.JWK())
+	}
+	return s
+}
+
+// Key returns the key in the set with the key ID.
+func (s JSONWebKeySet) Key(kid string) (JSONWebKey, bool) {
+	for _, k := range s.Keys {
+		if k.KeyID == kid {
+			return k, true
+		}
+	}
+	return JSONWebKey{}, false
+}
+
+// JWK returns the public part of the key.
+func (k *Key) JWK() JSONWebKey {
+	j := JSONWebKey{KeyID: k.ID, Use: "sig", Alg: k.Alg}
+	switch p := k.Private.Public().(type) {
+	case *rsa.PublicKey:
+		j.KeyType = "RSA"
+		j.N = b64(p.N.Bytes())
+		j.E = b64(big.NewInt(int64(p.E)).Bytes())
+	case *ecdsa.PublicKey:
+		j.KeyType = "EC"
+		j.Curve = "P-256"
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		p.X.FillBytes(x)
+		p.Y.FillBytes(y)
+		j.X = b64(x)
+		j.Y = b64(y)
+	case ed25519.PublicKey:
+		j.KeyType = "OKP"
+		j.Curve = "Ed25519"
+		j.X = b64(p)
+	}
+	return j
+}
+
+// PublicKey returns the public key the JWK represents.
+func (j JSONWebKey) PublicKey() (interface{}, error) {
+	switch j.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, errors.New("RSA key modulus could not be decoded: " + err.Error())
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, errors.New("RSA key exponent could not be decoded: " + err.Error())
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, errors.New("EC key could not be decoded: " + err.Error())
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, errors.New("EC key could not be decoded: " + err.Error())
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("Ed25519 key could not be decoded")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("Unsupported key type " + j.KeyType)
+}
+
+// thumbprint calculates the JWK thumbprint (RFC 7638) from the required members of the key in lexicographic order.
+func thumbprint(j JSONWebKey) (string, error) {
+	var m interface{}
+	switch j.KeyType {
+	case "RSA":
+		m = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.KeyType, j.N}
+	case "EC":
+		m = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.Curve, j.KeyType, j.X, j.Y}
+	case "OKP":
+		m = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Curve, j.KeyType, j.X}
+	default:
+		return "", errors.New("Unsupported key type " + j.KeyType)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	d := sha256.Sum256(b)
+	return b64(d[:]), nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,320 @@
+// Package audit writes security events to an append-only audit log. Each record is chained to the previous one by
+// including its hash, and the head of the chain is periodically signed, so that records deleted or modified after they
+// were written can be detected.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/jcmturner/mfaserver/assertion"
+	"io"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of security event
+const (
+	EventValidate             = "validate"
+	EventEnrol                = "enrol"
+	EventUpdate               = "update"
+	EventDelete               = "delete"
+	EventList                 = "list"
+	EventLogin                = "login"
+	EventAdminCacheInvalidate = "admin_cache_invalidate"
+)
+
+// Issuer of the signed checkpoints
+const checkpointIssuer = "mfaserver-audit"
+
+// Hash the first record of a chain follows
+var genesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Event is a security event.
+type Event struct {
+	Type        string `json:"type"`
+	Outcome     string `json:"outcome"`
+	RequestID   string `json:"request_id,omitempty"`
+	RemoteAddr  string `json:"remote_addr,omitempty"`
+	Application string `json:"application,omitempty"`
+	Admin       string `json:"admin,omitempty"`
+	Issuer      string `json:"issuer,omitempty"`
+	Domain      string `json:"domain,omitempty"`
+	Username    string `json:"username,omitempty"`
+}
+
+// Record is an entry of the audit log. It holds either an event or a checkpoint, which is a signature over the chain up
+// to the previous record. The hash is calculated over the JSON encoding of the record without the hash, which is
+// always the last member.
+type Record struct {
+	Seq        uint64 `json:"seq"`
+	Time       string `json:"time"`
+	Event      *Event `json:"event,omitempty"`
+	Checkpoint string `json:"checkpoint,omitempty"`
+	Prev       string `json:"prev"`
+	Hash       string `json:"hash,omitempty"`
+}
+
+// checkpointClaims are the claims of the JWT that signs the chain.
+type checkpointClaims struct {
+	Issuer   string `json:"iss"`
+	IssuedAt int64  `json:"iat"`
+	Seq      uint64 `json:"seq"`
+	Prev     string `json:"prev"`
+}
+
+// Log writes records to the audit log's outputs.
+type Log struct {
+	mux      sync.Mutex
+	outputs  []io.Writer
+	closers  []io.Closer
+	key      *assertion.Key
+	interval time.Duration
+	seq      uint64
+	prev     string
+	unsigned int
+	signed   time.Time
+	stop     chan struct{}
+	closed   bool
+	path     string
+	next     *Log
+}
+
+// New creates an audit log without any outputs. If a key is provided the chain is signed at the interval and when the
+// log is closed.
+func New(key *assertion.Key, interval time.Duration) *Log {
+	l := &Log{key: key, interval: interval, prev: genesisHash, signed: time.Now()}
+	if key != nil && interval > 0 {
+		l.stop = make(chan struct{})
+		go l.signPeriodically()
+	}
+	return l
+}
+
+// WithFile appends the records to the file. If the file already holds records the chain continues from the last one.
+// This must be called before any other output is added or records written.
+func (l *Log) WithFile(path string) error {
+	seq, prev, err := lastRecord(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return errors.New("Could not open audit log file " + path + ": " + err.Error())
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.seq, l.prev = seq, prev
+	l.path = path
+	l.outputs = append(l.outputs, f)
+	l.closers = append(l.closers, f)
+	return nil
+}
+
+// WithSyslog also sends the records to syslog with the auth facility. If the network and address are empty the local
+// syslog server is used.
+func (l *Log) WithSyslog(network, address, tag string) error {
+	w, err := syslog.Dial(network, address, syslog.LOG_NOTICE|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return errors.New("Could not connect to syslog for the audit log: " + err.Error())
+	}
+	l.WithOutput(w)
+	l.mux.Lock()
+	l.closers = append(l.closers, w)
+	l.mux.Unlock()
+	return nil
+}
+
+// WithOutput also writes the records to the writer. Each record is written with a single call.
+func (l *Log) WithOutput(w io.Writer) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.outputs = append(l.outputs, w)
+}
+
+// Write records the event.
+func (l *Log) Write(e Event) error {
+	l.mux.Lock()
+	if next := l.next; next != nil {
+		l.mux.Unlock()
+		return next.Write(e)
+	}
+	defer l.mux.Unlock()
+	if l.closed {
+		return errors.New("Audit log is closed")
+	}
+	if err := l.append(Record{Event: &e}); err != nil {
+		return err
+	}
+	l.unsigned++
+	if l.key != nil && l.interval > 0 && time.Since(l.signed) >= l.interval {
+		return l.checkpoint()
+	}
+	return nil
+}
+
+// Close signs the chain and closes the outputs.
+func (l *Log) Close() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.close()
+}
+
+// HandOver signs the chain and closes the outputs, as Close does, then writes the events recorded afterwards to the next
+// log. If the next log appends to the same file its chain continues from the last record of this log, so that the
+// next log can replace this one while events are still being recorded to it.
+func (l *Log) HandOver(next *Log) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.closed {
+		return errors.New("Audit log is closed")
+	}
+	err := l.close()
+	if l.path != "" {
+		next.mux.Lock()
+		if next.path == l.path {
+			next.seq, next.prev = l.seq, l.prev
+		}
+		next.mux.Unlock()
+	}
+	l.next = next
+	return err
+}
+
+// close signs the chain and closes the outputs. The caller must hold the lock.
+func (l *Log) close() error {
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.stop != nil {
+		close(l.stop)
+	}
+	var err error
+	if l.key != nil && l.unsigned > 0 {
+		err = l.checkpoint()
+	}
+	for _, c := range l.closers {
+		c.Close()
+	}
+	return err
+}
+
+func (l *Log) signPeriodically() {
+	t := time.NewTicker(l.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-t.C:
+			l.mux.Lock()
+			if !l.closed && l.unsigned > 0 && time.Since(l.signed) >= l.interval {
+				l.checkpoint()
+			}
+			l.mux.Unlock()
+		}
+	}
+}
+
+// checkpoint appends a record signing the chain up to the last record. The caller must hold the lock.
+func (l *Log) checkpoint() error {
+	token, err := assertion.Sign(l.key, checkpointClaims{
+		Issuer:   checkpointIssuer,
+		IssuedAt: time.Now().UTC().Unix(),
+		Seq:      l.seq + 1,
+		Prev:     l.prev,
+	})
+	if err != nil {
+		return errors.New("Could not sign audit log: " + err.Error())
+	}
+	if err := l.append(Record{Checkpoint: token}); err != nil {
+		return err
+	}
+	l.unsigned = 0
+	l.signed = time.Now()
+	return nil
+}
+
+// append chains the record to the previous one and writes it. The caller must hold the lock.
+func (l *Log) append(rec Record) error {
+	rec.Seq = l.seq + 1
+	rec.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	rec.Prev = l.prev
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return errors.New("Could not encode audit record: " + err.Error())
+	}
+	d := sha256.Sum256(b)
+	h := hex.EncodeToString(d[:])
+	//Add the hash as the last member of the object
+	line := append(b[:len(b)-1], []byte(`,"hash":"`+h+`"}`+"\n")...)
+	var werr error
+	for _, w := range l.outputs {
+		if _, err := w.Write(line); err != nil {
+			werr = errors.New("Could not write audit record: " + err.Error())
+		}
+	}
+	//The chain continues from the record even if an output failed so that the other outputs remain valid
+	l.seq = rec.Seq
+	l.prev = h
+	return werr
+}
+
+// lastRecord returns the sequence number and hash of the last record in the file, or those that start a new chain if
+// the file does not exist or is empty.
+func lastRecord(path string) (uint64, string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, genesisHash, nil
+	}
+	if err != nil {
+		return 0, "", errors.New("Could not open audit log file " + path + ": " + err.Error())
+	}
+	defer f.Close()
+	var last []byte
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		if b := bytes.TrimSpace(s.Bytes()); len(b) > 0 {
+			last = append(last[:0], b...)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, "", errors.New("Could not read audit log file " + path + ": " + err.Error())
+	}
+	if last == nil {
+		return 0, genesisHash, nil
+	}
+	rec, _, err := parseRecord(last)
+	if err != nil || rec.Hash == "" {
+		return 0, "", errors.New("The last record of audit log file " + path + " could not be read so the chain cannot be continued")
+	}
+	return rec.Seq, rec.Hash, nil
+}
+
+// parseRecord decodes a line of the audit log and calculates its hash. Anything before the JSON object, such as a
+// syslog header, is ignored.
+func parseRecord(line []byte) (Record, string, error) {
+	var rec Record
+	i := bytes.IndexByte(line, '{')
+	if i < 0 {
+		return rec, "", errors.New("No record found")
+	}
+	line = line[i:]
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return rec, "", err
+	}
+	j := bytes.LastIndex(line, []byte(`,"hash":"`))
+	if j < 0 {
+		return rec, "", errors.New("Record has no hash")
+	}
+	d := sha256.Sum256(append(append([]byte{}, line[:j]...), '}'))
+	return rec, hex.EncodeToString(d[:]), nil
+}
@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/jcmturner/mfaserver/assertion"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) *assertion.Key {
+	_, p, _ := ed25519.GenerateKey(rand.Reader)
+	k, err := assertion.NewKey(p)
+	if err != nil {
+		t.Fatalf("Error creating signing key: %v", err)
+	}
+	return k
+}
+
+// writeTestLog writes the events to a new audit log file, signing after each event, and returns its lines.
+func writeTestLog(t *testing.T, path string, k *assertion.Key, events ...Event) []string {
+	l := New(k, time.Nanosecond)
+	if err := l.WithFile(path); err != nil {
+		t.Fatalf("Error opening audit log: %v", err)
+	}
+	for _, e := range events {
+		if err := l.Write(e); err != nil {
+			t.Fatalf("Error writing audit event: %v", err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Error closing audit log: %v", err)
+	}
+	b, _ := ioutil.ReadFile(path)
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func verifyLines(t *testing.T, lines []string, k *assertion.Key) Report {
+	var keys *assertion.JSONWebKeySet
+	if k != nil {
+		s := assertion.NewJSONWebKeySet([]*assertion.Key{k})
+		keys = &s
+	}
+	rep, err := Verify(strings.NewReader(strings.Join(lines, "\n")+"\n"), keys)
+	if err != nil {
+		t.Fatalf("Error verifying audit log: %v", err)
+	}
+	return rep
+}
+
+func TestLog_Verify(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-audit")
+	defer os.RemoveAll(dir)
+	k := testKey(t)
+	path := filepath.Join(dir, "audit.log")
+	events := []Event{
+		{Type: EventEnrol, Outcome: "success", Issuer: "testapp", Domain: "test.com", Username: "validuser"},
+		{Type: EventValidate, Outcome: "otp_fail", Issuer: "testapp", Domain: "test.com", Username: "validuser"},
+		{Type: EventDelete, Outcome: "success", Admin: "test.com/mfaadmin", Issuer: "testapp", Domain: "test.com", Username: "validuser"},
+	}
+	lines := writeTestLog(t, path, k, events...)
+	//Each event is followed by a checkpoint
+	assert.Len(t, lines, 6, "Number of audit records not as expected")
+	rep := verifyLines(t, lines, k)
+	assert.True(t, rep.Valid(), "Audit log not valid: %v", rep.Problems)
+	assert.Equal(t, 6, rep.Records, "Records not as expected")
+	assert.Equal(t, 3, rep.Events, "Events not as expected")
+	assert.Equal(t, 3, rep.Checkpoints, "Checkpoints not as expected")
+	assert.Equal(t, 0, rep.Unsigned, "Unsigned events not as expected")
+
+	//The chain continues from the records already in the file
+	lines = writeTestLog(t, path, k, events[0])
+	assert.Len(t, lines, 8, "Number of audit records not as expected after reopening")
+	assert.True(t, verifyLines(t, lines, k).Valid(), "Audit log not valid after reopening")
+
+	var tests = []struct {
+		name    string
+		tamper  func([]string) []string
+		problem string
+	}{
+		{"modified", func(l []string) []string {
+			l[2] = strings.Replace(l[2], "otp_fail", "success", 1)
+			return l
+		}, "record with seq 3 has been modified"},
+		{"deleted", func(l []string) []string {
+			return append(l[:2:2], l[4:]...)
+		}, "records with seq 3 to 4 are missing"},
+		{"head deleted", func(l []string) []string {
+			return l[2:]
+		}, "records before seq 3 are missing"},
+		{"reordered", func(l []string) []string {
+			l[2], l[4] = l[4], l[2]
+			return l
+		}, "out of sequence"},
+		{"not a record", func(l []string) []string {
+			l[1] = "garbage"
+			return l
+		}, "record could not be read"},
+		{"checkpoint forged", func(l []string) []string {
+			l[1] = writeTestLog(t, filepath.Join(dir, "other.log"), testKey(t), events[0])[1]
+			return l
+		}, "checkpoint with seq 2 is not valid"},
+	}
+	for _, test := range tests {
+		tampered := test.tamper(append([]string{}, lines...))
+		rep := verifyLines(t, tampered, k)
+		assert.False(t, rep.Valid(), "Audit log with a %s record reported valid", test.name)
+		assert.Contains(t, strings.Join(rep.Problems, "\n"), test.problem, "Problem not as expected for a %s record", test.name)
+	}
+
+	//Recalculating the hash of a modified record breaks the link from the next record
+	rec, _, _ := parseRecord([]byte(lines[2]))
+	rec.Event.Outcome = "success"
+	var buf bytes.Buffer
+	l := &Log{seq: rec.Seq - 1, prev: rec.Prev}
+	l.WithOutput(&buf)
+	l.append(Record{Event: rec.Event})
+	tampered := append([]string{}, lines...)
+	tampered[2] = strings.TrimSpace(buf.String())
+	rep = verifyLines(t, tampered, k)
+	assert.Contains(t, strings.Join(rep.Problems, "\n"), "record with seq 4 does not follow the previous record", "Rehashed record not detected")
+}
+
+func TestLog_Unsigned(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-audit")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	l := New(nil, 0)
+	if err := l.WithFile(path); err != nil {
+		t.Fatalf("Error opening audit log: %v", err)
+	}
+	l.Write(Event{Type: EventValidate, Outcome: "success"})
+	l.Write(Event{Type: EventValidate, Outcome: "success"})
+	l.Close()
+	assert.Error(t, l.Write(Event{Type: EventValidate}), "Write to a closed audit log did not return an error")
+	b, _ := ioutil.ReadFile(path)
+	rep, err := Verify(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatalf("Error verifying audit log: %v", err)
+	}
+	assert.True(t, rep.Valid(), "Audit log not valid: %v", rep.Problems)
+	assert.Equal(t, 0, rep.Checkpoints, "Checkpoints written without a signing key")
+	assert.Equal(t, 2, rep.Unsigned, "Unsigned events not as expected")
+
+	//A syslog header before the record is ignored
+	syslogged := "<37>1 2017-01-01T12:00:00Z host mfaserver - - - " + strings.Replace(string(b), "\n", "\n<37>1 2017-01-01T12:00:00Z host mfaserver - - - ", 1)
+	rep, _ = Verify(strings.NewReader(syslogged), nil)
+	assert.True(t, rep.Valid(), "Audit log with syslog headers not valid: %v", rep.Problems)
+}
+
+func TestLog_HandOver(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-audit")
+	defer os.RemoveAll(dir)
+	k := testKey(t)
+	path := filepath.Join(dir, "audit.log")
+	l := New(k, time.Hour)
+	if err := l.WithFile(path); err != nil {
+		t.Fatalf("Error opening audit log: %v", err)
+	}
+	l.Write(Event{Type: EventEnrol, Outcome: "success"})
+	//The next log is opened on the file while the previous log is still writing to it
+	next := New(k, time.Hour)
+	if err := next.WithFile(path); err != nil {
+		t.Fatalf("Error opening audit log: %v", err)
+	}
+	l.Write(Event{Type: EventValidate, Outcome: "success"})
+	if err := l.HandOver(next); err != nil {
+		t.Fatalf("Error handing over audit log: %v", err)
+	}
+	assert.NoError(t, l.Write(Event{Type: EventValidate, Outcome: "otp_fail"}), "Write after the hand over not sent to the next log")
+	next.Write(Event{Type: EventDelete, Outcome: "success"})
+	next.Close()
+	assert.Error(t, l.HandOver(next), "Closed audit log handed over")
+
+	b, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	rep := verifyLines(t, lines, k)
+	assert.True(t, rep.Valid(), "Audit log not valid after hand over: %v", rep.Problems)
+	assert.Equal(t, 4, rep.Events, "Events not as expected")
+	assert.Equal(t, 2, rep.Checkpoints, "Checkpoints not as expected")
+}
@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/mfaserver/assertion"
+	"io"
+)
+
+// Report is the result of verifying an audit log.
+type Report struct {
+	Records     int
+	Events      int
+	Checkpoints int
+	// Records written after the last checkpoint. These could have been removed without detection.
+	Unsigned int
+	Problems []string
+}
+
+// Valid reports whether no deleted or modified records were detected.
+func (r Report) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify reads an audit log and checks that no records have been deleted or modified. Each record must follow the
+// previous record in the chain and hash to the value recorded. If keys are provided the signature of each checkpoint is
+// verified, otherwise checkpoints are only checked to be part of the chain.
+func Verify(r io.Reader, keys *assertion.JSONWebKeySet) (Report, error) {
+	var rep Report
+	problem := func(format string, a ...interface{}) {
+		rep.Problems = append(rep.Problems, fmt.Sprintf(format, a...))
+	}
+	var seq uint64
+	prev := genesisHash
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		rec, h, err := parseRecord(line)
+		if err != nil {
+			problem("line %d: record could not be read: %v", n, err)
+			continue
+		}
+		rep.Records++
+		switch {
+		case rec.Seq == seq+1:
+		case rec.Seq > seq+1 && seq == 0:
+			problem("line %d: records before seq %d are missing", n, rec.Seq)
+		case rec.Seq > seq+1:
+			problem("line %d: records with seq %d to %d are missing", n, seq+1, rec.Seq-1)
+		default:
+			problem("line %d: record with seq %d is out of sequence after seq %d", n, rec.Seq, seq)
+		}
+		if rec.Seq == seq+1 && rec.Prev != prev {
+			problem("line %d: record with seq %d does not follow the previous record", n, rec.Seq)
+		}
+		if h != rec.Hash {
+			problem("line %d: record with seq %d has been modified", n, rec.Seq)
+		}
+		if rec.Checkpoint != "" {
+			rep.Checkpoints++
+			if err := verifyCheckpoint(rec, keys); err != nil {
+				problem("line %d: checkpoint with seq %d is not valid: %v", n, rec.Seq, err)
+			} else {
+				rep.Unsigned = 0
+			}
+		} else {
+			rep.Events++
+			rep.Unsigned++
+		}
+		seq = rec.Seq
+		prev = rec.Hash
+	}
+	if err := s.Err(); err != nil {
+		return rep, err
+	}
+	return rep, nil
+}
+
+func verifyCheckpoint(rec Record, keys *assertion.JSONWebKeySet) error {
+	if keys == nil {
+		return nil
+	}
+	var c checkpointClaims
+	if err := assertion.Verify(rec.Checkpoint, *keys, &c); err != nil {
+		return err
+	}
+	if c.Issuer != checkpointIssuer || c.Seq != rec.Seq || c.Prev != rec.Prev {
+		return errors.New(fmt.Sprintf("signature is for seq %d following %s rather than this record", c.Seq, c.Prev))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jcmturner/mfaserver/assertion"
+	"github.com/jcmturner/mfaserver/audit"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Exit codes of the audit command
+const (
+	auditValid    = 0
+	auditTampered = 1
+	auditError    = 2
+)
+
+// auditCommand runs the "audit" subcommand with its arguments and returns the exit code.
+func auditCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(stderr, "Usage: mfaserver audit verify -file=/path/to/audit.log [-key=/path/to/auditkey.pem | -jwks=/path/to/jwks.json]")
+		return auditError
+	}
+	fs := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file := fs.String("file", "", "Path to the audit log to verify, or - to read it from standard input. Rotated files must be concatenated in order")
+	keyFile := fs.String("key", "", "Path to the PEM encoded SigningKeyFile the audit log is signed with")
+	jwksFile := fs.String("jwks", "", "Path to a JSON Web Key Set of the public keys the audit log is signed with")
+	if err := fs.Parse(args[1:]); err != nil {
+		return auditError
+	}
+	if *file == "" {
+		fmt.Fprintln(stderr, "No audit log file provided")
+		return auditError
+	}
+	keys, err := auditKeys(*keyFile, *jwksFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return auditError
+	}
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not open audit log: %v\n", err)
+			return auditError
+		}
+		defer f.Close()
+		r = f
+	}
+	rep, err := audit.Verify(r, keys)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not read audit log: %v\n", err)
+		return auditError
+	}
+	for _, p := range rep.Problems {
+		fmt.Fprintln(stdout, p)
+	}
+	fmt.Fprintf(stdout, "%d records: %d events and %d checkpoints. %d events after the last valid checkpoint.\n", rep.Records, rep.Events, rep.Checkpoints, rep.Unsigned)
+	if keys == nil {
+		fmt.Fprintln(stdout, "Checkpoint signatures were not verified as no key was provided.")
+	}
+	if !rep.Valid() {
+		fmt.Fprintln(stdout, "Audit log NOT valid: records have been deleted or modified.")
+		return auditTampered
+	}
+	fmt.Fprintln(stdout, "Audit log valid.")
+	return auditValid
+}
+
+// auditKeys returns the public keys to verify the checkpoints with, or nil if none are provided.
+func auditKeys(keyFile, jwksFile string) (*assertion.JSONWebKeySet, error) {
+	switch {
+	case keyFile != "" && jwksFile != "":
+		return nil, errors.New("Only one of -key and -jwks can be provided")
+	case keyFile != "":
+		k, err := assertion.LoadKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		s := assertion.NewJSONWebKeySet([]*assertion.Key{k})
+		return &s, nil
+	case jwksFile != "":
+		b, err := ioutil.ReadFile(jwksFile)
+		if err != nil {
+			return nil, errors.New("Could not read JSON Web Key Set: " + err.Error())
+		}
+		var s assertion.JSONWebKeySet
+		if err := json.Unmarshal(b, &s); err != nil {
+			return nil, errors.New("Could not parse JSON Web Key Set: " + err.Error())
+		}
+		return &s, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Membership is an in memory cache of group membership decisions keyed by user DN.
+// Only whether a user is a member of a group is cached, never any credentials.
+type Membership struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	entries     map[string]*userEntry
+	seq         uint64
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+}
+
+type userEntry struct {
+	groups map[string]groupEntry
+	seq    uint64
+}
+
+type groupEntry struct {
+	member  bool
+	expires time.Time
+}
+
+// Stats of the use of the cache.
+type Stats struct {
+	Entries   int     `json:"entries"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"`
+	HitRate   float64 `json:"hitRate"`
+}
+
+// NewMembership creates a cache that holds positive decisions for ttl and negative decisions for negativeTTL.
+// No more than maxEntries users are held, the oldest being evicted to make space.
+func NewMembership(ttl, negativeTTL time.Duration, maxEntries int) *Membership {
+	return &Membership{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		entries:     make(map[string]*userEntry),
+	}
+}
+
+// Get returns which of the groups the user is a member of.
+// The result is only returned if an unexpired decision is cached for every one of the groups.
+func (c *Membership) Get(dn string, groups []string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var member []string
+	e, ok := c.entries[strings.ToLower(dn)]
+	if ok {
+		for _, g := range groups {
+			ge, gok := e.groups[strings.ToLower(g)]
+			if !gok || now.After(ge.expires) {
+				ok = false
+				break
+			}
+			if ge.member {
+				member = append(member, g)
+			}
+		}
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return member, true
+}
+
+// Set caches the decisions for the groups checked. The user is a member of the groups in member and not a member of the rest.
+func (c *Membership) Set(dn string, groups, member []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	k := strings.ToLower(dn)
+	e, ok := c.entries[k]
+	if !ok {
+		if c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+			c.evict(now)
+		}
+		c.seq++
+		e = &userEntry{groups: make(map[string]groupEntry), seq: c.seq}
+		c.entries[k] = e
+	}
+	for _, g := range groups {
+		ge := groupEntry{expires: now.Add(c.negativeTTL)}
+		for _, m := range member {
+			if strings.EqualFold(g, m) {
+				ge = groupEntry{member: true, expires: now.Add(c.ttl)}
+				break
+			}
+		}
+		e.groups[strings.ToLower(g)] = ge
+	}
+}
+
+// evict removes expired users and if none have expired removes the oldest user.
+// Must be called with the lock held.
+func (c *Membership) evict(now time.Time) {
+	var oldest string
+	for k, e := range c.entries {
+		expired := true
+		for _, ge := range e.groups {
+			if !now.After(ge.expires) {
+				expired = false
+				break
+			}
+		}
+		if expired {
+			delete(c.entries, k)
+			c.evictions++
+			continue
+		}
+		if oldest == "" || e.seq < c.entries[oldest].seq {
+			oldest = k
+		}
+	}
+	if len(c.entries) >= c.maxEntries && oldest != "" {
+		delete(c.entries, oldest)
+		c.evictions++
+	}
+}
+
+// Invalidate removes the cached decisions for the user.
+func (c *Membership) Invalidate(dn string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, strings.ToLower(dn))
+}
+
+// InvalidatePrefix removes the cached decisions for the users whose DN starts with the prefix.
+func (c *Membership) InvalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix = strings.ToLower(prefix)
+	for dn := range c.entries {
+		if strings.HasPrefix(dn, prefix) {
+			delete(c.entries, dn)
+		}
+	}
+}
+
+// Purge removes all cached decisions.
+func (c *Membership) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*userEntry)
+}
+
+func (c *Membership) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := Stats{
+		Entries:   len(c.entries),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+	if c.hits+c.misses > 0 {
+		s.HitRate = float64(c.hits) / float64(c.hits+c.misses)
+	}
+	return s
+}
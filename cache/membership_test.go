@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const (
+	testUserDN = "cn=validuser,ou=users,dc=example,dc=com"
+	testGroup1 = "cn=mfaadmin,ou=groups,dc=example,dc=com"
+	testGroup2 = "cn=auditors,ou=groups,dc=example,dc=com"
+)
+
+func TestMembership_GetSet(t *testing.T) {
+	c := NewMembership(time.Minute, time.Minute, 10)
+	_, ok := c.Get(testUserDN, []string{testGroup1})
+	assert.False(t, ok, "Empty cache should not return a result")
+
+	c.Set(testUserDN, []string{testGroup1, testGroup2}, []string{testGroup1})
+	m, ok := c.Get(testUserDN, []string{testGroup1, testGroup2})
+	assert.True(t, ok, "Cached decision not returned")
+	assert.Equal(t, []string{testGroup1}, m, "Cached groups not as expected")
+
+	m, ok = c.Get("CN=ValidUser,ou=users,dc=example,dc=com", []string{testGroup2})
+	assert.True(t, ok, "Cached negative decision not returned")
+	assert.Empty(t, m, "User should not be a member of any of the groups")
+
+	_, ok = c.Get(testUserDN, []string{testGroup1, "cn=othergroup,ou=groups,dc=example,dc=com"})
+	assert.False(t, ok, "Result should not be returned when a group has not been checked")
+
+	s := c.Stats()
+	assert.Equal(t, uint64(2), s.Hits, "Cache hits not as expected")
+	assert.Equal(t, uint64(2), s.Misses, "Cache misses not as expected")
+	assert.Equal(t, 0.5, s.HitRate, "Cache hit rate not as expected")
+	assert.Equal(t, 1, s.Entries, "Cache entries not as expected")
+}
+
+func TestMembership_Expiry(t *testing.T) {
+	c := NewMembership(time.Minute, time.Millisecond, 10)
+	c.Set(testUserDN, []string{testGroup1, testGroup2}, []string{testGroup1})
+	time.Sleep(5 * time.Millisecond)
+	_, ok := c.Get(testUserDN, []string{testGroup1})
+	assert.True(t, ok, "Positive decision should not have expired")
+	_, ok = c.Get(testUserDN, []string{testGroup2})
+	assert.False(t, ok, "Negative decision should have expired")
+}
+
+func TestMembership_MaxEntries(t *testing.T) {
+	c := NewMembership(time.Minute, time.Minute, 2)
+	c.Set("cn=user1", []string{testGroup1}, nil)
+	c.Set("cn=user2", []string{testGroup1}, nil)
+	c.Set("cn=user3", []string{testGroup1}, nil)
+	s := c.Stats()
+	assert.Equal(t, 2, s.Entries, "Cache should not exceed the maximum entries")
+	assert.Equal(t, uint64(1), s.Evictions, "Cache evictions not as expected")
+	_, ok := c.Get("cn=user1", []string{testGroup1})
+	assert.False(t, ok, "Oldest entry should have been evicted")
+	_, ok = c.Get("cn=user3", []string{testGroup1})
+	assert.True(t, ok, "Newest entry should be cached")
+}
+
+func TestMembership_Invalidate(t *testing.T) {
+	c := NewMembership(time.Minute, time.Minute, 0)
+	c.Set("cn=user1", []string{testGroup1}, []string{testGroup1})
+	c.Set("cn=user2", []string{testGroup1}, []string{testGroup1})
+	c.Invalidate("cn=user1")
+	_, ok := c.Get("cn=user1", []string{testGroup1})
+	assert.False(t, ok, "Invalidated entry should not be returned")
+	_, ok = c.Get("cn=user2", []string{testGroup1})
+	assert.True(t, ok, "Entry that was not invalidated should be returned")
+	c.Purge()
+	_, ok = c.Get("cn=user2", []string{testGroup1})
+	assert.False(t, ok, "Purged entry should not be returned")
+
+	c.Set("ldap://dir1 cn=user1", []string{testGroup1}, []string{testGroup1})
+	c.Set("ldap://dir2 cn=user1", []string{testGroup1}, []string{testGroup1})
+	c.InvalidatePrefix("LDAP://dir1 ")
+	_, ok = c.Get("ldap://dir1 cn=user1", []string{testGroup1})
+	assert.False(t, ok, "Entry with the prefix should not be returned")
+	_, ok = c.Get("ldap://dir2 cn=user1", []string{testGroup1})
+	assert.True(t, ok, "Entry without the prefix should be returned")
+}
@@ -0,0 +1,283 @@
+// Package client is a Go client of the MFA server's REST API.
+//
+// A Client is safe for concurrent use. Failed requests return an *Error, which can be tested for the reason with
+// errors.Is and the Err values:
+//
+//	if err := c.Validate(ctx, u, otp); errors.Is(err, client.ErrUnauthorized) {
+//		//The password or OTP is not valid
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/mfaserver/version"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Path prefix of the version of the REST API the client uses
+const apiVersionPrefix = "/v1"
+
+// Time allowed for each attempt at a request unless another timeout is configured
+const defaultTimeout = 30 * time.Second
+
+// Limit on the response body read
+const maxResponseSize = 1024 * 1024
+
+// Client makes requests to an MFA server.
+type Client struct {
+	baseURL     string
+	httpClient  *http.Client
+	application string
+	apiKey      string
+	hmacSecret  []byte
+	retries     int
+	retryWait   time.Duration
+}
+
+// User identifies a user and holds their password. The password is not needed if the issuer does not require one.
+type User struct {
+	Issuer   string
+	Domain   string
+	Username string
+	Password string
+}
+
+// Admin holds the credentials of an administrator.
+type Admin struct {
+	Username string
+	Password string
+}
+
+// Secret is a user's OTP secret.
+type Secret struct {
+	Secret string
+	user   User
+}
+
+// URI returns the otpauth URI of the secret that authenticator applications are configured with.
+func (s *Secret) URI() string {
+	return fmt.Sprintf("otpauth://totp/%s:%s@%s?secret=%s&issuer=%s&algorithm=SHA1&digits=6&period=30",
+		url.QueryEscape(s.user.Issuer), s.user.Username, s.user.Domain, s.Secret, url.QueryEscape(s.user.Issuer))
+}
+
+type userRequestData struct {
+	Issuer   string `json:"issuer"`
+	Domain   string `json:"domain"`
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	OTP      string `json:"otp,omitempty"`
+}
+
+type secretResponseData struct {
+	Secret string `json:"secret"`
+}
+
+// New returns a client of the MFA server at the base URL, such as https://mfaserver.example.com:8443.
+func New(baseURL string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("MFA server URL must be an absolute http or https URL")
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: defaultTimeout, Transport: http.DefaultTransport.(*http.Transport).Clone()},
+	}, nil
+}
+
+// WithTLSConfig sets the TLS configuration of the connections to the MFA server, such as the CAs trusted and a client
+// certificate that authenticates the application.
+func (c *Client) WithTLSConfig(t *tls.Config) *Client {
+	if tr, ok := c.httpClient.Transport.(*http.Transport); ok {
+		tr.TLSClientConfig = t
+	}
+	return c
+}
+
+// WithHTTPClient makes requests with the HTTP client. Its timeout applies to each attempt at a request.
+func (c *Client) WithHTTPClient(hc *http.Client) *Client {
+	c.httpClient = hc
+	return c
+}
+
+// WithAPIKey authenticates as the application with the API key.
+func (c *Client) WithAPIKey(application, key string) *Client {
+	c.application = application
+	c.apiKey = key
+	c.hmacSecret = nil
+	return c
+}
+
+// WithSignature authenticates as the application by signing each request with the HMAC secret.
+func (c *Client) WithSignature(application, secret string) *Client {
+	c.application = application
+	c.apiKey = ""
+	c.hmacSecret = []byte(secret)
+	return c
+}
+
+// WithRetries retries a request up to the number of times if the MFA server could not be reached or was unavailable.
+// The wait before the first retry is doubled for each retry after it.
+//
+// A retry after the MFA server has handled a request but the response was lost can fail, for example with
+// ErrAlreadyEnrolled from Enrol, or for Update return a different secret to the one stored first.
+func (c *Client) WithRetries(retries int, wait time.Duration) *Client {
+	c.retries = retries
+	c.retryWait = wait
+	return c
+}
+
+// Enrol creates and stores a new secret for the user.
+func (c *Client) Enrol(ctx context.Context, u User) (*Secret, error) {
+	s, err := c.secret(ctx, "/enrol", userRequestData{Issuer: u.Issuer, Domain: u.Domain, Username: u.Username, Password: u.Password})
+	if err != nil {
+		return nil, err
+	}
+	return &Secret{Secret: s, user: u}, nil
+}
+
+// EnrolQRCode creates and stores a new secret for the user and returns a PNG QR code of it for authenticator
+// applications to scan.
+func (c *Client) EnrolQRCode(ctx context.Context, u User) ([]byte, error) {
+	return c.qrCode(ctx, "/enrol", userRequestData{Issuer: u.Issuer, Domain: u.Domain, Username: u.Username, Password: u.Password})
+}
+
+// Validate checks the user's password and OTP. ErrUnauthorized is returned if either is not valid.
+func (c *Client) Validate(ctx context.Context, u User, otp string) error {
+	_, err := c.do(ctx, "/validate", userData(u, otp), nil, nil)
+	return err
+}
+
+// Update replaces the secret of the user, who authenticates with their current OTP.
+func (c *Client) Update(ctx context.Context, u User, otp string) (*Secret, error) {
+	s, err := c.secret(ctx, "/update", userData(u, otp))
+	if err != nil {
+		return nil, err
+	}
+	return &Secret{Secret: s, user: u}, nil
+}
+
+// UpdateQRCode replaces the secret of the user, who authenticates with their current OTP, and returns a PNG QR code of
+// the new secret.
+func (c *Client) UpdateQRCode(ctx context.Context, u User, otp string) ([]byte, error) {
+	return c.qrCode(ctx, "/update", userData(u, otp))
+}
+
+// Delete deletes the secret of the user, who authenticates with their password and OTP.
+func (c *Client) Delete(ctx context.Context, u User, otp string) error {
+	_, err := c.do(ctx, "/delete", userData(u, otp), nil, nil)
+	return err
+}
+
+// AdminDelete deletes the secret of the user on behalf of an administrator with the reset permission for the issuer
+// and domain. The user's password is not used.
+func (c *Client) AdminDelete(ctx context.Context, admin Admin, u User) error {
+	_, err := c.do(ctx, "/delete", userRequestData{Issuer: u.Issuer, Domain: u.Domain, Username: u.Username}, nil, &admin)
+	return err
+}
+
+func userData(u User, otp string) userRequestData {
+	return userRequestData{Issuer: u.Issuer, Domain: u.Domain, Username: u.Username, Password: u.Password, OTP: otp}
+}
+
+// secret makes the request to the endpoint and returns the secret in the response.
+func (c *Client) secret(ctx context.Context, path string, data userRequestData) (string, error) {
+	b, err := c.do(ctx, path, data, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	var d secretResponseData
+	if err := json.Unmarshal(b, &d); err != nil {
+		return "", errors.New("Could not parse the response from the MFA server: " + err.Error())
+	}
+	return d.Secret, nil
+}
+
+// qrCode makes the request to the endpoint and returns the PNG QR code in the response.
+func (c *Client) qrCode(ctx context.Context, path string, data userRequestData) ([]byte, error) {
+	//The MFA server returns the QR code when image/png is requested in the Accept-Encoding header
+	return c.do(ctx, path, data, http.Header{"Accept-Encoding": []string{"image/png"}}, nil)
+}
+
+// do posts the data to the API endpoint, retrying if configured, and returns the response body.
+func (c *Client) do(ctx context.Context, path string, data interface{}, header http.Header, admin *Admin) ([]byte, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.New("Could not encode the request: " + err.Error())
+	}
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		b, err := c.attempt(ctx, apiVersionPrefix+path, body, header, admin)
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			return b, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, path string, body []byte, header http.Header, admin *Admin) ([]byte, error) {
+	r, err := http.NewRequest("POST", c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.New("Could not create the request: " + err.Error())
+	}
+	r = r.WithContext(ctx)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", "mfaserver-client/"+version.Version)
+	if admin != nil {
+		r.SetBasicAuth(admin.Username, admin.Password)
+	}
+	if c.application != "" {
+		r.Header.Set("X-MFA-Application", c.application)
+	}
+	if c.apiKey != "" {
+		r.Header.Set("X-MFA-Application-Key", c.apiKey)
+	}
+	if c.hmacSecret != nil {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		r.Header.Set("X-MFA-Timestamp", ts)
+		r.Header.Set("X-MFA-Signature", hex.EncodeToString(signature(c.hmacSecret, r.Method, r.URL.RequestURI(), ts, body)))
+	}
+	resp, err := c.httpClient.Do(r)
+	if err != nil {
+		return nil, &transportError{err}
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, &transportError{err}
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return b, nil
+	}
+	return nil, responseError(resp, b)
+}
+
+// signature calculates the HMAC-SHA256 signature of a request that the MFA server verifies. The signed string is the
+// HTTP method, request URI, timestamp and hex encoded SHA-256 hash of the body, each separated by a newline.
+func signature(secret []byte, method, uri, ts string, body []byte) []byte {
+	bh := sha256.Sum256(body)
+	m := hmac.New(sha256.New, secret)
+	io.WriteString(m, method+"\n"+uri+"\n"+ts+"\n"+hex.EncodeToString(bh[:]))
+	return m.Sum(nil)
+}
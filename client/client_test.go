@@ -0,0 +1,283 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"errors"
+	"github.com/jcmturner/gootp"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/handlers"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testApp       = "app1"
+	testAppKey    = "testappkey"
+	testAppSecret = "testapphmacsecret"
+)
+
+// apiHandler serves the REST API as the MFA server does, with the configuration.
+func apiHandler(c *config.Config) http.Handler {
+	conf := func() *config.Config { return c }
+	mux := http.NewServeMux()
+	for path, f := range map[string]handlers.HandlerFunc{
+		"/enrol":    handlers.Enrol,
+		"/validate": handlers.ValidateOTP,
+		"/update":   handlers.Update,
+		"/delete":   handlers.DeleteOTP,
+	} {
+		p := handlers.APIVersionPrefix + path
+		mux.HandleFunc(p, handlers.Logged(p, conf, handlers.Methods(f, "POST")))
+	}
+	return mux
+}
+
+func testConfig() *config.Config {
+	c := config.NewConfig()
+	c.MFAServer.Loggers.Error = log.New(ioutil.Discard, "", 0)
+	c.MFAServer.Loggers.Warning = log.New(ioutil.Discard, "", 0)
+	return c
+}
+
+func TestNew(t *testing.T) {
+	for _, u := range []string{"", "mfaserver.example.com", "ftp://mfaserver.example.com", "https://"} {
+		_, err := New(u)
+		assert.Error(t, err, "URL %q should not be accepted", u)
+	}
+	c, err := New("https://mfaserver.example.com:8443/")
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	assert.Equal(t, "https://mfaserver.example.com:8443", c.baseURL, "Base URL not as expected")
+}
+
+func TestSecret_URI(t *testing.T) {
+	s := &Secret{Secret: "JBSWY3DPEHPK3PXP", user: User{Issuer: "My App", Domain: "testdom", Username: "validuser"}}
+	assert.Equal(t, "otpauth://totp/My+App:validuser@testdom?secret=JBSWY3DPEHPK3PXP&issuer=My+App&algorithm=SHA1&digits=6&period=30", s.URI(), "URI not as expected")
+}
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"issuer":"testapp"}`)
+	assert.Equal(t, handlers.RequestSignature([]byte(testAppSecret), "POST", "/v1/validate", "1700000000", body),
+		signature([]byte(testAppSecret), "POST", "/v1/validate", "1700000000", body), "Signature not as verified by the MFA server")
+}
+
+func TestClient_Errors(t *testing.T) {
+	c := testConfig()
+	c.WithApplication(testApp, []string{testAppKey}, testAppSecret, []string{"testapp"})
+	s := httptest.NewServer(apiHandler(c))
+	defer s.Close()
+
+	u := User{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "validpassword"}
+	newClient := func() *Client {
+		cl, err := New(s.URL)
+		if err != nil {
+			t.Fatalf("Error creating client: %v", err)
+		}
+		return cl
+	}
+	var tests = []struct {
+		Client *Client
+		Call   func(*Client) error
+		Err    error
+		Status int
+		Code   string
+	}{
+		//No application credentials
+		{newClient(), func(cl *Client) error { return cl.Validate(context.Background(), u, "123456") }, ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+		{newClient().WithAPIKey(testApp, "wrongkey"), func(cl *Client) error { return cl.Validate(context.Background(), u, "123456") }, ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+		{newClient().WithSignature(testApp, "wrongsecret"), func(cl *Client) error { return cl.Delete(context.Background(), u, "123456") }, ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+		//The issuer is checked once the application is authenticated
+		{newClient().WithAPIKey(testApp, testAppKey), func(cl *Client) error {
+			_, err := cl.Enrol(context.Background(), User{Issuer: "otherapp", Domain: "testdom", Username: "validuser", Password: "validpassword"})
+			return err
+		}, ErrForbidden, http.StatusForbidden, "forbidden"},
+		{newClient().WithSignature(testApp, testAppSecret), func(cl *Client) error {
+			_, err := cl.Update(context.Background(), User{Issuer: "otherapp", Domain: "testdom", Username: "validuser", Password: "validpassword"}, "123456")
+			return err
+		}, ErrForbidden, http.StatusForbidden, "forbidden"},
+		{newClient().WithAPIKey(testApp, testAppKey), func(cl *Client) error {
+			_, err := cl.EnrolQRCode(context.Background(), User{Issuer: "testapp", Domain: "testdom"})
+			return err
+		}, ErrInvalidRequest, http.StatusBadRequest, "invalid_request"},
+		{newClient().WithAPIKey(testApp, testAppKey), func(cl *Client) error {
+			return cl.AdminDelete(context.Background(), Admin{}, User{Issuer: "testapp", Domain: "testdom"})
+		}, ErrInvalidRequest, http.StatusBadRequest, "invalid_request"},
+	}
+	for i, test := range tests {
+		err := test.Call(test.Client)
+		assert.True(t, errors.Is(err, test.Err), "Error for test %d not as expected: %v", i, err)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("Error for test %d is not an *Error: %v", i, err)
+			continue
+		}
+		assert.Equal(t, test.Status, e.StatusCode, "Status code not as expected for test %d", i)
+		assert.Equal(t, test.Code, e.Code, "Error code not as expected for test %d", i)
+		assert.NotEmpty(t, e.Message, "No error message for test %d", i)
+		assert.NotEmpty(t, e.RequestID, "No request ID for test %d", i)
+	}
+}
+
+func TestError_Unwrap(t *testing.T) {
+	var tests = []struct {
+		Status int
+		Code   string
+		Err    error
+	}{
+		{http.StatusForbidden, "already_enrolled", ErrAlreadyEnrolled},
+		{http.StatusForbidden, "forbidden", ErrForbidden},
+		{http.StatusLocked, "", ErrLocked},
+		{http.StatusForbidden, "locked", ErrLocked},
+		{http.StatusNotFound, "not_found", ErrNotFound},
+		{http.StatusInternalServerError, "internal_error", ErrServer},
+		{http.StatusUnauthorized, "", ErrUnauthorized},
+		{http.StatusServiceUnavailable, "", ErrUnavailable},
+		{http.StatusBadGateway, "", ErrUnavailable},
+		{http.StatusTeapot, "", ErrServer},
+	}
+	for _, test := range tests {
+		err := &Error{StatusCode: test.Status, Code: test.Code}
+		assert.True(t, errors.Is(err, test.Err), "Error for %d %s not as expected: %v", test.Status, test.Code, err.Unwrap())
+	}
+}
+
+func TestClient_Retries(t *testing.T) {
+	c := testConfig()
+	c.WithApplication(testApp, []string{testAppKey}, "", []string{"testapp"})
+	api := apiHandler(c)
+	var attempts int32
+	//The MFA server is unavailable for the first two attempts
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		api.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+	cl, _ := New(s.URL)
+	cl.WithAPIKey(testApp, testAppKey)
+	u := User{Issuer: "otherapp", Domain: "testdom", Username: "validuser", Password: "validpassword"}
+
+	err := cl.Validate(context.Background(), u, "123456")
+	assert.True(t, errors.Is(err, ErrUnavailable), "Unavailable server not reported without retries: %v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts), "Request retried when retries not configured")
+
+	atomic.StoreInt32(&attempts, 0)
+	cl.WithRetries(2, time.Millisecond)
+	err = cl.Validate(context.Background(), u, "123456")
+	assert.True(t, errors.Is(err, ErrForbidden), "Request not retried until the server was available: %v", err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts), "Number of attempts not as expected")
+
+	//Errors from the handlers are not retried
+	atomic.StoreInt32(&attempts, 2)
+	err = cl.Validate(context.Background(), u, "123456")
+	assert.True(t, errors.Is(err, ErrForbidden), "Error not as expected: %v", err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts), "Request failed by the handler was retried")
+
+	//A server that cannot be reached
+	s.Close()
+	cl.WithRetries(1, time.Millisecond)
+	err = cl.Validate(context.Background(), u, "123456")
+	assert.True(t, errors.Is(err, ErrUnavailable), "Unreachable server not reported: %v", err)
+	var e *Error
+	assert.False(t, errors.As(err, &e), "Unreachable server should not have a response error")
+}
+
+func TestClient_TLS(t *testing.T) {
+	c := testConfig()
+	c.WithApplication(testApp, []string{testAppKey}, "", []string{"testapp"})
+	s := httptest.NewTLSServer(apiHandler(c))
+	defer s.Close()
+	u := User{Issuer: "otherapp", Domain: "testdom", Username: "validuser", Password: "validpassword"}
+
+	cl, _ := New(s.URL)
+	cl.WithAPIKey(testApp, testAppKey)
+	err := cl.Validate(context.Background(), u, "123456")
+	assert.True(t, errors.Is(err, ErrUnavailable), "Untrusted server certificate should fail: %v", err)
+
+	cl.WithTLSConfig(&tls.Config{RootCAs: s.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs})
+	err = cl.Validate(context.Background(), u, "123456")
+	assert.True(t, errors.Is(err, ErrForbidden), "Request not made over TLS: %v", err)
+}
+
+// TestClient runs the client against the handlers with the mock LDAP directory and Vault.
+func TestClient(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	c := testConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	c.WithLDAPAdminSettings("cn=mfaadmin,ou=groups,dc=example,dc=com", "memberUid", "{username}")
+	c.WithApplication(testApp, []string{testAppKey}, testAppSecret, []string{"testapp"})
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	s := httptest.NewServer(apiHandler(c))
+	defer s.Close()
+	cl, _ := New(s.URL)
+	cl.WithSignature(testApp, testAppSecret)
+	ctx := context.Background()
+	u := User{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "validpassword"}
+
+	secret, err := cl.Enrol(ctx, u)
+	if err != nil {
+		t.Fatalf("Error enroling: %v", err)
+	}
+	assert.NotEmpty(t, secret.Secret, "No secret returned")
+	assert.Contains(t, secret.URI(), "secret="+secret.Secret, "Secret not in the URI")
+	_, err = cl.Enrol(ctx, u)
+	assert.True(t, errors.Is(err, ErrAlreadyEnrolled), "Enroling again not rejected as expected: %v", err)
+
+	otp, _, _ := gootp.GetTOTPNow(secret.Secret, sha1.New, 6)
+	assert.NoError(t, cl.Validate(ctx, u, otp), "Valid OTP not accepted")
+	err = cl.Validate(ctx, User{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "invalidpassword"}, otp)
+	assert.True(t, errors.Is(err, ErrUnauthorized), "Invalid password not rejected as expected: %v", err)
+
+	img, err := cl.UpdateQRCode(ctx, u, otp)
+	if err != nil {
+		t.Fatalf("Error updating: %v", err)
+	}
+	if _, err := png.Decode(bytes.NewReader(img)); err != nil {
+		t.Errorf("QR code not a PNG: %v", err)
+	}
+	//The secret the QR code is of is not known, so the user can no longer authenticate with the OTP of the old secret
+	err = cl.Delete(ctx, u, otp)
+	assert.True(t, errors.Is(err, ErrUnauthorized), "OTP of the replaced secret accepted: %v", err)
+
+	err = cl.AdminDelete(ctx, Admin{Username: "validuser", Password: "invalidpassword"}, u)
+	assert.True(t, errors.Is(err, ErrInvalidRequest), "Administrator with an invalid password not rejected as expected: %v", err)
+	assert.NoError(t, cl.AdminDelete(ctx, Admin{Username: "validuser", Password: "validpassword"}, u), "Administrator could not delete the user's secret")
+
+	//The user can enrol again once their secret is deleted
+	secret, err = cl.Enrol(ctx, u)
+	if err != nil {
+		t.Fatalf("Error enroling again: %v", err)
+	}
+	otp, _, _ = gootp.GetTOTPNow(secret.Secret, sha1.New, 6)
+	updated, err := cl.Update(ctx, u, otp)
+	if err != nil {
+		t.Fatalf("Error updating: %v", err)
+	}
+	assert.NotEqual(t, secret.Secret, updated.Secret, "Secret not replaced")
+	otp, _, _ = gootp.GetTOTPNow(updated.Secret, sha1.New, 6)
+	assert.NoError(t, cl.Delete(ctx, u, otp), "User could not delete their own secret")
+	err = cl.Validate(ctx, u, otp)
+	assert.True(t, errors.Is(err, ErrUnauthorized), "Deleted user not rejected: %v", err)
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Reasons a request to the MFA server failed, to test the errors returned by the client with errors.Is.
+var (
+	// ErrInvalidRequest is returned if a required value is missing from the request.
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrUnauthorized is returned if the application, the user's password or OTP, or the administrator could not be
+	// authenticated or authorised.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned if the application is not permitted to use the issuer.
+	ErrForbidden = errors.New("forbidden")
+	// ErrAlreadyEnrolled is returned if the user to enrol already has a secret.
+	ErrAlreadyEnrolled = errors.New("already enrolled")
+	// ErrLocked is returned if the user is locked out, indicated by the "locked" error code or HTTP response code 423.
+	// The MFA server does not currently lock users out, so it is only returned by servers that do.
+	ErrLocked = errors.New("locked")
+	// ErrNotFound is returned if the feature requested is not enabled on the MFA server.
+	ErrNotFound = errors.New("not found")
+	// ErrUnavailable is returned if the MFA server could not be reached or was unavailable.
+	ErrUnavailable = errors.New("unavailable")
+	// ErrServer is returned for other failures of the MFA server.
+	ErrServer = errors.New("server error")
+)
+
+// Errors returned for the error codes of the REST API's error responses
+var codeErrors = map[string]error{
+	"invalid_request":  ErrInvalidRequest,
+	"unauthorized":     ErrUnauthorized,
+	"forbidden":        ErrForbidden,
+	"already_enrolled": ErrAlreadyEnrolled,
+	"locked":           ErrLocked,
+	"not_found":        ErrNotFound,
+	"internal_error":   ErrServer,
+}
+
+// Error is a request that the MFA server responded to with an error.
+type Error struct {
+	// StatusCode is the HTTP response code.
+	StatusCode int
+	// Code is the error code of the response, such as "already_enrolled".
+	Code string
+	// Message describes the error.
+	Message string
+	// RequestID identifies the request in the MFA server's logs.
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	s := "MFA server responded with " + http.StatusText(e.StatusCode)
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		s += " (request ID " + e.RequestID + ")"
+	}
+	return s
+}
+
+// Unwrap returns the Err value for the reason the request failed.
+func (e *Error) Unwrap() error {
+	if err, ok := codeErrors[e.Code]; ok {
+		return err
+	}
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return ErrInvalidRequest
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusLocked:
+		return ErrLocked
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrUnavailable
+	}
+	return ErrServer
+}
+
+// transportError is a request that could not be made or whose response could not be read.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return "Could not make request to the MFA server: " + e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// Is reports the request failed as the MFA server was unavailable.
+func (e *transportError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// responseError returns the error of the response with the body read from it.
+func responseError(resp *http.Response, body []byte) error {
+	e := &Error{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+	var d struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id"`
+	}
+	if json.Unmarshal(body, &d) == nil {
+		e.Code = d.Code
+		e.Message = d.Message
+		if d.RequestID != "" {
+			e.RequestID = d.RequestID
+		}
+	}
+	return e
+}
+
+// retryable reports whether the request may succeed if it is made again.
+func retryable(err error) bool {
+	return errors.Is(err, ErrUnavailable)
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Path within the MFA secrets path where applications registered in the secrets store are held
+const ApplicationStorePath = "/_applications"
+
+// ApplicationConf defines the credentials of an application calling the REST API and the issuers it may use.
+type ApplicationConf struct {
+	APIKeys            []string `json:"APIKeys"`
+	HMACSecret         string   `json:"HMACSecret"`
+	ClientCertificates []string `json:"ClientCertificates"`
+	Issuers            []string `json:"Issuers"`
+}
+
+func (a *ApplicationConf) validate() error {
+	if len(a.APIKeys) == 0 && a.HMACSecret == "" && len(a.ClientCertificates) == 0 {
+		return errors.New("No APIKeys, HMACSecret or ClientCertificates defined")
+	}
+	for _, k := range a.APIKeys {
+		if k == "" {
+			return errors.New("APIKeys cannot be empty")
+		}
+	}
+	if len(a.Issuers) == 0 {
+		return errors.New("No Issuers defined")
+	}
+	return nil
+}
+
+// IssuerPermitted reports whether the application may enrol and validate users of the issuer. "*" permits all issuers.
+func (a *ApplicationConf) IssuerPermitted(issuer string) bool {
+	return StringInSlice(issuer, a.Issuers) || StringInSlice("*", a.Issuers)
+}
+
+// CertificatePermitted reports whether a client certificate with the identities authenticates the application.
+func (a *ApplicationConf) CertificatePermitted(ids []string) bool {
+	for _, id := range ids {
+		if StringInSlice(id, a.ClientCertificates) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewApplicationFromStore creates the settings of an application from the values held for it in the secrets store.
+// API keys, client certificate identities and issuers are comma separated lists.
+func NewApplicationFromStore(m map[string]interface{}) (*ApplicationConf, error) {
+	a := new(ApplicationConf)
+	if v, ok := m["apikeys"].(string); ok && v != "" {
+		a.APIKeys = splitList(v)
+	}
+	if v, ok := m["hmacsecret"].(string); ok {
+		a.HMACSecret = v
+	}
+	if v, ok := m["clientcertificates"].(string); ok && v != "" {
+		a.ClientCertificates = splitList(v)
+	}
+	if v, ok := m["issuers"].(string); ok && v != "" {
+		a.Issuers = splitList(v)
+	}
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// WithApplication registers an application permitted to call the REST API for the issuers.
+func (c *Config) WithApplication(name string, keys []string, hmacSecret string, issuers []string) (*Config, error) {
+	return c.withApplication(name, &ApplicationConf{
+		APIKeys:    keys,
+		HMACSecret: hmacSecret,
+		Issuers:    issuers,
+	})
+}
+
+// WithApplicationClientCertificates registers an application authenticated by a client certificate with one of the
+// identities. An identity is the subject distinguished name, subject common name or a DNS, email or URI subject
+// alternative name of the certificate.
+func (c *Config) WithApplicationClientCertificates(name string, ids []string, issuers []string) (*Config, error) {
+	return c.withApplication(name, &ApplicationConf{
+		ClientCertificates: ids,
+		Issuers:            issuers,
+	})
+}
+
+func (c *Config) withApplication(name string, a *ApplicationConf) (*Config, error) {
+	if err := a.validate(); err != nil {
+		return c, errors.New("Invalid configuration for application " + name + ": " + err.Error())
+	}
+	if c.Applications == nil {
+		c.Applications = make(map[string]*ApplicationConf)
+	}
+	c.Applications[name] = a
+	return c, nil
+}
+
+// WithApplicationStore looks up applications that are not in the configuration in the secrets store.
+func (c *Config) WithApplicationStore() *Config {
+	c.ApplicationStore = true
+	return c
+}
+
+// ApplicationAuthRequired reports whether requests to the REST API must be made by a registered application.
+func (c *Config) ApplicationAuthRequired() bool {
+	return len(c.Applications) > 0 || c.ApplicationStore
+}
+
+// Application returns the settings of the application if it is registered in the configuration.
+func (c *Config) Application(name string) (*ApplicationConf, bool) {
+	a, ok := c.Applications[name]
+	return a, ok && a != nil
+}
+
+// ApplicationForCertificate returns the application in the configuration that a client certificate with the identities
+// authenticates.
+func (c *Config) ApplicationForCertificate(ids []string) (string, *ApplicationConf, bool) {
+	//Check in name order so the same application is always chosen if more than one matches
+	var names []string
+	for n := range c.Applications {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if a := c.Applications[n]; a != nil && a.CertificatePermitted(ids) {
+			return n, a, true
+		}
+	}
+	return "", nil, false
+}
+
+func splitList(v string) []string {
+	var l []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			l = append(l, s)
+		}
+	}
+	return l
+}
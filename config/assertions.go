@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"github.com/jcmturner/mfaserver/assertion"
+	"time"
+)
+
+// Defaults for the signed assertions issued on successful validation
+const (
+	defaultAssertionIssuer   = "mfaserver"
+	defaultAssertionLifetime = 5 * time.Minute
+)
+
+// AssertionConf defines the keys used to sign the assertions (JWTs) issued when a user's OTP is validated.
+// The first key signs new assertions. All keys are published so that assertions signed with a retired key can still be verified.
+type AssertionConf struct {
+	Issuer   string   `json:"Issuer"`
+	Lifetime int      `json:"Lifetime"`
+	KeyFiles []string `json:"KeyFiles"`
+	Keys     []*assertion.Key
+}
+
+// WithAssertionKey adds a key used to sign assertions. The first key added signs new assertions.
+func (c *Config) WithAssertionKey(k *assertion.Key) *Config {
+	c.Assertions.Keys = append(c.Assertions.Keys, k)
+	return c
+}
+
+// WithAssertionKeyFile adds the PEM encoded private key in the file as a key used to sign assertions.
+func (c *Config) WithAssertionKeyFile(p string) (*Config, error) {
+	k, err := assertion.LoadKey(p)
+	if err != nil {
+		return c, err
+	}
+	c.Assertions.KeyFiles = append(c.Assertions.KeyFiles, p)
+	return c.WithAssertionKey(k), nil
+}
+
+func (c *Config) WithAssertionIssuer(iss string, lifetime time.Duration) *Config {
+	c.Assertions.Issuer = iss
+	c.Assertions.Lifetime = int(lifetime / time.Second)
+	return c
+}
+
+func (c *Config) loadAssertionKeys() error {
+	if c.Assertions.Lifetime < 0 {
+		return errors.New("Lifetime cannot be negative")
+	}
+	files := c.Assertions.KeyFiles
+	c.Assertions.KeyFiles = nil
+	for _, f := range files {
+		if _, err := c.WithAssertionKeyFile(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AssertionsEnabled reports whether signed assertions can be issued.
+func (c *Config) AssertionsEnabled() bool {
+	return len(c.Assertions.Keys) > 0
+}
+
+// AssertionSigningKey returns the key used to sign new assertions.
+func (c *Config) AssertionSigningKey() *assertion.Key {
+	if len(c.Assertions.Keys) == 0 {
+		return nil
+	}
+	return c.Assertions.Keys[0]
+}
+
+// AssertionIssuer returns the value of the "iss" claim of the assertions.
+func (c *Config) AssertionIssuer() string {
+	if c.Assertions.Issuer != "" {
+		return c.Assertions.Issuer
+	}
+	return defaultAssertionIssuer
+}
+
+// AssertionLifetime returns how long an assertion is valid for.
+func (c *Config) AssertionLifetime() time.Duration {
+	if c.Assertions.Lifetime > 0 {
+		return time.Duration(c.Assertions.Lifetime) * time.Second
+	}
+	return defaultAssertionLifetime
+}
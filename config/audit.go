@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"github.com/jcmturner/mfaserver/assertion"
+	"github.com/jcmturner/mfaserver/audit"
+	"time"
+)
+
+// Default interval between signatures of the audit log
+const defaultAuditSignInterval = 5 * time.Minute
+
+// AuditConf defines where the audit log of security events is written and the key that signs it.
+type AuditConf struct {
+	File           *string          `json:"File"`
+	Syslog         *AuditSyslogConf `json:"Syslog"`
+	SigningKeyFile *string          `json:"SigningKeyFile"`
+	SignInterval   int              `json:"SignInterval"`
+	Key            *assertion.Key
+	Log            *audit.Log
+}
+
+// AuditSyslogConf defines the syslog server the audit log is sent to. If the Network and Address are empty the local
+// syslog server is used.
+type AuditSyslogConf struct {
+	Network string `json:"Network"`
+	Address string `json:"Address"`
+	Tag     string `json:"Tag"`
+}
+
+// WithAuditLog writes the audit log to the file, continuing the chain of records already in it. If a key is provided
+// the log is signed at the interval.
+func (c *Config) WithAuditLog(path string, key *assertion.Key, interval time.Duration) (*Config, error) {
+	c.Audit.File = &path
+	c.Audit.Key = key
+	c.Audit.SignInterval = int(interval / time.Second)
+	c.Audit.Log = nil
+	if err := c.OpenAuditLog(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func (c *Config) validateAudit() error {
+	if c.Audit.SignInterval < 0 {
+		return errors.New("SignInterval cannot be negative")
+	}
+	if c.Audit.File == nil && c.Audit.Syslog == nil {
+		if c.Audit.SigningKeyFile != nil {
+			return errors.New("No File or Syslog defined for the audit log")
+		}
+		return nil
+	}
+	if c.Audit.SigningKeyFile != nil {
+		k, err := assertion.LoadKey(*c.Audit.SigningKeyFile)
+		if err != nil {
+			return err
+		}
+		c.Audit.Key = k
+	}
+	return nil
+}
+
+// AuditEnabled reports whether security events are written to an audit log.
+func (c *Config) AuditEnabled() bool {
+	return c.Audit.File != nil || c.Audit.Syslog != nil
+}
+
+// AuditSignInterval returns how often the audit log is signed.
+func (c *Config) AuditSignInterval() time.Duration {
+	return secondsOrDefault(c.Audit.SignInterval, defaultAuditSignInterval)
+}
+
+// OpenAuditLog opens the audit log if one is configured and it is not already open. Only one audit log may write to a
+// file at a time so, when the configuration is reloaded, the previous audit log hands over to the one opened.
+func (c *Config) OpenAuditLog() error {
+	if !c.AuditEnabled() || c.Audit.Log != nil {
+		return nil
+	}
+	l := audit.New(c.Audit.Key, c.AuditSignInterval())
+	if c.Audit.File != nil {
+		if err := l.WithFile(*c.Audit.File); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	if s := c.Audit.Syslog; s != nil {
+		if err := l.WithSyslog(s.Network, s.Address, s.Tag); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	c.Audit.Log = l
+	return nil
+}
+
+// RecordAuditEvent writes the security event to the audit log and sends it to the SIEM if they are configured. The
+// webhooks are notified if it is a lifecycle event.
+func (c *Config) RecordAuditEvent(e audit.Event) {
+	c.sendSIEMEvent(e)
+	c.sendWebhookEvent(e)
+	if c.Audit.Log == nil {
+		return
+	}
+	if err := c.Audit.Log.Write(e); err != nil {
+		c.MFAServer.Loggers.Error.Printf("Audit event %s for %s/%s could not be written: %v", e.Type, e.Domain, e.Username, err)
+	}
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// crlFile holds the certificate revocation lists of a file. The file is re-read when it is modified.
+type crlFile struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	lists   []*x509.RevocationList
+}
+
+// WithMFAClientCA verifies the certificates presented by clients against the CA certificates in the PEM file.
+// If required is true clients that do not present a valid certificate cannot connect.
+func (c *Config) WithMFAClientCA(path string, required bool) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, errors.New("Could not read client CA file " + path + ": " + err.Error())
+	}
+	p := x509.NewCertPool()
+	if !p.AppendCertsFromPEM(b) {
+		return c, errors.New("No PEM encoded certificates found in client CA file " + path)
+	}
+	c.MFAServer.TLS.ClientCAFile = &path
+	c.MFAServer.TLS.ClientCAs = p
+	c.MFAServer.TLS.RequireClientCertificate = required
+	return c, nil
+}
+
+// WithMFAClientCRL rejects client certificates that are revoked by the certificate revocation lists in the file.
+// The file may be PEM or DER encoded and is re-read when it is modified so it can be updated without a restart.
+func (c *Config) WithMFAClientCRL(path string) (*Config, error) {
+	f := &crlFile{path: path}
+	if _, err := f.load(); err != nil {
+		return c, err
+	}
+	c.MFAServer.TLS.ClientCRLFile = &path
+	c.MFAServer.TLS.clientCRL = f
+	return c, nil
+}
+
+func (c *Config) validateClientTLS() error {
+	t := c.MFAServer.TLS
+	if t.ClientCAFile == nil {
+		if t.ClientCRLFile != nil || t.RequireClientCertificate {
+			return errors.New("No ClientCAFile defined")
+		}
+		return nil
+	}
+	if _, err := c.WithMFAClientCA(*t.ClientCAFile, t.RequireClientCertificate); err != nil {
+		return err
+	}
+	if t.ClientCRLFile != nil {
+		if _, err := c.WithMFAClientCRL(*t.ClientCRLFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MFATLSConfig returns the TLS configuration for the MFA server's listener.
+func (c *Config) MFATLSConfig() *tls.Config {
+	t := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.MFAServer.TLS.Certificate != nil {
+		t.Certificates = []tls.Certificate{*c.MFAServer.TLS.Certificate}
+	}
+	if c.MFAServer.TLS.ClientCAs == nil {
+		return t
+	}
+	t.ClientCAs = c.MFAServer.TLS.ClientCAs
+	t.ClientAuth = tls.VerifyClientCertIfGiven
+	if c.MFAServer.TLS.RequireClientCertificate {
+		t.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if c.MFAServer.TLS.clientCRL != nil {
+		t.VerifyPeerCertificate = c.MFAServer.TLS.clientCRL.verifyChains
+	}
+	return t
+}
+
+// verifyChains rejects a verified certificate chain if any certificate in it has been revoked by its issuer.
+func (f *crlFile) verifyChains(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+	if len(chains) == 0 {
+		return nil
+	}
+	lists, err := f.load()
+	if err != nil {
+		return err
+	}
+	for _, chain := range chains {
+		if err := checkRevocation(chain, lists); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkRevocation(chain []*x509.Certificate, lists []*x509.RevocationList) error {
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		for _, l := range lists {
+			if !bytes.Equal(l.RawIssuer, issuer.RawSubject) || l.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+			//Fail closed rather than trust a list that may be missing recent revocations
+			if !l.NextUpdate.IsZero() && time.Now().After(l.NextUpdate) {
+				return errors.New("The CRL of " + issuer.Subject.String() + " has expired")
+			}
+			for _, rc := range l.RevokedCertificateEntries {
+				if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return errors.New("Client certificate " + cert.Subject.String() + " has been revoked")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (f *crlFile) load() ([]*x509.RevocationList, error) {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return nil, errors.New("Could not open CRL file: " + err.Error())
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.lists != nil && f.modTime.Equal(fi.ModTime()) {
+		return f.lists, nil
+	}
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, errors.New("Could not read CRL file: " + err.Error())
+	}
+	lists, err := parseCRLs(b)
+	if err != nil {
+		return nil, errors.New("CRL file " + f.path + " not valid: " + err.Error())
+	}
+	f.lists = lists
+	f.modTime = fi.ModTime()
+	return lists, nil
+}
+
+func parseCRLs(b []byte) ([]*x509.RevocationList, error) {
+	var lists []*x509.RevocationList
+	if !bytes.Contains(b, []byte("-----BEGIN")) {
+		l, err := x509.ParseRevocationList(b)
+		if err != nil {
+			return nil, err
+		}
+		return append(lists, l), nil
+	}
+	for {
+		var blk *pem.Block
+		blk, b = pem.Decode(b)
+		if blk == nil {
+			break
+		}
+		if blk.Type != "X509 CRL" {
+			continue
+		}
+		l, err := x509.ParseRevocationList(blk.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	if len(lists) == 0 {
+		return nil, errors.New("No PEM encoded CRLs found")
+	}
+	return lists, nil
+}
+
+// CertificateIdentities returns the names that identify the holder of a client certificate: the subject distinguished
+// name, the subject common name and the DNS, email and URI subject alternative names.
+func CertificateIdentities(cert *x509.Certificate) []string {
+	ids := []string{cert.Subject.String()}
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return ids
+}
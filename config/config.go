@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -8,6 +9,8 @@ import (
 	"errors"
 	"fmt"
 	vaultAPI "github.com/hashicorp/vault/api"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/mfaserver/cache"
 	"github.com/jcmturner/mfaserver/vault"
 	"github.com/jcmturner/restclient"
 	"github.com/mavricknz/ldap"
@@ -19,14 +22,43 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var validLogLevels = []string{"ERROR", "WARNING", "INFO", "DEBUG"}
 
+// Methods of resolving administrators who are members of the admin groups via nested groups
+const (
+	NestedGroupsNone      = ""
+	NestedGroupsInChain   = "InChain"
+	NestedGroupsRecursive = "Recursive"
+)
+
+var validNestedGroupModes = []string{NestedGroupsNone, NestedGroupsInChain, NestedGroupsRecursive}
+
 type Config struct {
-	Vault     VaultConf `json:"Vault"`
-	MFAServer MFAServer `json:"MFAServer"`
-	LDAP      LDAPConf  `json:"LDAP"`
+	Vault            VaultConf                   `json:"Vault"`
+	MFAServer        MFAServer                   `json:"MFAServer"`
+	LDAP             LDAPConf                    `json:"LDAP"`
+	LDAPDomains      map[string]*LDAPConf        `json:"LDAPDomains"`
+	AdminRoles       []AdminRole                 `json:"AdminRoles"`
+	AdminCache       AdminCacheConf              `json:"AdminGroupCache"`
+	Issuers          map[string]*IssuerConf      `json:"Issuers"`
+	Kerberos         KerberosConf                `json:"Kerberos"`
+	RADIUS           RADIUSConf                  `json:"RADIUS"`
+	ForwardAuth      ForwardAuthConf             `json:"ForwardAuth"`
+	Assertions       AssertionConf               `json:"Assertions"`
+	OIDC             OIDCConf                    `json:"OIDC"`
+	Applications     map[string]*ApplicationConf `json:"Applications"`
+	ApplicationStore bool                        `json:"ApplicationStore"`
+	Audit            AuditConf                   `json:"Audit"`
+	Webhooks         WebhooksConf                `json:"Webhooks"`
+	Tracing          TracingConf                 `json:"Tracing"`
+	Request          *RequestLog                 `json:"-"`
+	loaded           *Config
+	ctx              context.Context
+	usage            *usage
+	loggers          *currentLoggers
 }
 
 type VaultConf struct {
@@ -42,45 +74,92 @@ type VaultConf struct {
 }
 
 type LDAPConf struct {
-	EndPoint            *string `json:"EndPoint"`
-	TrustCACert         *string `json:"TrustCACert"`
-	UserDN              *string `json:"UserDN"`
-	AdminGroupDN        *string `json:"AdminGroupDN"`
-	AdminMembershipAttr *string `json:"AdminGroupMembershipAttribute"`
-	AdminMemberUserDN   *string `json:"AdminGroupMemberDNFormat"`
+	EndPoint            *string  `json:"EndPoint"`
+	TrustCACert         *string  `json:"TrustCACert"`
+	UserDN              *string  `json:"UserDN"`
+	AdminGroupDN        *string  `json:"AdminGroupDN"`
+	AdminGroupDNs       []string `json:"AdminGroupDNs"`
+	AdminMembershipAttr *string  `json:"AdminGroupMembershipAttribute"`
+	AdminMemberUserDN   *string  `json:"AdminGroupMemberDNFormat"`
+	AdminMemberOfAttr   *string  `json:"AdminUserMemberOfAttribute"`
+	AdminNestedGroups   string   `json:"AdminNestedGroups"`
 	LDAPConnection      *ldap.LDAPConnection
 }
 
+// AdminCacheConf defines the caching of administrator group membership. TTLs are in seconds and a TTL of zero disables the cache.
+type AdminCacheConf struct {
+	TTL         int `json:"TTL"`
+	NegativeTTL int `json:"NegativeTTL"`
+	MaxEntries  int `json:"MaxEntries"`
+	Cache       *cache.Membership
+}
+
+// KerberosConf defines how SPNEGO Kerberos tokens presented as the first factor are validated.
+type KerberosConf struct {
+	KeytabFile       *string           `json:"KeytabFile"`
+	ServicePrincipal *string           `json:"ServicePrincipal"`
+	RealmDomains     map[string]string `json:"RealmDomains"`
+	Keytab           *keytab.Keytab
+}
+
 type UserIdFile struct {
 	UserID string `json:"UserID"`
 }
 
 type MFAServer struct {
-	ListenerSocket *string `json:"ListenerSocket"`
-	TLS            TLS     `json:"TLS"`
-	LogFilePath    *string `json:"LogFile"`
-	LogLevel       *string `json:"LogLevel"`
-	Loggers        *Loggers
+	ListenerSocket     *string   `json:"ListenerSocket"`
+	GRPCListenerSocket *string   `json:"GRPCListenerSocket"`
+	TLS                TLS       `json:"TLS"`
+	LogFilePath        *string   `json:"LogFile"`
+	LogLevel           *string   `json:"LogLevel"`
+	LogFormat          *string   `json:"LogFormat"`
+	SIEM               *SIEMConf `json:"SIEM"`
+	ReadTimeout        int       `json:"ReadTimeout"`
+	WriteTimeout       int       `json:"WriteTimeout"`
+	IdleTimeout        int       `json:"IdleTimeout"`
+	ShutdownTimeout    int       `json:"ShutdownTimeout"`
+	ShutdownDelay      int       `json:"ShutdownDelay"`
+	Loggers            *Loggers
 }
 
 type TLS struct {
-	Enabled         bool    `json:"Enabled"`
-	CertificateFile *string `json:"CertificateFile"`
-	KeyFile         *string `json:"KeyFile"`
+	Enabled                  bool    `json:"Enabled"`
+	CertificateFile          *string `json:"CertificateFile"`
+	KeyFile                  *string `json:"KeyFile"`
+	ClientCAFile             *string `json:"ClientCAFile"`
+	ClientCRLFile            *string `json:"ClientCRLFile"`
+	RequireClientCertificate bool    `json:"RequireClientCertificate"`
+	Certificate              *tls.Certificate
+	ClientCAs                *x509.CertPool
+	clientCRL                *crlFile
 }
 
 type Loggers struct {
-	Debug   *log.Logger
-	Info    *log.Logger
-	Warning *log.Logger
-	Error   *log.Logger
+	Debug     *log.Logger
+	Info      *log.Logger
+	Warning   *log.Logger
+	Error     *log.Logger
+	out       io.Writer
+	format    string
+	requestID string
+	//The log file opened for LogFile, kept so that it is not opened again when the configuration is reloaded
+	file     *os.File
+	filePath string
 }
 
 func NewConfig() *Config {
 	defSecPath := "secret/mfa"
 	defSocket := "0.0.0.0:8443"
 	dl := log.New(ioutil.Discard, "", os.O_APPEND)
+	l := &Loggers{
+		Debug:   dl,
+		Info:    dl,
+		Warning: dl,
+		Error:   dl,
+	}
 	return &Config{
+		usage:   new(usage),
+		loggers: newCurrentLoggers(l),
 		Vault: VaultConf{
 			VaultReSTClientConfig: restclient.NewConfig(),
 			VaultConfig:           vaultAPI.DefaultConfig(),
@@ -88,39 +167,49 @@ func NewConfig() *Config {
 		},
 		MFAServer: MFAServer{
 			ListenerSocket: &defSocket,
-			Loggers: &Loggers{
-				Debug:   dl,
-				Info:    dl,
-				Warning: dl,
-				Error:   dl,
-			},
+			Loggers:        l,
 		},
 	}
 }
 
 func loggerSetUp(c *Config) error {
 	var logfile io.Writer
+	l := c.MFAServer.Loggers
 	if c.MFAServer.LogFilePath != nil {
-		var err error
-		logfile, err = os.OpenFile(*c.MFAServer.LogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0664)
-		if err != nil {
-			return err
+		if l.file == nil || l.filePath != *c.MFAServer.LogFilePath {
+			f, err := os.OpenFile(*c.MFAServer.LogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0664)
+			if err != nil {
+				return err
+			}
+			l.file = f
+			l.filePath = *c.MFAServer.LogFilePath
 		}
+		logfile = l.file
 	} else {
+		l.file = nil
+		l.filePath = ""
 		logfile = os.Stdout
 	}
-	c.MFAServer.Loggers.Error = log.New(logfile, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+	l.out = logfile
+	l.format = LogFormatText
+	if c.MFAServer.LogFormat != nil {
+		if !isValidLogFormat(*c.MFAServer.LogFormat) {
+			return errors.New(fmt.Sprintf("An invalid log format was provided. Accepted values are %v", validLogFormats))
+		}
+		l.format = *c.MFAServer.LogFormat
+	}
+	l.Error = l.newLogger("ERROR")
 	if c.MFAServer.LogLevel != nil && isValidLogLevel(*c.MFAServer.LogLevel) {
 		switch *c.MFAServer.LogLevel {
 		case "DEBUG":
-			c.MFAServer.Loggers.Debug = log.New(logfile, "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile)
-			c.MFAServer.Loggers.Info = log.New(logfile, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-			c.MFAServer.Loggers.Warning = log.New(logfile, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile)
+			l.Debug = l.newLogger("DEBUG")
+			l.Info = l.newLogger("INFO")
+			l.Warning = l.newLogger("WARNING")
 		case "INFO":
-			c.MFAServer.Loggers.Info = log.New(logfile, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-			c.MFAServer.Loggers.Warning = log.New(logfile, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile)
+			l.Info = l.newLogger("INFO")
+			l.Warning = l.newLogger("WARNING")
 		case "WARNING":
-			c.MFAServer.Loggers.Warning = log.New(logfile, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile)
+			l.Warning = l.newLogger("WARNING")
 		}
 		return nil
 	} else {
@@ -133,12 +222,32 @@ func Load(cfgPath string) (*Config, error) {
 	if err != nil {
 		return nil, errors.New("Configuration file could not be openned: " + cfgPath + " " + err.Error())
 	}
+	return load(j)
+}
 
-	c := NewConfig()
+func load(j []byte) (*Config, error) {
+	return loadReplacing(j, nil)
+}
+
+// loadReplacing loads the configuration that is to replace the old configuration, if there is one. The old
+// configuration's log file continues to be written to if LogFile is unchanged.
+func loadReplacing(j []byte, old *Config) (c *Config, err error) {
+	c = NewConfig()
 	err = json.Unmarshal(j, c)
 	if err != nil {
 		return nil, errors.New("Configuration file could not be parsed: " + err.Error())
 	}
+	l := c.MFAServer.Loggers
+	if old != nil {
+		l.file = old.MFAServer.Loggers.file
+		l.filePath = old.MFAServer.Loggers.filePath
+	}
+	defer func() {
+		//A log file opened for a configuration that is not valid would otherwise be left open
+		if err != nil && l.file != nil && (old == nil || l.file != old.MFAServer.Loggers.file) {
+			l.file.Close()
+		}
+	}()
 	err = loggerSetUp(c)
 	if err != nil {
 		return nil, errors.New("Configuration failed in setting up logging: " + err.Error())
@@ -162,10 +271,116 @@ func Load(cfgPath string) (*Config, error) {
 		if err != nil {
 			return nil, errors.New("TLS configuration for MFA Server not valid: " + err.Error())
 		}
+		if err := c.validateClientTLS(); err != nil {
+			return nil, errors.New("Client certificate configuration for MFA Server not valid: " + err.Error())
+		}
+	} else if c.MFAServer.TLS.ClientCAFile != nil {
+		return nil, errors.New("A ClientCAFile cannot be used with TLS disabled")
 	}
-	err = c.createLDAPConnection()
-	if err != nil {
-		return nil, errors.New("Error configuring LDAP connection: " + err.Error())
+	if err := c.validateAudit(); err != nil {
+		return nil, errors.New("Audit configuration not valid: " + err.Error())
+	}
+	if err := c.validateWebhooks(); err != nil {
+		return nil, errors.New("Webhooks configuration not valid: " + err.Error())
+	}
+	if err := c.validateTracing(); err != nil {
+		return nil, errors.New("Tracing configuration not valid: " + err.Error())
+	}
+	if err := c.validateSIEM(); err != nil {
+		return nil, errors.New("SIEM configuration not valid: " + err.Error())
+	}
+	if err := c.validateTimeouts(); err != nil {
+		return nil, errors.New("MFAServer timeouts not valid: " + err.Error())
+	}
+	if c.LDAP.EndPoint == nil && len(c.LDAPDomains) == 0 {
+		return nil, errors.New("Configuration file does not define an LDAP directory")
+	}
+	if c.LDAP.EndPoint != nil {
+		err = c.createLDAPConnection()
+		if err != nil {
+			return nil, errors.New("Error configuring LDAP connection: " + err.Error())
+		}
+		if !isValidNestedGroupMode(c.LDAP.AdminNestedGroups) {
+			return nil, errors.New(fmt.Sprintf("An invalid AdminNestedGroups value was provided. Accepted values are %q", validNestedGroupModes))
+		}
+	}
+	if c.AdminCache.TTL < 0 || c.AdminCache.NegativeTTL < 0 || c.AdminCache.MaxEntries < 0 {
+		return nil, errors.New("AdminGroupCache values cannot be negative")
+	}
+	if c.AdminCache.TTL > 0 {
+		c.WithAdminGroupCache(time.Duration(c.AdminCache.TTL)*time.Second, time.Duration(c.AdminCache.NegativeTTL)*time.Second, c.AdminCache.MaxEntries)
+	}
+	if c.Kerberos.KeytabFile != nil {
+		_, err = c.WithKerberosKeytabFile(*c.Kerberos.KeytabFile)
+		if err != nil {
+			return nil, errors.New("Kerberos configuration not valid: " + err.Error())
+		}
+	}
+	if c.MFAServer.GRPCListenerSocket != nil {
+		if _, err := c.WithGRPCListenerSocket(*c.MFAServer.GRPCListenerSocket); err != nil {
+			return nil, err
+		}
+	}
+	if c.RADIUS.ListenerSocket != nil {
+		if err := c.validateRADIUS(); err != nil {
+			return nil, errors.New("RADIUS configuration not valid: " + err.Error())
+		}
+	}
+	if c.ForwardAuth.Issuer != nil {
+		if err := c.validateForwardAuth(); err != nil {
+			return nil, errors.New("ForwardAuth configuration not valid: " + err.Error())
+		}
+		if c.ForwardAuth.KeyFile == nil {
+			c.MFAServer.Loggers.Warning.Println("No ForwardAuth KeyFile is configured. A random session signing key is used so sessions will not survive a restart.")
+		}
+	}
+	if err := c.loadAssertionKeys(); err != nil {
+		return nil, errors.New("Assertions configuration not valid: " + err.Error())
+	}
+	if len(c.OIDC.Clients) > 0 {
+		if err := c.validateOIDC(); err != nil {
+			return nil, errors.New("OIDC configuration not valid: " + err.Error())
+		}
+	}
+	for n, i := range c.Issuers {
+		if i == nil {
+			continue
+		}
+		if i.PasswordVerifier == "" {
+			i.PasswordVerifier = VerifierLDAP
+		}
+		if err := i.validate(); err != nil {
+			return nil, errors.New("Invalid configuration for issuer " + n + ": " + err.Error())
+		}
+		//Only an authenticated application can be trusted to have verified the password
+		if i.PasswordVerifier == VerifierDelegated && !c.ApplicationAuthRequired() {
+			return nil, errors.New("Invalid configuration for issuer " + n + ": The delegated password verifier requires Applications or ApplicationStore to be configured")
+		}
+	}
+	for n, a := range c.Applications {
+		if a == nil {
+			return nil, errors.New("No configuration for application " + n)
+		}
+		if err := a.validate(); err != nil {
+			return nil, errors.New("Invalid configuration for application " + n + ": " + err.Error())
+		}
+	}
+	for _, a := range c.AdminRoles {
+		if err := a.validate(); err != nil {
+			return nil, errors.New("Invalid admin role configuration: " + err.Error())
+		}
+	}
+	for d, l := range c.LDAPDomains {
+		if l == nil || l.EndPoint == nil {
+			return nil, errors.New("LDAP directory for domain " + d + " does not define an EndPoint")
+		}
+		err = l.createConnection()
+		if err != nil {
+			return nil, errors.New("Error configuring LDAP connection for domain " + d + ": " + err.Error())
+		}
+		if !isValidNestedGroupMode(l.AdminNestedGroups) {
+			return nil, errors.New(fmt.Sprintf("An invalid AdminNestedGroups value was provided for domain %s. Accepted values are %q", d, validNestedGroupModes))
+		}
 	}
 	return c, nil
 }
@@ -259,6 +474,15 @@ func (c *Config) WithMFAListenerSocket(s string) (*Config, error) {
 	return c, nil
 }
 
+// WithGRPCListenerSocket serves the gRPC API on the socket, with the same TLS settings as the REST API.
+func (c *Config) WithGRPCListenerSocket(s string) (*Config, error) {
+	if _, err := net.ResolveTCPAddr("tcp", s); err != nil {
+		return c, errors.New("Invalid gRPC listener socket defined for MFA server")
+	}
+	c.MFAServer.GRPCListenerSocket = &s
+	return c, nil
+}
+
 func (c *Config) WithMFATLS(certPath, keyPath string) (*Config, error) {
 	if err := isValidPEMFile(certPath); err != nil {
 		return c, errors.New("MFA Server TLS certificate not valid: " + err.Error())
@@ -266,17 +490,14 @@ func (c *Config) WithMFATLS(certPath, keyPath string) (*Config, error) {
 	if err := isValidPEMFile(keyPath); err != nil {
 		return c, errors.New("MFA Server TLS key not valid: " + err.Error())
 	}
-	if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
-		cert, _ := ioutil.ReadFile(certPath)
-		key, _ := ioutil.ReadFile(keyPath)
-		fmt.Printf("Cert: \n %s\n Key: \n %s", cert, key)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
 		return c, errors.New("Key pair provided not valid: " + err.Error())
 	}
-	c.MFAServer.TLS = TLS{
-		Enabled:         true,
-		CertificateFile: &certPath,
-		KeyFile:         &keyPath,
-	}
+	c.MFAServer.TLS.Enabled = true
+	c.MFAServer.TLS.CertificateFile = &certPath
+	c.MFAServer.TLS.KeyFile = &keyPath
+	c.MFAServer.TLS.Certificate = &cert
 	return c, nil
 }
 
@@ -305,10 +526,15 @@ func isValidPEMFile(p string) error {
 }
 
 func isValidLogLevel(l string) bool {
-	return stringInSlice(l, validLogLevels)
+	return StringInSlice(l, validLogLevels)
+}
+
+func isValidNestedGroupMode(m string) bool {
+	return StringInSlice(m, validNestedGroupModes)
 }
 
-func stringInSlice(a string, list []string) bool {
+// StringInSlice reports whether the string is one of the values in the list.
+func StringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
 			return true
@@ -317,6 +543,45 @@ func stringInSlice(a string, list []string) bool {
 	return false
 }
 
+// WithAdminGroupCache caches administrator group membership decisions.
+// Positive decisions are held for ttl and negative decisions for negativeTTL. A maxEntries of zero does not limit the cache size.
+func (c *Config) WithAdminGroupCache(ttl, negativeTTL time.Duration, maxEntries int) *Config {
+	c.AdminCache.TTL = int(ttl / time.Second)
+	c.AdminCache.NegativeTTL = int(negativeTTL / time.Second)
+	c.AdminCache.MaxEntries = maxEntries
+	c.AdminCache.Cache = cache.NewMembership(ttl, negativeTTL, maxEntries)
+	return c
+}
+
+func (c *Config) WithKerberosKeytabFile(p string) (*Config, error) {
+	kt, err := keytab.Load(p)
+	if err != nil {
+		return c, errors.New("Could not load keytab file " + p + ": " + err.Error())
+	}
+	c.Kerberos.KeytabFile = &p
+	c.Kerberos.Keytab = kt
+	return c, nil
+}
+
+func (c *Config) WithKerberosKeytab(kt *keytab.Keytab) *Config {
+	c.Kerberos.Keytab = kt
+	return c
+}
+
+func (c *Config) WithKerberosServicePrincipal(spn string) *Config {
+	c.Kerberos.ServicePrincipal = &spn
+	return c
+}
+
+// WithKerberosRealmDomain maps users of the Kerberos realm to the domain provided. Unmapped realms are used as the domain.
+func (c *Config) WithKerberosRealmDomain(realm, d string) *Config {
+	if c.Kerberos.RealmDomains == nil {
+		c.Kerberos.RealmDomains = make(map[string]string)
+	}
+	c.Kerberos.RealmDomains[realm] = d
+	return c
+}
+
 func (c *Config) WithLDAPConnection(e, ca, dn string) {
 	c.LDAP.EndPoint = &e
 	c.LDAP.TrustCACert = &ca
@@ -331,10 +596,84 @@ func (c *Config) WithLDAPAdminSettings(gdn, attr, m string) {
 	c.LDAP.AdminMemberUserDN = &m
 }
 
+// WithLDAPAdminGroups sets the list of groups whose members are administrators and how nested groups are resolved.
+func (c *Config) WithLDAPAdminGroups(gdns []string, nested string) (*Config, error) {
+	if !isValidNestedGroupMode(nested) {
+		return c, errors.New(fmt.Sprintf("An invalid nested group mode of %s was provided. Accepted values are %q", nested, validNestedGroupModes))
+	}
+	c.LDAP.AdminGroupDNs = gdns
+	c.LDAP.AdminNestedGroups = nested
+	return c, nil
+}
+
+// WithLDAPAdminMemberOf sets administrators to be identified by the groups listed in an attribute of their own entry, such as memberOf, rather than by the membership attribute of the groups.
+func (c *Config) WithLDAPAdminMemberOf(attr, m string) {
+	c.LDAP.AdminMemberOfAttr = &attr
+	c.LDAP.AdminMemberUserDN = &m
+}
+
 func (c *Config) createLDAPConnection() error {
+	return c.LDAP.createConnection()
+}
+
+// WithLDAPDomain configures an LDAP directory to be used to authenticate users of the domain specified.
+// Once any domain has been configured requests for domains that have not been configured are rejected.
+func (c *Config) WithLDAPDomain(d, e, ca, dn string) (*Config, error) {
+	l := &LDAPConf{
+		EndPoint:    &e,
+		TrustCACert: &ca,
+		UserDN:      &dn,
+	}
+	if err := l.createConnection(); err != nil {
+		return c, errors.New("Error configuring LDAP connection for domain " + d + ": " + err.Error())
+	}
+	if c.LDAPDomains == nil {
+		c.LDAPDomains = make(map[string]*LDAPConf)
+	}
+	c.LDAPDomains[d] = l
+	return c, nil
+}
+
+func (c *Config) WithLDAPDomainAdminSettings(d, gdn, attr, m string) (*Config, error) {
+	l, ok := c.LDAPDomains[d]
+	if !ok {
+		return c, errors.New("No LDAP directory configured for domain " + d)
+	}
+	l.AdminGroupDN = &gdn
+	l.AdminMembershipAttr = &attr
+	l.AdminMemberUserDN = &m
+	return c, nil
+}
+
+// LDAPForDomain returns the LDAP directory configuration to use for users of the domain provided.
+// If no per domain directories are configured the single LDAP directory is used for all domains.
+func (c *Config) LDAPForDomain(d string) (*LDAPConf, error) {
+	if len(c.LDAPDomains) == 0 {
+		if c.LDAP.LDAPConnection == nil {
+			return nil, errors.New("No LDAP directory configured")
+		}
+		return &c.LDAP, nil
+	}
+	l, ok := c.LDAPDomains[d]
+	if !ok || l.LDAPConnection == nil {
+		return nil, errors.New("No LDAP directory configured for domain " + d)
+	}
+	return l, nil
+}
+
+// AdminGroups returns the DNs of all the groups whose members are administrators.
+func (l *LDAPConf) AdminGroups() []string {
+	var g []string
+	if l.AdminGroupDN != nil {
+		g = append(g, *l.AdminGroupDN)
+	}
+	return append(g, l.AdminGroupDNs...)
+}
+
+func (l *LDAPConf) createConnection() error {
 	var port uint64
-	s := *c.LDAP.EndPoint
-	if strings.HasPrefix(*c.LDAP.EndPoint, "ldaps://") {
+	s := *l.EndPoint
+	if strings.HasPrefix(*l.EndPoint, "ldaps://") {
 		s = s[len("ldaps://"):]
 		if i := strings.LastIndex(s, ":"); i != -1 {
 			port, _ = strconv.ParseUint(s[i+1:], 10, 16)
@@ -344,7 +683,7 @@ func (c *Config) createLDAPConnection() error {
 		}
 
 		tlsConfig := &tls.Config{RootCAs: x509.NewCertPool()}
-		pemData, err := ioutil.ReadFile(*c.LDAP.TrustCACert)
+		pemData, err := ioutil.ReadFile(*l.TrustCACert)
 		if err != nil {
 			return err
 		}
@@ -353,8 +692,8 @@ func (c *Config) createLDAPConnection() error {
 			return errors.New("Couldn't load PEM data for LDAP connection")
 		}
 
-		c.LDAP.LDAPConnection = ldap.NewLDAPTLSConnection(s, uint16(port), tlsConfig)
-	} else if strings.HasPrefix(*c.LDAP.EndPoint, "ldap://") {
+		l.LDAPConnection = ldap.NewLDAPTLSConnection(s, uint16(port), tlsConfig)
+	} else if strings.HasPrefix(*l.EndPoint, "ldap://") {
 		s = s[len("ldap://"):]
 		if i := strings.LastIndex(s, ":"); i != -1 {
 			port, _ = strconv.ParseUint(s[i+1:], 10, 16)
@@ -362,9 +701,9 @@ func (c *Config) createLDAPConnection() error {
 		} else {
 			port = 389
 		}
-		c.LDAP.LDAPConnection = ldap.NewLDAPConnection(s, uint16(port))
+		l.LDAPConnection = ldap.NewLDAPConnection(s, uint16(port))
 	} else {
-		return errors.New("Invalid protocol in LDAP endpoint: " + *c.LDAP.EndPoint)
+		return errors.New("Invalid protocol in LDAP endpoint: " + *l.EndPoint)
 	}
 	return nil
 }
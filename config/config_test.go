@@ -1,16 +1,25 @@
 package config
 
 import (
+	"bytes"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"github.com/jcmturner/mfaserver/audit"
 	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/jcmturner/mfaserver/webhook"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfig_NewConfig(t *testing.T) {
@@ -132,6 +141,20 @@ func TestConfig_WithMFAListenerSocket(t *testing.T) {
 	assert.Error(t, err, "Setting listener socket did not error for invalid socket")
 }
 
+func TestConfig_WithGRPCListenerSocket(t *testing.T) {
+	c := NewConfig()
+	assert.Nil(t, c.MFAServer.GRPCListenerSocket, "gRPC should not be served by default")
+	s := "127.0.0.1:7444"
+	_, err := c.WithGRPCListenerSocket(s)
+	if err != nil {
+		t.Fatalf("Error setting the gRPC listener socket: %v", err)
+	}
+	assert.Equal(t, s, *c.MFAServer.GRPCListenerSocket, "gRPC listener socket not set as expected")
+
+	_, err = c.WithGRPCListenerSocket("127.265.0.1:70000")
+	assert.Error(t, err, "Setting gRPC listener socket did not error for invalid socket")
+}
+
 func TestConfig_WithMFATLS(t *testing.T) {
 	c := NewConfig()
 	certPath, keyPath, _, _ := testtools.GenerateSelfSignedTLSKeyPairFiles(t)
@@ -146,6 +169,49 @@ func TestConfig_WithMFATLS(t *testing.T) {
 	assert.Equal(t, keyPath, *c.MFAServer.TLS.KeyFile, "Error setting TLS key file path. Unexpected value")
 }
 
+func TestConfig_WithMFAClientCA(t *testing.T) {
+	ca, caKey := testtools.GenerateTestCA(t)
+	caOut, _ := ioutil.TempFile(os.TempDir(), "clientCA")
+	defer os.Remove(caOut.Name())
+	pem.Encode(caOut, &pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	caOut.Close()
+
+	c := NewConfig()
+	assert.Equal(t, tls.NoClientCert, c.MFATLSConfig().ClientAuth, "Client certificates should not be requested by default")
+	_, err := c.WithMFAClientCA(caOut.Name(), true)
+	if err != nil {
+		t.Fatalf("Error setting client CA file: %v", err)
+	}
+	assert.Equal(t, tls.RequireAndVerifyClientCert, c.MFATLSConfig().ClientAuth, "Client certificates should be required")
+	assert.Nil(t, c.MFATLSConfig().VerifyPeerCertificate, "Revocation should not be checked without a CRL")
+
+	good := testtools.GenerateTestClientCertificate(t, ca, caKey, "svc1", []string{"svc1.example.com"})
+	revoked := testtools.GenerateTestClientCertificate(t, ca, caKey, "svc2", nil)
+	crlOut, _ := ioutil.TempFile(os.TempDir(), "clientCRL")
+	defer os.Remove(crlOut.Name())
+	crlOut.Write(testtools.GenerateTestCRL(t, ca, caKey, time.Now().Add(time.Hour), revoked.Leaf))
+	crlOut.Close()
+	_, err = c.WithMFAClientCRL(crlOut.Name())
+	if err != nil {
+		t.Fatalf("Error setting client CRL file: %v", err)
+	}
+	verify := c.MFATLSConfig().VerifyPeerCertificate
+	assert.NoError(t, verify(nil, [][]*x509.Certificate{{good.Leaf, ca}}), "Certificate that is not revoked should be accepted")
+	assert.Error(t, verify(nil, [][]*x509.Certificate{{revoked.Leaf, ca}}), "Revoked certificate should be rejected")
+
+	//An updated CRL is picked up without reconfiguring
+	ioutil.WriteFile(crlOut.Name(), testtools.GenerateTestCRL(t, ca, caKey, time.Now().Add(-time.Minute)), 0600)
+	os.Chtimes(crlOut.Name(), time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	assert.Error(t, verify(nil, [][]*x509.Certificate{{good.Leaf, ca}}), "Certificates should be rejected when the CRL has expired")
+
+	_, err = c.WithMFAClientCRL(caOut.Name())
+	assert.Error(t, err, "A file without a CRL should not be accepted")
+
+	assert.Contains(t, CertificateIdentities(good.Leaf), "svc1", "Common name not in the certificate identities")
+	assert.Contains(t, CertificateIdentities(good.Leaf), "svc1.example.com", "DNS name not in the certificate identities")
+	assert.Contains(t, CertificateIdentities(good.Leaf), "CN=svc1,O=Acme Co", "Subject not in the certificate identities")
+}
+
 func TestLoad(t *testing.T) {
 	certPath, keyPath, certBytes, _ := testtools.GenerateSelfSignedTLSKeyPairFiles(t)
 	//Have to add test cert into a certPool to compare in the assertion as this is all we can get back from the TLSClientConfig of the http.Client and certPool has no public mechanism to extract certs from it
@@ -261,3 +327,619 @@ func TestConfig_WithLogLevel(t *testing.T) {
 	assert.Equal(t, "WARNING: ", c.MFAServer.Loggers.Warning.Prefix(), "Prefix not correct for debug logger")
 	assert.Equal(t, "ERROR: ", c.MFAServer.Loggers.Error.Prefix(), "Prefix not correct for debug logger")
 }
+
+func TestConfig_LDAPForDomain(t *testing.T) {
+	c := NewConfig()
+	_, err := c.LDAPForDomain("testdom")
+	assert.Error(t, err, "Should have errored when no LDAP directory is configured")
+
+	c.WithLDAPConnection("ldap://127.0.0.1:389", "", "{username}")
+	l, err := c.LDAPForDomain("anydom")
+	if err != nil {
+		t.Fatalf("Error getting LDAP directory when a single directory is configured: %v", err)
+	}
+	assert.Equal(t, &c.LDAP, l, "Single LDAP directory should be used for all domains")
+
+	_, err = c.WithLDAPDomain("testdom", "ldap://127.0.0.2:389", "", "uid={username},dc=testdom")
+	if err != nil {
+		t.Fatalf("Error configuring LDAP directory for domain: %v", err)
+	}
+	_, err = c.WithLDAPDomain("otherdom", "ldap://127.0.0.3:1389", "", "uid={username},dc=otherdom")
+	if err != nil {
+		t.Fatalf("Error configuring LDAP directory for domain: %v", err)
+	}
+	l, err = c.LDAPForDomain("testdom")
+	if err != nil {
+		t.Fatalf("Error getting LDAP directory for configured domain: %v", err)
+	}
+	assert.Equal(t, "127.0.0.2:389", l.LDAPConnection.Addr, "LDAP endpoint address for domain not as expected")
+	assert.Equal(t, "uid={username},dc=testdom", *l.UserDN, "LDAP DN for binding not as expected")
+	l, err = c.LDAPForDomain("otherdom")
+	if err != nil {
+		t.Fatalf("Error getting LDAP directory for configured domain: %v", err)
+	}
+	assert.Equal(t, "127.0.0.3:1389", l.LDAPConnection.Addr, "LDAP endpoint address for domain not as expected")
+	_, err = c.LDAPForDomain("anydom")
+	assert.Error(t, err, "Should have errored for a domain without an LDAP directory configured")
+
+	_, err = c.WithLDAPDomainAdminSettings("testdom", "cn=mfaadmin,dc=testdom", "memberUid", "{username}")
+	assert.NoError(t, err)
+	assert.Equal(t, "cn=mfaadmin,dc=testdom", *c.LDAPDomains["testdom"].AdminGroupDN, "Admin group DN for domain not as expected")
+	_, err = c.WithLDAPDomainAdminSettings("anydom", "cn=mfaadmin,dc=testdom", "memberUid", "{username}")
+	assert.Error(t, err, "Should have errored setting admin settings for a domain without an LDAP directory configured")
+
+	_, err = c.WithLDAPDomain("baddom", "http://127.0.0.1", "", "{username}")
+	assert.Error(t, err, "Should have errored for an invalid LDAP endpoint protocol")
+}
+
+func TestConfig_AdminPermitted(t *testing.T) {
+	c := NewConfig()
+	c.WithLDAPConnection("ldap://127.0.0.1:389", "", "{username}")
+	c.WithLDAPAdminSettings("cn=mfaadmin,ou=groups,dc=example,dc=com", "memberUid", "{username}")
+	_, err := c.WithAdminRole(RoleHelpdesk, []string{"cn=helpdesk,ou=groups,dc=example,dc=com"}, []string{"testapp"}, []string{"testdom"})
+	assert.NoError(t, err)
+	_, err = c.WithAdminRole(RoleAuditor, []string{"cn=auditors,ou=groups,dc=example,dc=com"}, nil, nil)
+	assert.NoError(t, err)
+	_, err = c.WithAdminRole("unknown", []string{"cn=auditors,ou=groups,dc=example,dc=com"}, nil, nil)
+	assert.Error(t, err, "Should have errored for an unknown role")
+	_, err = c.WithAdminRole(RoleAuditor, nil, nil, nil)
+	assert.Error(t, err, "Should have errored for a role without groups")
+
+	assert.ElementsMatch(t, []string{"cn=mfaadmin,ou=groups,dc=example,dc=com", "cn=helpdesk,ou=groups,dc=example,dc=com", "cn=auditors,ou=groups,dc=example,dc=com"}, c.AdminGroupDNs("testdom"), "Admin groups for domain not as expected")
+	assert.ElementsMatch(t, []string{"cn=mfaadmin,ou=groups,dc=example,dc=com", "cn=auditors,ou=groups,dc=example,dc=com"}, c.AdminGroupDNs("otherdom"), "Admin groups for domain not as expected")
+
+	var tests = []struct {
+		MemberOf   []string
+		Permission string
+		Issuer     string
+		Domain     string
+		Permitted  bool
+	}{
+		{[]string{"cn=mfaadmin,ou=groups,dc=example,dc=com"}, PermissionReset, "anyapp", "anydom", true},
+		{[]string{"CN=MFAAdmin,ou=groups,dc=example,dc=com"}, PermissionManage, "anyapp", "anydom", true},
+		{[]string{"cn=helpdesk,ou=groups,dc=example,dc=com"}, PermissionReset, "testapp", "testdom", true},
+		{[]string{"cn=helpdesk,ou=groups,dc=example,dc=com"}, PermissionReset, "otherapp", "testdom", false},
+		{[]string{"cn=helpdesk,ou=groups,dc=example,dc=com"}, PermissionReset, "testapp", "otherdom", false},
+		{[]string{"cn=helpdesk,ou=groups,dc=example,dc=com"}, PermissionList, "testapp", "testdom", false},
+		{[]string{"cn=auditors,ou=groups,dc=example,dc=com"}, PermissionList, "anyapp", "anydom", true},
+		{[]string{"cn=auditors,ou=groups,dc=example,dc=com"}, PermissionReset, "anyapp", "anydom", false},
+		{[]string{"cn=othergroup,ou=groups,dc=example,dc=com"}, PermissionList, "anyapp", "anydom", false},
+		{nil, PermissionList, "anyapp", "anydom", false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Permitted, c.AdminPermitted(test.MemberOf, test.Permission, test.Issuer, test.Domain), "Permission %s for %v on %s:%s not as expected", test.Permission, test.MemberOf, test.Issuer, test.Domain)
+	}
+}
+
+func TestConfig_Issuers(t *testing.T) {
+	c := NewConfig()
+	f, _ := ioutil.TempFile(os.TempDir(), "htpasswd")
+	defer os.Remove(f.Name())
+	f.Close()
+
+	assert.Equal(t, VerifierLDAP, c.Issuer("anyapp").PasswordVerifier, "Issuers not configured should use LDAP")
+	assert.True(t, c.PasswordRequired("anyapp"), "Password should be required for LDAP verification")
+
+	_, err := c.WithIssuerHtpasswd("htpasswdapp", f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, VerifierHtpasswd, c.Issuer("htpasswdapp").PasswordVerifier, "Password verifier for issuer not as expected")
+	assert.True(t, c.PasswordRequired("htpasswdapp"), "Password should be required for htpasswd verification")
+	_, err = c.WithIssuerHtpasswd("missingapp", f.Name()+"missing")
+	assert.Error(t, err, "Should have errored when the htpasswd file does not exist")
+
+	_, err = c.WithIssuerDelegated("delegatedapp")
+	assert.NoError(t, err)
+	assert.Equal(t, VerifierDelegated, c.Issuer("delegatedapp").PasswordVerifier, "Password verifier for issuer not as expected")
+	assert.False(t, c.PasswordRequired("delegatedapp"), "Password should not be required for delegated verification")
+}
+
+func TestConfig_Kerberos(t *testing.T) {
+	c := NewConfig()
+	_, err := c.WithKerberosKeytabFile(os.TempDir() + "/missing.keytab")
+	assert.Error(t, err, "Should have errored when the keytab file does not exist")
+	assert.Nil(t, c.Kerberos.Keytab, "Keytab should not be set when the file could not be loaded")
+
+	c.WithKerberosServicePrincipal("HTTP/mfa.example.com").WithKerberosRealmDomain("EXAMPLE.COM", "testdom")
+	assert.Equal(t, "HTTP/mfa.example.com", *c.Kerberos.ServicePrincipal, "Service principal not as expected")
+	assert.Equal(t, "testdom", c.Kerberos.RealmDomains["EXAMPLE.COM"], "Realm to domain mapping not as expected")
+}
+
+func TestConfig_RADIUSClient(t *testing.T) {
+	c := NewConfig()
+	_, err := c.WithRADIUSClient("192.168.1.10", "secret1", RADIUSOTPConcatenated)
+	assert.NoError(t, err)
+	_, err = c.WithRADIUSClient("192.168.0.0/16", "secret2", RADIUSOTPChallenge)
+	assert.NoError(t, err)
+	_, err = c.WithRADIUSClient("192.168.2.0/24", "secret3", RADIUSOTPChallenge)
+	assert.NoError(t, err)
+	_, err = c.WithRADIUSClient("notanaddress", "secret", RADIUSOTPChallenge)
+	assert.Error(t, err, "Should have errored for an invalid client address")
+	_, err = c.WithRADIUSClient("10.0.0.1", "", RADIUSOTPChallenge)
+	assert.Error(t, err, "Should have errored for an empty secret")
+	_, err = c.WithRADIUSClient("10.0.0.1", "secret", "unknown")
+	assert.Error(t, err, "Should have errored for an unknown OTP mode")
+
+	var tests = []struct {
+		IP     string
+		Secret string
+	}{
+		{"192.168.1.10", "secret1"},
+		{"192.168.1.11", "secret2"},
+		{"192.168.2.1", "secret3"},
+		{"10.0.0.1", ""},
+	}
+	for _, test := range tests {
+		rc := c.RADIUSClient(net.ParseIP(test.IP))
+		if test.Secret == "" {
+			assert.Nil(t, rc, "No client should match %s", test.IP)
+			continue
+		}
+		if assert.NotNil(t, rc, "Client should match %s", test.IP) {
+			assert.Equal(t, test.Secret, rc.Secret, "Wrong client matched for %s", test.IP)
+		}
+	}
+	assert.Equal(t, 60, c.RADIUSChallengeTimeout(), "Default challenge timeout not as expected")
+}
+
+func TestConfig_ForwardAuth(t *testing.T) {
+	c := NewConfig()
+	assert.False(t, c.ForwardAuthEnabled(), "Forward authentication should not be enabled by default")
+	_, err := c.WithForwardAuth("testapp", []byte("tooshort"))
+	assert.Error(t, err, "Should have errored with a short session key")
+	_, err = c.WithForwardAuth("testapp", []byte("0123456789abcdef0123456789abcdef"))
+	assert.NoError(t, err)
+	assert.True(t, c.ForwardAuthEnabled(), "Forward authentication should be enabled")
+	assert.Equal(t, "mfaserver_session", c.SessionCookieName(), "Default cookie name not as expected")
+	assert.Equal(t, 8*time.Hour, c.SessionTimeout(), "Default session timeout not as expected")
+
+	f, _ := ioutil.TempFile(os.TempDir(), "sessionkey")
+	defer os.Remove(f.Name())
+	f.WriteString("abcdefghijklmnopqrstuvwxyz0123456789\n")
+	f.Close()
+	_, err = c.WithForwardAuthKeyFile(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("abcdefghijklmnopqrstuvwxyz0123456789"), c.ForwardAuth.Key, "Session key not read from file as expected")
+	c.WithForwardAuthCookie("mycookie", "example.com", time.Minute)
+	assert.Equal(t, "mycookie", c.SessionCookieName(), "Cookie name not as expected")
+	assert.Equal(t, time.Minute, c.SessionTimeout(), "Session timeout not as expected")
+}
+
+func TestConfig_OIDC(t *testing.T) {
+	c := NewConfig()
+	_, err := c.WithOIDCClient("client", "secret", nil, "testapp")
+	assert.Error(t, err, "Should have errored without redirect URIs")
+	_, err = c.WithOIDCClient("client", "secret", []string{"/relative"}, "testapp")
+	assert.Error(t, err, "Should have errored with a relative redirect URI")
+	_, err = c.WithOIDCClient("client", "secret", []string{"https://rp.example.com/cb"}, "")
+	assert.Error(t, err, "Should have errored without an issuer")
+	_, err = c.WithOIDCClient("client", "secret", []string{"https://rp.example.com/cb"}, "testapp")
+	assert.NoError(t, err)
+	assert.False(t, c.OIDCEnabled(), "OIDC should not be enabled without assertion signing keys")
+	o, ok := c.OIDCClient("client")
+	if assert.True(t, ok, "Registered client not returned") {
+		assert.True(t, o.RedirectURIPermitted("https://rp.example.com/cb"), "Registered redirect URI should be permitted")
+		assert.False(t, o.RedirectURIPermitted("https://rp.example.com/cb/other"), "Redirect URIs should match exactly")
+	}
+	_, ok = c.OIDCClient("unknown")
+	assert.False(t, ok, "Unknown client should not be returned")
+}
+
+func TestConfig_Applications(t *testing.T) {
+	c := NewConfig()
+	assert.False(t, c.ApplicationAuthRequired(), "Application authentication should not be required by default")
+	_, err := c.WithApplication("app1", nil, "", []string{"testapp"})
+	assert.Error(t, err, "Should have errored without any credentials")
+	_, err = c.WithApplication("app1", []string{"key"}, "", nil)
+	assert.Error(t, err, "Should have errored without any issuers")
+	_, err = c.WithApplication("app1", []string{"key"}, "", []string{"testapp"})
+	assert.NoError(t, err)
+	assert.True(t, c.ApplicationAuthRequired(), "Application authentication should be required once an application is registered")
+	a, ok := c.Application("app1")
+	if assert.True(t, ok, "Registered application not returned") {
+		assert.True(t, a.IssuerPermitted("testapp"), "Issuer should be permitted")
+		assert.False(t, a.IssuerPermitted("otherapp"), "Issuer should not be permitted")
+	}
+
+	a, err = NewApplicationFromStore(map[string]interface{}{"apikeys": "key1, key2", "issuers": "app1,app2"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"key1", "key2"}, a.APIKeys, "API keys from the store not as expected")
+		assert.Equal(t, []string{"app1", "app2"}, a.Issuers, "Issuers from the store not as expected")
+	}
+	c.WithApplicationClientCertificates("app2", []string{"svc2.example.com"}, []string{"*"})
+	n, _, ok := c.ApplicationForCertificate([]string{"CN=svc2", "svc2.example.com"})
+	assert.True(t, ok, "Application should be found for the certificate")
+	assert.Equal(t, "app2", n, "Application for the certificate not as expected")
+	_, _, ok = c.ApplicationForCertificate([]string{"svc3.example.com"})
+	assert.False(t, ok, "No application should be found for the certificate")
+
+	_, err = NewApplicationFromStore(map[string]interface{}{"issuers": "app1"})
+	assert.Error(t, err, "Should have errored for an application in the store without credentials")
+}
+
+func TestHolder_Reload(t *testing.T) {
+	logfile, _ := ioutil.TempFile(os.TempDir(), "mocklogfile")
+	defer os.Remove(logfile.Name())
+	logfile.Close()
+	configJson := `{
+		"MFAServer": {
+			"ListenerSocket": "127.0.0.1:7443",
+			"LogFile": "%s",
+			"LogLevel": "%s"
+		},
+		"Vault": {
+			"VaultConnection": {
+				"EndPoint": "https://127.0.0.1:8200"
+			},
+			"AppIDRead": "appidread",
+			"AppIDWrite": "appidwrite",
+			"UserID": "0ecd7b5d-4885-45c1-a03f-5949e485c6bf"
+		},
+		"LDAP": {
+			"EndPoint": "ldap://127.0.0.1:389",
+			"UserDN": "uid={username},ou=users,dc=example,dc=com"
+		},
+		"ForwardAuth": {
+			"Issuer": "intranet"
+		}
+	}`
+	testConfigFile, _ := ioutil.TempFile(os.TempDir(), "config")
+	defer os.Remove(testConfigFile.Name())
+	testConfigFile.WriteString(fmt.Sprintf(configJson, logfile.Name(), "INFO"))
+	testConfigFile.Close()
+
+	h, err := NewHolder(testConfigFile.Name())
+	if err != nil {
+		t.Fatalf("Error loading configuration JSON: %v", err)
+	}
+	old := h.Config()
+	assert.Equal(t, "INFO", *old.MFAServer.LogLevel, "Log level not as expected")
+
+	ioutil.WriteFile(testConfigFile.Name(), []byte(fmt.Sprintf(configJson, logfile.Name(), "DEBUG")), 0600)
+	err = h.Reload()
+	if err != nil {
+		t.Fatalf("Error reloading configuration: %v", err)
+	}
+	assert.Equal(t, "DEBUG", *h.Config().MFAServer.LogLevel, "Log level not changed by the reload")
+	assert.Equal(t, "INFO", *old.MFAServer.LogLevel, "Configuration in use by earlier requests should not be modified")
+	assert.Equal(t, old.ForwardAuth.Key, h.Config().ForwardAuth.Key, "Random session key should be kept across reloads")
+	assert.Equal(t, old.MFAServer.Loggers.file, h.Config().MFAServer.Loggers.file, "Log file should be kept open across reloads when unchanged")
+
+	//The replaced log file is closed once the requests using it have been handled
+	rc := h.Config().ForRequest("abc123", "127.0.0.1:1234")
+	f := h.Config().MFAServer.Loggers.file
+	logfile2, _ := ioutil.TempFile(os.TempDir(), "mocklogfile")
+	defer os.Remove(logfile2.Name())
+	logfile2.Close()
+	ioutil.WriteFile(testConfigFile.Name(), []byte(fmt.Sprintf(configJson, logfile2.Name(), "DEBUG")), 0600)
+	err = h.Reload()
+	if err != nil {
+		t.Fatalf("Error reloading configuration: %v", err)
+	}
+	assert.NotEqual(t, f, h.Config().MFAServer.Loggers.file, "New log file not opened")
+	assert.Equal(t, h.Config().MFAServer.Loggers.Error, old.loggers.Error(), "Errors of the senders kept across reloads not logged to the new log file")
+	_, err = f.Write([]byte{})
+	assert.NoError(t, err, "Log file closed while a request was using it")
+	nrc := rc.Loaded().ForRequest("def456", "127.0.0.1:1234")
+	assert.Equal(t, h.Config(), nrc.Loaded(), "Request started after the reload should use the new configuration")
+	nrc.Done()
+	rc.Done()
+	for i := 0; i < 100; i++ {
+		if _, err = f.Write([]byte{}); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Error(t, err, "Replaced log file not closed once the request using it was handled")
+	ioutil.WriteFile(testConfigFile.Name(), []byte(fmt.Sprintf(configJson, logfile.Name(), "DEBUG")), 0600)
+	if err := h.Reload(); err != nil {
+		t.Fatalf("Error reloading configuration: %v", err)
+	}
+
+	//An invalid configuration is not used
+	ioutil.WriteFile(testConfigFile.Name(), []byte(fmt.Sprintf(configJson, logfile.Name(), "VERBOSE")), 0600)
+	assert.Error(t, h.Reload(), "Reload of an invalid configuration should return an error")
+	assert.Equal(t, "DEBUG", *h.Config().MFAServer.LogLevel, "Configuration should be unchanged after a failed reload")
+
+	b, _ := ioutil.ReadFile(logfile.Name())
+	assert.Contains(t, string(b), "Changed: MFAServer.LogLevel", "Changes not logged")
+	assert.Contains(t, string(b), "Configuration reload failed", "Failed reload not logged")
+}
+
+func TestHolder_ReloadAuditLog(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-audit")
+	defer os.RemoveAll(dir)
+	auditFile := filepath.Join(dir, "audit.log")
+	configJson := `{
+		"MFAServer": {
+			"LogLevel": "ERROR"
+		},
+		"Vault": {
+			"VaultConnection": {
+				"EndPoint": "https://127.0.0.1:8200"
+			},
+			"AppIDRead": "appidread",
+			"AppIDWrite": "appidwrite",
+			"UserID": "0ecd7b5d-4885-45c1-a03f-5949e485c6bf"
+		},
+		"LDAP": {
+			"EndPoint": "ldap://127.0.0.1:389",
+			"UserDN": "uid={username},ou=users,dc=example,dc=com"
+		},
+		"Audit": {
+			"File": "%s",
+			"SignInterval": %d
+		}
+	}`
+	testConfigFile, _ := ioutil.TempFile(os.TempDir(), "config")
+	defer os.Remove(testConfigFile.Name())
+	testConfigFile.WriteString(fmt.Sprintf(configJson, auditFile, 60))
+	testConfigFile.Close()
+	h, err := NewHolder(testConfigFile.Name())
+	if err != nil {
+		t.Fatalf("Error loading configuration JSON: %v", err)
+	}
+	rc := h.Config().ForRequest("abc123", "127.0.0.1:1234")
+	rc.RecordAuditEvent(audit.Event{Type: audit.EventEnrol, Outcome: "success", Issuer: "testapp", Domain: "test.com", Username: "user1"})
+
+	//A request in progress records its events to the audit log of the reloaded configuration
+	ioutil.WriteFile(testConfigFile.Name(), []byte(fmt.Sprintf(configJson, auditFile, 120)), 0600)
+	if err := h.Reload(); err != nil {
+		t.Fatalf("Error reloading configuration: %v", err)
+	}
+	assert.NotEqual(t, rc.Audit.Log, h.Config().Audit.Log, "Audit log with changed settings not reopened")
+	rc.RecordAuditEvent(audit.Event{Type: audit.EventDelete, Outcome: "success", Issuer: "testapp", Domain: "test.com", Username: "user1"})
+	rc.Done()
+	h.Config().RecordAuditEvent(audit.Event{Type: audit.EventEnrol, Outcome: "success", Issuer: "testapp", Domain: "test.com", Username: "user2"})
+
+	//An audit log that cannot be opened fails the reload
+	ioutil.WriteFile(testConfigFile.Name(), []byte(fmt.Sprintf(configJson, filepath.Join(dir, "missing", "audit.log"), 120)), 0600)
+	assert.Error(t, h.Reload(), "Reload with an audit log that cannot be opened should return an error")
+	assert.Equal(t, auditFile, *h.Config().Audit.File, "Configuration should be unchanged after a failed reload")
+
+	h.Config().Audit.Log.Close()
+	b, _ := ioutil.ReadFile(auditFile)
+	rep, err := audit.Verify(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatalf("Error verifying audit log: %v", err)
+	}
+	assert.True(t, rep.Valid(), "Audit log not valid after reload: %v", rep.Problems)
+	assert.Equal(t, 3, rep.Events, "Events not as expected")
+}
+
+func TestHolder_ReloadWebhooks(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-webhook")
+	defer os.RemoveAll(dir)
+	events := make(chan webhook.Event, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer s.Close()
+	configJson := `{
+		"MFAServer": {
+			"LogLevel": "ERROR"
+		},
+		"Vault": {
+			"VaultConnection": {
+				"EndPoint": "https://127.0.0.1:8200"
+			},
+			"AppIDRead": "appidread",
+			"AppIDWrite": "appidwrite",
+			"UserID": "0ecd7b5d-4885-45c1-a03f-5949e485c6bf"
+		},
+		"LDAP": {
+			"EndPoint": "ldap://127.0.0.1:389",
+			"UserDN": "uid={username},ou=users,dc=example,dc=com"
+		},
+		"Webhooks": {
+			"OutboxFile": "%s",
+			"Subscriptions": [
+				{
+					"Name": "tickets",
+					"URL": "%s",
+					"Secret": "0123456789abcdef",
+					"Events": ["%s"]
+				}
+			]
+		}
+	}`
+	testConfigFile, _ := ioutil.TempFile(os.TempDir(), "config")
+	defer os.Remove(testConfigFile.Name())
+	testConfigFile.WriteString(fmt.Sprintf(configJson, filepath.Join(dir, "outbox"), s.URL, webhook.EventEnrolled))
+	testConfigFile.Close()
+	h, err := NewHolder(testConfigFile.Name())
+	if err != nil {
+		t.Fatalf("Error loading configuration JSON: %v", err)
+	}
+	old := h.Config()
+	rc := old.ForRequest("abc123", "127.0.0.1:1234")
+
+	//The dispatcher is kept for the same outbox and delivers to the subscriptions now configured
+	ioutil.WriteFile(testConfigFile.Name(), []byte(fmt.Sprintf(configJson, filepath.Join(dir, "outbox"), s.URL, webhook.EventDeleted)), 0600)
+	if err := h.Reload(); err != nil {
+		t.Fatalf("Error reloading configuration: %v", err)
+	}
+	assert.True(t, old.Webhooks.Dispatcher == h.Config().Webhooks.Dispatcher, "Dispatcher not kept for the same outbox")
+	rc.RecordAuditEvent(audit.Event{Type: audit.EventEnrol, Outcome: "success", Issuer: "testapp", Domain: "test.com", Username: "user1"})
+	rc.RecordAuditEvent(audit.Event{Type: audit.EventDelete, Outcome: "success", Issuer: "testapp", Domain: "test.com", Username: "user2"})
+	select {
+	case e := <-events:
+		assert.Equal(t, "user2", e.Username, "Webhook event not sent to the reloaded subscription")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook event not delivered")
+	}
+	rc.Done()
+
+	//The dispatcher for another outbox is only closed once the requests using it have been handled
+	rc = h.Config().ForRequest("def456", "127.0.0.1:1234")
+	ioutil.WriteFile(testConfigFile.Name(), []byte(fmt.Sprintf(configJson, filepath.Join(dir, "outbox2"), s.URL, webhook.EventDeleted)), 0600)
+	if err := h.Reload(); err != nil {
+		t.Fatalf("Error reloading configuration: %v", err)
+	}
+	defer h.Config().CloseWebhooks()
+	assert.True(t, rc.Webhooks.Dispatcher != h.Config().Webhooks.Dispatcher, "Dispatcher not opened for the new outbox")
+	assert.NoError(t, rc.Webhooks.Dispatcher.Send(webhook.NewEvent(webhook.EventDeleted)), "Dispatcher closed while a request was using it")
+	d := rc.Webhooks.Dispatcher
+	rc.Done()
+	for i := 0; i < 100; i++ {
+		if err = d.Send(webhook.NewEvent(webhook.EventDeleted)); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Error(t, err, "Replaced dispatcher not closed once the request using it was handled")
+}
+
+func TestConfig_WithMFATimeouts(t *testing.T) {
+	c := NewConfig()
+	assert.Equal(t, DefaultReadTimeout, c.ReadTimeout(), "Default read timeout not as expected")
+	assert.Equal(t, DefaultWriteTimeout, c.WriteTimeout(), "Default write timeout not as expected")
+	assert.Equal(t, DefaultIdleTimeout, c.IdleTimeout(), "Default idle timeout not as expected")
+	assert.Equal(t, DefaultShutdownTimeout, c.ShutdownTimeout(), "Default shutdown timeout not as expected")
+	_, err := c.WithMFATimeouts(10*time.Second, 20*time.Second, 0, time.Minute)
+	if err != nil {
+		t.Fatalf("Error setting timeouts: %v", err)
+	}
+	assert.Equal(t, 10*time.Second, c.ReadTimeout(), "Read timeout not as expected")
+	assert.Equal(t, 20*time.Second, c.WriteTimeout(), "Write timeout not as expected")
+	assert.Equal(t, DefaultIdleTimeout, c.IdleTimeout(), "A zero idle timeout should use the default")
+	assert.Equal(t, time.Minute, c.ShutdownTimeout(), "Shutdown timeout not as expected")
+	_, err = c.WithMFATimeouts(-time.Second, 0, 0, 0)
+	assert.Error(t, err, "Negative timeouts should not be accepted")
+}
+
+func TestConfig_WithLogFormat(t *testing.T) {
+	var tests = []struct {
+		format string
+		entry  string
+	}{
+		{LogFormatJSON, `"level":"INFO","request_id":"abc123","caller":"config_test.go`},
+		{LogFormatLogfmt, `level=INFO request_id=abc123 caller=config_test.go`},
+		{LogFormatText, `INFO: [abc123] `},
+	}
+	for _, test := range tests {
+		logfile, err := ioutil.TempFile(os.TempDir(), "mfaserver-log")
+		if err != nil {
+			t.Fatalf("Error creating log file: %v", err)
+		}
+		defer os.Remove(logfile.Name())
+		c := NewConfig()
+		n := logfile.Name()
+		c.MFAServer.LogFilePath = &n
+		if _, err := c.WithLogFormat(test.format); err != nil {
+			t.Fatalf("Error setting log format %s: %v", test.format, err)
+		}
+		if _, err := c.WithLogLevel("INFO"); err != nil {
+			t.Fatalf("Error setting log level: %v", err)
+		}
+		rc := c.ForRequest("abc123", "127.0.0.1:1234")
+		rc.MFAServer.Loggers.Info.Printf("127.0.0.1:1234, OTP validation passed for %s/%s", "test.com", "validuser")
+		rc.MFAServer.Loggers.Debug.Printf("Debug entries are not logged at INFO")
+		rc.SetRequestUser("testapp", "test.com", "validuser")
+		rc.Request.Endpoint = "/validate"
+		rc.Request.Outcome = "success"
+		rc.Request.Status = 204
+		rc.Request.Duration = 1500 * time.Millisecond
+		rc.MFAServer.Loggers.Request(rc.Request)
+		c.MFAServer.Loggers.Info.Println("Not for a request")
+
+		b, _ := ioutil.ReadFile(n)
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Expected 3 log entries in %s format, got %d: %s", test.format, len(lines), b)
+		}
+		assert.Contains(t, lines[0], test.entry, "Log entry not as expected for %s format", test.format)
+		assert.Contains(t, lines[0], "OTP validation passed for test.com/validuser", "Log message not as expected for %s format", test.format)
+		assert.NotContains(t, lines[2], "abc123", "Request ID logged for entry not for the request in %s format", test.format)
+		switch test.format {
+		case LogFormatJSON:
+			var e map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+				t.Fatalf("Request log entry is not valid JSON: %v", err)
+			}
+			for k, v := range map[string]interface{}{"request_id": "abc123", "remote_addr": "127.0.0.1:1234", "endpoint": "/validate",
+				"issuer": "testapp", "domain": "test.com", "username": "validuser", "outcome": "success", "status": float64(204), "duration": 1.5} {
+				assert.Equal(t, v, e[k], "Request log field %s not as expected", k)
+			}
+		default:
+			assert.Contains(t, lines[1], `endpoint=/validate issuer=testapp domain=test.com username=validuser outcome=success status=204 duration=1.5`, "Request log entry not as expected for %s format", test.format)
+		}
+	}
+	c := NewConfig()
+	_, err := c.WithLogFormat("xml")
+	assert.Error(t, err, "Invalid log format not rejected")
+}
+
+func TestConfig_ForRequest(t *testing.T) {
+	c := NewConfig()
+	c.WithVaultUserId("0ecd7e6f-9ad9-4d5d-8b54-7dcd2e8e0a6d")
+	a := "6a1ab78a-0f0b-4d8d-8c4b-5e0bd3a4fd0b"
+	c.Vault.AppIDWrite = &a
+	rc := c.ForRequest("abc123", "127.0.0.1:1234")
+	assert.NotNil(t, c.Vault.VaultClient, "Vault client not created before the configuration was copied")
+	assert.Equal(t, c.Vault.VaultClient, rc.Vault.VaultClient, "Vault client not shared with the copy for the request")
+	assert.Equal(t, c.Vault.VaultLogin, rc.Vault.VaultLogin, "Vault login not shared with the copy for the request")
+	assert.Equal(t, c, rc.Loaded(), "Loaded configuration not as expected")
+	assert.Equal(t, c, c.Loaded(), "Loaded configuration not as expected")
+	assert.Nil(t, c.Request, "Request fields set on the loaded configuration")
+	assert.Equal(t, "abc123", rc.Request.ID, "Request ID not as expected")
+}
+
+func TestConfig_WithWebhookSubscription(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-webhook")
+	defer os.RemoveAll(dir)
+	events := make(chan webhook.Event, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer s.Close()
+
+	c := NewConfig()
+	var tests = []struct {
+		name   string
+		URL    string
+		secret string
+		events []string
+	}{
+		{"", s.URL, "0123456789abcdef", nil},
+		{"tickets", "ftp://example.com", "0123456789abcdef", nil},
+		{"tickets", s.URL, "short", nil},
+		{"tickets", s.URL, "0123456789abcdef", []string{"mfa.unknown"}},
+		{"tickets", s.URL, "0123456789abcdef", nil},
+	}
+	for _, test := range tests {
+		_, err := c.WithWebhookSubscription(test.name, test.URL, test.secret, test.events)
+		assert.Error(t, err, "Webhook subscription %q to %s with secret %q and events %v not rejected", test.name, test.URL, test.secret, test.events)
+	}
+	assert.Empty(t, c.Webhooks.Subscriptions, "Subscriptions not valid were kept")
+	if _, err := c.WithWebhookOutbox(filepath.Join(dir, "outbox")); err != nil {
+		t.Fatalf("Error setting webhook outbox: %v", err)
+	}
+	if _, err := c.WithWebhookSubscription("tickets", s.URL, "0123456789abcdef", []string{webhook.EventDeleted}); err != nil {
+		t.Fatalf("Error adding webhook subscription: %v", err)
+	}
+	defer c.CloseWebhooks()
+	_, err := c.WithWebhookSubscription("tickets", s.URL, "0123456789abcdef", nil)
+	assert.Error(t, err, "Duplicate subscription name not rejected")
+
+	//Only successful lifecycle events the subscription is for are sent
+	c.RecordAuditEvent(audit.Event{Type: audit.EventEnrol, Outcome: "success", Issuer: "testapp", Domain: "test.com", Username: "user1"})
+	c.RecordAuditEvent(audit.Event{Type: audit.EventDelete, Outcome: "unauthorized", Issuer: "testapp", Domain: "test.com", Username: "user2"})
+	c.RecordAuditEvent(audit.Event{Type: audit.EventDelete, Outcome: "success", Admin: "test.com/mfaadmin", Issuer: "testapp", Domain: "test.com", Username: "user3"})
+	select {
+	case e := <-events:
+		assert.Equal(t, webhook.EventDeleted, e.Type, "Webhook event type not as expected")
+		assert.Equal(t, "user3", e.Username, "Webhook event user not as expected")
+		assert.Equal(t, "test.com/mfaadmin", e.Admin, "Webhook event admin not as expected")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook event not delivered")
+	}
+	select {
+	case e := <-events:
+		t.Errorf("Unexpected webhook event delivered: %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Defaults for the reverse proxy forward authentication sessions
+const (
+	defaultSessionCookieName = "mfaserver_session"
+	defaultSessionTimeout    = 8 * time.Hour
+	minSessionKeyLength      = 32
+)
+
+// ForwardAuthConf defines the login page and session cookie used to authenticate requests for reverse proxies.
+type ForwardAuthConf struct {
+	Issuer         *string `json:"Issuer"`
+	Domain         *string `json:"Domain"`
+	CookieName     string  `json:"CookieName"`
+	CookieDomain   string  `json:"CookieDomain"`
+	SessionTimeout int     `json:"SessionTimeout"`
+	KeyFile        *string `json:"KeyFile"`
+	Key            []byte
+}
+
+// WithForwardAuth enables the forward authentication endpoints with sessions for the issuer signed by the key.
+func (c *Config) WithForwardAuth(issuer string, key []byte) (*Config, error) {
+	if len(key) < minSessionKeyLength {
+		return c, errors.New("The session signing key must be at least 32 bytes")
+	}
+	c.ForwardAuth.Issuer = &issuer
+	c.ForwardAuth.Key = key
+	return c, nil
+}
+
+// WithForwardAuthKeyFile reads the key used to sign the session cookies from the file.
+func (c *Config) WithForwardAuthKeyFile(p string) (*Config, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return c, errors.New("Could not read session key file " + p + ": " + err.Error())
+	}
+	k := []byte(strings.TrimSpace(string(b)))
+	if len(k) < minSessionKeyLength {
+		return c, errors.New("The session signing key in " + p + " must be at least 32 bytes")
+	}
+	c.ForwardAuth.KeyFile = &p
+	c.ForwardAuth.Key = k
+	return c, nil
+}
+
+func (c *Config) WithForwardAuthCookie(name, d string, timeout time.Duration) *Config {
+	c.ForwardAuth.CookieName = name
+	c.ForwardAuth.CookieDomain = d
+	c.ForwardAuth.SessionTimeout = int(timeout / time.Second)
+	return c
+}
+
+func (c *Config) validateForwardAuth() error {
+	if c.ForwardAuth.SessionTimeout < 0 {
+		return errors.New("SessionTimeout cannot be negative")
+	}
+	if c.ForwardAuth.KeyFile != nil {
+		_, err := c.WithForwardAuthKeyFile(*c.ForwardAuth.KeyFile)
+		return err
+	}
+	//Without a key file sessions do not survive a restart of the MFA server
+	k := make([]byte, minSessionKeyLength)
+	if _, err := rand.Read(k); err != nil {
+		return errors.New("Could not generate session signing key: " + err.Error())
+	}
+	c.ForwardAuth.Key = k
+	return nil
+}
+
+// ForwardAuthEnabled reports whether the forward authentication endpoints are configured.
+func (c *Config) ForwardAuthEnabled() bool {
+	return c.ForwardAuth.Issuer != nil && len(c.ForwardAuth.Key) > 0
+}
+
+// SessionCookieName returns the name of the forward authentication session cookie.
+func (c *Config) SessionCookieName() string {
+	if c.ForwardAuth.CookieName != "" {
+		return c.ForwardAuth.CookieName
+	}
+	return defaultSessionCookieName
+}
+
+// SessionTimeout returns how long a forward authentication session lasts.
+func (c *Config) SessionTimeout() time.Duration {
+	if c.ForwardAuth.SessionTimeout > 0 {
+		return time.Duration(c.ForwardAuth.SessionTimeout) * time.Second
+	}
+	return defaultSessionTimeout
+}
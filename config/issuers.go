@@ -0,0 +1,80 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Password verifiers used to check the first factor of a user's authentication
+const (
+	// Bind to the LDAP directory for the user's domain
+	VerifierLDAP = "ldap"
+	// Compare against the bcrypt hashes in an htpasswd file
+	VerifierHtpasswd = "htpasswd"
+	// The calling application, authenticated as one of the Applications, vouches for the password
+	VerifierDelegated = "delegated"
+)
+
+var validVerifiers = []string{VerifierLDAP, VerifierHtpasswd, VerifierDelegated}
+
+// IssuerConf defines settings specific to an issuer.
+type IssuerConf struct {
+	PasswordVerifier string  `json:"PasswordVerifier"`
+	HtpasswdFile     *string `json:"HtpasswdFile"`
+}
+
+func (i *IssuerConf) validate() error {
+	if !StringInSlice(i.PasswordVerifier, validVerifiers) {
+		return errors.New(fmt.Sprintf("Unknown password verifier %s. Accepted values are %q", i.PasswordVerifier, validVerifiers))
+	}
+	if i.PasswordVerifier == VerifierHtpasswd {
+		if i.HtpasswdFile == nil {
+			return errors.New("No HtpasswdFile defined for the htpasswd password verifier")
+		}
+		if _, err := os.Stat(*i.HtpasswdFile); err != nil {
+			return errors.New("HtpasswdFile could not be openned: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// WithIssuerHtpasswd verifies the passwords of users of the issuer against the bcrypt hashes in the htpasswd file.
+func (c *Config) WithIssuerHtpasswd(issuer, path string) (*Config, error) {
+	return c.withIssuer(issuer, &IssuerConf{
+		PasswordVerifier: VerifierHtpasswd,
+		HtpasswdFile:     &path,
+	})
+}
+
+// WithIssuerDelegated trusts the applications permitted to use the issuer to have verified the passwords of its users.
+// Applications must also be configured so that the calling application is authenticated.
+func (c *Config) WithIssuerDelegated(issuer string) (*Config, error) {
+	return c.withIssuer(issuer, &IssuerConf{
+		PasswordVerifier: VerifierDelegated,
+	})
+}
+
+func (c *Config) withIssuer(issuer string, i *IssuerConf) (*Config, error) {
+	if err := i.validate(); err != nil {
+		return c, errors.New("Invalid configuration for issuer " + issuer + ": " + err.Error())
+	}
+	if c.Issuers == nil {
+		c.Issuers = make(map[string]*IssuerConf)
+	}
+	c.Issuers[issuer] = i
+	return c, nil
+}
+
+// Issuer returns the settings for the issuer. Issuers that are not configured verify passwords with LDAP.
+func (c *Config) Issuer(issuer string) *IssuerConf {
+	if i, ok := c.Issuers[issuer]; ok && i != nil {
+		return i
+	}
+	return &IssuerConf{PasswordVerifier: VerifierLDAP}
+}
+
+// PasswordRequired reports whether users of the issuer must provide their password to the MFA server.
+func (c *Config) PasswordRequired(issuer string) bool {
+	return c.Issuer(issuer).PasswordVerifier != VerifierDelegated
+}
@@ -0,0 +1,295 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	vaultAPI "github.com/hashicorp/vault/api"
+	"github.com/jcmturner/mfaserver/vault"
+	"io"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Formats of the log
+const (
+	LogFormatText   = "text"
+	LogFormatJSON   = "json"
+	LogFormatLogfmt = "logfmt"
+)
+
+var validLogFormats = []string{LogFormatText, LogFormatJSON, LogFormatLogfmt}
+
+// Serialises the creation of the Vault login and client shared by the copies of the configuration made for requests
+var vaultMux sync.Mutex
+
+// RequestLog holds the fields logged once a request has been handled. The application and administrator that made the
+// request are only recorded in the audit log.
+type RequestLog struct {
+	ID          string
+	RemoteAddr  string
+	Endpoint    string
+	Application string
+	Admin       string
+	Issuer      string
+	Domain      string
+	Username    string
+	Outcome     string
+	Status      int
+	Duration    time.Duration
+}
+
+// logField is a named value of a structured log entry.
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// recordWriter turns the lines written by a log.Logger into structured log entries.
+type recordWriter struct {
+	l     *Loggers
+	level string
+}
+
+// WithLogFormat sets the format of the log entries: text, json or logfmt.
+func (c *Config) WithLogFormat(f string) (*Config, error) {
+	if !isValidLogFormat(f) {
+		return c, errors.New(fmt.Sprintf("An invalid log format of %s was provided. Accepted values are %v", f, validLogFormats))
+	}
+	c.MFAServer.LogFormat = &f
+	if c.MFAServer.LogLevel == nil {
+		return c, nil
+	}
+	if err := loggerSetUp(c); err != nil {
+		return c, errors.New(fmt.Sprintf("Configuring loggers failed: %v", err))
+	}
+	return c, nil
+}
+
+// ForRequest returns a copy of the configuration for handling a request. Its loggers include the request's ID in each
+// entry and it holds the fields logged once the request has been handled. Done must be called once the request has been
+// handled. If the configuration has just been replaced by a reload the request is handled with the new configuration.
+func (c *Config) ForRequest(id, remoteAddr string) *Config {
+	lc := c.Loaded()
+	for !lc.usage.acquire() {
+		lc = lc.usage.next
+	}
+	lc.prepareVault()
+	rc := *lc
+	rc.loaded = lc
+	rc.MFAServer.Loggers = lc.MFAServer.Loggers.WithRequestID(id)
+	rc.Request = &RequestLog{ID: id, RemoteAddr: remoteAddr}
+	return &rc
+}
+
+// Done records that the request the configuration was copied for has been handled. Once a configuration replaced by a
+// reload is no longer used by any request its connections and files are closed.
+func (c *Config) Done() {
+	c.Loaded().usage.done()
+}
+
+// Loaded returns the configuration as it was loaded, rather than the copy made of it for a request.
+func (c *Config) Loaded() *Config {
+	if c.loaded != nil {
+		return c.loaded
+	}
+	return c
+}
+
+// SetRequestContext sets the context of the request, which holds the span of the request's trace that the calls made
+// to the LDAP directory and the Vault are recorded in.
+func (c *Config) SetRequestContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// Context returns the context of the request the configuration was copied for, or the background context.
+func (c *Config) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// SetRequestUser records the user a request is for so that it is included when the request is logged.
+func (c *Config) SetRequestUser(issuer, domain, username string) {
+	if c.Request == nil {
+		return
+	}
+	c.Request.Issuer = issuer
+	c.Request.Domain = domain
+	c.Request.Username = username
+}
+
+// SetRequestApplication records the application that made a request.
+func (c *Config) SetRequestApplication(name string) {
+	if c.Request != nil {
+		c.Request.Application = name
+	}
+}
+
+// SetRequestAdmin records the administrator that made a request.
+func (c *Config) SetRequestAdmin(domain, username string) {
+	if c.Request != nil {
+		c.Request.Admin = domain + "/" + username
+	}
+}
+
+// prepareVault creates the Vault login and client, if they have not been already, so that they are shared by the copies
+// of the configuration made for requests rather than each request logging in to the Vault.
+func (c *Config) prepareVault() {
+	vaultMux.Lock()
+	defer vaultMux.Unlock()
+	if c.Vault.VaultLogin == nil && c.Vault.VaultReSTClientConfig != nil && c.Vault.AppIDWrite != nil && c.Vault.UserID != nil {
+		var l vault.Login
+		if err := l.NewRequest(c.Vault.VaultReSTClientConfig, *c.Vault.AppIDWrite, *c.Vault.UserID); err == nil {
+			c.Vault.VaultLogin = &l
+		}
+	}
+	if c.Vault.VaultClient == nil && c.Vault.VaultConfig != nil {
+		if vc, err := vaultAPI.NewClient(c.Vault.VaultConfig); err == nil {
+			c.Vault.VaultClient = vc
+		}
+	}
+}
+
+// currentLoggers holds the loggers of the configuration in use. The SIEM sink and webhook dispatcher are kept across
+// reloads so they report their errors to these rather than the loggers of the configuration that opened them.
+type currentLoggers struct {
+	v atomic.Value
+}
+
+func newCurrentLoggers(l *Loggers) *currentLoggers {
+	cl := new(currentLoggers)
+	cl.v.Store(l)
+	return cl
+}
+
+// Error returns the error logger of the configuration in use.
+func (cl *currentLoggers) Error() *log.Logger {
+	return cl.v.Load().(*Loggers).Error
+}
+
+// WithRequestID returns loggers that include the request ID in each entry.
+func (l *Loggers) WithRequestID(id string) *Loggers {
+	if l.out == nil {
+		//The loggers have not been set up from the configuration
+		return l
+	}
+	rl := *l
+	rl.requestID = id
+	for level, lg := range map[string]**log.Logger{"DEBUG": &rl.Debug, "INFO": &rl.Info, "WARNING": &rl.Warning, "ERROR": &rl.Error} {
+		if (*lg).Writer() != ioutil.Discard {
+			*lg = rl.newLogger(level)
+		}
+	}
+	return &rl
+}
+
+// Request logs a request once it has been handled.
+func (l *Loggers) Request(e *RequestLog) {
+	fields := []logField{
+		{"remote_addr", e.RemoteAddr},
+		{"endpoint", e.Endpoint},
+		{"issuer", e.Issuer},
+		{"domain", e.Domain},
+		{"username", e.Username},
+		{"outcome", e.Outcome},
+		{"status", e.Status},
+		{"duration", e.Duration.Seconds()},
+	}
+	if l.out == nil || l.format == LogFormatText {
+		l.Info.Printf("%s, Request completed: %s", e.RemoteAddr, formatLogfmt(fields))
+		return
+	}
+	if l.Info.Writer() != ioutil.Discard {
+		l.write("INFO", "Request completed", fields...)
+	}
+}
+
+func (l *Loggers) newLogger(level string) *log.Logger {
+	if l.format == LogFormatText {
+		prefix := level + ": "
+		if l.requestID != "" {
+			prefix += "[" + l.requestID + "] "
+		}
+		return log.New(l.out, prefix, log.Ldate|log.Ltime|log.Lshortfile)
+	}
+	return log.New(&recordWriter{l: l, level: level}, "", log.Lshortfile)
+}
+
+// write writes a structured log entry. Each entry is written with a single call so that entries written concurrently
+// are not interleaved.
+func (l *Loggers) write(level, msg string, fields ...logField) error {
+	fs := []logField{{"time", time.Now().UTC().Format(time.RFC3339Nano)}, {"level", level}}
+	if l.requestID != "" {
+		fs = append(fs, logField{"request_id", l.requestID})
+	}
+	fs = append(fs, fields...)
+	fs = append(fs, logField{"msg", msg})
+	var s string
+	if l.format == LogFormatJSON {
+		s = formatJSON(fs)
+	} else {
+		s = formatLogfmt(fs)
+	}
+	_, err := io.WriteString(l.out, s+"\n")
+	return err
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	var caller string
+	if i := strings.Index(msg, ": "); i > 0 {
+		caller, msg = msg[:i], msg[i+2:]
+	}
+	if err := w.l.write(w.level, msg, logField{"caller", caller}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// formatJSON formats the fields as a JSON object, keeping them in order.
+func formatJSON(fields []logField) string {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(f.key)
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(f.value))
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// formatLogfmt formats the fields as key=value pairs, quoting values that are empty or contain spaces, quotes or equals
+// signs.
+func formatLogfmt(fields []logField) string {
+	s := make([]string, len(fields))
+	for i, f := range fields {
+		v := fmt.Sprint(f.value)
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		s[i] = f.key + "=" + v
+	}
+	return strings.Join(s, " ")
+}
+
+func isValidLogFormat(f string) bool {
+	return StringInSlice(f, validLogFormats)
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"errors"
+	"github.com/jcmturner/mfaserver/session"
+	"net/url"
+	"time"
+)
+
+// Seconds an OpenID Connect authorization code can be exchanged for tokens if no CodeLifetime is configured
+const defaultOIDCCodeLifetime = 60
+
+// OIDCConf defines the OpenID Connect provider and the relying parties registered with it.
+type OIDCConf struct {
+	Domain       *string                    `json:"Domain"`
+	CodeLifetime int                        `json:"CodeLifetime"`
+	Clients      map[string]*OIDCClientConf `json:"Clients"`
+	Codes        *session.Store
+}
+
+// OIDCClientConf defines a relying party permitted to request authentication of users.
+// Clients without a secret are public clients and must use PKCE.
+type OIDCClientConf struct {
+	Secret       string   `json:"Secret"`
+	RedirectURIs []string `json:"RedirectURIs"`
+	Issuer       string   `json:"Issuer"`
+}
+
+func (o *OIDCClientConf) validate() error {
+	if len(o.RedirectURIs) == 0 {
+		return errors.New("No RedirectURIs defined")
+	}
+	for _, r := range o.RedirectURIs {
+		u, err := url.Parse(r)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return errors.New("RedirectURI " + r + " is not an absolute URI without a fragment")
+		}
+	}
+	if o.Issuer == "" {
+		return errors.New("No Issuer defined")
+	}
+	return nil
+}
+
+// RedirectURIPermitted reports whether the URI exactly matches one registered for the client.
+func (o *OIDCClientConf) RedirectURIPermitted(r string) bool {
+	return StringInSlice(r, o.RedirectURIs)
+}
+
+// WithOIDCClient registers a relying party that authenticates users of the MFA issuer.
+func (c *Config) WithOIDCClient(id, secret string, redirectURIs []string, issuer string) (*Config, error) {
+	o := &OIDCClientConf{
+		Secret:       secret,
+		RedirectURIs: redirectURIs,
+		Issuer:       issuer,
+	}
+	if err := o.validate(); err != nil {
+		return c, errors.New("Invalid configuration for OIDC client " + id + ": " + err.Error())
+	}
+	if c.OIDC.Clients == nil {
+		c.OIDC.Clients = make(map[string]*OIDCClientConf)
+	}
+	c.OIDC.Clients[id] = o
+	if c.OIDC.Codes == nil {
+		c.OIDC.Codes = session.NewStore(c.OIDCCodeLifetime())
+	}
+	return c, nil
+}
+
+func (c *Config) validateOIDC() error {
+	if !c.AssertionsEnabled() {
+		return errors.New("Assertions KeyFiles must be defined to sign ID tokens")
+	}
+	if u, err := url.Parse(c.Assertions.Issuer); err != nil || !u.IsAbs() {
+		return errors.New("The Assertions Issuer must be the URL of the MFA server")
+	}
+	if c.OIDC.CodeLifetime < 0 {
+		return errors.New("CodeLifetime cannot be negative")
+	}
+	for id, o := range c.OIDC.Clients {
+		if o == nil {
+			return errors.New("No configuration for OIDC client " + id)
+		}
+		if err := o.validate(); err != nil {
+			return errors.New("Invalid configuration for OIDC client " + id + ": " + err.Error())
+		}
+	}
+	c.OIDC.Codes = session.NewStore(c.OIDCCodeLifetime())
+	return nil
+}
+
+// OIDCEnabled reports whether the OpenID Connect provider endpoints are configured.
+func (c *Config) OIDCEnabled() bool {
+	return len(c.OIDC.Clients) > 0 && c.OIDC.Codes != nil && c.AssertionsEnabled()
+}
+
+// OIDCClient returns the settings of the registered relying party.
+func (c *Config) OIDCClient(id string) (*OIDCClientConf, bool) {
+	o, ok := c.OIDC.Clients[id]
+	return o, ok && o != nil
+}
+
+// OIDCCodeLifetime returns how long an authorization code can be exchanged for tokens.
+func (c *Config) OIDCCodeLifetime() time.Duration {
+	if c.OIDC.CodeLifetime > 0 {
+		return time.Duration(c.OIDC.CodeLifetime) * time.Second
+	}
+	return defaultOIDCCodeLifetime * time.Second
+}
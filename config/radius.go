@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Methods of providing the OTP in a RADIUS Access-Request
+const (
+	// The OTP is appended to the end of the user's password in the User-Password attribute
+	RADIUSOTPConcatenated = "Concatenated"
+	// The password is sent first and the OTP is sent in reply to an Access-Challenge
+	RADIUSOTPChallenge = "Challenge"
+)
+
+var validRADIUSOTPModes = []string{RADIUSOTPConcatenated, RADIUSOTPChallenge}
+
+// Seconds a user has to respond to an Access-Challenge if no ChallengeTimeout is configured
+const defaultRADIUSChallengeTimeout = 60
+
+// RADIUSConf defines the optional RADIUS (RFC 2865) listener.
+type RADIUSConf struct {
+	ListenerSocket   *string                      `json:"ListenerSocket"`
+	Issuer           *string                      `json:"Issuer"`
+	Domain           *string                      `json:"Domain"`
+	ChallengeTimeout int                          `json:"ChallengeTimeout"`
+	Clients          map[string]*RADIUSClientConf `json:"Clients"`
+}
+
+// RADIUSClientConf defines a RADIUS client, such as a VPN concentrator, permitted to send requests.
+// Access-Requests must include a valid Message-Authenticator (RFC 3579) unless AllowMissingMessageAuthenticator is set
+// for clients that cannot send one.
+type RADIUSClientConf struct {
+	Secret                           string  `json:"Secret"`
+	OTPMode                          string  `json:"OTPMode"`
+	Issuer                           *string `json:"Issuer"`
+	AllowMissingMessageAuthenticator bool    `json:"AllowMissingMessageAuthenticator"`
+}
+
+func (r *RADIUSClientConf) validate() error {
+	if r.Secret == "" {
+		return errors.New("No Secret defined")
+	}
+	if !StringInSlice(r.OTPMode, validRADIUSOTPModes) {
+		return errors.New(fmt.Sprintf("Unknown OTPMode %s. Accepted values are %q", r.OTPMode, validRADIUSOTPModes))
+	}
+	return nil
+}
+
+func (c *Config) WithRADIUSListener(socket, issuer, d string) *Config {
+	c.RADIUS.ListenerSocket = &socket
+	c.RADIUS.Issuer = &issuer
+	if d != "" {
+		c.RADIUS.Domain = &d
+	}
+	return c
+}
+
+// WithRADIUSClient permits requests from the client address, or CIDR range, that are signed with the shared secret.
+func (c *Config) WithRADIUSClient(addr, secret, mode string) (*Config, error) {
+	r := &RADIUSClientConf{
+		Secret:  secret,
+		OTPMode: mode,
+	}
+	if err := validateRADIUSClient(addr, r); err != nil {
+		return c, err
+	}
+	if c.RADIUS.Clients == nil {
+		c.RADIUS.Clients = make(map[string]*RADIUSClientConf)
+	}
+	c.RADIUS.Clients[addr] = r
+	return c, nil
+}
+
+func validateRADIUSClient(addr string, r *RADIUSClientConf) error {
+	if _, _, err := net.ParseCIDR(addr); err != nil && net.ParseIP(addr) == nil {
+		return errors.New("RADIUS client " + addr + " is not a valid IP address or CIDR range")
+	}
+	if err := r.validate(); err != nil {
+		return errors.New("Invalid configuration for RADIUS client " + addr + ": " + err.Error())
+	}
+	return nil
+}
+
+func (c *Config) validateRADIUS() error {
+	if c.RADIUS.Issuer == nil {
+		return errors.New("No Issuer defined for the RADIUS listener")
+	}
+	if c.RADIUS.ChallengeTimeout < 0 {
+		return errors.New("ChallengeTimeout cannot be negative")
+	}
+	if len(c.RADIUS.Clients) == 0 {
+		return errors.New("No RADIUS Clients defined")
+	}
+	for a, r := range c.RADIUS.Clients {
+		if r == nil {
+			return errors.New("No configuration for RADIUS client " + a)
+		}
+		if r.OTPMode == "" {
+			r.OTPMode = RADIUSOTPConcatenated
+		}
+		if err := validateRADIUSClient(a, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RADIUSClient returns the settings for the RADIUS client at the IP address.
+// An exact address match is preferred, otherwise the most specific CIDR range containing the address is used.
+func (c *Config) RADIUSClient(ip net.IP) *RADIUSClientConf {
+	if ip == nil {
+		return nil
+	}
+	var match *RADIUSClientConf
+	bits := -1
+	for a, r := range c.RADIUS.Clients {
+		if !strings.Contains(a, "/") {
+			if ip.Equal(net.ParseIP(a)) {
+				return r
+			}
+			continue
+		}
+		if _, n, err := net.ParseCIDR(a); err == nil && n.Contains(ip) {
+			if ones, _ := n.Mask.Size(); ones > bits {
+				match = r
+				bits = ones
+			}
+		}
+	}
+	return match
+}
+
+// RADIUSChallengeTimeout returns the number of seconds a user has to respond to an Access-Challenge.
+func (c *Config) RADIUSChallengeTimeout() int {
+	if c.RADIUS.ChallengeTimeout > 0 {
+		return c.RADIUS.ChallengeTimeout
+	}
+	return defaultRADIUSChallengeTimeout
+}
+
+// RADIUSIssuer returns the issuer for requests from the RADIUS client.
+func (c *Config) RADIUSIssuer(r *RADIUSClientConf) string {
+	if r != nil && r.Issuer != nil {
+		return *r.Issuer
+	}
+	if c.RADIUS.Issuer != nil {
+		return *c.RADIUS.Issuer
+	}
+	return ""
+}
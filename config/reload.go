@@ -0,0 +1,399 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/jcmturner/mfaserver/audit"
+	"github.com/jcmturner/mfaserver/siem"
+	"github.com/jcmturner/mfaserver/webhook"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Holder holds the current configuration so that it can be replaced, while requests are being served, when the
+// configuration file or TLS certificate is changed. Requests already in progress continue with the configuration they
+// started with and the replaced configuration's connections and files are closed once they have been handled.
+type Holder struct {
+	path    string
+	v       atomic.Value
+	mux     sync.Mutex
+	raw     []byte
+	modTime map[string]time.Time
+}
+
+// usage counts the requests using a configuration so that the connections and files it holds are only closed, once it
+// has been replaced, after the requests using it have been handled.
+type usage struct {
+	mux     sync.Mutex
+	active  int
+	retired bool
+	next    *Config
+	release []func()
+}
+
+// acquire records a request using the configuration. It returns false if the configuration has been replaced, in which
+// case the request should use the next configuration.
+func (u *usage) acquire() bool {
+	if u == nil {
+		return true
+	}
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if u.retired {
+		return false
+	}
+	u.active++
+	return true
+}
+
+// done records that a request has finished using the configuration. The release functions are called in the
+// background so that the request is not delayed while the SIEM sink sends the events queued.
+func (u *usage) done() {
+	if u == nil {
+		return
+	}
+	u.mux.Lock()
+	u.active--
+	var release []func()
+	if u.retired && u.active == 0 {
+		release, u.release = u.release, nil
+	}
+	u.mux.Unlock()
+	if len(release) > 0 {
+		go func() {
+			for _, f := range release {
+				f()
+			}
+		}()
+	}
+}
+
+// retire records that the configuration has been replaced by next. The release function is called once no requests
+// are using the configuration.
+func (u *usage) retire(next *Config, release func()) {
+	if u == nil {
+		release()
+		return
+	}
+	u.mux.Lock()
+	u.retired = true
+	u.next = next
+	if u.active > 0 {
+		u.release = append(u.release, release)
+		u.mux.Unlock()
+		return
+	}
+	u.mux.Unlock()
+	release()
+}
+
+// NewHolder loads the configuration file so that it can later be reloaded.
+func NewHolder(path string) (*Holder, error) {
+	j, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("Configuration file could not be openned: " + path + " " + err.Error())
+	}
+	c, err := load(j)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.OpenAuditLog(); err != nil {
+		return nil, errors.New("Audit configuration not valid: " + err.Error())
+	}
+	if err := c.OpenSIEM(); err != nil {
+		return nil, errors.New("SIEM configuration not valid: " + err.Error())
+	}
+	if err := c.OpenWebhooks(); err != nil {
+		return nil, errors.New("Webhooks configuration not valid: " + err.Error())
+	}
+	h := &Holder{path: path, raw: j}
+	h.v.Store(c)
+	h.modTime = watchedModTimes(path, c)
+	return h, nil
+}
+
+// Config returns the current configuration.
+func (h *Holder) Config() *Config {
+	return h.v.Load().(*Config)
+}
+
+// Reload re-reads the configuration file and the files it references. The new configuration is only used if it is
+// valid, otherwise the current configuration is kept and the error returned.
+func (h *Holder) Reload() error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	old := h.Config()
+	j, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		err = errors.New("Configuration file could not be openned: " + h.path + " " + err.Error())
+		old.MFAServer.Loggers.Error.Printf("Configuration reload failed, continuing with the current configuration: %v", err)
+		return err
+	}
+	c, err := loadReplacing(j, old)
+	if err != nil {
+		old.MFAServer.Loggers.Error.Printf("Configuration reload failed, continuing with the current configuration: %v", err)
+		return err
+	}
+	c.carryOver(old)
+	fail := func(err error) error {
+		old.MFAServer.Loggers.Error.Printf("Configuration reload failed, continuing with the current configuration: %v", err)
+		c.discard(old)
+		return err
+	}
+	if err := c.OpenAuditLog(); err != nil {
+		return fail(errors.New("Audit configuration not valid: " + err.Error()))
+	}
+	if err := c.OpenSIEM(); err != nil {
+		return fail(errors.New("SIEM configuration not valid: " + err.Error()))
+	}
+	if err := c.OpenWebhooks(); err != nil {
+		return fail(errors.New("Webhooks configuration not valid: " + err.Error()))
+	}
+	//The dispatcher kept for the outbox delivers to the subscriptions now configured
+	if d := c.Webhooks.Dispatcher; d != nil && d == old.Webhooks.Dispatcher {
+		if err := d.SetSubscriptions(c.webhookSubscriptions(), c.Webhooks.MaxAttempts); err != nil {
+			c.MFAServer.Loggers.Error.Printf("Webhook subscriptions could not be updated: %v", err)
+		}
+	}
+	changed := changedSections(h.raw, j)
+	certChanged := certificateChanged(old, c)
+	if certChanged {
+		changed = append(changed, "MFAServer.TLS certificate")
+	}
+	release := old.replacedBy(c)
+	h.v.Store(c)
+	c.loggers.v.Store(c.MFAServer.Loggers)
+	old.usage.retire(c, release)
+	h.raw = j
+	h.modTime = watchedModTimes(h.path, c)
+	if len(changed) == 0 {
+		c.MFAServer.Loggers.Info.Println("Configuration reloaded with no changes")
+		return nil
+	}
+	c.MFAServer.Loggers.Info.Printf("Configuration reloaded. Changed: %s", strings.Join(changed, ", "))
+	for _, s := range restartRequired(old, c) {
+		c.MFAServer.Loggers.Warning.Printf("The change to %s only takes effect when the MFA server is restarted", s)
+	}
+	if cert := c.MFAServer.TLS.Certificate; certChanged && cert != nil && len(cert.Certificate) > 0 {
+		d := sha256.Sum256(cert.Certificate[0])
+		c.MFAServer.Loggers.Info.Printf("TLS certificate now in use has SHA-256 fingerprint %s", hex.EncodeToString(d[:]))
+	}
+	return nil
+}
+
+// Watch checks the configuration file, TLS certificate and key and client CA file for modifications at the interval
+// and reloads the configuration when they change.
+func (h *Holder) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		h.mux.Lock()
+		m := watchedModTimes(h.path, h.Config())
+		changed := !reflect.DeepEqual(m, h.modTime)
+		if changed {
+			//Only attempt to reload the same files once even if they are not valid
+			h.modTime = m
+		}
+		h.mux.Unlock()
+		if changed {
+			h.Reload()
+		}
+	}
+}
+
+// carryOver keeps the state of the previous configuration that must survive a reload.
+func (c *Config) carryOver(old *Config) {
+	//The SIEM sink and webhook dispatcher kept report their errors to the loggers of the configuration in use
+	c.loggers = old.loggers
+	//Sessions signed with a random key would otherwise all become invalid
+	if c.ForwardAuth.KeyFile == nil && old.ForwardAuth.KeyFile == nil && old.ForwardAuth.Key != nil && c.ForwardAuth.Key != nil {
+		c.ForwardAuth.Key = old.ForwardAuth.Key
+	}
+	//Keep using the Vault token so that it can be revoked on shutdown
+	if reflect.DeepEqual(c.Vault.VaultReSTClientConfig.EndPoint, old.Vault.VaultReSTClientConfig.EndPoint) &&
+		reflect.DeepEqual(c.Vault.VaultReSTClientConfig.TrustCACert, old.Vault.VaultReSTClientConfig.TrustCACert) &&
+		reflect.DeepEqual(c.Vault.AppIDWrite, old.Vault.AppIDWrite) && reflect.DeepEqual(c.Vault.UserID, old.Vault.UserID) {
+		c.Vault.VaultLogin = old.Vault.VaultLogin
+		c.Vault.VaultClient = old.Vault.VaultClient
+	}
+	//Only one audit log can write to the chain of records at a time
+	if reflect.DeepEqual(c.Audit.File, old.Audit.File) && reflect.DeepEqual(c.Audit.Syslog, old.Audit.Syslog) &&
+		reflect.DeepEqual(c.Audit.SigningKeyFile, old.Audit.SigningKeyFile) && c.Audit.SignInterval == old.Audit.SignInterval {
+		c.Audit.Log = old.Audit.Log
+	}
+	//Only one dispatcher can use the webhook outbox at a time, so it is kept and given the new subscriptions
+	if len(c.Webhooks.Subscriptions) > 0 && reflect.DeepEqual(c.Webhooks.OutboxFile, old.Webhooks.OutboxFile) {
+		c.Webhooks.Dispatcher = old.Webhooks.Dispatcher
+	}
+	//Events still queued for the SIEM would otherwise be lost
+	if s, o := c.MFAServer.SIEM, old.MFAServer.SIEM; s != nil && o != nil && s.Network == o.Network && s.Address == o.Address &&
+		s.Format == o.Format && reflect.DeepEqual(s.TrustCACert, o.TrustCACert) {
+		s.Sink = o.Sink
+	}
+	//Authorization codes issued but not yet exchanged
+	if c.OIDC.Codes != nil && old.OIDC.Codes != nil && c.OIDCCodeLifetime() == old.OIDCCodeLifetime() {
+		c.OIDC.Codes = old.OIDC.Codes
+	}
+}
+
+// replacedBy hands the audit log over to the configuration replacing this one and returns the function that closes the
+// connections and files that are not shared with it, once no requests are using this configuration.
+func (c *Config) replacedBy(next *Config) func() {
+	var f *os.File
+	if c.MFAServer.Loggers.file != next.MFAServer.Loggers.file {
+		f = c.MFAServer.Loggers.file
+	}
+	var l *audit.Log
+	if c.Audit.Log != next.Audit.Log {
+		l = c.Audit.Log
+	}
+	//Events recorded by the requests still using the old audit log are written to the new one
+	if l != nil && next.Audit.Log != nil {
+		if err := l.HandOver(next.Audit.Log); err != nil {
+			next.MFAServer.Loggers.Error.Printf("Audit log could not be handed over to the reloaded configuration: %v", err)
+		}
+		l = nil
+	}
+	var s *siem.Sink
+	if c.siemSink() != next.siemSink() {
+		s = c.siemSink()
+	}
+	var d *webhook.Dispatcher
+	if c.Webhooks.Dispatcher != next.Webhooks.Dispatcher {
+		d = c.Webhooks.Dispatcher
+	}
+	return func() {
+		c.CloseLDAPConnections()
+		if f != nil {
+			f.Close()
+		}
+		if l != nil {
+			l.Close()
+		}
+		if s != nil {
+			s.Close(siemCloseTimeout)
+		}
+		if d != nil {
+			if err := d.Close(); err != nil {
+				next.loggers.Error().Printf("Webhook outbox could not be closed: %v", err)
+			}
+		}
+	}
+}
+
+// discard closes the connections and files of a configuration that failed to load in place of the old configuration,
+// other than those shared with the old configuration.
+func (c *Config) discard(old *Config) {
+	c.CloseLDAPConnections()
+	if f := c.MFAServer.Loggers.file; f != nil && f != old.MFAServer.Loggers.file {
+		f.Close()
+	}
+	if c.Audit.Log != nil && c.Audit.Log != old.Audit.Log {
+		c.Audit.Log.Close()
+	}
+	if s := c.siemSink(); s != nil && s != old.siemSink() {
+		c.CloseSIEM()
+	}
+	if d := c.Webhooks.Dispatcher; d != nil && d != old.Webhooks.Dispatcher {
+		c.CloseWebhooks()
+	}
+}
+
+// changedSections lists the top level sections, and the keys of the MFAServer section, that differ between the
+// configuration files. Values are not logged as they may contain secrets.
+func changedSections(old, new []byte) []string {
+	var o, n map[string]json.RawMessage
+	json.Unmarshal(old, &o)
+	json.Unmarshal(new, &n)
+	var changed []string
+	for _, k := range unionKeys(o, n) {
+		if k == "MFAServer" {
+			var om, nm map[string]json.RawMessage
+			json.Unmarshal(o[k], &om)
+			json.Unmarshal(n[k], &nm)
+			for _, mk := range unionKeys(om, nm) {
+				if !jsonEqual(om[mk], nm[mk]) {
+					changed = append(changed, k+"."+mk)
+				}
+			}
+			continue
+		}
+		if !jsonEqual(o[k], n[k]) {
+			changed = append(changed, k)
+		}
+	}
+	return changed
+}
+
+// restartRequired lists the changes that cannot be applied to the running listeners.
+func restartRequired(old, c *Config) []string {
+	var s []string
+	if !reflect.DeepEqual(old.MFAServer.ListenerSocket, c.MFAServer.ListenerSocket) {
+		s = append(s, "MFAServer.ListenerSocket")
+	}
+	if !reflect.DeepEqual(old.MFAServer.GRPCListenerSocket, c.MFAServer.GRPCListenerSocket) {
+		s = append(s, "MFAServer.GRPCListenerSocket")
+	}
+	if old.MFAServer.TLS.Enabled != c.MFAServer.TLS.Enabled {
+		s = append(s, "MFAServer.TLS.Enabled")
+	}
+	if !reflect.DeepEqual(old.RADIUS.ListenerSocket, c.RADIUS.ListenerSocket) {
+		s = append(s, "RADIUS.ListenerSocket")
+	}
+	if !reflect.DeepEqual(old.Tracing, c.Tracing) {
+		s = append(s, "Tracing")
+	}
+	return s
+}
+
+func certificateChanged(old, c *Config) bool {
+	o, n := old.MFAServer.TLS.Certificate, c.MFAServer.TLS.Certificate
+	if o == nil || n == nil {
+		return o != n
+	}
+	return len(o.Certificate) == 0 || len(n.Certificate) == 0 || !bytes.Equal(o.Certificate[0], n.Certificate[0])
+}
+
+func watchedModTimes(path string, c *Config) map[string]time.Time {
+	files := []string{path}
+	for _, f := range []*string{c.MFAServer.TLS.CertificateFile, c.MFAServer.TLS.KeyFile, c.MFAServer.TLS.ClientCAFile} {
+		if f != nil {
+			files = append(files, *f)
+		}
+	}
+	m := make(map[string]time.Time)
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			m[f] = fi.ModTime()
+		}
+	}
+	return m
+}
+
+func unionKeys(a, b map[string]json.RawMessage) []string {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var av, bv interface{}
+	json.Unmarshal(a, &av)
+	json.Unmarshal(b, &bv)
+	return reflect.DeepEqual(av, bv)
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Administrative roles
+const (
+	RoleSuperAdmin = "superadmin"
+	RoleHelpdesk   = "helpdesk"
+	RoleAuditor    = "auditor"
+)
+
+// Permissions granted by the administrative roles
+const (
+	// Delete a user's MFA secret so that they must enrol again
+	PermissionReset = "reset"
+	// Read only listing of enroled users
+	PermissionList = "list"
+	// Manage the running MFA server
+	PermissionManage = "manage"
+)
+
+var rolePermissions = map[string][]string{
+	RoleSuperAdmin: {PermissionReset, PermissionList, PermissionManage},
+	RoleHelpdesk:   {PermissionReset},
+	RoleAuditor:    {PermissionList},
+}
+
+// AdminRole grants a role to the members of LDAP groups.
+// The role can be scoped to specific issuers and domains. If no issuers or domains are listed the role applies to all.
+type AdminRole struct {
+	Role     string   `json:"Role"`
+	GroupDNs []string `json:"GroupDNs"`
+	Issuers  []string `json:"Issuers"`
+	Domains  []string `json:"Domains"`
+}
+
+func (a *AdminRole) validate() error {
+	if _, ok := rolePermissions[a.Role]; !ok {
+		return errors.New(fmt.Sprintf("Unknown role %s. Accepted values are %q", a.Role, []string{RoleSuperAdmin, RoleHelpdesk, RoleAuditor}))
+	}
+	if len(a.GroupDNs) == 0 {
+		return errors.New("No GroupDNs defined for role " + a.Role)
+	}
+	return nil
+}
+
+func (a *AdminRole) grants(p, issuer, domain string) bool {
+	return StringInSlice(p, rolePermissions[a.Role]) &&
+		(len(a.Issuers) == 0 || StringInSlice(issuer, a.Issuers)) &&
+		(len(a.Domains) == 0 || StringInSlice(domain, a.Domains))
+}
+
+// WithAdminRole grants the role to members of the groups for the issuers and domains listed.
+func (c *Config) WithAdminRole(role string, gdns, issuers, domains []string) (*Config, error) {
+	a := AdminRole{
+		Role:     role,
+		GroupDNs: gdns,
+		Issuers:  issuers,
+		Domains:  domains,
+	}
+	if err := a.validate(); err != nil {
+		return c, err
+	}
+	c.AdminRoles = append(c.AdminRoles, a)
+	return c, nil
+}
+
+// AdminGroupDNs returns the DNs of all groups that grant an administrative role to users of the domain.
+// This includes the admin groups of the domain's LDAP directory which grant the superadmin role.
+func (c *Config) AdminGroupDNs(domain string) []string {
+	var g []string
+	if l, err := c.LDAPForDomain(domain); err == nil {
+		g = append(g, l.AdminGroups()...)
+	}
+	for _, a := range c.AdminRoles {
+		if len(a.Domains) == 0 || StringInSlice(domain, a.Domains) {
+			g = append(g, a.GroupDNs...)
+		}
+	}
+	return g
+}
+
+// AdminPermitted reports whether membership of the groups provided grants the permission for the issuer and domain.
+func (c *Config) AdminPermitted(memberOf []string, p, issuer, domain string) bool {
+	if l, err := c.LDAPForDomain(domain); err == nil && groupsIntersect(memberOf, l.AdminGroups()) {
+		return true
+	}
+	for _, a := range c.AdminRoles {
+		if a.grants(p, issuer, domain) && groupsIntersect(memberOf, a.GroupDNs) {
+			return true
+		}
+	}
+	return false
+}
+
+func groupsIntersect(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if strings.EqualFold(x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
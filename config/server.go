@@ -0,0 +1,80 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// Defaults for the timeouts of the MFA server's listener
+const (
+	DefaultReadTimeout     = 30 * time.Second
+	DefaultWriteTimeout    = 30 * time.Second
+	DefaultIdleTimeout     = 120 * time.Second
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// WithMFATimeouts sets how long the MFA server waits to read a request, to write a response and for the next request
+// on an idle keep-alive connection, and how long in-flight requests have to complete when shutting down.
+// A zero duration uses the default.
+func (c *Config) WithMFATimeouts(read, write, idle, shutdown time.Duration) (*Config, error) {
+	if read < 0 || write < 0 || idle < 0 || shutdown < 0 {
+		return c, errors.New("Timeouts cannot be negative")
+	}
+	c.MFAServer.ReadTimeout = int(read.Seconds())
+	c.MFAServer.WriteTimeout = int(write.Seconds())
+	c.MFAServer.IdleTimeout = int(idle.Seconds())
+	c.MFAServer.ShutdownTimeout = int(shutdown.Seconds())
+	return c, nil
+}
+
+func (c *Config) validateTimeouts() error {
+	if c.MFAServer.ReadTimeout < 0 || c.MFAServer.WriteTimeout < 0 || c.MFAServer.IdleTimeout < 0 || c.MFAServer.ShutdownTimeout < 0 || c.MFAServer.ShutdownDelay < 0 {
+		return errors.New("Timeouts cannot be negative")
+	}
+	return nil
+}
+
+// ReadTimeout returns the maximum time to read a request including its body.
+func (c *Config) ReadTimeout() time.Duration {
+	return secondsOrDefault(c.MFAServer.ReadTimeout, DefaultReadTimeout)
+}
+
+// WriteTimeout returns the maximum time from the end of reading a request to the end of writing its response.
+func (c *Config) WriteTimeout() time.Duration {
+	return secondsOrDefault(c.MFAServer.WriteTimeout, DefaultWriteTimeout)
+}
+
+// IdleTimeout returns how long a keep-alive connection is kept open waiting for the next request.
+func (c *Config) IdleTimeout() time.Duration {
+	return secondsOrDefault(c.MFAServer.IdleTimeout, DefaultIdleTimeout)
+}
+
+// ShutdownTimeout returns how long in-flight requests have to complete once shutdown starts.
+func (c *Config) ShutdownTimeout() time.Duration {
+	return secondsOrDefault(c.MFAServer.ShutdownTimeout, DefaultShutdownTimeout)
+}
+
+// ShutdownDelay returns how long the MFA server reports it is not ready, while still accepting requests, before it stops
+// listening. This gives load balancers time to stop sending it requests.
+func (c *Config) ShutdownDelay() time.Duration {
+	return time.Duration(c.MFAServer.ShutdownDelay) * time.Second
+}
+
+// CloseLDAPConnections closes the connections to the LDAP directories.
+func (c *Config) CloseLDAPConnections() {
+	if c.LDAP.LDAPConnection != nil {
+		c.LDAP.LDAPConnection.Close()
+	}
+	for _, l := range c.LDAPDomains {
+		if l != nil && l.LDAPConnection != nil {
+			l.LDAPConnection.Close()
+		}
+	}
+}
+
+func secondsOrDefault(s int, d time.Duration) time.Duration {
+	if s <= 0 {
+		return d
+	}
+	return time.Duration(s) * time.Second
+}
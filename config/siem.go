@@ -0,0 +1,130 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/jcmturner/mfaserver/audit"
+	"github.com/jcmturner/mfaserver/siem"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// How long queued events are given to reach the SIEM when the sink is closed
+const siemCloseTimeout = 5 * time.Second
+
+// SIEMConf defines the syslog server of a SIEM that security events are sent to in CEF or LEEF.
+type SIEMConf struct {
+	Network     string  `json:"Network"`
+	Address     string  `json:"Address"`
+	Format      string  `json:"Format"`
+	TrustCACert *string `json:"TrustCACert"`
+	TLSConfig   *tls.Config
+	Sink        *siem.Sink
+}
+
+// WithSIEM sends security events to the syslog server at the address over the network (udp, tcp or tls) in the
+// format (CEF or LEEF).
+func (c *Config) WithSIEM(network, address, format string) (*Config, error) {
+	c.CloseSIEM()
+	c.MFAServer.SIEM = &SIEMConf{
+		Network: network,
+		Address: address,
+		Format:  format,
+	}
+	if err := c.validateSIEM(); err != nil {
+		c.MFAServer.SIEM = nil
+		return c, err
+	}
+	if err := c.OpenSIEM(); err != nil {
+		c.MFAServer.SIEM = nil
+		return c, err
+	}
+	return c, nil
+}
+
+func (c *Config) validateSIEM() error {
+	s := c.MFAServer.SIEM
+	if s == nil {
+		return nil
+	}
+	if s.Network == "" {
+		s.Network = siem.NetworkUDP
+	}
+	if s.Format == "" {
+		s.Format = siem.FormatCEF
+	}
+	if s.Network != siem.NetworkUDP && s.Network != siem.NetworkTCP && s.Network != siem.NetworkTLS {
+		return errors.New("Network must be one of udp, tcp or tls")
+	}
+	if s.Format != siem.FormatCEF && s.Format != siem.FormatLEEF {
+		return errors.New("Format must be CEF or LEEF")
+	}
+	host, _, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		return errors.New("Address must be in the form host:port: " + err.Error())
+	}
+	if s.Network != siem.NetworkTLS {
+		if s.TrustCACert != nil {
+			return errors.New("A TrustCACert can only be used with the tls network")
+		}
+		return nil
+	}
+	s.TLSConfig = &tls.Config{ServerName: host}
+	if s.TrustCACert != nil {
+		pemData, err := ioutil.ReadFile(*s.TrustCACert)
+		if err != nil {
+			return errors.New("Could not read TrustCACert: " + err.Error())
+		}
+		s.TLSConfig.RootCAs = x509.NewCertPool()
+		if !s.TLSConfig.RootCAs.AppendCertsFromPEM(pemData) {
+			return errors.New("Couldn't load PEM data from TrustCACert")
+		}
+	}
+	return nil
+}
+
+// OpenSIEM starts sending security events to the SIEM if one is configured and the sink is not already open.
+func (c *Config) OpenSIEM() error {
+	s := c.MFAServer.SIEM
+	if s == nil || s.Sink != nil {
+		return nil
+	}
+	sink, err := siem.New(s.Network, s.Address, s.Format, s.TLSConfig)
+	if err != nil {
+		return err
+	}
+	l := c.loggers
+	sink.OnError = func(err error) {
+		l.Error().Println(err.Error())
+	}
+	s.Sink = sink
+	return nil
+}
+
+// CloseSIEM sends the security events still queued for the SIEM, waiting a few seconds at most, and stops the sink.
+func (c *Config) CloseSIEM() {
+	if s := c.MFAServer.SIEM; s != nil && s.Sink != nil {
+		s.Sink.Close(siemCloseTimeout)
+	}
+}
+
+// siemSink returns the SIEM sink if one is open.
+func (c *Config) siemSink() *siem.Sink {
+	if c.MFAServer.SIEM == nil {
+		return nil
+	}
+	return c.MFAServer.SIEM.Sink
+}
+
+// sendSIEMEvent sends the security event to the SIEM if one is configured.
+func (c *Config) sendSIEMEvent(e audit.Event) {
+	s := c.MFAServer.SIEM
+	if s == nil || s.Sink == nil {
+		return
+	}
+	if err := s.Sink.Send(e); err != nil {
+		c.MFAServer.Loggers.Error.Printf("Security event %s for %s/%s could not be sent to the SIEM: %v", e.Type, e.Domain, e.Username, err)
+	}
+}
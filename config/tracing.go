@@ -0,0 +1,73 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/jcmturner/mfaserver/tracing"
+	"io/ioutil"
+)
+
+// TracingConf defines the OpenTelemetry collector that spans are exported to over OTLP.
+type TracingConf struct {
+	Endpoint    *string           `json:"Endpoint"`
+	Protocol    string            `json:"Protocol"`
+	Headers     map[string]string `json:"Headers"`
+	TrustCACert *string           `json:"TrustCACert"`
+	ServiceName string            `json:"ServiceName"`
+	SampleRatio *float64          `json:"SampleRatio"`
+	TLSConfig   *tls.Config
+}
+
+// WithTracing exports spans to the OpenTelemetry collector at the endpoint URL with the protocol (http or grpc).
+func (c *Config) WithTracing(endpoint, protocol string) (*Config, error) {
+	c.Tracing.Endpoint = &endpoint
+	c.Tracing.Protocol = protocol
+	return c, c.validateTracing()
+}
+
+func (c *Config) validateTracing() error {
+	t := &c.Tracing
+	if t.Endpoint == nil {
+		return nil
+	}
+	if t.Protocol != "" && t.Protocol != tracing.ProtocolHTTP && t.Protocol != tracing.ProtocolGRPC {
+		return errors.New("Protocol must be http or grpc")
+	}
+	if t.SampleRatio != nil && (*t.SampleRatio < 0 || *t.SampleRatio > 1) {
+		return errors.New("SampleRatio must be between 0 and 1")
+	}
+	if t.TrustCACert != nil {
+		pemData, err := ioutil.ReadFile(*t.TrustCACert)
+		if err != nil {
+			return errors.New("Could not read TrustCACert: " + err.Error())
+		}
+		t.TLSConfig = &tls.Config{RootCAs: x509.NewCertPool()}
+		if !t.TLSConfig.RootCAs.AppendCertsFromPEM(pemData) {
+			return errors.New("Couldn't load PEM data from TrustCACert")
+		}
+	}
+	return nil
+}
+
+// StartTracing starts exporting spans if a collector is configured. The function returned flushes the spans not yet
+// exported and stops exporting.
+func (c *Config) StartTracing() (func(context.Context) error, error) {
+	t := c.Tracing
+	if t.Endpoint == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+	ratio := 1.0
+	if t.SampleRatio != nil {
+		ratio = *t.SampleRatio
+	}
+	return tracing.Init(tracing.Options{
+		Endpoint:    *t.Endpoint,
+		Protocol:    t.Protocol,
+		Headers:     t.Headers,
+		TLSConfig:   t.TLSConfig,
+		ServiceName: t.ServiceName,
+		SampleRatio: ratio,
+	})
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jcmturner/mfaserver/audit"
+	"github.com/jcmturner/mfaserver/webhook"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// WebhooksConf defines the subscriptions notified of MFA lifecycle events and the outbox their deliveries are kept in.
+type WebhooksConf struct {
+	OutboxFile    *string                   `json:"OutboxFile"`
+	MaxAttempts   int                       `json:"MaxAttempts"`
+	Subscriptions []WebhookSubscriptionConf `json:"Subscriptions"`
+	Dispatcher    *webhook.Dispatcher
+}
+
+// WebhookSubscriptionConf defines a URL that lifecycle events are POSTed to, signed with the secret. If no Events are
+// listed all lifecycle events are delivered.
+type WebhookSubscriptionConf struct {
+	Name       string   `json:"Name"`
+	URL        string   `json:"URL"`
+	Events     []string `json:"Events"`
+	SecretFile *string  `json:"SecretFile"`
+	Secret     *string  `json:"Secret"`
+	secret     []byte
+}
+
+// Lifecycle events sent to the webhooks for the successful security events
+var webhookEvents = map[string]string{
+	audit.EventEnrol:  webhook.EventEnrolled,
+	audit.EventUpdate: webhook.EventRotated,
+	audit.EventDelete: webhook.EventDeleted,
+}
+
+// WithWebhookOutbox keeps the webhook deliveries in the outbox file at the path.
+func (c *Config) WithWebhookOutbox(path string) (*Config, error) {
+	c.Webhooks.OutboxFile = &path
+	if err := c.validateWebhooks(); err != nil {
+		return c, err
+	}
+	c.CloseWebhooks()
+	return c, c.OpenWebhooks()
+}
+
+// WithWebhookSubscription POSTs the lifecycle events listed, or all if none are, to the URL signed with the secret.
+func (c *Config) WithWebhookSubscription(name, URL string, secret string, events []string) (*Config, error) {
+	c.Webhooks.Subscriptions = append(c.Webhooks.Subscriptions, WebhookSubscriptionConf{
+		Name:   name,
+		URL:    URL,
+		Events: events,
+		Secret: &secret,
+	})
+	if err := c.validateWebhooks(); err != nil {
+		c.Webhooks.Subscriptions = c.Webhooks.Subscriptions[:len(c.Webhooks.Subscriptions)-1]
+		return c, err
+	}
+	c.CloseWebhooks()
+	return c, c.OpenWebhooks()
+}
+
+func (c *Config) validateWebhooks() error {
+	w := &c.Webhooks
+	if w.MaxAttempts < 0 {
+		return errors.New("MaxAttempts cannot be negative")
+	}
+	names := make(map[string]bool)
+	for i := range w.Subscriptions {
+		s := &w.Subscriptions[i]
+		if s.Name == "" {
+			return errors.New("No Name defined for a subscription")
+		}
+		if names[s.Name] {
+			return errors.New("Subscription name " + s.Name + " is not unique")
+		}
+		names[s.Name] = true
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("URL of subscription " + s.Name + " must be an absolute http or https URL")
+		}
+		for _, e := range s.Events {
+			if !StringInSlice(e, webhook.EventTypes) {
+				return errors.New(fmt.Sprintf("Unknown event %s for subscription %s. Accepted values are %q", e, s.Name, webhook.EventTypes))
+			}
+		}
+		switch {
+		case s.Secret != nil && s.SecretFile != nil:
+			return errors.New("Only one of Secret and SecretFile can be defined for subscription " + s.Name)
+		case s.SecretFile != nil:
+			b, err := ioutil.ReadFile(*s.SecretFile)
+			if err != nil {
+				return errors.New("Could not read SecretFile of subscription " + s.Name + ": " + err.Error())
+			}
+			s.secret = []byte(strings.TrimSpace(string(b)))
+		case s.Secret != nil:
+			s.secret = []byte(*s.Secret)
+		}
+		if len(s.secret) < 16 {
+			return errors.New("The secret of subscription " + s.Name + " must be at least 16 characters")
+		}
+	}
+	if len(w.Subscriptions) > 0 && w.OutboxFile == nil {
+		return errors.New("No OutboxFile defined for the webhook deliveries")
+	}
+	return nil
+}
+
+// OpenWebhooks starts delivering lifecycle events to the subscriptions, resuming the deliveries pending in the outbox,
+// if any are configured and the dispatcher is not already open. Only one dispatcher may use an outbox at a time so,
+// when the configuration is reloaded with the same OutboxFile, the previous dispatcher is kept.
+func (c *Config) OpenWebhooks() error {
+	w := &c.Webhooks
+	if len(w.Subscriptions) == 0 || w.Dispatcher != nil {
+		return nil
+	}
+	d, err := webhook.New(*w.OutboxFile, c.webhookSubscriptions(), w.MaxAttempts, nil)
+	if err != nil {
+		return err
+	}
+	l := c.loggers
+	d.OnError = func(err error) {
+		l.Error().Println(err.Error())
+	}
+	w.Dispatcher = d
+	return nil
+}
+
+func (c *Config) webhookSubscriptions() []webhook.Subscription {
+	subs := make([]webhook.Subscription, len(c.Webhooks.Subscriptions))
+	for i, s := range c.Webhooks.Subscriptions {
+		subs[i] = webhook.Subscription{
+			Name:   s.Name,
+			URL:    s.URL,
+			Secret: s.secret,
+			Events: s.Events,
+		}
+	}
+	return subs
+}
+
+// CloseWebhooks stops delivering lifecycle events. Deliveries still pending are kept in the outbox.
+func (c *Config) CloseWebhooks() {
+	if c.Webhooks.Dispatcher == nil {
+		return
+	}
+	if err := c.Webhooks.Dispatcher.Close(); err != nil {
+		c.MFAServer.Loggers.Error.Printf("Webhook outbox could not be closed: %v", err)
+	}
+	c.Webhooks.Dispatcher = nil
+}
+
+// sendWebhookEvent sends the lifecycle event for the security event to the webhooks if it is one that they are
+// notified of.
+func (c *Config) sendWebhookEvent(e audit.Event) {
+	t, ok := webhookEvents[e.Type]
+	if !ok || e.Outcome != "success" || c.Webhooks.Dispatcher == nil {
+		return
+	}
+	we := webhook.NewEvent(t)
+	we.Issuer = e.Issuer
+	we.Domain = e.Domain
+	we.Username = e.Username
+	we.Admin = e.Admin
+	we.Application = e.Application
+	we.RequestID = e.RequestID
+	if err := c.Webhooks.Dispatcher.Send(we); err != nil {
+		c.MFAServer.Loggers.Error.Printf("Lifecycle event %s for %s/%s could not be sent to the webhooks: %v", t, e.Domain, e.Username, err)
+	}
+}
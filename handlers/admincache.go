@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/mfaserver/cache"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/ldap"
+	"io"
+	"net/http"
+)
+
+type adminCacheRequestData struct {
+	Domain   string `json:"domain"`
+	Username string `json:"username"`
+}
+
+// AdminCacheInvalidate removes the cached admin group membership decisions for a user, or for all users of the domain's
+// directory if no username is provided.
+func AdminCacheInvalidate(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	if _, ok := checkApplication(w, r, c); !ok {
+		return
+	}
+	data, err, HTTPCode := processAdminCacheRequestData(r)
+	setNoCacheHeaders(w)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Println(err.Error())
+		writeError(w, c, HTTPCode, ErrorInvalidRequest, requestErrorMessage(r, err))
+		return
+	}
+	if aerr := invalidateAdminCache(c, r, &data); aerr != nil {
+		writeAPIError(w, c, aerr)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminCacheStats returns the hit rate and size of the admin group membership cache.
+func AdminCacheStats(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	if _, ok := checkApplication(w, r, c); !ok {
+		return
+	}
+	data, err, HTTPCode := processAdminCacheRequestData(r)
+	setNoCacheHeaders(w)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Println(err.Error())
+		writeError(w, c, HTTPCode, ErrorInvalidRequest, requestErrorMessage(r, err))
+		return
+	}
+	st, aerr := adminCacheStats(c, r, &data)
+	if aerr != nil {
+		writeAPIError(w, c, aerr)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Admin group cache statistics failed whilst returning body data: %v", r.RemoteAddr, err)
+	}
+}
+
+// invalidateAdminCache removes the cached admin group membership decisions if the request was made by an administrator
+// permitted to manage the domain.
+func invalidateAdminCache(c *config.Config, r *http.Request, data *adminCacheRequestData) *apiError {
+	c.SetRequestUser("", data.Domain, data.Username)
+	c.MFAServer.Loggers.Info.Printf("%s, Admin group cache invalidation request received for %s/%s", r.RemoteAddr, data.Domain, data.Username)
+	if !checkAdminAuth(c, r, config.PermissionManage, "", data.Domain) {
+		return &apiError{http.StatusUnauthorized, ErrorUnauthorized, "The administrator is not authorised to manage the domain"}
+	}
+	if err := ldap.InvalidateAdminCache(data.Domain, data.Username, c); err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Admin group cache invalidation failed for %s/%s: %v", r.RemoteAddr, data.Domain, data.Username, err)
+		return &apiError{http.StatusBadRequest, ErrorInvalidRequest, err.Error()}
+	}
+	c.MFAServer.Loggers.Info.Printf("%s, Admin group cache invalidated for %s/%s", r.RemoteAddr, data.Domain, data.Username)
+	return nil
+}
+
+// adminCacheStats returns the statistics of the admin group membership cache if the request was made by an
+// administrator permitted to manage the domain. The statistics are for the whole cache but are only counts, with no
+// details of the users cached.
+func adminCacheStats(c *config.Config, r *http.Request, data *adminCacheRequestData) (cache.Stats, *apiError) {
+	if !checkAdminAuth(c, r, config.PermissionManage, "", data.Domain) {
+		return cache.Stats{}, &apiError{http.StatusUnauthorized, ErrorUnauthorized, "The administrator is not authorised to manage the domain"}
+	}
+	if c.AdminCache.Cache == nil {
+		return cache.Stats{}, &apiError{http.StatusNotFound, ErrorNotFound, "The admin group cache is not enabled"}
+	}
+	return c.AdminCache.Cache.Stats(), nil
+}
+
+func processAdminCacheRequestData(r *http.Request) (adminCacheRequestData, error, int) {
+	var data adminCacheRequestData
+	defer r.Body.Close()
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1024))
+	err := dec.Decode(&data)
+	if err != nil {
+		return data, errors.New(fmt.Sprintf("%s, Could not parse data posted from client to the admin cache api : %v", r.RemoteAddr, err)), http.StatusBadRequest
+	}
+	if err := checkAdminCacheRequestData(r, &data); err != nil {
+		return data, err, http.StatusBadRequest
+	}
+	return data, nil, 0
+}
+
+// checkAdminCacheRequestData checks that the admin cache request identifies the domain.
+func checkAdminCacheRequestData(r *http.Request, data *adminCacheRequestData) error {
+	if data.Domain == "" {
+		return errors.New(fmt.Sprintf("%s, Could not extract values correctly from the admin cache request.", r.RemoteAddr))
+	}
+	return nil
+}
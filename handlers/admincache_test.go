@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/cache"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAdminCache(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+
+	//Set up the MFA config
+	c := config.NewConfig()
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	c.WithLDAPAdminSettings("cn=mfaadmin,ou=groups,dc=example,dc=com", "memberUid", "{username}")
+	c.WithAdminGroupCache(time.Minute, time.Minute, 10)
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/cache/invalidate", func(w http.ResponseWriter, r *http.Request) { AdminCacheInvalidate(w, r, c) })
+	mux.HandleFunc("/admin/cache/stats", func(w http.ResponseWriter, r *http.Request) { AdminCacheStats(w, r, c) })
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	var tests = []struct {
+		Path          string
+		AdminUser     string
+		AdminPassword string
+		Json          string
+		HttpCode      int
+		Stats         cache.Stats
+	}{
+		//The first admin check misses the cache and subsequent ones hit
+		{"/admin/cache/stats", "validuser", "validpassword", `{"domain": "testdom"}`, http.StatusOK, cache.Stats{Entries: 1, Hits: 0, Misses: 1}},
+		{"/admin/cache/stats", "validuser", "validpassword", `{"domain": "testdom"}`, http.StatusOK, cache.Stats{Entries: 1, Hits: 1, Misses: 1, HitRate: 0.5}},
+		//Credentials are not cached so an invalid password must still fail
+		{"/admin/cache/stats", "validuser", "invalidpassword", `{"domain": "testdom"}`, http.StatusUnauthorized, cache.Stats{}},
+		{"/admin/cache/invalidate", "validuser", "validpassword", `{"domain": "testdom", "username": "validuser"}`, http.StatusNoContent, cache.Stats{}},
+		{"/admin/cache/stats", "validuser", "validpassword", `{"domain": "testdom"}`, http.StatusOK, cache.Stats{Entries: 1, Hits: 2, Misses: 2, HitRate: 0.5}},
+		{"/admin/cache/invalidate", "validuser", "validpassword", `{"domain": "testdom"}`, http.StatusNoContent, cache.Stats{}},
+		{"/admin/cache/invalidate", "validuser", "validpassword", `{"username": "validuser"}`, http.StatusBadRequest, cache.Stats{}},
+		{"/admin/cache/stats", "", "", `{"domain": "testdom"}`, http.StatusUnauthorized, cache.Stats{}},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("POST", s.URL+test.Path, bytes.NewBuffer([]byte(test.Json)))
+		if err != nil {
+			t.Errorf("Error returned from creating request: %v", err)
+		}
+		if test.AdminUser != "" {
+			r.SetBasicAuth(test.AdminUser, test.AdminPassword)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("Error returned from sending request: %v", err)
+		}
+		if resp.StatusCode != test.HttpCode {
+			t.Errorf("Expected code %v, got %v for %s with post data %v", test.HttpCode, resp.StatusCode, test.Path, test.Json)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var j cache.Stats
+			err = json.NewDecoder(resp.Body).Decode(&j)
+			if err != nil {
+				t.Errorf("Failed to marshal the response into the JSON object: %v", err)
+			}
+			assert.Equal(t, test.Stats, j, "Cache statistics not as expected")
+		}
+		resp.Body.Close()
+	}
+}
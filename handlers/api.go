@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/config"
+	"net/http"
+	"strings"
+)
+
+// APIVersionPrefix is the path prefix of the current version of the REST API. The API endpoints are also served at their
+// original, unversioned paths as deprecated aliases.
+const APIVersionPrefix = "/v1"
+
+// Codes identifying why a request failed in the body of an error response
+const (
+	ErrorInvalidRequest   = "invalid_request"
+	ErrorUnauthorized     = "unauthorized"
+	ErrorForbidden        = "forbidden"
+	ErrorAlreadyEnrolled  = "already_enrolled"
+	ErrorNotFound         = "not_found"
+	ErrorMethodNotAllowed = "method_not_allowed"
+	ErrorInternal         = "internal_error"
+)
+
+// HandlerFunc handles a request with the configuration for that request.
+type HandlerFunc func(http.ResponseWriter, *http.Request, *config.Config)
+
+type errorResponseData struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// writeError responds with the status and a JSON body describing the error. The request ID in the body is the one in
+// the request's log entries.
+func writeError(w http.ResponseWriter, c *config.Config, status int, code, message string) {
+	d := errorResponseData{Code: code, Message: message, RequestID: w.Header().Get(RequestIDHeader)}
+	if c.Request != nil {
+		d.RequestID = c.Request.ID
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		c.MFAServer.Loggers.Error.Printf("Error response %s could not be returned: %v", code, err)
+	}
+}
+
+// apiError is why an API operation failed. It is returned to REST clients as an error response and to gRPC clients as
+// the status of the call.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// writeAPIError responds with the error response of the failed operation.
+func writeAPIError(w http.ResponseWriter, c *config.Config, e *apiError) {
+	writeError(w, c, e.Status, e.Code, e.Message)
+}
+
+// invalidRequest logs why the request data is not valid and returns the error to respond to the client with.
+func invalidRequest(c *config.Config, r *http.Request, err error) *apiError {
+	c.MFAServer.Loggers.Error.Println(err.Error())
+	return &apiError{http.StatusBadRequest, ErrorInvalidRequest, requestErrorMessage(r, err)}
+}
+
+// requestErrorMessage returns the error from processing the request data without the client address that prefixes it
+// in the log, so that it can be returned to the client.
+func requestErrorMessage(r *http.Request, err error) string {
+	return strings.TrimSpace(strings.TrimPrefix(err.Error(), r.RemoteAddr+", "))
+}
+
+// Methods responds with 405 Method Not Allowed to requests that do not use one of the methods.
+func Methods(f HandlerFunc, methods ...string) HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request, c *config.Config) {
+		if !config.StringInSlice(r.Method, methods) {
+			c.MFAServer.Loggers.Info.Printf("%s, Method %s not allowed for %s", r.RemoteAddr, r.Method, r.URL.Path)
+			w.Header().Set("Allow", allow)
+			writeError(w, c, http.StatusMethodNotAllowed, ErrorMethodNotAllowed, "The method must be one of "+allow)
+			return
+		}
+		f(w, r, c)
+	}
+}
+
+// Deprecated serves an endpoint at a deprecated path, indicating in the response headers the path that replaces it.
+func Deprecated(successor string, f HandlerFunc) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, c *config.Config) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		f(w, r, c)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorResponses(t *testing.T) {
+	c := config.NewConfig()
+	var tests = []struct {
+		Handler  HandlerFunc
+		Method   string
+		Json     string
+		HttpCode int
+		Code     string
+	}{
+		{Enrol, "POST", `{"domain": "testdom"`, http.StatusBadRequest, ErrorInvalidRequest},
+		{Update, "POST", `{"domain": "testdom"}`, http.StatusBadRequest, ErrorInvalidRequest},
+		{ValidateOTP, "POST", `{"issuer": "testapp"}`, http.StatusBadRequest, ErrorInvalidRequest},
+		{DeleteOTP, "POST", `not json`, http.StatusBadRequest, ErrorInvalidRequest},
+		{ListUsers, "POST", `{"domain": "testdom"}`, http.StatusBadRequest, ErrorInvalidRequest},
+		{AdminCacheStats, "POST", `{}`, http.StatusBadRequest, ErrorInvalidRequest},
+		{Methods(ValidateOTP, "POST"), "GET", ``, http.StatusMethodNotAllowed, ErrorMethodNotAllowed},
+		{Methods(Enrol, "POST"), "PUT", `{}`, http.StatusMethodNotAllowed, ErrorMethodNotAllowed},
+	}
+	for i, test := range tests {
+		h := Logged(APIVersionPrefix+"/test", func() *config.Config { return c }, test.Handler)
+		r := httptest.NewRequest(test.Method, APIVersionPrefix+"/test", bytes.NewBufferString(test.Json))
+		w := httptest.NewRecorder()
+		h(w, r)
+		assert.Equal(t, test.HttpCode, w.Code, "Status code not as expected for test %d", i)
+		assert.Equal(t, "application/json; charset=UTF-8", w.Header().Get("Content-Type"), "Content-Type not as expected for test %d", i)
+		var e errorResponseData
+		if err := json.NewDecoder(w.Body).Decode(&e); err != nil {
+			t.Errorf("Error response for test %d could not be decoded: %v", i, err)
+			continue
+		}
+		assert.Equal(t, test.Code, e.Code, "Error code not as expected for test %d", i)
+		assert.NotEmpty(t, e.Message, "No error message for test %d", i)
+		assert.NotContains(t, e.Message, r.RemoteAddr, "Client address in the error message for test %d", i)
+		assert.Equal(t, w.Header().Get(RequestIDHeader), e.RequestID, "Request ID not as expected for test %d", i)
+		if test.HttpCode == http.StatusMethodNotAllowed {
+			assert.Equal(t, "POST", w.Header().Get("Allow"), "Allow header not as expected for test %d", i)
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	c := config.NewConfig()
+	var handled bool
+	h := Methods(func(w http.ResponseWriter, r *http.Request, c *config.Config) {
+		handled = true
+		w.WriteHeader(http.StatusOK)
+	}, "GET", "HEAD")
+	var tests = []struct {
+		Method   string
+		HttpCode int
+	}{
+		{"GET", http.StatusOK},
+		{"HEAD", http.StatusOK},
+		{"POST", http.StatusMethodNotAllowed},
+		{"DELETE", http.StatusMethodNotAllowed},
+		{"OPTIONS", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		handled = false
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(test.Method, "/healthz", nil), c)
+		assert.Equal(t, test.HttpCode, w.Code, "Status code not as expected for %s", test.Method)
+		assert.Equal(t, test.HttpCode == http.StatusOK, handled, "Handler called not as expected for %s", test.Method)
+		if test.HttpCode == http.StatusMethodNotAllowed {
+			assert.Equal(t, "GET, HEAD", w.Header().Get("Allow"), "Allow header not as expected for %s", test.Method)
+		}
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	c := config.NewConfig()
+	h := Deprecated(APIVersionPrefix+"/validate", Methods(ValidateOTP, "POST"))
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/validate", nil), c)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "Deprecated path not handled by the endpoint")
+	assert.Equal(t, "true", w.Header().Get("Deprecation"), "Deprecation header not as expected")
+	assert.Equal(t, `</v1/validate>; rel="successor-version"`, w.Header().Get("Link"), "Link to the successor not as expected")
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/secrets"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Request headers carrying the credentials of the calling application
+const (
+	ApplicationHeader    = "X-MFA-Application"
+	ApplicationKeyHeader = "X-MFA-Application-Key"
+	TimestampHeader      = "X-MFA-Timestamp"
+	SignatureHeader      = "X-MFA-Signature"
+)
+
+// How far the timestamp of a signed request may differ from the MFA server's clock
+const maxSignatureSkew = 5 * time.Minute
+
+// Limit on the body read to verify the signature of a request
+const maxSignedBodySize = 1024 * 1024
+
+// application is a calling application that has been authenticated.
+type application struct {
+	Name string
+	Conf *config.ApplicationConf
+}
+
+// requestApplication authenticates the application that made the request with its client certificate, API key or HMAC
+// signature. If applications are not required to authenticate nil is returned.
+// The request body is read to verify a signature and is replaced so that it can be read again by the handler.
+func requestApplication(c *config.Config, r *http.Request) (*application, error, int) {
+	if !c.ApplicationAuthRequired() {
+		return nil, nil, 0
+	}
+	n := r.Header.Get(ApplicationHeader)
+	if a, ok := certificateApplication(c, r, n); ok {
+		return a, nil, 0
+	}
+	if n == "" {
+		return nil, errors.New(r.RemoteAddr + ", No application credentials provided in the request."), http.StatusUnauthorized
+	}
+	a, err := lookupApplication(c, n)
+	if err != nil {
+		return nil, errors.New(r.RemoteAddr + ", Application " + n + " could not be authenticated: " + err.Error()), http.StatusUnauthorized
+	}
+	if k := r.Header.Get(ApplicationKeyHeader); k != "" {
+		for _, ak := range a.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(ak)) == 1 {
+				return &application{Name: n, Conf: a}, nil, 0
+			}
+		}
+		return nil, errors.New(r.RemoteAddr + ", Application " + n + " could not be authenticated: API key not valid"), http.StatusUnauthorized
+	}
+	if r.Header.Get(SignatureHeader) != "" && a.HMACSecret != "" {
+		if err := verifyRequestSignature(r, []byte(a.HMACSecret)); err != nil {
+			return nil, errors.New(r.RemoteAddr + ", Application " + n + " could not be authenticated: " + err.Error()), http.StatusUnauthorized
+		}
+		return &application{Name: n, Conf: a}, nil, 0
+	}
+	return nil, errors.New(r.RemoteAddr + ", Application " + n + " could not be authenticated: No API key or signature provided"), http.StatusUnauthorized
+}
+
+// certificateApplication returns the application authenticated by the verified client certificate of the request.
+// If the request names the application the certificate must be one of its identities, otherwise the applications in the
+// configuration are searched for one the certificate identifies.
+func certificateApplication(c *config.Config, r *http.Request, n string) (*application, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	ids := config.CertificateIdentities(r.TLS.VerifiedChains[0][0])
+	if n == "" {
+		n, a, ok := c.ApplicationForCertificate(ids)
+		if !ok {
+			return nil, false
+		}
+		return &application{Name: n, Conf: a}, true
+	}
+	a, err := lookupApplication(c, n)
+	if err != nil || !a.CertificatePermitted(ids) {
+		return nil, false
+	}
+	return &application{Name: n, Conf: a}, true
+}
+
+// checkApplication authenticates the application that made the request, responding to the request if it could not be.
+func checkApplication(w http.ResponseWriter, r *http.Request, c *config.Config) (*application, bool) {
+	a, aerr := authenticateApplication(c, r)
+	if aerr != nil {
+		setNoCacheHeaders(w)
+		writeAPIError(w, c, aerr)
+		return nil, false
+	}
+	return a, true
+}
+
+// authenticateApplication authenticates the application that made the request and records it as the request's
+// application.
+func authenticateApplication(c *config.Config, r *http.Request) (*application, *apiError) {
+	a, err, HTTPCode := requestApplication(c, r)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Println(err.Error())
+		return nil, &apiError{HTTPCode, ErrorUnauthorized, "The application could not be authenticated"}
+	}
+	if a != nil {
+		c.SetRequestApplication(a.Name)
+	}
+	return a, nil
+}
+
+// applicationPermitted reports whether the authenticated application may act for the issuer.
+// Requests not tied to an issuer only need to be from an authenticated application.
+func applicationPermitted(c *config.Config, r *http.Request, a *application, issuer string) bool {
+	if !c.ApplicationAuthRequired() {
+		return true
+	}
+	if a == nil {
+		return false
+	}
+	if issuer != "" && !a.Conf.IssuerPermitted(issuer) {
+		c.MFAServer.Loggers.Warning.Printf("%s, Application %s is not permitted to use issuer %s", r.RemoteAddr, a.Name, issuer)
+		return false
+	}
+	return true
+}
+
+// lookupApplication returns the application from the configuration or, if enabled, the secrets store.
+func lookupApplication(c *config.Config, n string) (*config.ApplicationConf, error) {
+	if a, ok := c.Application(n); ok {
+		return a, nil
+	}
+	if !c.ApplicationStore {
+		return nil, errors.New("Application is not registered")
+	}
+	m, err := secrets.Read(c, config.ApplicationStorePath+"/"+n)
+	if err != nil {
+		return nil, errors.New("Could not read application from the secrets store: " + err.Error())
+	}
+	if m == nil {
+		return nil, errors.New("Application is not registered")
+	}
+	return config.NewApplicationFromStore(m)
+}
+
+// verifyRequestSignature checks the HMAC-SHA256 signature of the request made with the secret.
+func verifyRequestSignature(r *http.Request, secret []byte) error {
+	ts := r.Header.Get(TimestampHeader)
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("Request timestamp not valid")
+	}
+	if d := time.Since(time.Unix(t, 0)); d > maxSignatureSkew || d < -maxSignatureSkew {
+		return errors.New("Request timestamp is outside of the permitted clock skew")
+	}
+	sig, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil {
+		return errors.New("Request signature could not be decoded")
+	}
+	var b []byte
+	if r.Body != nil {
+		b, err = ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBodySize))
+		r.Body.Close()
+		if err != nil {
+			return errors.New("Request body could not be read: " + err.Error())
+		}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if !hmac.Equal(sig, RequestSignature(secret, r.Method, r.URL.RequestURI(), ts, b)) {
+		return errors.New("Request signature not valid")
+	}
+	return nil
+}
+
+// RequestSignature calculates the HMAC-SHA256 signature of a request. The signed string is the HTTP method, request URI,
+// timestamp and hex encoded SHA-256 hash of the body, each separated by a newline.
+func RequestSignature(secret []byte, method, uri, ts string, body []byte) []byte {
+	bh := sha256.Sum256(body)
+	m := hmac.New(sha256.New, secret)
+	io.WriteString(m, method+"\n"+uri+"\n"+ts+"\n"+hex.EncodeToString(bh[:]))
+	return m.Sum(nil)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/pem"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	testAppKey    = "testappkey"
+	testAppSecret = "testapphmacsecret"
+)
+
+func signRequest(r *http.Request, secret string, ts time.Time, body []byte) {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	r.Header.Set(TimestampHeader, t)
+	r.Header.Set(SignatureHeader, hex.EncodeToString(RequestSignature([]byte(secret), r.Method, r.URL.RequestURI(), t, body)))
+}
+
+func TestRequestApplication(t *testing.T) {
+	c := config.NewConfig()
+	body := []byte(`{"issuer": "testapp"}`)
+
+	//Applications are not required to authenticate unless registered
+	r := httptest.NewRequest("POST", "/validate", bytes.NewReader(body))
+	a, err, _ := requestApplication(c, r)
+	assert.NoError(t, err)
+	assert.True(t, applicationPermitted(c, r, a, "anyapp"), "Any issuer should be permitted when applications are not registered")
+
+	c.WithApplication("app1", []string{testAppKey}, testAppSecret, []string{"testapp"})
+	c.WithApplication("app2", []string{"otherkey"}, "", []string{"*"})
+
+	var tests = []struct {
+		App      string
+		Key      string
+		Sign     string
+		SignTime time.Time
+		Issuer   string
+		HttpCode int
+		Allowed  bool
+	}{
+		{"app1", testAppKey, "", time.Time{}, "testapp", 0, true},
+		{"app1", testAppKey, "", time.Time{}, "otherapp", 0, false},
+		{"app1", "wrongkey", "", time.Time{}, "testapp", http.StatusUnauthorized, false},
+		{"app1", "", testAppSecret, time.Now(), "testapp", 0, true},
+		{"app1", "", "wrongsecret", time.Now(), "testapp", http.StatusUnauthorized, false},
+		{"app1", "", testAppSecret, time.Now().Add(-10 * time.Minute), "testapp", http.StatusUnauthorized, false},
+		{"app1", "", "", time.Time{}, "testapp", http.StatusUnauthorized, false},
+		{"app2", "otherkey", "", time.Time{}, "anyapp", 0, true},
+		{"app2", "", testAppSecret, time.Now(), "anyapp", http.StatusUnauthorized, false},
+		{"unknown", testAppKey, "", time.Time{}, "testapp", http.StatusUnauthorized, false},
+		{"", "", "", time.Time{}, "testapp", http.StatusUnauthorized, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/validate", bytes.NewReader(body))
+		if test.App != "" {
+			r.Header.Set(ApplicationHeader, test.App)
+		}
+		if test.Key != "" {
+			r.Header.Set(ApplicationKeyHeader, test.Key)
+		}
+		if test.Sign != "" {
+			signRequest(r, test.Sign, test.SignTime, body)
+		}
+		a, err, code := requestApplication(c, r)
+		assert.Equal(t, test.HttpCode, code, "Status code not as expected for %+v", test)
+		if test.HttpCode == 0 {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, test.Allowed, applicationPermitted(c, r, a, test.Issuer), "Application permission not as expected for %+v", test)
+		if err == nil {
+			//The body must still be readable by the handler after the signature is verified
+			b, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, body, b, "Request body not available after authentication")
+		}
+	}
+}
+
+func TestValidateOTPApplication(t *testing.T) {
+	c := config.NewConfig()
+	c.WithApplication("app1", []string{testAppKey}, "", []string{"testapp"})
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ValidateOTP(w, r, c) }))
+	defer s.Close()
+
+	var tests = []struct {
+		Key      string
+		Json     string
+		HttpCode int
+	}{
+		{"", `{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "testapp", "otp": "123456"}`, http.StatusUnauthorized},
+		{"wrongkey", `{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "testapp", "otp": "123456"}`, http.StatusUnauthorized},
+		{testAppKey, `{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "otherapp", "otp": "123456"}`, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", s.URL+"/validate", bytes.NewBufferString(test.Json))
+		if test.Key != "" {
+			r.Header.Set(ApplicationHeader, "app1")
+			r.Header.Set(ApplicationKeyHeader, test.Key)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Error returned from sending request: %v", err)
+		}
+		assert.Equal(t, test.HttpCode, resp.StatusCode, "Status code not as expected for key %s and data %s", test.Key, test.Json)
+	}
+}
+
+func TestRequestApplicationClientCertificate(t *testing.T) {
+	ca, caKey := testtools.GenerateTestCA(t)
+	caOut, _ := ioutil.TempFile(os.TempDir(), "clientCA")
+	defer os.Remove(caOut.Name())
+	pem.Encode(caOut, &pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	caOut.Close()
+	svc1 := testtools.GenerateTestClientCertificate(t, ca, caKey, "svc1", []string{"svc1.example.com"})
+	svc2 := testtools.GenerateTestClientCertificate(t, ca, caKey, "svc2", nil)
+	revoked := testtools.GenerateTestClientCertificate(t, ca, caKey, "svc3", []string{"svc1.example.com"})
+	crlOut, _ := ioutil.TempFile(os.TempDir(), "clientCRL")
+	defer os.Remove(crlOut.Name())
+	crlOut.Write(testtools.GenerateTestCRL(t, ca, caKey, time.Now().Add(time.Hour), revoked.Leaf))
+	crlOut.Close()
+	otherCA, otherCAKey := testtools.GenerateTestCA(t)
+	untrusted := testtools.GenerateTestClientCertificate(t, otherCA, otherCAKey, "svc1", []string{"svc1.example.com"})
+
+	c := config.NewConfig()
+	c.MFAServer.Loggers.Error = log.New(ioutil.Discard, "", 0)
+	c.WithMFAClientCA(caOut.Name(), false)
+	c.WithMFAClientCRL(crlOut.Name())
+	c.WithApplicationClientCertificates("app1", []string{"svc1.example.com"}, []string{"testapp"})
+	c.WithApplication("app2", []string{testAppKey}, "", []string{"otherapp"})
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a, ok := checkApplication(w, r, c); ok {
+			w.Write([]byte(a.Name))
+		}
+	}))
+	s.TLS = c.MFATLSConfig()
+	s.StartTLS()
+	defer s.Close()
+
+	var tests = []struct {
+		Cert      *tls.Certificate
+		App       string
+		Key       string
+		Handshake bool
+		HttpCode  int
+		Name      string
+	}{
+		{&svc1, "", "", true, http.StatusOK, "app1"},
+		{&svc1, "app1", "", true, http.StatusOK, "app1"},
+		{&svc1, "app2", "", true, http.StatusUnauthorized, ""},
+		{&svc2, "", "", true, http.StatusUnauthorized, ""},
+		{&svc2, "app2", testAppKey, true, http.StatusOK, "app2"},
+		{nil, "app2", testAppKey, true, http.StatusOK, "app2"},
+		{&revoked, "", "", false, 0, ""},
+		{&untrusted, "", "", false, 0, ""},
+	}
+	for _, test := range tests {
+		tr := s.Client().Transport.(*http.Transport).Clone()
+		if test.Cert != nil {
+			tr.TLSClientConfig.Certificates = []tls.Certificate{*test.Cert}
+		}
+		r, _ := http.NewRequest("GET", s.URL, nil)
+		if test.App != "" {
+			r.Header.Set(ApplicationHeader, test.App)
+		}
+		if test.Key != "" {
+			r.Header.Set(ApplicationKeyHeader, test.Key)
+		}
+		resp, err := (&http.Client{Transport: tr}).Do(r)
+		if !test.Handshake {
+			assert.Error(t, err, "TLS handshake should have failed for %+v", test)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Error returned from sending request: %v", err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, test.HttpCode, resp.StatusCode, "Status code not as expected for %+v", test)
+		if resp.StatusCode == http.StatusOK {
+			assert.Equal(t, test.Name, string(b), "Authenticated application not as expected for %+v", test)
+		} else {
+			assert.Contains(t, string(b), `"code":"unauthorized"`, "Error response not as expected for %+v", test)
+		}
+	}
+}
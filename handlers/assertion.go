@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/assertion"
+	"github.com/jcmturner/mfaserver/config"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Media type requested in the Accept header to receive a signed assertion when an OTP is validated
+const assertionContentType = "application/jwt"
+
+// JWKS publishes the public keys that the assertions issued by the MFA server can be verified with.
+func JWKS(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	if !c.AssertionsEnabled() {
+		setNoCacheHeaders(w)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	//Allow the key set to be cached for a short time. Keys should be published before they are used to sign.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(assertion.NewJSONWebKeySet(c.Assertions.Keys)); err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, JWKS request failed whilst returning body data: %v", r.RemoteAddr, err)
+	}
+}
+
+// wantsAssertion reports whether the caller asked for a signed assertion rather than an empty response.
+func wantsAssertion(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), assertionContentType)
+}
+
+// writeAssertion responds with a signed assertion that the user has completed two factor authentication.
+func writeAssertion(w http.ResponseWriter, r *http.Request, c *config.Config, data *validateRequestData) {
+	t, aerr := issueAssertion(c, r, data)
+	if aerr != nil {
+		writeAPIError(w, c, aerr)
+		return
+	}
+	if t == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", assertionContentType)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, t)
+}
+
+// issueAssertion returns a signed assertion that the user has completed two factor authentication, or an empty string
+// if no assertion signing keys are configured.
+func issueAssertion(c *config.Config, r *http.Request, data *validateRequestData) (string, *apiError) {
+	if !c.AssertionsEnabled() {
+		c.MFAServer.Loggers.Debug.Printf("%s, Assertion requested but no assertion signing keys are configured", r.RemoteAddr)
+		return "", nil
+	}
+	claims := assertion.NewClaims(c.AssertionIssuer(), data.Issuer, data.Domain, data.Username, authenticationMethods(c, r, data.Issuer), c.AssertionLifetime())
+	t, err := assertion.Sign(c.AssertionSigningKey(), claims)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Could not issue assertion for %s/%s: %v", r.RemoteAddr, data.Domain, data.Username, err)
+		return "", &apiError{http.StatusInternalServerError, ErrorInternal, "The assertion could not be issued"}
+	}
+	c.MFAServer.Loggers.Info.Printf("%s, Assertion %s issued for %s/%s", r.RemoteAddr, claims.ID, data.Domain, data.Username)
+	return t, nil
+}
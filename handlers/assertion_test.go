@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/assertion"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJWKS(t *testing.T) {
+	c := config.NewConfig()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { JWKS(w, r, c) }))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/.well-known/jwks.json")
+	if err != nil {
+		t.Fatalf("Error returned from sending request: %v", err)
+	}
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "JWKS should not be found when no keys are configured")
+
+	//Publish a current and a retired key
+	for i := 0; i < 2; i++ {
+		_, p, _ := ed25519.GenerateKey(rand.Reader)
+		k, _ := assertion.NewKey(p)
+		c.WithAssertionKey(k)
+	}
+	resp, err = http.Get(s.URL + "/.well-known/jwks.json")
+	if err != nil {
+		t.Fatalf("Error returned from sending request: %v", err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "JWKS status code not as expected")
+	var jwks assertion.JSONWebKeySet
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	assert.NoError(t, err, "Could not decode the JWKS response")
+	if assert.Len(t, jwks.Keys, 2, "All keys should be published") {
+		assert.Equal(t, c.Assertions.Keys[0].ID, jwks.Keys[0].KeyID, "Key ID not as expected")
+		assert.Equal(t, "OKP", jwks.Keys[0].KeyType, "Key type not as expected")
+		assert.Empty(t, jwks.Keys[0].N, "Private key material should not be published")
+	}
+}
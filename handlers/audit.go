@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"github.com/jcmturner/mfaserver/audit"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/mfaserverpb"
+	"net/http"
+	"strings"
+)
+
+// Security events recorded in the audit log for requests to each endpoint
+var auditedEndpoints = map[string]string{
+	"/validate":               audit.EventValidate,
+	"/enrol":                  audit.EventEnrol,
+	"/update":                 audit.EventUpdate,
+	"/delete":                 audit.EventDelete,
+	"/list":                   audit.EventList,
+	"/admin/cache/invalidate": audit.EventAdminCacheInvalidate,
+	"/login":                  audit.EventLogin,
+	OIDCAuthorizationPath:     audit.EventLogin,
+	"radius":                  audit.EventValidate,
+
+	mfaserverpb.MFAServer_Validate_FullMethodName:             audit.EventValidate,
+	mfaserverpb.MFAServer_Enrol_FullMethodName:                audit.EventEnrol,
+	mfaserverpb.MFAServer_Update_FullMethodName:               audit.EventUpdate,
+	mfaserverpb.MFAServer_Delete_FullMethodName:               audit.EventDelete,
+	mfaserverpb.MFAServer_ListUsers_FullMethodName:            audit.EventList,
+	mfaserverpb.MFAServer_InvalidateAdminCache_FullMethodName: audit.EventAdminCacheInvalidate,
+}
+
+// recordAuditEvent records the handled request in the audit log if it is a security event. Requests to the versioned
+// API paths are recorded as for the unversioned paths and calls to the gRPC API by their method. Requests for the login forms are only security events when the
+// form is submitted.
+func recordAuditEvent(c *config.Config, r *http.Request, e *config.RequestLog) {
+	t, ok := auditedEndpoints[strings.TrimPrefix(e.Endpoint, APIVersionPrefix)]
+	if !ok || (t == audit.EventLogin && r.Method != "POST") {
+		return
+	}
+	c.RecordAuditEvent(audit.Event{
+		Type:        t,
+		Outcome:     e.Outcome,
+		RequestID:   e.ID,
+		RemoteAddr:  e.RemoteAddr,
+		Application: e.Application,
+		Admin:       e.Admin,
+		Issuer:      e.Issuer,
+		Domain:      e.Domain,
+		Username:    e.Username,
+	})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"bytes"
+	"github.com/jcmturner/mfaserver/audit"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/metrics"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAuditEvent(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-audit")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	c := config.NewConfig()
+	if _, err := c.WithAuditLog(path, nil, 0); err != nil {
+		t.Fatalf("Error opening audit log: %v", err)
+	}
+	conf := func() *config.Config { return c }
+	f := func(w http.ResponseWriter, r *http.Request, rc *config.Config) {
+		rc.SetRequestUser("testapp", "test.com", "validuser")
+		metrics.SetOutcome(r, metrics.OutcomeOTPFail)
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	var tests = []struct {
+		endpoint string
+		method   string
+		recorded bool
+	}{
+		{"/validate", "POST", true},
+		{"/v1/validate", "POST", true},
+		{"/login", "GET", false},
+		{"/login", "POST", true},
+		{"/healthz", "GET", false},
+	}
+	for _, test := range tests {
+		r := metrics.WithOutcome(httptest.NewRequest(test.method, test.endpoint, nil))
+		r.Header.Set(RequestIDHeader, "audit-test")
+		Logged(test.endpoint, conf, f)(httptest.NewRecorder(), r)
+	}
+	c.Audit.Log.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 3, "Number of audit events not as expected")
+	for _, l := range lines {
+		assert.Contains(t, l, `"outcome":"otp_fail","request_id":"audit-test","remote_addr":"192.0.2.1:1234","issuer":"testapp","domain":"test.com","username":"validuser"`, "Audit event not as expected")
+	}
+	assert.Contains(t, lines[0], `"type":"`+audit.EventValidate+`"`, "Audit event type not as expected")
+	assert.Contains(t, lines[1], `"type":"`+audit.EventValidate+`"`, "Audit event type not as expected")
+	assert.Contains(t, lines[2], `"type":"`+audit.EventLogin+`"`, "Audit event type not as expected")
+	rep, err := audit.Verify(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatalf("Error verifying audit log: %v", err)
+	}
+	assert.True(t, rep.Valid(), "Audit log not valid: %v", rep.Problems)
+}
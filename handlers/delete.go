@@ -2,50 +2,66 @@ package handlers
 
 import (
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"github.com/jcmturner/mfaserver/config"
 	"github.com/jcmturner/mfaserver/ldap"
+	"github.com/jcmturner/mfaserver/metrics"
 	"github.com/jcmturner/mfaserver/secrets"
 	"net/http"
 	"strings"
 )
 
 func DeleteOTP(w http.ResponseWriter, r *http.Request, c *config.Config) {
-	//Process the request data
-	admin := checkAdminAuth(c, r)
-	data, err, HTTPCode := processValidateRequestData(r, admin)
+	app, ok := checkApplication(w, r, c)
+	if !ok {
+		return
+	}
+	//Process the request data. The password and OTP are not required if administrator credentials are provided.
+	_, _, adminCreds := r.BasicAuth()
+	data, err, HTTPCode := processValidateRequestData(r, adminCreds, c)
 	setNoCacheHeaders(w)
 	if err != nil {
 		c.MFAServer.Loggers.Error.Println(err.Error())
-		w.WriteHeader(HTTPCode)
+		writeError(w, c, HTTPCode, ErrorInvalidRequest, requestErrorMessage(r, err))
 		return
 	}
+	if aerr := deleteOTP(c, r, app, &data, adminCreds); aerr != nil {
+		writeAPIError(w, c, aerr)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
+
+// deleteOTP deletes the user's secret if the request was made by an administrator permitted to reset it or by the user
+// themselves with their password and OTP.
+func deleteOTP(c *config.Config, r *http.Request, app *application, data *validateRequestData, adminCreds bool) *apiError {
+	c.SetRequestUser(data.Issuer, data.Domain, data.Username)
+	if !applicationPermitted(c, r, app, data.Issuer) {
+		return &apiError{http.StatusForbidden, ErrorForbidden, "The application is not permitted to use the issuer"}
+	}
+	//The administrator is authorised against the directory for the domain of the user being deleted
+	admin := adminCreds && checkAdminAuth(c, r, config.PermissionReset, data.Issuer, data.Domain)
+	if !admin && !hasUserCredentials(c, r, data) {
+		c.MFAServer.Loggers.Error.Printf("%s, Could not extract values correctly from the deletion request.", r.RemoteAddr)
+		return &apiError{http.StatusBadRequest, ErrorInvalidRequest, "Could not extract values correctly from the deletion request."}
+	}
 	c.MFAServer.Loggers.Info.Printf("%s, OTP deletion request received for %s:%s/%s", r.RemoteAddr, data.Issuer, data.Domain, data.Username)
 	if !admin {
 		//Not an admin so check if they are deleting their own secret
 		c.MFAServer.Loggers.Info.Printf("%s, Deletion request for %s:%s/%s was not made by an administrator.", r.RemoteAddr, data.Issuer, data.Domain, data.Username)
-		ok, HTTPCode := twoFactorAuthenticate(c, r, &data)
+		ok, HTTPCode := twoFactorAuthenticate(c, r, app, data)
 		if !ok {
 			c.MFAServer.Loggers.Info.Printf("%s, Deletion request for %s:%s/%s denied as not made by an administrator or the user themselves.", r.RemoteAddr, data.Issuer, data.Domain, data.Username)
-			w.WriteHeader(HTTPCode)
-			d := messageResponseData{Message: "Cannot delete user's secret as either 2FA failed or user has not been enroled"}
-			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-			json.NewEncoder(w).Encode(d)
-			return
+			return &apiError{HTTPCode, ErrorUnauthorized, "Cannot delete user's secret as either 2FA failed or user has not been enroled"}
 		}
 	}
-	err = deleteSecret(c, &data)
-	if err != nil {
+	if err := deleteSecret(c, data); err != nil {
 		c.MFAServer.Loggers.Error.Printf("Failed to delete secret for %s:%s/%s: %v", data.Issuer, data.Domain, data.Username, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		d := messageResponseData{Message: "Error in deleting user's MFA secret"}
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		json.NewEncoder(w).Encode(d)
-		return
+		metrics.SetOutcome(r, metrics.OutcomeStoreError)
+		return &apiError{http.StatusInternalServerError, ErrorInternal, "Error in deleting user's MFA secret"}
 	}
-	w.WriteHeader(http.StatusNoContent)
-	return
+	return nil
 }
 
 func deleteSecret(c *config.Config, data *validateRequestData) error {
@@ -57,7 +73,9 @@ func deleteSecret(c *config.Config, data *validateRequestData) error {
 	return nil
 }
 
-func checkAdminAuth(c *config.Config, r *http.Request) bool {
+// checkAdminAuth checks the basic authentication credentials of the request are for an administrator
+// with a role that grants the permission for the issuer and domain.
+func checkAdminAuth(c *config.Config, r *http.Request, perm, issuer, d string) bool {
 	s := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
 	if len(s) != 2 {
 		return false
@@ -70,11 +88,17 @@ func checkAdminAuth(c *config.Config, r *http.Request) bool {
 	if len(pair) != 2 {
 		return false
 	}
-	err = ldap.AdminAuthorise(pair[0], pair[1], c)
+	m, err := ldap.MemberGroups(d, pair[0], pair[1], c.AdminGroupDNs(d), c)
 	if err != nil {
-		c.MFAServer.Loggers.Info.Printf("Administrator authorisation failed for user %s", pair[0])
+		c.MFAServer.Loggers.Info.Printf("Administrator authorisation failed for user %s/%s: %v", d, pair[0], err)
+		metrics.SetOutcome(r, metrics.OutcomeLDAPFail)
+		return false
+	}
+	if !c.AdminPermitted(m, perm, issuer, d) {
+		c.MFAServer.Loggers.Info.Printf("Administrator authorisation failed for user %s/%s as no role grants %s permission for %s:%s", d, pair[0], perm, issuer, d)
 		return false
 	}
-	c.MFAServer.Loggers.Info.Printf("Administrator authorisation passed for user %s", pair[0])
+	c.SetRequestAdmin(d, pair[0])
+	c.MFAServer.Loggers.Info.Printf("Administrator authorisation passed for user %s/%s with %s permission for %s:%s", d, pair[0], perm, issuer, d)
 	return true
 }
@@ -5,13 +5,13 @@ import (
 	"errors"
 	"github.com/jcmturner/gootp"
 	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/metrics"
 	"github.com/jcmturner/mfaserver/secrets"
 	"io"
 	"net/http"
 
 	"fmt"
 	"github.com/jcmturner/goqr"
-	"github.com/jcmturner/mfaserver/ldap"
 	"net/url"
 )
 
@@ -26,49 +26,29 @@ type enrolResponseData struct {
 	Secret string `json:"secret"`
 }
 
-type messageResponseData struct {
-	Message string `json:"message"`
-}
-
 func Enrol(w http.ResponseWriter, r *http.Request, c *config.Config) {
-	data, err, HTTPCode := processEnrolRequestData(r)
-	setNoCacheHeaders(w)
-	if err != nil {
-		c.MFAServer.Loggers.Error.Println(err.Error())
-		w.WriteHeader(HTTPCode)
+	app, ok := checkApplication(w, r, c)
+	if !ok {
 		return
 	}
-	c.MFAServer.Loggers.Info.Printf("%s, OTP enrolement request received for %s/%s\n", r.RemoteAddr, data.Domain, data.Username)
-
-	err = ldap.Authenticate(data.Username, data.Password, c)
+	data, err, HTTPCode := processEnrolRequestData(r, c)
+	setNoCacheHeaders(w)
 	if err != nil {
-		c.MFAServer.Loggers.Info.Printf("%s, OTP enrolement failed for %s/%s. LDAP authentication failed: %v", r.RemoteAddr, data.Domain, data.Username, err)
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	if secrets.Exists(c, "/"+data.Issuer+"/"+data.Domain+"/"+data.Username, "mfa") {
-		c.MFAServer.Loggers.Info.Printf("%s, OTP enrolement failed for %s/%s as the user already has enroled.", r.RemoteAddr, data.Domain, data.Username)
-		w.WriteHeader(http.StatusForbidden)
-		d := messageResponseData{Message: "Forbidden - User already enroled"}
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		json.NewEncoder(w).Encode(d)
+		c.MFAServer.Loggers.Error.Println(err.Error())
+		writeError(w, c, HTTPCode, ErrorInvalidRequest, requestErrorMessage(r, err))
 		return
 	}
-
-	s, err := createAndStoreSecret(c, &data)
-	if err != nil {
-		c.MFAServer.Loggers.Error.Printf("%s, OTP enrolement failed for %s/%s whilst generating and storing secret: %v", r.RemoteAddr, data.Domain, data.Username, err)
-		w.WriteHeader(http.StatusInternalServerError)
+	s, aerr := enrol(c, r, app, &data)
+	if aerr != nil {
+		writeAPIError(w, c, aerr)
 		return
 	}
 
 	if r.Header.Get("Accept-Encoding") == "image/png" {
-		gAuthURL := fmt.Sprintf("otpauth://totp/%s:%s@%s?secret=%s&issuer=%s&algorithm=SHA1&digits=6&period=30", url.QueryEscape(data.Issuer), data.Username, data.Domain, s, url.QueryEscape(data.Issuer))
-		img, err := getQRCodeBytes(gAuthURL)
+		img, err := secretQRCode(&data, s)
 		if err != nil {
 			c.MFAServer.Loggers.Error.Printf("%s, OTP enrolement failed for %s/%s whilst generating QR code: %v", r.RemoteAddr, data.Domain, data.Username, err)
-			w.WriteHeader(http.StatusInternalServerError)
+			writeError(w, c, http.StatusInternalServerError, ErrorInternal, "The QR code could not be generated")
 			return
 		}
 		w.Header().Set("Content-Type", "image/png")
@@ -84,7 +64,36 @@ func Enrol(w http.ResponseWriter, r *http.Request, c *config.Config) {
 	}
 }
 
-func processEnrolRequestData(r *http.Request) (enrolRequestData, error, int) {
+// enrol creates and stores a secret for a user that has not already enroled, once their password has been verified.
+func enrol(c *config.Config, r *http.Request, app *application, data *enrolRequestData) (string, *apiError) {
+	c.SetRequestUser(data.Issuer, data.Domain, data.Username)
+	if !applicationPermitted(c, r, app, data.Issuer) {
+		return "", &apiError{http.StatusForbidden, ErrorForbidden, "The application is not permitted to use the issuer"}
+	}
+	c.MFAServer.Loggers.Info.Printf("%s, OTP enrolement request received for %s/%s\n", r.RemoteAddr, data.Domain, data.Username)
+
+	err := verifyPassword(c, r, app, data.Issuer, data.Domain, data.Username, data.Password)
+	if err != nil {
+		c.MFAServer.Loggers.Info.Printf("%s, OTP enrolement failed for %s/%s. Password verification failed: %v", r.RemoteAddr, data.Domain, data.Username, err)
+		metrics.SetOutcome(r, metrics.OutcomeLDAPFail)
+		return "", &apiError{http.StatusUnauthorized, ErrorUnauthorized, "The user's password could not be verified"}
+	}
+
+	if secrets.Exists(c, "/"+data.Issuer+"/"+data.Domain+"/"+data.Username, "mfa") {
+		c.MFAServer.Loggers.Info.Printf("%s, OTP enrolement failed for %s/%s as the user already has enroled.", r.RemoteAddr, data.Domain, data.Username)
+		return "", &apiError{http.StatusForbidden, ErrorAlreadyEnrolled, "Forbidden - User already enroled"}
+	}
+
+	s, err := createAndStoreSecret(c, data)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, OTP enrolement failed for %s/%s whilst generating and storing secret: %v", r.RemoteAddr, data.Domain, data.Username, err)
+		metrics.SetOutcome(r, metrics.OutcomeStoreError)
+		return "", &apiError{http.StatusInternalServerError, ErrorInternal, "The user's secret could not be stored"}
+	}
+	return s, nil
+}
+
+func processEnrolRequestData(r *http.Request, c *config.Config) (enrolRequestData, error, int) {
 	var data enrolRequestData
 	defer r.Body.Close()
 	var dec *json.Decoder
@@ -94,12 +103,25 @@ func processEnrolRequestData(r *http.Request) (enrolRequestData, error, int) {
 	if err != nil {
 		return data, errors.New(fmt.Sprintf("%s, Could not parse data posted from client to the enrole api : %v\n", r.RemoteAddr, err)), http.StatusBadRequest
 	}
-	if data.Domain == "" || data.Username == "" || data.Password == "" || data.Issuer == "" {
-		return data, errors.New(fmt.Sprintf("%s, Could extract values correctly from the enrolement request.\n", r.RemoteAddr)), http.StatusBadRequest
+	if err := checkEnrolRequestData(r, c, &data); err != nil {
+		return data, err, http.StatusBadRequest
 	}
 	return data, nil, 0
 }
 
+// checkEnrolRequestData checks that the enrolement request has the values needed, whichever API it was made with.
+func checkEnrolRequestData(r *http.Request, c *config.Config, data *enrolRequestData) error {
+	//A user authenticated by Kerberos is identified by their principal
+	if u, d, ok := negotiatedIdentity(c, r); ok {
+		data.Username = u
+		data.Domain = d
+	}
+	if data.Domain == "" || data.Username == "" || data.Issuer == "" || (data.Password == "" && passwordRequired(c, r, data.Issuer)) {
+		return errors.New(fmt.Sprintf("%s, Could extract values correctly from the enrolement request.\n", r.RemoteAddr))
+	}
+	return nil
+}
+
 func createAndStoreSecret(c *config.Config, data *enrolRequestData) (string, error) {
 	s, err := gootp.GenerateOTPSecret(32)
 	if err != nil {
@@ -113,6 +135,12 @@ func createAndStoreSecret(c *config.Config, data *enrolRequestData) (string, err
 	return s, nil
 }
 
+// secretQRCode returns a PNG of the QR code that adds the user's secret to an authenticator app.
+func secretQRCode(data *enrolRequestData, s string) ([]byte, error) {
+	gAuthURL := fmt.Sprintf("otpauth://totp/%s:%s@%s?secret=%s&issuer=%s&algorithm=SHA1&digits=6&period=30", url.QueryEscape(data.Issuer), data.Username, data.Domain, s, url.QueryEscape(data.Issuer))
+	return getQRCodeBytes(gAuthURL)
+}
+
 func getQRCodeBytes(u string) ([]byte, error) {
 	code, err := goqr.Encode(u, goqr.H)
 	if err != nil {
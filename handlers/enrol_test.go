@@ -110,3 +110,48 @@ func TestEnrolQRCode(t *testing.T) {
 		t.Errorf("Could not decode QR code response into a png object: %v", err)
 	}
 }
+
+func TestEnrolLDAPDomains(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	//Set up the MFA config with a directory only for the testdom domain
+	c := config.NewConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	_, err := c.WithLDAPDomain("testdom", "ldap://"+l.Listener.Addr().String(), "", "{username}")
+	if err != nil {
+		t.Fatalf("Error configuring LDAP directory for domain: %v", err)
+	}
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { Enrol(w, r, c) }))
+	defer s.Close()
+
+	var tests = []struct {
+		Json     string
+		HttpCode int
+	}{
+		{`{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "testapp"}`, http.StatusCreated},
+		{`{"domain": "unknowndom", "username": "validuser", "password": "validpassword", "issuer": "testapp"}`, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("POST", s.URL+"/enrol", bytes.NewBuffer([]byte(test.Json)))
+		if err != nil {
+			t.Errorf("Error returned from creating request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("Error returned from sending request: %v", err)
+		}
+		if resp.StatusCode != test.HttpCode {
+			t.Errorf("Expected code %v, got %v for post data %v", test.HttpCode, resp.StatusCode, test.Json)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/session"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Response headers identifying the user of a valid session to the reverse proxy
+const (
+	HeaderUsername = "X-MFA-Username"
+	HeaderDomain   = "X-MFA-Domain"
+	HeaderIssuer   = "X-MFA-Issuer"
+)
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sign in</title>
+</head>
+<body>
+<h1>Sign in</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+<form method="POST" action="{{.Action}}">
+{{range $name, $value := .Hidden}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<label>Domain <input type="text" name="domain" value="{{.Domain}}"></label><br>
+<label>Username <input type="text" name="username" value="{{.Username}}" autocomplete="username"></label><br>
+<label>Password <input type="password" name="password" autocomplete="current-password"></label><br>
+<label>One time password <input type="text" name="otp" inputmode="numeric" autocomplete="one-time-code"></label><br>
+<input type="submit" value="Sign in">
+</form>
+</body>
+</html>
+`))
+
+type loginPageData struct {
+	Message  string
+	Action   string
+	Hidden   map[string]string
+	Domain   string
+	Username string
+}
+
+// ForwardAuth checks the session cookie of a request forwarded by a reverse proxy such as nginx (auth_request) or Traefik (forwardAuth).
+// A valid session is answered with 200 and the user's identity in the response headers, otherwise 401 is returned.
+func ForwardAuth(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	setNoCacheHeaders(w)
+	if !c.ForwardAuthEnabled() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s, ok := requestSession(r, c)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	c.MFAServer.Loggers.Debug.Printf("%s, Forward authentication session valid for %s/%s", r.RemoteAddr, s.Domain, s.Username)
+	w.Header().Set(HeaderUsername, s.Username)
+	w.Header().Set(HeaderDomain, s.Domain)
+	w.Header().Set(HeaderIssuer, s.Issuer)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Login presents a login form and, once the user's password and OTP have been validated, sets the signed session cookie.
+func Login(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	setNoCacheHeaders(w)
+	if !c.ForwardAuthEnabled() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	p := loginPageData{Action: "/login", Hidden: map[string]string{"rd": r.FormValue("rd")}}
+	if c.ForwardAuth.Domain != nil {
+		p.Domain = *c.ForwardAuth.Domain
+	}
+	if r.Method != "POST" {
+		renderLogin(w, http.StatusOK, p)
+		return
+	}
+
+	//Limit the form data that will be read
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if err := r.ParseForm(); err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Could not parse login form data: %v", r.RemoteAddr, err)
+		renderLogin(w, http.StatusBadRequest, p)
+		return
+	}
+	p.Hidden["rd"] = r.PostFormValue("rd")
+	if d := r.PostFormValue("domain"); d != "" {
+		p.Domain = d
+	}
+	p.Username = r.PostFormValue("username")
+	data := validateRequestData{
+		Issuer:   *c.ForwardAuth.Issuer,
+		Domain:   p.Domain,
+		Username: p.Username,
+		Password: r.PostFormValue("password"),
+		OTP:      r.PostFormValue("otp"),
+	}
+	c.SetRequestUser(data.Issuer, data.Domain, data.Username)
+	if data.Domain == "" || data.Username == "" || !hasUserCredentials(c, r, &data) {
+		p.Message = "Please provide all of the values requested."
+		renderLogin(w, http.StatusBadRequest, p)
+		return
+	}
+	c.MFAServer.Loggers.Info.Printf("%s, Login request received for %s/%s", r.RemoteAddr, data.Domain, data.Username)
+	if ok, _ := twoFactorAuthenticate(c, r, nil, &data); !ok {
+		p.Message = "Sign in failed."
+		renderLogin(w, http.StatusUnauthorized, p)
+		return
+	}
+
+	v, err := session.Sign(c.ForwardAuth.Key, session.New(data.Issuer, data.Domain, data.Username, c.SessionTimeout()))
+	if err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Could not create session for %s/%s: %v", r.RemoteAddr, data.Domain, data.Username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, sessionCookie(c, r, v, int(c.SessionTimeout().Seconds())))
+	if rd := p.Hidden["rd"]; safeRedirect(c, rd) {
+		http.Redirect(w, r, rd, http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "Signed in.\n")
+}
+
+// Logout removes the session cookie.
+func Logout(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	setNoCacheHeaders(w)
+	if !c.ForwardAuthEnabled() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	http.SetCookie(w, sessionCookie(c, r, "", -1))
+	if rd := r.FormValue("rd"); safeRedirect(c, rd) {
+		http.Redirect(w, r, rd, http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestSession returns the valid session of the request's cookie.
+func requestSession(r *http.Request, c *config.Config) (session.Session, bool) {
+	ck, err := r.Cookie(c.SessionCookieName())
+	if err != nil {
+		return session.Session{}, false
+	}
+	s, err := session.Verify(c.ForwardAuth.Key, ck.Value)
+	if err != nil {
+		c.MFAServer.Loggers.Debug.Printf("%s, Forward authentication session not valid: %v", r.RemoteAddr, err)
+		return s, false
+	}
+	if s.Issuer != *c.ForwardAuth.Issuer {
+		return s, false
+	}
+	return s, true
+}
+
+func sessionCookie(c *config.Config, r *http.Request, v string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     c.SessionCookieName(),
+		Value:    v,
+		Path:     "/",
+		Domain:   c.ForwardAuth.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   c.MFAServer.TLS.Enabled || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// safeRedirect reports whether the user can be redirected to the URL after login.
+// Only paths on this server or URLs within the cookie domain are permitted to prevent open redirects.
+func safeRedirect(c *config.Config, rd string) bool {
+	if rd == "" {
+		return false
+	}
+	u, err := url.Parse(rd)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return strings.HasPrefix(rd, "/") && !strings.HasPrefix(rd, "//") && !strings.HasPrefix(rd, `/\`)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	d := strings.ToLower(strings.TrimPrefix(c.ForwardAuth.CookieDomain, "."))
+	h := strings.ToLower(u.Hostname())
+	return d != "" && (h == d || strings.HasSuffix(h, "."+d))
+}
+
+func renderLogin(w http.ResponseWriter, code int, p loginPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(code)
+	loginTemplate.Execute(w, p)
+}
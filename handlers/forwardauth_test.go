@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"crypto/sha1"
+	"github.com/jcmturner/gootp"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/session"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSessionKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestForwardAuth(t *testing.T) {
+	c := config.NewConfig()
+	c.WithForwardAuth("testapp", testSessionKey)
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ForwardAuth(w, r, c) }))
+	defer s.Close()
+
+	valid, _ := session.Sign(testSessionKey, session.New("testapp", "testdom", "validuser", time.Minute))
+	expired, _ := session.Sign(testSessionKey, session.New("testapp", "testdom", "validuser", -time.Minute))
+	otherIssuer, _ := session.Sign(testSessionKey, session.New("otherapp", "testdom", "validuser", time.Minute))
+	otherKey, _ := session.Sign([]byte("adifferentkeyadifferentkeyadiffe"), session.New("testapp", "testdom", "validuser", time.Minute))
+
+	var tests = []struct {
+		Cookie   string
+		HttpCode int
+	}{
+		{valid, http.StatusOK},
+		{expired, http.StatusUnauthorized},
+		{otherIssuer, http.StatusUnauthorized},
+		{otherKey, http.StatusUnauthorized},
+		{"notasession", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", s.URL+"/auth", nil)
+		if test.Cookie != "" {
+			r.AddCookie(&http.Cookie{Name: c.SessionCookieName(), Value: test.Cookie})
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Error returned from sending request: %v", err)
+		}
+		assert.Equal(t, test.HttpCode, resp.StatusCode, "Status code not as expected for cookie %s", test.Cookie)
+		if resp.StatusCode == http.StatusOK {
+			assert.Equal(t, "validuser", resp.Header.Get(HeaderUsername), "Username header not as expected")
+			assert.Equal(t, "testdom", resp.Header.Get(HeaderDomain), "Domain header not as expected")
+			assert.Equal(t, "testapp", resp.Header.Get(HeaderIssuer), "Issuer header not as expected")
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	//Set up the MFA config
+	c := config.NewConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	c.WithForwardAuth("testapp", testSessionKey)
+	c.WithForwardAuthCookie("", "example.com", time.Hour)
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { Login(w, r, c) }))
+	defer s.Close()
+
+	udata := enrolRequestData{Username: "validuser",
+		Domain:   "testdom",
+		Issuer:   "testapp",
+		Password: "validpassword"}
+	secret, _ := createAndStoreSecret(c, &udata)
+
+	//Do not follow the redirect after login
+	cl := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+
+	var tests = []struct {
+		Username string
+		Password string
+		OTP      string
+		Redirect string
+		HttpCode int
+		Location string
+	}{
+		{"validuser", "validpassword", "", "https://app.example.com/page", http.StatusSeeOther, "https://app.example.com/page"},
+		{"validuser", "validpassword", "", "/page", http.StatusSeeOther, "/page"},
+		{"validuser", "validpassword", "", "https://evil.test/page", http.StatusOK, ""},
+		{"validuser", "validpassword", "", "//evil.test/page", http.StatusOK, ""},
+		{"validuser", "validpassword", "123456", "/page", http.StatusUnauthorized, ""},
+		{"validuser", "invalidpassword", "", "/page", http.StatusUnauthorized, ""},
+		{"validuser", "", "", "/page", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		otp := test.OTP
+		if otp == "" && test.Password != "" {
+			otp, _, _ = gootp.GetTOTPNow(secret, sha1.New, 6)
+		}
+		f := url.Values{}
+		f.Set("domain", "testdom")
+		f.Set("username", test.Username)
+		f.Set("password", test.Password)
+		f.Set("otp", otp)
+		f.Set("rd", test.Redirect)
+		r, _ := http.NewRequest("POST", s.URL+"/login", strings.NewReader(f.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := cl.Do(r)
+		if err != nil {
+			t.Fatalf("Error returned from sending request: %v", err)
+		}
+		assert.Equal(t, test.HttpCode, resp.StatusCode, "Status code not as expected for %s with redirect %s", test.Username, test.Redirect)
+		assert.Equal(t, test.Location, resp.Header.Get("Location"), "Redirect location not as expected")
+		var ck *http.Cookie
+		for _, k := range resp.Cookies() {
+			if k.Name == c.SessionCookieName() {
+				ck = k
+			}
+		}
+		if test.HttpCode == http.StatusSeeOther || test.HttpCode == http.StatusOK {
+			if assert.NotNil(t, ck, "Session cookie not set") {
+				sess, err := session.Verify(testSessionKey, ck.Value)
+				assert.NoError(t, err, "Session cookie not valid")
+				assert.Equal(t, "validuser", sess.Username, "Session username not as expected")
+				assert.True(t, ck.HttpOnly, "Session cookie should be HttpOnly")
+			}
+		} else {
+			assert.Nil(t, ck, "Session cookie should not be set when login fails")
+		}
+	}
+
+	//The login form is returned for GET requests
+	resp, err := http.Get(s.URL + "/login?rd=/page")
+	if err != nil {
+		t.Fatalf("Error returned from sending request: %v", err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Login form not returned")
+	assert.Equal(t, "text/html; charset=UTF-8", resp.Header.Get("Content-Type"), "Login form content type not as expected")
+}
@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/metrics"
+	"github.com/jcmturner/mfaserver/mfaserverpb"
+	"github.com/jcmturner/mfaserver/tracing"
+	"github.com/jcmturner/mfaserver/webhook"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorDomain is the domain of the ErrorInfo detail of the gRPC API's errors. The reason is the error code of the REST
+// API's error response.
+const ErrorDomain = "mfaserver"
+
+// How often a health Watch call checks whether the serving status has changed
+const grpcHealthWatchInterval = readinessCacheTTL
+
+// NewGRPCServer returns a server of the gRPC API and the standard gRPC health service. Each call is served with the
+// current configuration, by the same operations as the REST API. If TLS is enabled the TLS settings, including
+// whether client certificates are verified, are taken from the current configuration for each connection.
+func NewGRPCServer(conf func() *config.Config) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor(), grpcLogged(conf))}
+	if conf().MFAServer.TLS.Enabled {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				t := conf().MFATLSConfig()
+				//gRPC is served over HTTP/2 only
+				t.NextProtos = []string{"h2"}
+				return t, nil
+			},
+		})))
+	}
+	s := grpc.NewServer(opts...)
+	mfaserverpb.RegisterMFAServerServer(s, &grpcServer{})
+	healthpb.RegisterHealthServer(s, &grpcHealthServer{conf: conf})
+	return s
+}
+
+type grpcCallKey struct{}
+
+// grpcCall is the configuration for a gRPC call and the call represented as an HTTP request, so that the credentials
+// in its metadata are checked as for the REST API.
+type grpcCall struct {
+	c *config.Config
+	r *http.Request
+}
+
+// grpcLogged serves gRPC calls with a copy of the current configuration for the call, then logs the call, records it in
+// the audit log if it is a security event and counts it in the metrics, as Logged does for HTTP requests. Calls are
+// logged with the HTTP status code the REST API would have responded with.
+func grpcLogged(conf func() *config.Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		r := metrics.WithOutcome(grpcRequest(ctx, info.FullMethod))
+		id := requestID(r)
+		grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(RequestIDHeader), id))
+		c := conf().ForRequest(id, r.RemoteAddr)
+		defer c.Done()
+		c.SetRequestContext(ctx)
+		c.Request.Endpoint = info.FullMethod
+		resp, err := handler(context.WithValue(ctx, grpcCallKey{}, &grpcCall{c: c, r: r}), req)
+		code := http.StatusOK
+		if aerr, ok := err.(*apiError); ok {
+			code = aerr.Status
+			err = grpcStatus(aerr, id)
+		} else if err != nil {
+			//Errors not from the API's operations, such as an unknown health service, already have a status
+			code = http.StatusInternalServerError
+			if status.Code(err) == codes.NotFound {
+				code = http.StatusNotFound
+			}
+		}
+		c.Request.Status = code
+		c.Request.Outcome = metrics.Outcome(r, code)
+		c.Request.Duration = time.Since(start)
+		metrics.ObserveRequest(info.FullMethod, c.Request.Outcome, start)
+		c.MFAServer.Loggers.Request(c.Request)
+		recordAuditEvent(c, r, c.Request)
+		tracing.SetAttributes(ctx, attribute.String("mfaserver.request_id", id), attribute.String("mfaserver.outcome", c.Request.Outcome))
+		return resp, err
+	}
+}
+
+// grpcRequest represents the gRPC call as an HTTP POST request with the call's metadata as headers. Binary metadata is
+// not carried. Request signatures are not carried either as the signature would not cover the call's message, so an
+// application authenticates with a client certificate or an API key.
+func grpcRequest(ctx context.Context, method string) *http.Request {
+	r := &http.Request{
+		Method:     "POST",
+		URL:        &url.URL{Path: method},
+		RequestURI: method,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, v := range md {
+		if strings.HasPrefix(k, ":") || strings.HasSuffix(k, "-bin") {
+			continue
+		}
+		r.Header[http.CanonicalHeaderKey(k)] = v
+	}
+	r.Header.Del(SignatureHeader)
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		if ti, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			s := ti.State
+			r.TLS = &s
+		}
+	}
+	return r.WithContext(ctx)
+}
+
+// grpcStatus returns the gRPC status of the failed operation, with the error code and request ID in an ErrorInfo
+// detail.
+func grpcStatus(e *apiError, id string) error {
+	var c codes.Code
+	switch e.Status {
+	case http.StatusBadRequest:
+		c = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		c = codes.Unauthenticated
+	case http.StatusForbidden:
+		c = codes.PermissionDenied
+		if e.Code == ErrorAlreadyEnrolled {
+			c = codes.AlreadyExists
+		}
+	case http.StatusNotFound:
+		c = codes.NotFound
+	case http.StatusInternalServerError:
+		c = codes.Internal
+	default:
+		c = codes.Unknown
+	}
+	s := status.New(c, e.Message)
+	if d, err := s.WithDetails(&errdetails.ErrorInfo{
+		Reason:   e.Code,
+		Domain:   ErrorDomain,
+		Metadata: map[string]string{"request_id": id},
+	}); err == nil {
+		s = d
+	}
+	return s.Err()
+}
+
+// grpcServer serves the MFAServer gRPC service.
+type grpcServer struct {
+	mfaserverpb.UnimplementedMFAServerServer
+}
+
+// call returns the configuration and HTTP request of the call, authenticating the application that made it.
+func (s *grpcServer) call(ctx context.Context) (*config.Config, *http.Request, *application, error) {
+	gc := ctx.Value(grpcCallKey{}).(*grpcCall)
+	app, aerr := authenticateApplication(gc.c, gc.r)
+	if aerr != nil {
+		return nil, nil, nil, aerr
+	}
+	return gc.c, gc.r, app, nil
+}
+
+func (s *grpcServer) Enrol(ctx context.Context, req *mfaserverpb.EnrolRequest) (*mfaserverpb.SecretResponse, error) {
+	c, r, app, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data := enrolRequestData{Issuer: req.GetIssuer(), Domain: req.GetDomain(), Username: req.GetUsername(), Password: req.GetPassword()}
+	if err := checkEnrolRequestData(r, c, &data); err != nil {
+		return nil, invalidRequest(c, r, err)
+	}
+	secret, aerr := enrol(c, r, app, &data)
+	if aerr != nil {
+		return nil, aerr
+	}
+	return secretResponse(c, r, &data, secret, req.GetQrCode())
+}
+
+func (s *grpcServer) Validate(ctx context.Context, req *mfaserverpb.ValidateRequest) (*mfaserverpb.ValidateResponse, error) {
+	c, r, app, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data := validateRequestData{Issuer: req.GetIssuer(), Domain: req.GetDomain(), Username: req.GetUsername(), Password: req.GetPassword(), OTP: req.GetOtp()}
+	if err := checkValidateRequestData(r, false, c, &data); err != nil {
+		return nil, invalidRequest(c, r, err)
+	}
+	if aerr := validate(c, r, app, &data); aerr != nil {
+		return nil, aerr
+	}
+	resp := &mfaserverpb.ValidateResponse{}
+	if req.GetAssertion() {
+		t, aerr := issueAssertion(c, r, &data)
+		if aerr != nil {
+			return nil, aerr
+		}
+		resp.Assertion = t
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) Update(ctx context.Context, req *mfaserverpb.UpdateRequest) (*mfaserverpb.SecretResponse, error) {
+	c, r, app, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data := validateRequestData{Issuer: req.GetIssuer(), Domain: req.GetDomain(), Username: req.GetUsername(), Password: req.GetPassword(), OTP: req.GetOtp()}
+	if err := checkValidateRequestData(r, false, c, &data); err != nil {
+		return nil, invalidRequest(c, r, err)
+	}
+	secret, aerr := update(c, r, app, &data)
+	if aerr != nil {
+		return nil, aerr
+	}
+	return secretResponse(c, r, &enrolRequestData{Issuer: data.Issuer, Domain: data.Domain, Username: data.Username}, secret, req.GetQrCode())
+}
+
+func (s *grpcServer) Delete(ctx context.Context, req *mfaserverpb.DeleteRequest) (*mfaserverpb.DeleteResponse, error) {
+	c, r, app, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	//The password and OTP are not required if administrator credentials are provided
+	_, _, adminCreds := r.BasicAuth()
+	data := validateRequestData{Issuer: req.GetIssuer(), Domain: req.GetDomain(), Username: req.GetUsername(), Password: req.GetPassword(), OTP: req.GetOtp()}
+	if err := checkValidateRequestData(r, adminCreds, c, &data); err != nil {
+		return nil, invalidRequest(c, r, err)
+	}
+	if aerr := deleteOTP(c, r, app, &data, adminCreds); aerr != nil {
+		return nil, aerr
+	}
+	return &mfaserverpb.DeleteResponse{}, nil
+}
+
+func (s *grpcServer) ListUsers(ctx context.Context, req *mfaserverpb.ListUsersRequest) (*mfaserverpb.ListUsersResponse, error) {
+	c, r, app, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data := listRequestData{Issuer: req.GetIssuer(), Domain: req.GetDomain()}
+	if err := checkListRequestData(r, &data); err != nil {
+		return nil, invalidRequest(c, r, err)
+	}
+	u, aerr := listUsers(c, r, app, &data)
+	if aerr != nil {
+		return nil, aerr
+	}
+	return &mfaserverpb.ListUsersResponse{Usernames: u}, nil
+}
+
+func (s *grpcServer) InvalidateAdminCache(ctx context.Context, req *mfaserverpb.InvalidateAdminCacheRequest) (*mfaserverpb.InvalidateAdminCacheResponse, error) {
+	c, r, _, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data := adminCacheRequestData{Domain: req.GetDomain(), Username: req.GetUsername()}
+	if err := checkAdminCacheRequestData(r, &data); err != nil {
+		return nil, invalidRequest(c, r, err)
+	}
+	if aerr := invalidateAdminCache(c, r, &data); aerr != nil {
+		return nil, aerr
+	}
+	return &mfaserverpb.InvalidateAdminCacheResponse{}, nil
+}
+
+func (s *grpcServer) GetAdminCacheStats(ctx context.Context, req *mfaserverpb.GetAdminCacheStatsRequest) (*mfaserverpb.AdminCacheStats, error) {
+	c, r, _, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data := adminCacheRequestData{Domain: req.GetDomain()}
+	if err := checkAdminCacheRequestData(r, &data); err != nil {
+		return nil, invalidRequest(c, r, err)
+	}
+	st, aerr := adminCacheStats(c, r, &data)
+	if aerr != nil {
+		return nil, aerr
+	}
+	return &mfaserverpb.AdminCacheStats{
+		Entries:   int64(st.Entries),
+		Hits:      st.Hits,
+		Misses:    st.Misses,
+		Evictions: st.Evictions,
+		HitRate:   st.HitRate,
+	}, nil
+}
+
+func (s *grpcServer) ListWebhookDeliveries(ctx context.Context, req *mfaserverpb.ListWebhookDeliveriesRequest) (*mfaserverpb.ListWebhookDeliveriesResponse, error) {
+	c, r, app, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data := webhookDeliveriesRequestData{Domain: req.GetDomain(), Subscription: req.GetSubscription(), Status: req.GetStatus(), Limit: int(req.GetLimit())}
+	if err := checkWebhookDeliveriesRequestData(r, &data); err != nil {
+		return nil, invalidRequest(c, r, err)
+	}
+	ds, aerr := webhookDeliveries(c, r, app, &data)
+	if aerr != nil {
+		return nil, aerr
+	}
+	resp := &mfaserverpb.ListWebhookDeliveriesResponse{}
+	for _, d := range ds {
+		resp.Deliveries = append(resp.Deliveries, grpcWebhookDelivery(d))
+	}
+	return resp, nil
+}
+
+// secretResponse returns the user's secret and, if requested, its QR code.
+func secretResponse(c *config.Config, r *http.Request, data *enrolRequestData, secret string, qr bool) (*mfaserverpb.SecretResponse, error) {
+	resp := &mfaserverpb.SecretResponse{Secret: secret}
+	if qr {
+		img, err := secretQRCode(data, secret)
+		if err != nil {
+			c.MFAServer.Loggers.Error.Printf("%s, QR code of the secret for %s/%s could not be generated: %v", r.RemoteAddr, data.Domain, data.Username, err)
+			return nil, &apiError{http.StatusInternalServerError, ErrorInternal, "The QR code could not be generated"}
+		}
+		resp.QrCodePng = img
+	}
+	return resp, nil
+}
+
+func grpcWebhookDelivery(d webhook.Delivery) *mfaserverpb.WebhookDelivery {
+	return &mfaserverpb.WebhookDelivery{
+		Id:           d.ID,
+		Subscription: d.Subscription,
+		Event: &mfaserverpb.WebhookEvent{
+			Id:          d.Event.ID,
+			Type:        d.Event.Type,
+			Time:        d.Event.Time,
+			Issuer:      d.Event.Issuer,
+			Domain:      d.Event.Domain,
+			Username:    d.Event.Username,
+			Admin:       d.Event.Admin,
+			Application: d.Event.Application,
+			RequestId:   d.Event.RequestID,
+		},
+		Status:       d.Status,
+		Attempts:     int32(d.Attempts),
+		Created:      grpcTimestamp(d.Created),
+		NextAttempt:  grpcTimestamp(d.NextAttempt),
+		LastAttempt:  grpcTimestamp(d.LastAttempt),
+		ResponseCode: int32(d.ResponseCode),
+		LastError:    d.LastError,
+	}
+}
+
+// grpcTimestamp returns the time as a timestamp, or nil if it is not set.
+func grpcTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// grpcHealthServer serves the standard gRPC health service. The MFA server, and the MFAServer service, are serving when
+// the readiness checks pass, as reported by /readyz.
+type grpcHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	conf func() *config.Config
+}
+
+func (h *grpcHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if !grpcHealthService(req.GetService()) {
+		return nil, status.Error(codes.NotFound, "Unknown service "+req.GetService())
+	}
+	return &healthpb.HealthCheckResponse{Status: h.status()}, nil
+}
+
+func (h *grpcHealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	if !grpcHealthService(req.GetService()) {
+		//A watched service that is not known is reported as such rather than failing the call
+		return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVICE_UNKNOWN})
+	}
+	var last healthpb.HealthCheckResponse_ServingStatus = -1
+	t := time.NewTicker(grpcHealthWatchInterval)
+	defer t.Stop()
+	for {
+		if s := h.status(); s != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: s}); err != nil {
+				return err
+			}
+			last = s
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-t.C:
+		}
+	}
+}
+
+// status returns whether the MFA server is serving, checking its dependencies as /readyz does.
+func (h *grpcHealthServer) status() healthpb.HealthCheckResponse_ServingStatus {
+	if atomic.LoadInt32(&draining) == 1 {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	c := h.conf()
+	checks, _ := dependencyChecks(c)
+	for n, chk := range checks {
+		if chk.Status != StatusOK {
+			c.MFAServer.Loggers.Warning.Printf("gRPC health check of %s failed: %s", n, chk.Error)
+			return healthpb.HealthCheckResponse_NOT_SERVING
+		}
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// grpcHealthService reports whether the health of the service is served. The empty name is the MFA server as a whole.
+func grpcHealthService(s string) bool {
+	return s == "" || s == mfaserverpb.MFAServer_ServiceDesc.ServiceName
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"github.com/jcmturner/gootp"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/mfaserverpb"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// grpcTestServer serves the gRPC API with the configuration over an in-memory connection.
+func grpcTestServer(t *testing.T, c *config.Config) (*grpc.ClientConn, func()) {
+	l := bufconn.Listen(1024 * 1024)
+	s := NewGRPCServer(func() *config.Config { return c })
+	go s.Serve(l)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Error connecting to the gRPC server: %v", err)
+	}
+	return conn, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+// grpcErrorInfo returns the ErrorInfo detail of the gRPC error.
+func grpcErrorInfo(err error) *errdetails.ErrorInfo {
+	for _, d := range status.Convert(err).Details() {
+		if e, ok := d.(*errdetails.ErrorInfo); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+func basicAuthMetadata(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestGRPCServer_Errors(t *testing.T) {
+	c := config.NewConfig()
+	c.WithApplication("app1", []string{testAppKey}, testAppSecret, []string{"testapp"})
+	c.MFAServer.Loggers.Error = log.New(ioutil.Discard, "", 0)
+	conn, stop := grpcTestServer(t, c)
+	defer stop()
+	client := mfaserverpb.NewMFAServerClient(conn)
+
+	validate := func(ctx context.Context, req *mfaserverpb.ValidateRequest, opt grpc.CallOption) error {
+		_, err := client.Validate(ctx, req, opt)
+		return err
+	}
+	var tests = []struct {
+		Metadata []string
+		Call     func(context.Context, grpc.CallOption) error
+		Code     codes.Code
+		Reason   string
+	}{
+		{[]string{"x-mfa-application", "app1", "x-mfa-application-key", testAppKey}, func(ctx context.Context, opt grpc.CallOption) error {
+			_, err := client.Enrol(ctx, &mfaserverpb.EnrolRequest{Domain: "testdom"}, opt)
+			return err
+		}, codes.InvalidArgument, ErrorInvalidRequest},
+		{nil, func(ctx context.Context, opt grpc.CallOption) error {
+			return validate(ctx, &mfaserverpb.ValidateRequest{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "validpassword", Otp: "123456"}, opt)
+		}, codes.Unauthenticated, ErrorUnauthorized},
+		{[]string{"x-mfa-application", "app1", "x-mfa-application-key", "wrongkey"}, func(ctx context.Context, opt grpc.CallOption) error {
+			return validate(ctx, &mfaserverpb.ValidateRequest{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "validpassword", Otp: "123456"}, opt)
+		}, codes.Unauthenticated, ErrorUnauthorized},
+		//Request signatures are not accepted as they would not cover the call's message
+		{[]string{"x-mfa-application", "app1", "x-mfa-timestamp", "0", "x-mfa-signature", "00"}, func(ctx context.Context, opt grpc.CallOption) error {
+			return validate(ctx, &mfaserverpb.ValidateRequest{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "validpassword", Otp: "123456"}, opt)
+		}, codes.Unauthenticated, ErrorUnauthorized},
+		{[]string{"x-mfa-application", "app1", "x-mfa-application-key", testAppKey}, func(ctx context.Context, opt grpc.CallOption) error {
+			return validate(ctx, &mfaserverpb.ValidateRequest{Issuer: "otherapp", Domain: "testdom", Username: "validuser", Password: "validpassword", Otp: "123456"}, opt)
+		}, codes.PermissionDenied, ErrorForbidden},
+		{[]string{"x-mfa-application", "app1", "x-mfa-application-key", testAppKey}, func(ctx context.Context, opt grpc.CallOption) error {
+			_, err := client.Delete(ctx, &mfaserverpb.DeleteRequest{Issuer: "testapp", Domain: "testdom", Username: "validuser"}, opt)
+			return err
+		}, codes.InvalidArgument, ErrorInvalidRequest},
+		{[]string{"x-mfa-application", "app1", "x-mfa-application-key", testAppKey}, func(ctx context.Context, opt grpc.CallOption) error {
+			_, err := client.GetAdminCacheStats(ctx, &mfaserverpb.GetAdminCacheStatsRequest{}, opt)
+			return err
+		}, codes.InvalidArgument, ErrorInvalidRequest},
+		{[]string{"x-mfa-application", "app1", "x-mfa-application-key", testAppKey}, func(ctx context.Context, opt grpc.CallOption) error {
+			_, err := client.ListWebhookDeliveries(ctx, &mfaserverpb.ListWebhookDeliveriesRequest{Domain: "testdom", Status: "unknown"}, opt)
+			return err
+		}, codes.InvalidArgument, ErrorInvalidRequest},
+	}
+	for i, test := range tests {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(test.Metadata...))
+		var header metadata.MD
+		err := test.Call(ctx, grpc.Header(&header))
+		assert.Equal(t, test.Code, status.Code(err), "Status code not as expected for test %d: %v", i, err)
+		e := grpcErrorInfo(err)
+		if e == nil {
+			t.Errorf("No ErrorInfo detail for test %d", i)
+			continue
+		}
+		assert.Equal(t, ErrorDomain, e.Domain, "Error domain not as expected for test %d", i)
+		assert.Equal(t, test.Reason, e.Reason, "Error reason not as expected for test %d", i)
+		assert.NotEmpty(t, e.Metadata["request_id"], "No request ID for test %d", i)
+		assert.Equal(t, header.Get("x-request-id"), []string{e.Metadata["request_id"]}, "Request ID not as expected for test %d", i)
+	}
+
+	//The client's request ID is used if it provides one
+	var header metadata.MD
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("x-request-id", "grpc-test"))
+	_, err := client.Enrol(ctx, &mfaserverpb.EnrolRequest{}, grpc.Header(&header))
+	assert.Equal(t, []string{"grpc-test"}, header.Get("x-request-id"), "Client request ID not returned")
+	assert.Equal(t, "grpc-test", grpcErrorInfo(err).Metadata["request_id"], "Client request ID not in the error")
+}
+
+func TestGRPCHealth(t *testing.T) {
+	//A Vault that is reachable but sealed
+	v := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"initialized": true, "sealed": true, "standby": true}`))
+	}))
+	defer v.Close()
+	ll, _ := net.Listen("tcp", "127.0.0.1:0")
+	ldapAddr := ll.Addr().String()
+	ll.Close()
+
+	c := config.NewConfig()
+	c.WithVaultEndPoint(v.URL)
+	c.Vault.VaultConfig.MaxRetries = 0
+	c.WithLDAPConnection("ldap://"+ldapAddr, "", "{username}")
+	c.MFAServer.Loggers.Warning = log.New(ioutil.Discard, "", 0)
+	conn, stop := grpcTestServer(t, c)
+	defer stop()
+	client := healthpb.NewHealthClient(conn)
+
+	for _, s := range []string{"", "mfaserver.v1.MFAServer"} {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: s})
+		if err != nil {
+			t.Fatalf("Error checking the health of %q: %v", s, err)
+		}
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status, "Serving status of %q not as expected", s)
+	}
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "Unknown service not reported as not found")
+
+	w, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+	if err != nil {
+		t.Fatalf("Error watching the health of an unknown service: %v", err)
+	}
+	resp, err := w.Recv()
+	if err != nil {
+		t.Fatalf("Error receiving the health of an unknown service: %v", err)
+	}
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVICE_UNKNOWN, resp.Status, "Unknown service not reported as unknown")
+}
+
+func TestGRPCServer(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	//Set up the MFA config
+	c := config.NewConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	attr := "memberUid"
+	m := "{username}"
+	c.LDAP.AdminMembershipAttr = &attr
+	c.LDAP.AdminMemberUserDN = &m
+	c.WithAdminRole(config.RoleHelpdesk, []string{"cn=helpdesk,ou=groups,dc=example,dc=com"}, nil, nil)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+	conn, stop := grpcTestServer(t, c)
+	defer stop()
+	client := mfaserverpb.NewMFAServerClient(conn)
+	ctx := context.Background()
+
+	enrol := &mfaserverpb.EnrolRequest{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "validpassword", QrCode: true}
+	secret, err := client.Enrol(ctx, enrol)
+	if err != nil {
+		t.Fatalf("Error enroling: %v", err)
+	}
+	assert.NotEmpty(t, secret.Secret, "No secret returned")
+	if _, err := png.Decode(bytes.NewReader(secret.QrCodePng)); err != nil {
+		t.Errorf("QR code not a PNG: %v", err)
+	}
+	_, err = client.Enrol(ctx, enrol)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err), "Enroling again not rejected as expected")
+	assert.Equal(t, ErrorAlreadyEnrolled, grpcErrorInfo(err).Reason, "Error reason not as expected")
+
+	otp, _, _ := gootp.GetTOTPNow(secret.Secret, sha1.New, 6)
+	_, err = client.Validate(ctx, &mfaserverpb.ValidateRequest{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "validpassword", Otp: otp})
+	assert.NoError(t, err, "Valid OTP not accepted")
+	_, err = client.Validate(ctx, &mfaserverpb.ValidateRequest{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "invalidpassword", Otp: otp})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "Invalid password not rejected as expected")
+
+	updated, err := client.Update(ctx, &mfaserverpb.UpdateRequest{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "validpassword", Otp: otp})
+	if err != nil {
+		t.Fatalf("Error updating: %v", err)
+	}
+	assert.NotEqual(t, secret.Secret, updated.Secret, "Secret not replaced")
+	assert.Empty(t, updated.QrCodePng, "QR code returned when not requested")
+
+	admin := metadata.AppendToOutgoingContext(ctx, "authorization", basicAuthMetadata("validuser", "validpassword"))
+	users, err := client.ListUsers(admin, &mfaserverpb.ListUsersRequest{Issuer: "testapp", Domain: "testdom"})
+	if err != nil {
+		t.Fatalf("Error listing users: %v", err)
+	}
+	assert.Equal(t, []string{"validuser"}, users.Usernames, "Users listed not as expected")
+	_, err = client.ListUsers(ctx, &mfaserverpb.ListUsersRequest{Issuer: "testapp", Domain: "testdom"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "Listing without administrator credentials not rejected")
+
+	_, err = client.Delete(admin, &mfaserverpb.DeleteRequest{Issuer: "testapp", Domain: "testdom", Username: "validuser"})
+	assert.NoError(t, err, "Administrator could not delete the user's secret")
+	_, err = client.Validate(ctx, &mfaserverpb.ValidateRequest{Issuer: "testapp", Domain: "testdom", Username: "validuser", Password: "validpassword", Otp: otp})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "Deleted user not rejected")
+}
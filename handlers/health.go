@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/ldap"
+	"github.com/jcmturner/mfaserver/secrets"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long the results of the readiness dependency checks are reused before the dependencies are checked again
+const readinessCacheTTL = 5 * time.Second
+
+// How long a dependency check can take before the dependency is considered unavailable
+const dependencyCheckTimeout = 10 * time.Second
+
+// Status values of the health endpoints
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusReady    = "ready"
+	StatusNotReady = "not ready"
+	StatusDraining = "draining"
+)
+
+type healthResponseData struct {
+	Status  string                     `json:"status"`
+	Checks  map[string]dependencyCheck `json:"checks,omitempty"`
+	Checked string                     `json:"checked,omitempty"`
+}
+
+// dependencyCheck is the result of checking a dependency. The error is logged but not returned as the readiness
+// endpoints do not require authentication and the error can describe the LDAP and Vault servers.
+type dependencyCheck struct {
+	Status   string `json:"status"`
+	Error    string `json:"-"`
+	Duration string `json:"duration"`
+}
+
+// draining is set once the MFA server has started shutting down
+var draining int32
+
+var readiness = struct {
+	sync.Mutex
+	c       *config.Config
+	checked time.Time
+	checks  map[string]dependencyCheck
+}{}
+
+// Drain marks the MFA server as shutting down so that /readyz reports it is not ready.
+func Drain() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+// Healthz reports that the MFA server process is alive and serving requests.
+func Healthz(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	setNoCacheHeaders(w)
+	writeHealth(w, c, r, http.StatusOK, healthResponseData{Status: StatusOK})
+}
+
+// Readyz reports whether the MFA server can serve requests: the Vault must be reachable and unsealed with a valid token
+// and each LDAP directory must accept a connection. The dependency checks are cached briefly so that frequent probes
+// do not load the dependencies.
+func Readyz(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	setNoCacheHeaders(w)
+	if atomic.LoadInt32(&draining) == 1 {
+		writeHealth(w, c, r, http.StatusServiceUnavailable, healthResponseData{Status: StatusDraining})
+		return
+	}
+	checks, checked := dependencyChecks(c)
+	d := healthResponseData{Status: StatusReady, Checks: checks, Checked: checked.UTC().Format(time.RFC3339)}
+	code := http.StatusOK
+	for n, chk := range checks {
+		if chk.Status != StatusOK {
+			c.MFAServer.Loggers.Warning.Printf("%s, Readiness check of %s failed: %s", r.RemoteAddr, n, chk.Error)
+			d.Status = StatusNotReady
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeHealth(w, c, r, code, d)
+}
+
+// dependencyChecks returns the results of checking the dependencies, checking them again if the cached results have
+// expired or the configuration has been reloaded.
+func dependencyChecks(c *config.Config) (map[string]dependencyCheck, time.Time) {
+	readiness.Lock()
+	defer readiness.Unlock()
+	if readiness.c == c.Loaded() && time.Since(readiness.checked) < readinessCacheTTL {
+		return readiness.checks, readiness.checked
+	}
+	checks := map[string]func() error{
+		"vault": func() error { return secrets.Health(c) },
+	}
+	if len(c.LDAPDomains) == 0 {
+		checks["ldap"] = func() error { return ldap.CheckConnection(&c.LDAP) }
+	}
+	for d, l := range c.LDAPDomains {
+		l := l
+		checks["ldap:"+d] = func() error { return ldap.CheckConnection(l) }
+	}
+
+	//Check the dependencies concurrently so a slow dependency does not delay the others
+	results := make(map[string]dependencyCheck)
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	for n, f := range checks {
+		wg.Add(1)
+		go func(n string, f func() error) {
+			defer wg.Done()
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() { done <- f() }()
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(dependencyCheckTimeout):
+				err = errors.New("Check did not complete within " + dependencyCheckTimeout.String())
+			}
+			chk := dependencyCheck{Status: StatusOK}
+			if err != nil {
+				chk.Status = StatusFail
+				chk.Error = err.Error()
+			}
+			chk.Duration = time.Since(start).String()
+			mux.Lock()
+			results[n] = chk
+			mux.Unlock()
+		}(n, f)
+	}
+	wg.Wait()
+	readiness.c = c.Loaded()
+	readiness.checked = time.Now()
+	readiness.checks = results
+	return results, readiness.checked
+}
+
+func writeHealth(w http.ResponseWriter, c *config.Config, r *http.Request, code int, d healthResponseData) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Health check failed whilst returning body data: %v", r.RemoteAddr, err)
+	}
+}
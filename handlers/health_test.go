@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func readyzResponse(t *testing.T, c *config.Config) (int, healthResponseData) {
+	w := httptest.NewRecorder()
+	Readyz(w, httptest.NewRequest("GET", "/readyz", nil), c)
+	var d healthResponseData
+	if err := json.NewDecoder(w.Body).Decode(&d); err != nil {
+		t.Fatalf("Could not decode readiness response: %v", err)
+	}
+	return w.Code, d
+}
+
+func TestHealthz(t *testing.T) {
+	c := config.NewConfig()
+	w := httptest.NewRecorder()
+	Healthz(w, httptest.NewRequest("GET", "/healthz", nil), c)
+	assert.Equal(t, http.StatusOK, w.Code, "Status code not as expected")
+	assert.Equal(t, "application/json; charset=UTF-8", w.Header().Get("Content-Type"), "Content type not as expected")
+	assert.JSONEq(t, `{"status": "ok"}`, w.Body.String(), "Liveness response not as expected")
+}
+
+func TestReadyz(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	c := config.NewConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	code, d := readyzResponse(t, c)
+	assert.Equal(t, http.StatusOK, code, "Status code not as expected: %+v", d)
+	assert.Equal(t, StatusReady, d.Status, "Readiness status not as expected")
+	assert.Equal(t, StatusOK, d.Checks["vault"].Status, "Vault check not as expected")
+	assert.Equal(t, StatusOK, d.Checks["ldap"].Status, "LDAP check not as expected")
+}
+
+func TestReadyzNotReady(t *testing.T) {
+	//A Vault that is reachable but sealed
+	v := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"initialized": true, "sealed": true, "standby": true}`))
+	}))
+	defer v.Close()
+	//A port with nothing listening for LDAP
+	ll, _ := net.Listen("tcp", "127.0.0.1:0")
+	ldapAddr := ll.Addr().String()
+	ll.Close()
+	//An LDAP directory that accepts connections
+	ol, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer ol.Close()
+
+	c := config.NewConfig()
+	c.WithVaultEndPoint(v.URL)
+	c.Vault.VaultConfig.MaxRetries = 0
+	c.WithLDAPConnection("ldap://"+ldapAddr, "", "{username}")
+	c.MFAServer.Loggers.Warning = log.New(ioutil.Discard, "", 0)
+
+	code, d := readyzResponse(t, c)
+	assert.Equal(t, http.StatusServiceUnavailable, code, "Status code not as expected: %+v", d)
+	assert.Equal(t, StatusNotReady, d.Status, "Readiness status not as expected")
+	assert.Equal(t, StatusFail, d.Checks["vault"].Status, "Sealed Vault should fail the check")
+	assert.Equal(t, StatusFail, d.Checks["ldap"].Status, "LDAP that does not accept connections should fail the check")
+	//The reason a check failed is logged but not returned to unauthenticated callers
+	w := httptest.NewRecorder()
+	Readyz(w, httptest.NewRequest("GET", "/readyz", nil), c)
+	assert.NotContains(t, w.Body.String(), "error", "Readiness response should not include the errors of the checks")
+	assert.NotContains(t, w.Body.String(), ldapAddr, "Readiness response should not include the LDAP address")
+
+	//Results are cached for the same configuration
+	_, cached := readyzResponse(t, c)
+	assert.Equal(t, d.Checked, cached.Checked, "Cached results should have been returned")
+
+	//Each directory is checked when there are directories per domain
+	c.WithLDAPDomain("up", "ldap://"+ol.Addr().String(), "", "{username}")
+	c.WithLDAPDomain("down", "ldap://"+ldapAddr, "", "{username}")
+	//A reloaded configuration is checked again
+	c2 := *c
+	_, d = readyzResponse(t, &c2)
+	assert.Equal(t, StatusOK, d.Checks["ldap:up"].Status, "LDAP check for the up domain not as expected: %+v", d.Checks["ldap:up"])
+	assert.Equal(t, StatusFail, d.Checks["ldap:down"].Status, "LDAP check for the down domain not as expected")
+
+	//Not ready once draining
+	Drain()
+	defer atomic.StoreInt32(&draining, 0)
+	code, d = readyzResponse(t, c)
+	assert.Equal(t, http.StatusServiceUnavailable, code, "Status code not as expected when draining")
+	assert.Equal(t, StatusDraining, d.Status, "Readiness status not as expected when draining")
+}
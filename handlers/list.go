@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/metrics"
+	"github.com/jcmturner/mfaserver/secrets"
+	"io"
+	"net/http"
+)
+
+type listRequestData struct {
+	Issuer string `json:"issuer"`
+	Domain string `json:"domain"`
+}
+
+type listResponseData struct {
+	Usernames []string `json:"usernames"`
+}
+
+func ListUsers(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	app, ok := checkApplication(w, r, c)
+	if !ok {
+		return
+	}
+	data, err, HTTPCode := processListRequestData(r)
+	setNoCacheHeaders(w)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Println(err.Error())
+		writeError(w, c, HTTPCode, ErrorInvalidRequest, requestErrorMessage(r, err))
+		return
+	}
+	u, aerr := listUsers(c, r, app, &data)
+	if aerr != nil {
+		writeAPIError(w, c, aerr)
+		return
+	}
+	d := listResponseData{Usernames: u}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Listing for %s:%s failed whilst returning body data: %v", r.RemoteAddr, data.Issuer, data.Domain, err)
+	}
+}
+
+// listUsers returns the users enroled with the issuer in the domain if the request was made by an administrator
+// permitted to list them.
+func listUsers(c *config.Config, r *http.Request, app *application, data *listRequestData) ([]string, *apiError) {
+	c.SetRequestUser(data.Issuer, data.Domain, "")
+	if !applicationPermitted(c, r, app, data.Issuer) {
+		return nil, &apiError{http.StatusForbidden, ErrorForbidden, "The application is not permitted to use the issuer"}
+	}
+	c.MFAServer.Loggers.Info.Printf("%s, Listing request received for %s:%s", r.RemoteAddr, data.Issuer, data.Domain)
+	if !checkAdminAuth(c, r, config.PermissionList, data.Issuer, data.Domain) {
+		return nil, &apiError{http.StatusUnauthorized, ErrorUnauthorized, "The administrator is not authorised to list the users"}
+	}
+
+	u, err := secrets.List(c, "/"+data.Issuer+"/"+data.Domain)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Failed to list enroled users for %s:%s: %v", r.RemoteAddr, data.Issuer, data.Domain, err)
+		metrics.SetOutcome(r, metrics.OutcomeStoreError)
+		return nil, &apiError{http.StatusInternalServerError, ErrorInternal, "The enroled users could not be listed"}
+	}
+	return u, nil
+}
+
+func processListRequestData(r *http.Request) (listRequestData, error, int) {
+	var data listRequestData
+	defer r.Body.Close()
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1024))
+	err := dec.Decode(&data)
+	if err != nil {
+		return data, errors.New(fmt.Sprintf("%s, Could not parse data posted from client to the list api : %v", r.RemoteAddr, err)), http.StatusBadRequest
+	}
+	if err := checkListRequestData(r, &data); err != nil {
+		return data, err, http.StatusBadRequest
+	}
+	return data, nil, 0
+}
+
+// checkListRequestData checks that the list request identifies the issuer and domain.
+func checkListRequestData(r *http.Request, data *listRequestData) error {
+	if data.Domain == "" || data.Issuer == "" {
+		return errors.New(fmt.Sprintf("%s, Could not extract values correctly from the list request.", r.RemoteAddr))
+	}
+	return nil
+}
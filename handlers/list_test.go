@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestListUsers(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	//Set up the MFA config
+	c := config.NewConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	attr := "memberUid"
+	m := "{username}"
+	c.LDAP.AdminMembershipAttr = &attr
+	c.LDAP.AdminMemberUserDN = &m
+	c.WithAdminRole(config.RoleAuditor, []string{"cn=auditors,ou=groups,dc=example,dc=com"}, []string{"testapp"}, nil)
+	c.WithAdminRole(config.RoleHelpdesk, []string{"cn=helpdesk,ou=groups,dc=example,dc=com"}, nil, nil)
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ListUsers(w, r, c) }))
+	defer s.Close()
+
+	for _, u := range []string{"validuser", "otheruser"} {
+		udata := enrolRequestData{Username: u,
+			Domain: "testdom",
+			Issuer: "testapp"}
+		createAndStoreSecret(c, &udata)
+	}
+
+	var tests = []struct {
+		AdminUser     string
+		AdminPassword string
+		Json          string
+		HttpCode      int
+	}{
+		{"validuser", "validpassword", `{"domain": "testdom", "issuer": "testapp"}`, http.StatusOK},
+		{"validuser", "invalidpassword", `{"domain": "testdom", "issuer": "testapp"}`, http.StatusUnauthorized},
+		{"validuser", "validpassword", `{"domain": "testdom", "issuer": "otherapp"}`, http.StatusUnauthorized},
+		{"", "", `{"domain": "testdom", "issuer": "testapp"}`, http.StatusUnauthorized},
+		{"validuser", "validpassword", `{"domain": "testdom"}`, http.StatusBadRequest},
+		{"validuser", "validpassword", `"domain": "testdom", "issuer": "testapp"}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("POST", s.URL+"/list", bytes.NewBuffer([]byte(test.Json)))
+		if err != nil {
+			t.Errorf("Error returned from creating request: %v", err)
+		}
+		if test.AdminUser != "" {
+			r.SetBasicAuth(test.AdminUser, test.AdminPassword)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("Error returned from sending request: %v", err)
+		}
+		if resp.StatusCode != test.HttpCode {
+			t.Errorf("Expected code %v, got %v for post data %v", test.HttpCode, resp.StatusCode, test.Json)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var j listResponseData
+			err = json.NewDecoder(resp.Body).Decode(&j)
+			if err != nil {
+				t.Errorf("Failed to marshal the response into the JSON object: %v", err)
+			}
+			assert.ElementsMatch(t, []string{"validuser", "otheruser"}, j.Usernames, "Usernames listed not as expected")
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"github.com/jcmturner/goidentity/v6"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/mfaserver/config"
+	"net/http"
+	"strings"
+)
+
+// Negotiate validates an "Authorization: Negotiate" SPNEGO token against the configured Kerberos keytab before calling the handler.
+// The authenticated principal is then used as the first factor in place of a username and password.
+// Requests without a Negotiate token, or when no keytab is configured, are passed to the handler unchanged.
+func Negotiate(c *config.Config, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.Kerberos.Keytab == nil || !strings.HasPrefix(r.Header.Get(spnego.HTTPHeaderAuthRequest), spnego.HTTPHeaderAuthResponseValueKey+" ") {
+			h(w, r)
+			return
+		}
+		settings := []func(*service.Settings){service.Logger(c.MFAServer.Loggers.Debug)}
+		if c.Kerberos.ServicePrincipal != nil {
+			settings = append(settings, service.KeytabPrincipal(*c.Kerberos.ServicePrincipal))
+		}
+		spnego.SPNEGOKRB5Authenticate(h, c.Kerberos.Keytab, settings...).ServeHTTP(w, r)
+	}
+}
+
+// negotiatedIdentity returns the username and domain of the Kerberos principal authenticated for the request.
+func negotiatedIdentity(c *config.Config, r *http.Request) (string, string, bool) {
+	id := goidentity.FromHTTPRequestContext(r)
+	if id == nil || !id.Authenticated() {
+		return "", "", false
+	}
+	d := id.Domain()
+	if m, ok := c.Kerberos.RealmDomains[d]; ok {
+		d = m
+	}
+	return id.UserName(), d, true
+}
+
+// passwordRequired reports whether the user must provide their password as the first factor.
+func passwordRequired(c *config.Config, r *http.Request, issuer string) bool {
+	if _, _, ok := negotiatedIdentity(c, r); ok {
+		return false
+	}
+	return c.PasswordRequired(issuer)
+}
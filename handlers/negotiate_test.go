@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/jcmturner/gootp"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/secrets"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	testRealm = "TEST.GOKRB5"
+	testSPN   = "HTTP/mfa.test.gokrb5"
+)
+
+// generateSPNEGOToken creates an SPNEGO token for the user with a service ticket encrypted with the keytab, so no KDC is needed.
+func generateSPNEGOToken(t *testing.T, kt *keytab.Keytab, username string) string {
+	now := time.Now().UTC()
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, username)
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, testSPN)
+	tkt, key, err := messages.NewTicket(cname, testRealm, sname, testRealm, types.NewKrbFlags(), kt, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Error creating service ticket: %v", err)
+	}
+	cl := client.NewWithPassword(username, testRealm, "notused", krb5config.New())
+	nt, err := spnego.NewNegTokenInitKRB5(cl, tkt, key)
+	if err != nil {
+		t.Fatalf("Error creating SPNEGO negotiation token: %v", err)
+	}
+	st := spnego.SPNEGOToken{Init: true, NegTokenInit: nt}
+	b, err := st.Marshal()
+	if err != nil {
+		t.Fatalf("Error marshalling SPNEGO token: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// negotiateHandler serves the handler behind Negotiate in the same way as the MFA server's endpoints.
+func negotiateHandler(c *config.Config, f HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Negotiate(c, func(w http.ResponseWriter, r *http.Request) {
+			f(w, r, c)
+		})(w, r)
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	kt := keytab.New()
+	err := kt.AddEntry(testSPN, testRealm, "servicepassword", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	if err != nil {
+		t.Fatalf("Error generating keytab: %v", err)
+	}
+	otherkt := keytab.New()
+	otherkt.AddEntry(testSPN, testRealm, "otherpassword", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	c := config.NewConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	c.WithKerberosKeytab(kt).WithKerberosServicePrincipal(testSPN).WithKerberosRealmDomain(testRealm, "testdom")
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	es := httptest.NewServer(negotiateHandler(c, Enrol))
+	defer es.Close()
+	vs := httptest.NewServer(negotiateHandler(c, ValidateOTP))
+	defer vs.Close()
+
+	//No password is provided as the principal is the first factor and is used in place of the username and domain
+	resp := negotiateRequest(t, es.URL, "Negotiate "+generateSPNEGOToken(t, kt, "validuser"), `{"domain": "otherdom", "username": "otheruser", "issuer": "testapp"}`)
+	if !assert.Equal(t, http.StatusCreated, resp.StatusCode, "Enrolment with Kerberos should succeed") {
+		t.FailNow()
+	}
+	var j enrolResponseData
+	err = json.NewDecoder(resp.Body).Decode(&j)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Error decoding enrolment response: %v", err)
+	}
+	m, err := secrets.Read(c, "/testapp/testdom/validuser")
+	if err != nil || m == nil {
+		t.Fatalf("Secret not stored for the Kerberos principal: %v", err)
+	}
+	assert.Equal(t, j.Secret, m["mfa"], "Secret stored not the one returned")
+	m, _ = secrets.Read(c, "/testapp/otherdom/otheruser")
+	assert.Nil(t, m, "Secret should not be stored for the username in the request")
+
+	var tests = []struct {
+		Description   string
+		URL           string
+		Authorization string
+		Json          string
+		HttpCode      int
+	}{
+		{"Validate with principal", vs.URL, "Negotiate " + generateSPNEGOToken(t, kt, "validuser"), `{"issuer": "testapp", "otp": "%s"}`, http.StatusNoContent},
+		{"Principal used in place of username", vs.URL, "Negotiate " + generateSPNEGOToken(t, kt, "validuser"), `{"domain": "otherdom", "username": "otheruser", "issuer": "testapp", "otp": "%s"}`, http.StatusNoContent},
+		{"Invalid OTP", vs.URL, "Negotiate " + generateSPNEGOToken(t, kt, "validuser"), `{"issuer": "testapp", "otp": "1234567"}`, http.StatusUnauthorized},
+		{"Principal not enrolled", vs.URL, "Negotiate " + generateSPNEGOToken(t, kt, "otheruser"), `{"issuer": "testapp", "otp": "%s"}`, http.StatusUnauthorized},
+		{"Ticket not for keytab", vs.URL, "Negotiate " + generateSPNEGOToken(t, otherkt, "validuser"), `{"issuer": "testapp", "otp": "%s"}`, http.StatusUnauthorized},
+		{"Token not base64", vs.URL, "Negotiate notbase64!", `{"issuer": "testapp", "otp": "%s"}`, http.StatusUnauthorized},
+		{"Password required without Kerberos", vs.URL, "", `{"domain": "testdom", "username": "validuser", "issuer": "testapp", "otp": "%s"}`, http.StatusBadRequest},
+		{"Enrol password required without Kerberos", es.URL, "", `{"domain": "testdom", "username": "otheruser", "issuer": "testapp"}`, http.StatusBadRequest},
+		{"Already enrolled", es.URL, "Negotiate " + generateSPNEGOToken(t, kt, "validuser"), `{"issuer": "testapp"}`, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		otp, _, _ := gootp.GetTOTPNow(j.Secret, sha1.New, 6)
+		resp := negotiateRequest(t, test.URL, test.Authorization, fmt.Sprintf(test.Json, otp))
+		resp.Body.Close()
+		assert.Equal(t, test.HttpCode, resp.StatusCode, "Status code not as expected: %s", test.Description)
+	}
+}
+
+func negotiateRequest(t *testing.T, url, authorization, data string) *http.Response {
+	r, _ := http.NewRequest("POST", url, bytes.NewBufferString(data))
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Error returned from sending request: %v", err)
+	}
+	return resp
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/assertion"
+	"github.com/jcmturner/mfaserver/config"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Paths of the OpenID Connect provider endpoints
+const (
+	OIDCDiscoveryPath     = "/.well-known/openid-configuration"
+	OIDCAuthorizationPath = "/authorize"
+	OIDCTokenPath         = "/token"
+	JWKSPath              = "/.well-known/jwks.json"
+)
+
+// The only PKCE code challenge method accepted
+const pkceMethodS256 = "S256"
+
+type oidcDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+}
+
+type oidcErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// oidcAuthorization is the result of a successful authentication held until the client exchanges the authorization code.
+type oidcAuthorization struct {
+	ClientID      string
+	RedirectURI   string
+	Nonce         string
+	CodeChallenge string
+	Issuer        string
+	Domain        string
+	Username      string
+	AuthTime      int64
+	AMR           []string
+}
+
+// OIDCDiscovery returns the OpenID Connect provider metadata.
+func OIDCDiscovery(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	if !c.OIDCEnabled() {
+		setNoCacheHeaders(w)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	iss := strings.TrimSuffix(c.AssertionIssuer(), "/")
+	algs := []string{}
+	for _, k := range c.Assertions.Keys {
+		if !config.StringInSlice(k.Alg, algs) {
+			algs = append(algs, k.Alg)
+		}
+	}
+	d := oidcDiscovery{
+		Issuer:                            c.AssertionIssuer(),
+		AuthorizationEndpoint:             iss + OIDCAuthorizationPath,
+		TokenEndpoint:                     iss + OIDCTokenPath,
+		JWKSURI:                           iss + JWKSPath,
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algs,
+		ScopesSupported:                   []string{"openid"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{pkceMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "amr", "domain"},
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, OIDC discovery request failed whilst returning body data: %v", r.RemoteAddr, err)
+	}
+}
+
+// Authorize is the OpenID Connect authorization endpoint. It presents a login form and, once the user's password and OTP
+// have been validated, redirects back to the relying party with an authorization code.
+func Authorize(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	setNoCacheHeaders(w)
+	if !c.OIDCEnabled() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == "POST" {
+		//Limit the form data that will be read
+		r.Body = http.MaxBytesReader(w, r.Body, 4096)
+		if err := r.ParseForm(); err != nil {
+			c.MFAServer.Loggers.Error.Printf("%s, Could not parse authorization form data: %v", r.RemoteAddr, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	clientID := r.FormValue("client_id")
+	redirectURI := r.FormValue("redirect_uri")
+	state := r.FormValue("state")
+	//An invalid client or redirect URI must not be redirected to
+	oc, ok := c.OIDCClient(clientID)
+	if !ok || !oc.RedirectURIPermitted(redirectURI) {
+		c.MFAServer.Loggers.Error.Printf("%s, OIDC authorization request with an unknown client %s or redirect URI %s", r.RemoteAddr, clientID, redirectURI)
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("The client or redirect URI is not registered.\n"))
+		return
+	}
+	if r.FormValue("response_type") != "code" {
+		oidcRedirectError(w, r, redirectURI, state, "unsupported_response_type")
+		return
+	}
+	if !config.StringInSlice("openid", strings.Fields(r.FormValue("scope"))) {
+		oidcRedirectError(w, r, redirectURI, state, "invalid_scope")
+		return
+	}
+	challenge := r.FormValue("code_challenge")
+	if (challenge == "" && oc.Secret == "") || (challenge != "" && r.FormValue("code_challenge_method") != pkceMethodS256) {
+		oidcRedirectError(w, r, redirectURI, state, "invalid_request")
+		return
+	}
+
+	p := loginPageData{
+		Action: OIDCAuthorizationPath,
+		Hidden: map[string]string{
+			"client_id":             clientID,
+			"redirect_uri":          redirectURI,
+			"response_type":         "code",
+			"scope":                 r.FormValue("scope"),
+			"state":                 state,
+			"nonce":                 r.FormValue("nonce"),
+			"code_challenge":        challenge,
+			"code_challenge_method": r.FormValue("code_challenge_method"),
+		},
+	}
+	if c.OIDC.Domain != nil {
+		p.Domain = *c.OIDC.Domain
+	}
+	if r.Method != "POST" {
+		renderLogin(w, http.StatusOK, p)
+		return
+	}
+
+	if d := r.PostFormValue("domain"); d != "" {
+		p.Domain = d
+	}
+	p.Username = r.PostFormValue("username")
+	data := validateRequestData{
+		Issuer:   oc.Issuer,
+		Domain:   p.Domain,
+		Username: p.Username,
+		Password: r.PostFormValue("password"),
+		OTP:      r.PostFormValue("otp"),
+	}
+	c.SetRequestUser(data.Issuer, data.Domain, data.Username)
+	if data.Domain == "" || data.Username == "" || !hasUserCredentials(c, r, &data) {
+		p.Message = "Please provide all of the values requested."
+		renderLogin(w, http.StatusBadRequest, p)
+		return
+	}
+	c.MFAServer.Loggers.Info.Printf("%s, OIDC authorization request received from client %s for %s/%s", r.RemoteAddr, clientID, data.Domain, data.Username)
+	if ok, _ := twoFactorAuthenticate(c, r, nil, &data); !ok {
+		p.Message = "Sign in failed."
+		renderLogin(w, http.StatusUnauthorized, p)
+		return
+	}
+
+	code, err := c.OIDC.Codes.Put(oidcAuthorization{
+		ClientID:      clientID,
+		RedirectURI:   redirectURI,
+		Nonce:         r.PostFormValue("nonce"),
+		CodeChallenge: challenge,
+		Issuer:        data.Issuer,
+		Domain:        data.Domain,
+		Username:      data.Username,
+		AuthTime:      time.Now().UTC().Unix(),
+		AMR:           authenticationMethods(c, r, data.Issuer),
+	})
+	if err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Could not create authorization code for %s/%s: %v", r.RemoteAddr, data.Domain, data.Username, err)
+		oidcRedirectError(w, r, redirectURI, state, "server_error")
+		return
+	}
+	q := url.Values{"code": {code}}
+	if state != "" {
+		q.Set("state", state)
+	}
+	http.Redirect(w, r, appendQuery(redirectURI, q), http.StatusSeeOther)
+}
+
+// Token is the OpenID Connect token endpoint that exchanges an authorization code for an ID token.
+func Token(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	setNoCacheHeaders(w)
+	if !c.OIDCEnabled() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		oidcTokenError(w, http.StatusMethodNotAllowed, "invalid_request", "The token endpoint only accepts POST requests")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if err := r.ParseForm(); err != nil {
+		oidcTokenError(w, http.StatusBadRequest, "invalid_request", "The request could not be parsed")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		oidcTokenError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	//Authenticate the client with basic authentication, form values or, for public clients, just the client ID
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	oc, ok := c.OIDCClient(clientID)
+	if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(oc.Secret)) != 1 {
+		c.MFAServer.Loggers.Info.Printf("%s, OIDC token request failed to authenticate client %s", r.RemoteAddr, clientID)
+		oidcTokenError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	v, ok := c.OIDC.Codes.Take(r.PostFormValue("code"))
+	a, _ := v.(oidcAuthorization)
+	if !ok || a.ClientID != clientID || a.RedirectURI != r.PostFormValue("redirect_uri") {
+		c.MFAServer.Loggers.Info.Printf("%s, OIDC token request from client %s with an invalid authorization code", r.RemoteAddr, clientID)
+		oidcTokenError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+	if a.CodeChallenge != "" && !pkceVerified(a.CodeChallenge, r.PostFormValue("code_verifier")) {
+		c.MFAServer.Loggers.Info.Printf("%s, OIDC token request from client %s failed PKCE verification", r.RemoteAddr, clientID)
+		oidcTokenError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	lifetime := c.AssertionLifetime()
+	idc := assertion.NewClaims(c.AssertionIssuer(), clientID, a.Domain, a.Username, a.AMR, lifetime)
+	idc.AuthTime = a.AuthTime
+	idc.Nonce = a.Nonce
+	idt, err := assertion.Sign(c.AssertionSigningKey(), idc)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Could not sign ID token for %s/%s: %v", r.RemoteAddr, a.Domain, a.Username, err)
+		oidcTokenError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	//The access token is the same assertion issued by /validate for the MFA issuer
+	ac := assertion.NewClaims(c.AssertionIssuer(), a.Issuer, a.Domain, a.Username, a.AMR, lifetime)
+	ac.AuthTime = a.AuthTime
+	at, err := assertion.Sign(c.AssertionSigningKey(), ac)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Could not sign access token for %s/%s: %v", r.RemoteAddr, a.Domain, a.Username, err)
+		oidcTokenError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	c.MFAServer.Loggers.Info.Printf("%s, OIDC ID token %s issued to client %s for %s/%s", r.RemoteAddr, idc.ID, clientID, a.Domain, a.Username)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(oidcTokenResponse{
+		AccessToken: at,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(lifetime.Seconds()),
+		IDToken:     idt,
+	})
+}
+
+// pkceVerified checks the code verifier against the S256 code challenge (RFC 7636).
+func pkceVerified(challenge, verifier string) bool {
+	if verifier == "" {
+		return false
+	}
+	h := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(h[:])), []byte(challenge)) == 1
+}
+
+func oidcRedirectError(w http.ResponseWriter, r *http.Request, redirectURI, state, e string) {
+	q := url.Values{"error": {e}}
+	if state != "" {
+		q.Set("state", state)
+	}
+	http.Redirect(w, r, appendQuery(redirectURI, q), http.StatusSeeOther)
+}
+
+func oidcTokenError(w http.ResponseWriter, code int, e, desc string) {
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(oidcErrorResponse{Error: e, Description: desc})
+}
+
+// appendQuery adds the values to any query the URI already has.
+func appendQuery(uri string, q url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	eq := u.Query()
+	for k, v := range q {
+		eq[k] = v
+	}
+	u.RawQuery = eq.Encode()
+	return u.String()
+}
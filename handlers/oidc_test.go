@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/jcmturner/gootp"
+	"github.com/jcmturner/mfaserver/assertion"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	testOIDCClient      = "testclient"
+	testOIDCSecret      = "testclientsecret"
+	testOIDCPublic      = "publicclient"
+	testOIDCRedirectURI = "https://rp.test/callback"
+)
+
+func oidcTestConfig(t *testing.T) *config.Config {
+	c := config.NewConfig()
+	p, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	k, _ := assertion.NewKey(p)
+	c.WithAssertionKey(k).WithAssertionIssuer("https://mfa.test", 0)
+	_, err := c.WithOIDCClient(testOIDCClient, testOIDCSecret, []string{testOIDCRedirectURI}, "testapp")
+	if err != nil {
+		t.Fatalf("Error registering OIDC client: %v", err)
+	}
+	_, err = c.WithOIDCClient(testOIDCPublic, "", []string{testOIDCRedirectURI}, "testapp")
+	if err != nil {
+		t.Fatalf("Error registering OIDC client: %v", err)
+	}
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+	return c
+}
+
+func oidcTestServer(c *config.Config) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(OIDCDiscoveryPath, func(w http.ResponseWriter, r *http.Request) { OIDCDiscovery(w, r, c) })
+	mux.HandleFunc(OIDCAuthorizationPath, func(w http.ResponseWriter, r *http.Request) { Authorize(w, r, c) })
+	mux.HandleFunc(OIDCTokenPath, func(w http.ResponseWriter, r *http.Request) { Token(w, r, c) })
+	mux.HandleFunc(JWKSPath, func(w http.ResponseWriter, r *http.Request) { JWKS(w, r, c) })
+	return httptest.NewServer(mux)
+}
+
+// Do not follow redirects to the relying party
+var noRedirectClient = &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+
+func TestOIDCDiscovery(t *testing.T) {
+	c := oidcTestConfig(t)
+	s := oidcTestServer(c)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + OIDCDiscoveryPath)
+	if err != nil {
+		t.Fatalf("Error returned from sending request: %v", err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Discovery status code not as expected")
+	var d oidcDiscovery
+	err = json.NewDecoder(resp.Body).Decode(&d)
+	assert.NoError(t, err, "Could not decode the discovery document")
+	assert.Equal(t, "https://mfa.test", d.Issuer, "Issuer not as expected")
+	assert.Equal(t, "https://mfa.test/authorize", d.AuthorizationEndpoint, "Authorization endpoint not as expected")
+	assert.Equal(t, "https://mfa.test/token", d.TokenEndpoint, "Token endpoint not as expected")
+	assert.Equal(t, "https://mfa.test/.well-known/jwks.json", d.JWKSURI, "JWKS URI not as expected")
+	assert.Equal(t, []string{assertion.AlgES256}, d.IDTokenSigningAlgValuesSupported, "Signing algorithms not as expected")
+}
+
+func TestAuthorizeRequestValidation(t *testing.T) {
+	c := oidcTestConfig(t)
+	s := oidcTestServer(c)
+	defer s.Close()
+
+	var tests = []struct {
+		Query    string
+		HttpCode int
+		Error    string
+	}{
+		{"client_id=testclient&redirect_uri=https://rp.test/callback&response_type=code&scope=openid&state=abc", http.StatusOK, ""},
+		{"client_id=unknown&redirect_uri=https://rp.test/callback&response_type=code&scope=openid", http.StatusBadRequest, ""},
+		{"client_id=testclient&redirect_uri=https://evil.test/callback&response_type=code&scope=openid", http.StatusBadRequest, ""},
+		{"client_id=testclient&redirect_uri=https://rp.test/callback&response_type=token&scope=openid&state=abc", http.StatusSeeOther, "unsupported_response_type"},
+		{"client_id=testclient&redirect_uri=https://rp.test/callback&response_type=code&scope=profile&state=abc", http.StatusSeeOther, "invalid_scope"},
+		//Public clients must use PKCE with S256
+		{"client_id=publicclient&redirect_uri=https://rp.test/callback&response_type=code&scope=openid&state=abc", http.StatusSeeOther, "invalid_request"},
+		{"client_id=publicclient&redirect_uri=https://rp.test/callback&response_type=code&scope=openid&state=abc&code_challenge=abc&code_challenge_method=plain", http.StatusSeeOther, "invalid_request"},
+		{"client_id=publicclient&redirect_uri=https://rp.test/callback&response_type=code&scope=openid&state=abc&code_challenge=abc&code_challenge_method=S256", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		resp, err := noRedirectClient.Get(s.URL + OIDCAuthorizationPath + "?" + test.Query)
+		if err != nil {
+			t.Fatalf("Error returned from sending request: %v", err)
+		}
+		assert.Equal(t, test.HttpCode, resp.StatusCode, "Status code not as expected for %s", test.Query)
+		if test.Error != "" {
+			l, _ := url.Parse(resp.Header.Get("Location"))
+			assert.Equal(t, "rp.test", l.Host, "Error should be redirected to the client")
+			assert.Equal(t, test.Error, l.Query().Get("error"), "Error not as expected for %s", test.Query)
+			assert.Equal(t, "abc", l.Query().Get("state"), "State should be returned with the error")
+		}
+	}
+}
+
+func TestTokenRequestValidation(t *testing.T) {
+	c := oidcTestConfig(t)
+	s := oidcTestServer(c)
+	defer s.Close()
+
+	code, _ := c.OIDC.Codes.Put(oidcAuthorization{ClientID: testOIDCClient, RedirectURI: testOIDCRedirectURI, Issuer: "testapp", Domain: "testdom", Username: "validuser"})
+	var tests = []struct {
+		ClientID string
+		Secret   string
+		Form     string
+		HttpCode int
+		Error    string
+	}{
+		{testOIDCClient, "wrongsecret", "grant_type=authorization_code&code=" + code + "&redirect_uri=https://rp.test/callback", http.StatusUnauthorized, "invalid_client"},
+		{testOIDCClient, testOIDCSecret, "grant_type=password", http.StatusBadRequest, "unsupported_grant_type"},
+		{testOIDCClient, testOIDCSecret, "grant_type=authorization_code&code=unknown&redirect_uri=https://rp.test/callback", http.StatusBadRequest, "invalid_grant"},
+		{testOIDCClient, testOIDCSecret, "grant_type=authorization_code&code=" + code + "&redirect_uri=https://rp.test/callback", http.StatusOK, ""},
+		//Codes can only be used once
+		{testOIDCClient, testOIDCSecret, "grant_type=authorization_code&code=" + code + "&redirect_uri=https://rp.test/callback", http.StatusBadRequest, "invalid_grant"},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", s.URL+OIDCTokenPath, strings.NewReader(test.Form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(test.ClientID, test.Secret)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Error returned from sending request: %v", err)
+		}
+		assert.Equal(t, test.HttpCode, resp.StatusCode, "Status code not as expected for %s", test.Form)
+		if test.Error != "" {
+			var e oidcErrorResponse
+			json.NewDecoder(resp.Body).Decode(&e)
+			assert.Equal(t, test.Error, e.Error, "Error not as expected for %s", test.Form)
+		}
+	}
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	c := oidcTestConfig(t)
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	s := oidcTestServer(c)
+	defer s.Close()
+
+	udata := enrolRequestData{Username: "validuser",
+		Domain:   "testdom",
+		Issuer:   "testapp",
+		Password: "validpassword"}
+	secret, _ := createAndStoreSecret(c, &udata)
+
+	verifier := "averylongcodeverifierforthepublicclienttest"
+	h := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(h[:])
+
+	var tests = []struct {
+		ClientID string
+		Password string
+		HttpCode int
+	}{
+		{testOIDCClient, "validpassword", http.StatusSeeOther},
+		{testOIDCPublic, "validpassword", http.StatusSeeOther},
+		{testOIDCClient, "invalidpassword", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		otp, _, _ := gootp.GetTOTPNow(secret, sha1.New, 6)
+		f := url.Values{
+			"client_id":     {test.ClientID},
+			"redirect_uri":  {testOIDCRedirectURI},
+			"response_type": {"code"},
+			"scope":         {"openid"},
+			"state":         {"xyz"},
+			"nonce":         {"n-0S6_WzA2Mj"},
+			"domain":        {"testdom"},
+			"username":      {"validuser"},
+			"password":      {test.Password},
+			"otp":           {otp},
+		}
+		if test.ClientID == testOIDCPublic {
+			f.Set("code_challenge", challenge)
+			f.Set("code_challenge_method", "S256")
+		}
+		resp, err := noRedirectClient.PostForm(s.URL+OIDCAuthorizationPath, f)
+		if err != nil {
+			t.Fatalf("Error returned from sending request: %v", err)
+		}
+		assert.Equal(t, test.HttpCode, resp.StatusCode, "Authorization status code not as expected for %s", test.ClientID)
+		if resp.StatusCode != http.StatusSeeOther {
+			continue
+		}
+		loc, _ := url.Parse(resp.Header.Get("Location"))
+		assert.Equal(t, "xyz", loc.Query().Get("state"), "State not returned to the client")
+		code := loc.Query().Get("code")
+
+		tf := url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {code},
+			"redirect_uri": {testOIDCRedirectURI},
+			"client_id":    {test.ClientID},
+		}
+		if test.ClientID == testOIDCPublic {
+			tf.Set("code_verifier", verifier)
+		} else {
+			tf.Set("client_secret", testOIDCSecret)
+		}
+		resp, err = http.PostForm(s.URL+OIDCTokenPath, tf)
+		if err != nil {
+			t.Fatalf("Error returned from sending request: %v", err)
+		}
+		if !assert.Equal(t, http.StatusOK, resp.StatusCode, "Token status code not as expected for %s", test.ClientID) {
+			continue
+		}
+		var tr oidcTokenResponse
+		json.NewDecoder(resp.Body).Decode(&tr)
+		var claims assertion.Claims
+		err = assertion.Verify(tr.IDToken, assertion.NewJSONWebKeySet(c.Assertions.Keys), &claims)
+		assert.NoError(t, err, "ID token could not be verified")
+		assert.Equal(t, test.ClientID, claims.Audience, "ID token audience not as expected")
+		assert.Equal(t, "validuser@testdom", claims.Subject, "ID token subject not as expected")
+		assert.Equal(t, "n-0S6_WzA2Mj", claims.Nonce, "ID token nonce not as expected")
+		assert.Equal(t, []string{"pwd", "otp"}, claims.AMR, "ID token authentication methods not as expected")
+	}
+}
@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/version"
+	"net/http"
+)
+
+// OpenAPIPath is the path the OpenAPI document describing the REST API is served at.
+const OpenAPIPath = "/openapi.json"
+
+// OpenAPI serves the OpenAPI document describing the REST API.
+func OpenAPI(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	b, err := openAPIDocument()
+	if err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, OpenAPI document could not be generated: %v", r.RemoteAddr, err)
+		writeError(w, c, http.StatusInternalServerError, ErrorInternal, "The OpenAPI document could not be generated")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// openAPIDocument returns the OpenAPI document with the version of the MFA server.
+func openAPIDocument() ([]byte, error) {
+	var d map[string]interface{}
+	if err := json.Unmarshal([]byte(openAPISpec), &d); err != nil {
+		return nil, err
+	}
+	d["info"].(map[string]interface{})["version"] = version.Version
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// openAPISpec describes the REST API. The request and response schemas must match the handlers' request and response
+// data, which is checked by the tests.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "MFA Server",
+    "description": "Enrol users for time based one time passwords (TOTP) and validate them, as a second factor to the user's password.\n\nIf applications are registered the calling application must authenticate with a client certificate, an API key or an HMAC signature. See Application Authentication in the README. Issuers using the delegated password verifier always require the application to authenticate, as it is trusted to have verified the user's password, which is then not provided.\n\nThe endpoints are also served at their original paths without the /v1 prefix. These paths are deprecated.",
+    "license": {
+      "name": "Apache 2.0",
+      "url": "http://www.apache.org/licenses/LICENSE-2.0"
+    },
+    "version": ""
+  },
+  "tags": [
+    {"name": "users", "description": "Enrolment and validation of users' OTPs"},
+    {"name": "admin", "description": "Administration, authenticated with the basic authentication credentials of an administrator"}
+  ],
+  "security": [
+    {},
+    {"applicationName": [], "applicationKey": []},
+    {"applicationName": [], "applicationTimestamp": [], "applicationSignature": []}
+  ],
+  "paths": {
+    "/v1/enrol": {
+      "post": {
+        "tags": ["users"],
+        "operationId": "enrol",
+        "summary": "Create and store a new OTP secret for a user",
+        "description": "If Kerberos is configured an Authorization: Negotiate header may be provided instead of the username, password and domain.",
+        "parameters": [{"$ref": "#/components/parameters/AcceptEncodingPNG"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/EnrolRequest"}}}
+        },
+        "responses": {
+          "201": {
+            "description": "The user is enroled. The secret is returned, or a QR code of it if requested.",
+            "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/SecretResponse"}},
+              "image/png": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/EnrolForbidden"},
+          "405": {"$ref": "#/components/responses/MethodNotAllowed"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/v1/validate": {
+      "post": {
+        "tags": ["users"],
+        "operationId": "validate",
+        "summary": "Validate a user's password and OTP",
+        "description": "If Kerberos is configured an Authorization: Negotiate header may be provided instead of the username, password and domain.",
+        "parameters": [
+          {
+            "name": "Accept",
+            "in": "header",
+            "description": "application/jwt to receive a signed assertion if the OTP is valid and assertions are configured.",
+            "schema": {"type": "string"}
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ValidateRequest"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The OTP is valid. The body is the signed assertion that was requested.",
+            "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+            "content": {"application/jwt": {"schema": {"type": "string"}}}
+          },
+          "204": {
+            "description": "The OTP is valid.",
+            "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "405": {"$ref": "#/components/responses/MethodNotAllowed"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/v1/update": {
+      "post": {
+        "tags": ["users"],
+        "operationId": "update",
+        "summary": "Replace the OTP secret of an enroled user",
+        "parameters": [{"$ref": "#/components/parameters/AcceptEncodingPNG"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ValidateRequest"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The secret is replaced. The new secret is returned, or a QR code of it if requested.",
+            "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/SecretResponse"}},
+              "image/png": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "405": {"$ref": "#/components/responses/MethodNotAllowed"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/v1/delete": {
+      "post": {
+        "tags": ["users", "admin"],
+        "operationId": "delete",
+        "summary": "Delete the OTP secret of a user",
+        "description": "The user deletes their own secret with their password and OTP. An administrator with the reset permission for the issuer and domain provides basic authentication credentials instead.",
+        "security": [
+          {},
+          {"adminBasic": []},
+          {"applicationName": [], "applicationKey": []},
+          {"applicationName": [], "applicationKey": [], "adminBasic": []},
+          {"applicationName": [], "applicationTimestamp": [], "applicationSignature": []},
+          {"applicationName": [], "applicationTimestamp": [], "applicationSignature": [], "adminBasic": []}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ValidateRequest"}}}
+        },
+        "responses": {
+          "204": {
+            "description": "The secret is deleted.",
+            "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "405": {"$ref": "#/components/responses/MethodNotAllowed"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/v1/list": {
+      "post": {
+        "tags": ["admin"],
+        "operationId": "list",
+        "summary": "List the users enroled for an issuer and domain",
+        "description": "The administrator needs the list permission for the issuer and domain.",
+        "security": [
+          {"adminBasic": []},
+          {"applicationName": [], "applicationKey": [], "adminBasic": []},
+          {"applicationName": [], "applicationTimestamp": [], "applicationSignature": [], "adminBasic": []}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ListRequest"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The usernames of the users enroled.",
+            "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ListResponse"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "405": {"$ref": "#/components/responses/MethodNotAllowed"},
+          "500": {"$ref": "#/components/responses/InternalError"}
+        }
+      }
+    },
+    "/v1/admin/cache/invalidate": {
+      "post": {
+        "tags": ["admin"],
+        "operationId": "invalidateAdminCache",
+        "summary": "Remove the cached admin group membership decisions for a user, or all users of the domain's directory if no username is given",
+        "description": "The administrator needs the manage permission for the domain.",
+        "security": [
+          {"adminBasic": []},
+          {"applicationName": [], "applicationKey": [], "adminBasic": []},
+          {"applicationName": [], "applicationTimestamp": [], "applicationSignature": [], "adminBasic": []}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AdminCacheRequest"}}}
+        },
+        "responses": {
+          "204": {
+            "description": "The cache is invalidated.",
+            "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "405": {"$ref": "#/components/responses/MethodNotAllowed"}
+        }
+      }
+    },
+    "/v1/admin/cache/stats": {
+      "post": {
+        "tags": ["admin"],
+        "operationId": "adminCacheStats",
+        "summary": "Return the hit rate and size of the admin group membership cache",
+        "description": "The administrator needs the manage permission for the domain. The statistics are for the whole cache, across all domains, and hold no details of the users cached.",
+        "security": [
+          {"adminBasic": []},
+          {"applicationName": [], "applicationKey": [], "adminBasic": []},
+          {"applicationName": [], "applicationTimestamp": [], "applicationSignature": [], "adminBasic": []}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AdminCacheRequest"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The cache statistics.",
+            "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AdminCacheStats"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "405": {"$ref": "#/components/responses/MethodNotAllowed"}
+        }
+      }
+    },
+    "/v1/admin/webhooks/deliveries": {
+      "post": {
+        "tags": ["admin"],
+        "operationId": "webhookDeliveries",
+        "summary": "Return the webhook deliveries of events for users in a domain, the most recent first",
+        "description": "The administrator needs the manage permission for the domain. If applications are registered only the deliveries of events for the issuers the calling application is permitted to use are returned.",
+        "security": [
+          {"adminBasic": []},
+          {"applicationName": [], "applicationKey": [], "adminBasic": []},
+          {"applicationName": [], "applicationTimestamp": [], "applicationSignature": [], "adminBasic": []}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/WebhookDeliveriesRequest"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The deliveries.",
+            "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/WebhookDeliveriesResponse"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "405": {"$ref": "#/components/responses/MethodNotAllowed"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "applicationName": {"type": "apiKey", "in": "header", "name": "X-MFA-Application", "description": "The name of the calling application. An application connecting with a verified client certificate is authenticated by the certificate and need not provide its name."},
+      "applicationKey": {"type": "apiKey", "in": "header", "name": "X-MFA-Application-Key", "description": "An API key of the calling application."},
+      "applicationTimestamp": {"type": "apiKey", "in": "header", "name": "X-MFA-Timestamp", "description": "The Unix time of the request, within 5 minutes of the MFA server's clock."},
+      "applicationSignature": {"type": "apiKey", "in": "header", "name": "X-MFA-Signature", "description": "The hex encoded HMAC-SHA256 signature of the method, request URI, timestamp and hex encoded SHA-256 hash of the body, each separated by a newline."},
+      "adminBasic": {"type": "http", "scheme": "basic", "description": "The LDAP credentials of an administrator."}
+    },
+    "parameters": {
+      "AcceptEncodingPNG": {
+        "name": "Accept-Encoding",
+        "in": "header",
+        "description": "image/png to receive a QR code of the secret, suitable for authenticator applications, instead of JSON.",
+        "schema": {"type": "string"}
+      }
+    },
+    "headers": {
+      "RequestID": {
+        "description": "The ID of the request in the MFA server's logs. A valid ID provided in the request is returned.",
+        "schema": {"type": "string"}
+      }
+    },
+    "responses": {
+      "BadRequest": {
+        "description": "The request data could not be parsed or a required value is missing (invalid_request).",
+        "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "Unauthorized": {
+        "description": "The application, user or administrator could not be authenticated or authorised (unauthorized).",
+        "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "Forbidden": {
+        "description": "The application is not permitted to use the issuer (forbidden).",
+        "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "EnrolForbidden": {
+        "description": "The application is not permitted to use the issuer (forbidden) or the user has already enroled (already_enrolled).",
+        "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "NotFound": {
+        "description": "The feature is not enabled (not_found).",
+        "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "MethodNotAllowed": {
+        "description": "The endpoint only accepts POST requests (method_not_allowed).",
+        "headers": {
+          "X-Request-ID": {"$ref": "#/components/headers/RequestID"},
+          "Allow": {"description": "The methods accepted.", "schema": {"type": "string"}}
+        },
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "InternalError": {
+        "description": "The Vault could not be read or written, or another server error (internal_error).",
+        "headers": {"X-Request-ID": {"$ref": "#/components/headers/RequestID"}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    },
+    "schemas": {
+      "EnrolRequest": {
+        "type": "object",
+        "required": ["issuer"],
+        "properties": {
+          "issuer": {"type": "string", "description": "The issuer, usually the application, the OTP is for."},
+          "domain": {"type": "string", "description": "The user's domain. Not required with Kerberos."},
+          "username": {"type": "string", "description": "Not required with Kerberos."},
+          "password": {"type": "string", "description": "The user's password. Not required with Kerberos, if the issuer does not require a password or if the issuer's password verification is delegated to the authenticated application."}
+        }
+      },
+      "ValidateRequest": {
+        "type": "object",
+        "required": ["issuer"],
+        "properties": {
+          "issuer": {"type": "string", "description": "The issuer, usually the application, the OTP is for."},
+          "domain": {"type": "string", "description": "The user's domain. Not required with Kerberos."},
+          "username": {"type": "string", "description": "Not required with Kerberos."},
+          "password": {"type": "string", "description": "The user's password. Not required with Kerberos, if the issuer does not require a password, if the issuer's password verification is delegated to the authenticated application or for a delete by an administrator."},
+          "otp": {"type": "string", "description": "The user's current OTP. Not required for a delete by an administrator."}
+        }
+      },
+      "ListRequest": {
+        "type": "object",
+        "required": ["issuer", "domain"],
+        "properties": {
+          "issuer": {"type": "string"},
+          "domain": {"type": "string"}
+        }
+      },
+      "AdminCacheRequest": {
+        "type": "object",
+        "required": ["domain"],
+        "properties": {
+          "domain": {"type": "string"},
+          "username": {"type": "string", "description": "The administrator whose cached decisions are removed. If omitted the cached decisions for all users of the domain's directory are removed."}
+        }
+      },
+      "WebhookDeliveriesRequest": {
+        "type": "object",
+        "required": ["domain"],
+        "properties": {
+          "domain": {"type": "string"},
+          "subscription": {"type": "string", "description": "Only return deliveries to this subscription."},
+          "status": {"type": "string", "enum": ["pending", "delivered", "failed"], "description": "Only return deliveries with this status."},
+          "limit": {"type": "integer", "minimum": 0, "maximum": 1000, "description": "The most deliveries to return. Defaults to 100."}
+        }
+      },
+      "SecretResponse": {
+        "type": "object",
+        "required": ["secret"],
+        "properties": {
+          "secret": {"type": "string", "description": "The base32 encoded TOTP secret."}
+        }
+      },
+      "ListResponse": {
+        "type": "object",
+        "required": ["usernames"],
+        "properties": {
+          "usernames": {"type": "array", "nullable": true, "items": {"type": "string"}}
+        }
+      },
+      "AdminCacheStats": {
+        "type": "object",
+        "required": ["entries", "hits", "misses", "evictions", "hitRate"],
+        "properties": {
+          "entries": {"type": "integer"},
+          "hits": {"type": "integer"},
+          "misses": {"type": "integer"},
+          "evictions": {"type": "integer"},
+          "hitRate": {"type": "number"}
+        }
+      },
+      "WebhookDeliveriesResponse": {
+        "type": "object",
+        "required": ["deliveries"],
+        "properties": {
+          "deliveries": {"type": "array", "items": {"$ref": "#/components/schemas/WebhookDelivery"}}
+        }
+      },
+      "WebhookDelivery": {
+        "type": "object",
+        "required": ["id", "subscription", "event", "status", "attempts", "created", "next_attempt", "last_attempt"],
+        "properties": {
+          "id": {"type": "string"},
+          "subscription": {"type": "string"},
+          "event": {"$ref": "#/components/schemas/WebhookEvent"},
+          "status": {"type": "string", "enum": ["pending", "delivered", "failed"]},
+          "attempts": {"type": "integer"},
+          "created": {"type": "string", "format": "date-time"},
+          "next_attempt": {"type": "string", "format": "date-time"},
+          "last_attempt": {"type": "string", "format": "date-time"},
+          "response_code": {"type": "integer", "description": "The HTTP response code of the last attempt."},
+          "last_error": {"type": "string"}
+        }
+      },
+      "WebhookEvent": {
+        "type": "object",
+        "required": ["id", "type", "time", "issuer", "domain", "username"],
+        "properties": {
+          "id": {"type": "string"},
+          "type": {"type": "string", "enum": ["mfa.enrolled", "mfa.rotated", "mfa.deleted"]},
+          "time": {"type": "string", "format": "date-time"},
+          "issuer": {"type": "string"},
+          "domain": {"type": "string"},
+          "username": {"type": "string"},
+          "admin": {"type": "string", "description": "The administrator that made the change, as domain/username."},
+          "application": {"type": "string"},
+          "request_id": {"type": "string"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["code", "message", "request_id"],
+        "properties": {
+          "code": {"type": "string", "enum": ["invalid_request", "unauthorized", "forbidden", "already_enrolled", "not_found", "method_not_allowed", "internal_error"]},
+          "message": {"type": "string", "description": "A description of the error for people. It may change."},
+          "request_id": {"type": "string", "description": "The ID of the request in the MFA server's logs."}
+        }
+      }
+    }
+  }
+}`
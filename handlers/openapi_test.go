@@ -0,0 +1,496 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/jcmturner/gootp"
+	"github.com/jcmturner/mfaserver/cache"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/jcmturner/mfaserver/version"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openAPIDoc is the part of the OpenAPI document that the handlers are checked against.
+type openAPIDoc struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components struct {
+		Responses map[string]*openAPIResponse `json:"responses"`
+		Schemas   map[string]*openAPISchema   `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	RequestBody struct {
+		Content map[string]openAPIMediaType `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref     string                      `json:"$ref"`
+	Content map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+	Format     string                    `json:"format"`
+	Nullable   bool                      `json:"nullable"`
+	Required   []string                  `json:"required"`
+	Enum       []string                  `json:"enum"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Items      *openAPISchema            `json:"items"`
+}
+
+// The API endpoints, without the version prefix, and their handlers
+var apiEndpoints = []struct {
+	Path    string
+	Handler HandlerFunc
+}{
+	{"/enrol", Enrol},
+	{"/validate", ValidateOTP},
+	{"/update", Update},
+	{"/delete", DeleteOTP},
+	{"/list", ListUsers},
+	{"/admin/cache/invalidate", AdminCacheInvalidate},
+	{"/admin/cache/stats", AdminCacheStats},
+	{"/admin/webhooks/deliveries", WebhookDeliveries},
+}
+
+func loadOpenAPI(t *testing.T) *openAPIDoc {
+	w := httptest.NewRecorder()
+	OpenAPI(w, httptest.NewRequest("GET", OpenAPIPath, nil), config.NewConfig())
+	if w.Code != http.StatusOK {
+		t.Fatalf("OpenAPI document not returned: %d", w.Code)
+	}
+	var d openAPIDoc
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatalf("OpenAPI document could not be parsed: %v", err)
+	}
+	return &d
+}
+
+func (d *openAPIDoc) schema(t *testing.T, s *openAPISchema) *openAPISchema {
+	if s == nil || s.Ref == "" {
+		return s
+	}
+	r, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	if !ok {
+		t.Fatalf("Schema %s not found", s.Ref)
+	}
+	return r
+}
+
+func (d *openAPIDoc) response(t *testing.T, r *openAPIResponse) *openAPIResponse {
+	if r == nil || r.Ref == "" {
+		return r
+	}
+	res, ok := d.Components.Responses[strings.TrimPrefix(r.Ref, "#/components/responses/")]
+	if !ok {
+		t.Fatalf("Response %s not found", r.Ref)
+	}
+	return res
+}
+
+// operation returns the documented POST operation of the API endpoint.
+func (d *openAPIDoc) operation(t *testing.T, path string) *openAPIOperation {
+	op, ok := d.Paths[APIVersionPrefix+path]["post"]
+	if !ok {
+		t.Fatalf("No POST operation documented for %s", APIVersionPrefix+path)
+	}
+	return op
+}
+
+// checkStruct checks the schema describes the JSON encoding of the type.
+func (d *openAPIDoc) checkStruct(t *testing.T, s *openAPISchema, typ reflect.Type, name string) {
+	s = d.schema(t, s)
+	if s == nil {
+		t.Errorf("No schema for %s", name)
+		return
+	}
+	if typ == reflect.TypeOf(time.Time{}) {
+		assert.Equal(t, "string", s.Type, "Schema type of %s not as expected", name)
+		assert.Equal(t, "date-time", s.Format, "Schema format of %s not as expected", name)
+		return
+	}
+	switch typ.Kind() {
+	case reflect.Struct:
+		assert.Equal(t, "object", s.Type, "Schema type of %s not as expected", name)
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			n := strings.Split(f.Tag.Get("json"), ",")[0]
+			if n == "-" || f.PkgPath != "" {
+				continue
+			}
+			if n == "" {
+				n = f.Name
+			}
+			fields[n] = f.Type
+		}
+		for n, ft := range fields {
+			p, ok := s.Properties[n]
+			if !ok {
+				t.Errorf("Field %s.%s not documented", name, n)
+				continue
+			}
+			d.checkStruct(t, p, ft, name+"."+n)
+		}
+		for n := range s.Properties {
+			if _, ok := fields[n]; !ok {
+				t.Errorf("Documented property %s.%s is not a field", name, n)
+			}
+		}
+		for _, n := range s.Required {
+			if _, ok := fields[n]; !ok {
+				t.Errorf("Required property %s.%s is not a field", name, n)
+			}
+		}
+	case reflect.Slice:
+		assert.Equal(t, "array", s.Type, "Schema type of %s not as expected", name)
+		d.checkStruct(t, s.Items, typ.Elem(), name+"[]")
+	case reflect.String:
+		assert.Equal(t, "string", s.Type, "Schema type of %s not as expected", name)
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		assert.Equal(t, "integer", s.Type, "Schema type of %s not as expected", name)
+	case reflect.Float64:
+		assert.Equal(t, "number", s.Type, "Schema type of %s not as expected", name)
+	case reflect.Bool:
+		assert.Equal(t, "boolean", s.Type, "Schema type of %s not as expected", name)
+	default:
+		t.Errorf("Type %v of %s not supported", typ, name)
+	}
+}
+
+// checkValue checks the decoded JSON value is valid against the schema.
+func (d *openAPIDoc) checkValue(t *testing.T, s *openAPISchema, v interface{}, name string) {
+	s = d.schema(t, s)
+	if v == nil {
+		if !s.Nullable {
+			t.Errorf("%s is null", name)
+		}
+		return
+	}
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			t.Errorf("%s is not an object", name)
+			return
+		}
+		for _, n := range s.Required {
+			if _, ok := m[n]; !ok {
+				t.Errorf("Required property %s.%s missing", name, n)
+			}
+		}
+		for n, pv := range m {
+			p, ok := s.Properties[n]
+			if !ok {
+				t.Errorf("Property %s.%s not documented", name, n)
+				continue
+			}
+			d.checkValue(t, p, pv, name+"."+n)
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			t.Errorf("%s is not an array", name)
+			return
+		}
+		for i, iv := range a {
+			d.checkValue(t, s.Items, iv, name+"["+strconv.Itoa(i)+"]")
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			t.Errorf("%s is not a string", name)
+			return
+		}
+		if len(s.Enum) > 0 {
+			assert.Contains(t, s.Enum, str, "%s not one of the values documented", name)
+		}
+	case "integer":
+		f, ok := v.(float64)
+		if !ok || f != float64(int64(f)) {
+			t.Errorf("%s is not an integer", name)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			t.Errorf("%s is not a number", name)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			t.Errorf("%s is not a boolean", name)
+		}
+	}
+}
+
+// checkResponse checks the response of the API endpoint is documented and its body is valid against the schema.
+func (d *openAPIDoc) checkResponse(t *testing.T, path string, w *httptest.ResponseRecorder) {
+	name := fmt.Sprintf("%d response from %s", w.Code, path)
+	r := d.response(t, d.operation(t, path).Responses[strconv.Itoa(w.Code)])
+	if r == nil {
+		t.Errorf("%s not documented", name)
+		return
+	}
+	if len(r.Content) == 0 {
+		assert.Empty(t, w.Body.Bytes(), "%s has a body", name)
+		return
+	}
+	mt, _, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil {
+		t.Errorf("%s has no content type: %v", name, err)
+		return
+	}
+	c, ok := r.Content[mt]
+	if !ok {
+		t.Errorf("Content type %s of %s not documented", mt, name)
+		return
+	}
+	if mt != "application/json" {
+		return
+	}
+	var v interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Errorf("%s could not be parsed: %v", name, err)
+		return
+	}
+	d.checkValue(t, c.Schema, v, name)
+}
+
+func TestOpenAPI(t *testing.T) {
+	d := loadOpenAPI(t)
+	assert.Equal(t, "3.0.3", d.OpenAPI, "OpenAPI version not as expected")
+	assert.Equal(t, version.Version, d.Info.Version, "API version not as expected")
+	var paths []string
+	for _, e := range apiEndpoints {
+		paths = append(paths, APIVersionPrefix+e.Path)
+	}
+	var documented []string
+	for p := range d.Paths {
+		documented = append(documented, p)
+	}
+	sort.Strings(paths)
+	sort.Strings(documented)
+	assert.Equal(t, paths, documented, "Paths documented not as expected")
+
+	//Every reference in the document can be resolved
+	b, _ := openAPIDocument()
+	var doc map[string]interface{}
+	json.Unmarshal(b, &doc)
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				var target interface{} = doc
+				for _, p := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					m, _ := target.(map[string]interface{})
+					target = m[p]
+				}
+				assert.NotNil(t, target, "Reference %s cannot be resolved", ref)
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(doc)
+}
+
+func TestOpenAPI_SecuritySchemes(t *testing.T) {
+	b, _ := openAPIDocument()
+	var doc struct {
+		Components struct {
+			SecuritySchemes map[string]struct {
+				Type string `json:"type"`
+				In   string `json:"in"`
+				Name string `json:"name"`
+			} `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	json.Unmarshal(b, &doc)
+	//Applications authenticate with the application headers only
+	var headers []string
+	for _, s := range doc.Components.SecuritySchemes {
+		if s.Type == "apiKey" {
+			assert.Equal(t, "header", s.In, "Security scheme %s not a header", s.Name)
+			headers = append(headers, s.Name)
+		}
+	}
+	sort.Strings(headers)
+	assert.Equal(t, []string{ApplicationHeader, ApplicationKeyHeader, SignatureHeader, TimestampHeader}, headers, "Security scheme headers not as expected")
+}
+
+func TestOpenAPI_Schemas(t *testing.T) {
+	d := loadOpenAPI(t)
+	var requests = []struct {
+		Path string
+		Data interface{}
+	}{
+		{"/enrol", enrolRequestData{}},
+		{"/validate", validateRequestData{}},
+		{"/update", validateRequestData{}},
+		{"/delete", validateRequestData{}},
+		{"/list", listRequestData{}},
+		{"/admin/cache/invalidate", adminCacheRequestData{}},
+		{"/admin/cache/stats", adminCacheRequestData{}},
+		{"/admin/webhooks/deliveries", webhookDeliveriesRequestData{}},
+	}
+	for _, test := range requests {
+		d.checkStruct(t, d.operation(t, test.Path).RequestBody.Content["application/json"].Schema, reflect.TypeOf(test.Data), test.Path+" request")
+	}
+
+	var responses = []struct {
+		Path string
+		Code string
+		Data interface{}
+	}{
+		{"/enrol", "201", enrolResponseData{}},
+		{"/update", "200", enrolResponseData{}},
+		{"/list", "200", listResponseData{}},
+		{"/admin/cache/stats", "200", cache.Stats{}},
+		{"/admin/webhooks/deliveries", "200", webhookDeliveriesResponseData{}},
+	}
+	for _, test := range responses {
+		r := d.response(t, d.operation(t, test.Path).Responses[test.Code])
+		if r == nil {
+			t.Errorf("%s response from %s not documented", test.Code, test.Path)
+			continue
+		}
+		d.checkStruct(t, r.Content["application/json"].Schema, reflect.TypeOf(test.Data), test.Path+" response")
+	}
+
+	//Every error response is an error body with one of the codes
+	d.checkStruct(t, d.Components.Schemas["Error"], reflect.TypeOf(errorResponseData{}), "error response")
+	codes := []string{ErrorInvalidRequest, ErrorUnauthorized, ErrorForbidden, ErrorAlreadyEnrolled, ErrorNotFound, ErrorMethodNotAllowed, ErrorInternal}
+	assert.ElementsMatch(t, codes, d.Components.Schemas["Error"].Properties["code"].Enum, "Error codes documented not as expected")
+	for _, e := range apiEndpoints {
+		for code, r := range d.operation(t, e.Path).Responses {
+			if c, _ := strconv.Atoi(code); c >= 400 {
+				assert.Equal(t, "#/components/schemas/Error", d.response(t, r).Content["application/json"].Schema.Ref, "%s response from %s is not an error body", code, e.Path)
+			}
+		}
+	}
+}
+
+func TestOpenAPI_ErrorResponses(t *testing.T) {
+	d := loadOpenAPI(t)
+	c := config.NewConfig()
+	c.MFAServer.Loggers.Info = log.New(ioutil.Discard, "", 0)
+	c.MFAServer.Loggers.Error = log.New(ioutil.Discard, "", 0)
+	conf := func() *config.Config { return c }
+	appConf := config.NewConfig()
+	appConf.MFAServer.Loggers.Error = log.New(ioutil.Discard, "", 0)
+	appConf.WithApplication("app1", []string{testAppKey}, "", []string{"testapp"})
+
+	for _, e := range apiEndpoints {
+		h := Methods(e.Handler, "POST")
+		var tests = []struct {
+			Conf     func() *config.Config
+			Method   string
+			Json     string
+			HttpCode int
+		}{
+			{conf, "GET", ``, http.StatusMethodNotAllowed},
+			{conf, "POST", `{"domain": "testdom"`, http.StatusBadRequest},
+			{func() *config.Config { return appConf }, "POST", `{"domain": "testdom", "issuer": "testapp"}`, http.StatusUnauthorized},
+		}
+		for _, test := range tests {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.Method, APIVersionPrefix+e.Path, bytes.NewBufferString(test.Json))
+			Logged(APIVersionPrefix+e.Path, test.Conf, h)(w, r)
+			assert.Equal(t, test.HttpCode, w.Code, "Status code from %s not as expected", e.Path)
+			d.checkResponse(t, e.Path, w)
+		}
+	}
+}
+
+func TestOpenAPI_Responses(t *testing.T) {
+	d := loadOpenAPI(t)
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	//Set up the MFA config
+	c := config.NewConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	c.WithLDAPAdminSettings("cn=mfaadmin,ou=groups,dc=example,dc=com", "memberUid", "{username}")
+	conf := func() *config.Config { return c }
+	handlers := make(map[string]HandlerFunc)
+	for _, e := range apiEndpoints {
+		handlers[e.Path] = e.Handler
+	}
+
+	var secret string
+	var tests = []struct {
+		Path     string
+		Admin    bool
+		Json     string
+		HttpCode int
+	}{
+		{"/enrol", false, `{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "testapp"}`, http.StatusCreated},
+		{"/enrol", false, `{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "testapp"}`, http.StatusForbidden},
+		{"/enrol", false, `{"domain": "testdom", "username": "validuser", "password": "invalidpassword", "issuer": "otherapp"}`, http.StatusUnauthorized},
+		{"/validate", false, `{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "testapp", "otp": "%s"}`, http.StatusNoContent},
+		{"/validate", false, `{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "testapp", "otp": "1234567"}`, http.StatusUnauthorized},
+		{"/update", false, `{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "testapp", "otp": "%s"}`, http.StatusOK},
+		{"/update", false, `{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "testapp", "otp": "1234567"}`, http.StatusUnauthorized},
+		{"/list", true, `{"domain": "testdom", "issuer": "testapp"}`, http.StatusOK},
+		{"/list", false, `{"domain": "testdom", "issuer": "testapp"}`, http.StatusUnauthorized},
+		{"/admin/cache/stats", true, `{"domain": "testdom"}`, http.StatusNotFound},
+		{"/admin/webhooks/deliveries", true, `{"domain": "testdom"}`, http.StatusNotFound},
+		{"/delete", true, `{"domain": "testdom", "username": "validuser", "issuer": "testapp"}`, http.StatusNoContent},
+		{"/delete", false, `{"domain": "testdom", "username": "validuser", "password": "validpassword", "issuer": "testapp", "otp": "123456"}`, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		j := test.Json
+		if strings.Contains(j, "%s") {
+			otp, _, _ := gootp.GetTOTPNow(secret, sha1.New, 6)
+			j = fmt.Sprintf(j, otp)
+		}
+		r := httptest.NewRequest("POST", APIVersionPrefix+test.Path, bytes.NewBufferString(j))
+		if test.Admin {
+			r.SetBasicAuth("validuser", "validpassword")
+		}
+		w := httptest.NewRecorder()
+		Logged(APIVersionPrefix+test.Path, conf, handlers[test.Path])(w, r)
+		assert.Equal(t, test.HttpCode, w.Code, "Status code from %s not as expected for %s", test.Path, j)
+		d.checkResponse(t, test.Path, w)
+		if w.Code == http.StatusCreated || (test.Path == "/update" && w.Code == http.StatusOK) {
+			var s enrolResponseData
+			json.Unmarshal(w.Body.Bytes(), &s)
+			secret = s.Secret
+		}
+	}
+}
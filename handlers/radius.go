@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/metrics"
+	"github.com/jcmturner/mfaserver/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Length of the OTP appended to the password when the concatenated OTP mode is used
+const radiusOTPLength = 6
+
+// RADIUS returns a RADIUS (RFC 2865) server that authenticates Access-Requests with the user's password and OTP.
+// Requests without a valid Message-Authenticator are discarded unless the client is permitted to omit it, and every
+// response includes one.
+// Each request uses the current configuration so that reloads apply to RADIUS clients as well.
+// The caller is responsible for starting the server.
+func RADIUS(conf func() *config.Config) *radius.PacketServer {
+	c := conf()
+	h := &radiusHandler{
+		conf:       conf,
+		challenges: make(map[string]radiusChallenge),
+	}
+	return &radius.PacketServer{
+		Addr:         *c.RADIUS.ListenerSocket,
+		Network:      "udp",
+		SecretSource: h,
+		Handler:      h,
+		ErrorLog:     c.MFAServer.Loggers.Warning,
+	}
+}
+
+type radiusHandler struct {
+	conf       func() *config.Config
+	mux        sync.Mutex
+	challenges map[string]radiusChallenge
+}
+
+// radiusChallenge holds the request data of a user who has been sent an Access-Challenge for their OTP.
+type radiusChallenge struct {
+	data    validateRequestData
+	client  string
+	expires time.Time
+}
+
+// RADIUSSecret returns the shared secret of the client. Requests from unknown clients are discarded.
+func (h *radiusHandler) RADIUSSecret(ctx context.Context, remoteAddr net.Addr) ([]byte, error) {
+	c := h.conf()
+	rc := c.RADIUSClient(addrIP(remoteAddr))
+	if rc == nil {
+		c.MFAServer.Loggers.Warning.Printf("%s, RADIUS request discarded from unknown client", remoteAddr)
+		return nil, nil
+	}
+	return []byte(rc.Secret), nil
+}
+
+func (h *radiusHandler) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	lc := h.conf()
+	if r.Code != radius.CodeAccessRequest {
+		lc.MFAServer.Loggers.Warning.Printf("%s, RADIUS packet with unsupported code %v ignored", r.RemoteAddr, r.Code)
+		return
+	}
+	rc := lc.RADIUSClient(addrIP(r.RemoteAddr))
+	if rc == nil {
+		return
+	}
+	if !radiusRequestAuthentic(rc, r) {
+		lc.MFAServer.Loggers.Warning.Printf("%s, RADIUS request discarded as its Message-Authenticator is missing or invalid", r.RemoteAddr)
+		return
+	}
+	//The existing authentication logic works with HTTP requests so the client's address is carried in one
+	hr := metrics.WithOutcome(&http.Request{RemoteAddr: r.RemoteAddr.String(), Header: make(http.Header)})
+	rw := &radiusCodeWriter{ResponseWriter: w}
+	w = rw
+	ctx, span := tracing.Start(r.Context(), "radius Access-Request", attribute.String("client.address", addrIP(r.RemoteAddr).String()))
+	c := lc.ForRequest(newRequestID(), hr.RemoteAddr)
+	defer c.Done()
+	c.SetRequestContext(ctx)
+	c.Request.Endpoint = "radius"
+	defer func(start time.Time) {
+		code := http.StatusOK
+		if rw.code == radius.CodeAccessReject {
+			code = http.StatusUnauthorized
+		}
+		outcome := metrics.Outcome(hr, code)
+		metrics.ObserveRequest("radius", outcome, start)
+		u, d, _ := userDomain(c, rfc2865.UserName_GetString(r.Packet))
+		c.SetRequestUser(c.RADIUSIssuer(rc), d, u)
+		c.Request.Outcome = outcome
+		c.Request.Status = code
+		c.Request.Duration = time.Since(start)
+		c.MFAServer.Loggers.Request(c.Request)
+		recordAuditEvent(c, hr, c.Request)
+		tracing.SetAttributes(ctx, attribute.String("mfaserver.request_id", c.Request.ID), attribute.String("mfaserver.outcome", outcome))
+		span.End()
+	}(time.Now())
+
+	if state := rfc2865.State_GetString(r.Packet); state != "" {
+		h.respondToChallenge(c, w, r, hr, state)
+		return
+	}
+
+	u, d, ok := userDomain(c, rfc2865.UserName_GetString(r.Packet))
+	p := rfc2865.UserPassword_GetString(r.Packet)
+	if !ok || p == "" {
+		c.MFAServer.Loggers.Error.Printf("%s, Could not extract values correctly from the RADIUS request.", r.RemoteAddr)
+		metrics.SetOutcome(hr, metrics.OutcomeBadRequest)
+		h.respond(c, w, r, radius.CodeAccessReject)
+		return
+	}
+	data := validateRequestData{
+		Issuer:   c.RADIUSIssuer(rc),
+		Domain:   d,
+		Username: u,
+		Password: p,
+	}
+	c.MFAServer.Loggers.Info.Printf("%s, RADIUS OTP validation request received for %s/%s", r.RemoteAddr, data.Domain, data.Username)
+
+	if rc.OTPMode == config.RADIUSOTPChallenge {
+		h.challenge(c, w, r, hr, data)
+		return
+	}
+	if len(p) <= radiusOTPLength {
+		c.MFAServer.Loggers.Info.Printf("%s, RADIUS OTP validation failed for %s/%s. No OTP appended to the password", r.RemoteAddr, data.Domain, data.Username)
+		metrics.SetOutcome(hr, metrics.OutcomeBadRequest)
+		h.respond(c, w, r, radius.CodeAccessReject)
+		return
+	}
+	data.Password = p[:len(p)-radiusOTPLength]
+	data.OTP = p[len(p)-radiusOTPLength:]
+	h.authenticate(c, w, r, hr, &data)
+}
+
+// challenge verifies the user's password and then sends an Access-Challenge asking for their OTP.
+func (h *radiusHandler) challenge(c *config.Config, w radius.ResponseWriter, r *radius.Request, hr *http.Request, data validateRequestData) {
+	//No application vouches for the password of RADIUS users
+	err := verifyPassword(c, hr, nil, data.Issuer, data.Domain, data.Username, data.Password)
+	if err != nil {
+		c.MFAServer.Loggers.Info.Printf("%s, RADIUS OTP validation failed for %s/%s. Password verification failed: %v", r.RemoteAddr, data.Domain, data.Username, err)
+		metrics.SetOutcome(hr, metrics.OutcomeLDAPFail)
+		h.respond(c, w, r, radius.CodeAccessReject)
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Could not generate RADIUS challenge state: %v", r.RemoteAddr, err)
+		h.respond(c, w, r, radius.CodeAccessReject)
+		return
+	}
+	state := hex.EncodeToString(b)
+	h.mux.Lock()
+	h.purgeExpired()
+	h.challenges[state] = radiusChallenge{
+		data:    data,
+		client:  addrIP(r.RemoteAddr).String(),
+		expires: time.Now().Add(time.Duration(c.RADIUSChallengeTimeout()) * time.Second),
+	}
+	h.mux.Unlock()
+
+	resp := r.Response(radius.CodeAccessChallenge)
+	rfc2865.State_SetString(resp, state)
+	rfc2865.ReplyMessage_SetString(resp, "Enter your one time password")
+	h.write(c, w, r, resp)
+}
+
+// respondToChallenge completes the authentication of a user using the OTP sent in reply to an Access-Challenge.
+func (h *radiusHandler) respondToChallenge(c *config.Config, w radius.ResponseWriter, r *radius.Request, hr *http.Request, state string) {
+	h.mux.Lock()
+	ch, ok := h.challenges[state]
+	//A challenge can only be answered once
+	delete(h.challenges, state)
+	h.mux.Unlock()
+	if !ok || time.Now().After(ch.expires) || ch.client != addrIP(r.RemoteAddr).String() {
+		c.MFAServer.Loggers.Info.Printf("%s, RADIUS OTP validation failed. Unknown or expired challenge state", r.RemoteAddr)
+		h.respond(c, w, r, radius.CodeAccessReject)
+		return
+	}
+	if u, d, _ := userDomain(c, rfc2865.UserName_GetString(r.Packet)); u != ch.data.Username || d != ch.data.Domain {
+		c.MFAServer.Loggers.Info.Printf("%s, RADIUS OTP validation failed for %s/%s. Challenge answered for a different user", r.RemoteAddr, ch.data.Domain, ch.data.Username)
+		h.respond(c, w, r, radius.CodeAccessReject)
+		return
+	}
+	data := ch.data
+	data.OTP = rfc2865.UserPassword_GetString(r.Packet)
+	h.authenticate(c, w, r, hr, &data)
+}
+
+func (h *radiusHandler) authenticate(c *config.Config, w radius.ResponseWriter, r *radius.Request, hr *http.Request, data *validateRequestData) {
+	if ok, _ := twoFactorAuthenticate(c, hr, nil, data); ok {
+		h.respond(c, w, r, radius.CodeAccessAccept)
+		return
+	}
+	h.respond(c, w, r, radius.CodeAccessReject)
+}
+
+func (h *radiusHandler) respond(c *config.Config, w radius.ResponseWriter, r *radius.Request, code radius.Code) {
+	h.write(c, w, r, r.Response(code))
+}
+
+// write sends the response with a Message-Authenticator as its first attribute.
+func (h *radiusHandler) write(c *config.Config, w radius.ResponseWriter, r *radius.Request, resp *radius.Packet) {
+	resp.Attributes = append(radius.Attributes{{Type: rfc2869.MessageAuthenticator_Type, Attribute: make([]byte, md5.Size)}}, resp.Attributes...)
+	ma, err := radiusMessageAuthenticator(resp, r.Authenticator)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Error signing RADIUS response: %v", r.RemoteAddr, err)
+		return
+	}
+	rfc2869.MessageAuthenticator_Set(resp, ma)
+	if err := w.Write(resp); err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Error sending RADIUS response: %v", r.RemoteAddr, err)
+	}
+}
+
+// radiusRequestAuthentic reports whether the Message-Authenticator of the request is valid. A request without one is
+// only accepted from a client permitted to omit it.
+func radiusRequestAuthentic(rc *config.RADIUSClientConf, r *radius.Request) bool {
+	ma, ok := r.Lookup(rfc2869.MessageAuthenticator_Type)
+	if !ok {
+		return rc.AllowMissingMessageAuthenticator
+	}
+	exp, err := radiusMessageAuthenticator(r.Packet, r.Authenticator)
+	return err == nil && hmac.Equal(ma, exp)
+}
+
+// radiusMessageAuthenticator returns the Message-Authenticator (RFC 3579) of the packet. This is the HMAC-MD5, keyed
+// with the shared secret, of the packet with the authenticator given and the Message-Authenticator set to zeros.
+// Responses use the authenticator of the request.
+func radiusMessageAuthenticator(p *radius.Packet, auth [md5.Size]byte) ([]byte, error) {
+	q := *p
+	q.Authenticator = auth
+	//Copy the attributes so that zeroing the Message-Authenticator does not change the packet
+	q.Attributes = append(radius.Attributes(nil), p.Attributes...)
+	rfc2869.MessageAuthenticator_Set(&q, make([]byte, md5.Size))
+	b, err := q.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	m := hmac.New(md5.New, p.Secret)
+	m.Write(b)
+	return m.Sum(nil), nil
+}
+
+// radiusCodeWriter records the code of the RADIUS response sent.
+type radiusCodeWriter struct {
+	radius.ResponseWriter
+	code radius.Code
+}
+
+func (w *radiusCodeWriter) Write(p *radius.Packet) error {
+	w.code = p.Code
+	return w.ResponseWriter.Write(p)
+}
+
+// purgeExpired removes challenges that were never answered. The caller must hold the lock.
+func (h *radiusHandler) purgeExpired() {
+	now := time.Now()
+	for s, ch := range h.challenges {
+		if now.After(ch.expires) {
+			delete(h.challenges, s)
+		}
+	}
+}
+
+// userDomain splits a RADIUS User-Name of the form "user@domain" or "DOMAIN\user" into the username and domain.
+// If no domain is included the domain configured for the RADIUS listener is used.
+func userDomain(c *config.Config, n string) (string, string, bool) {
+	u, d := n, ""
+	if i := strings.LastIndex(n, "@"); i > 0 {
+		u, d = n[:i], n[i+1:]
+	} else if i := strings.Index(n, `\`); i > 0 {
+		u, d = n[i+1:], n[:i]
+	}
+	if d == "" && c.RADIUS.Domain != nil {
+		d = *c.RADIUS.Domain
+	}
+	return u, d, u != "" && d != ""
+}
+
+func addrIP(a net.Addr) net.IP {
+	switch v := a.(type) {
+	case *net.UDPAddr:
+		return v.IP
+	case *net.TCPAddr:
+		return v.IP
+	}
+	h, _, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(h)
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"github.com/jcmturner/gootp"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRADIUSSecret = "radiussecret"
+
+func radiusExchange(t *testing.T, addr, secret, username, password string, state []byte) *radius.Packet {
+	p := radiusRequest(username, password, secret, state)
+	signRADIUSRequest(t, p)
+	return radiusSend(p, addr)
+}
+
+func radiusRequest(username, password, secret string, state []byte) *radius.Packet {
+	p := radius.New(radius.CodeAccessRequest, []byte(secret))
+	rfc2865.UserName_SetString(p, username)
+	rfc2865.UserPassword_SetString(p, password)
+	if state != nil {
+		rfc2865.State_Set(p, state)
+	}
+	return p
+}
+
+func signRADIUSRequest(t *testing.T, p *radius.Packet) {
+	ma, err := radiusMessageAuthenticator(p, p.Authenticator)
+	if err != nil {
+		t.Fatalf("Error calculating the Message-Authenticator: %v", err)
+	}
+	rfc2869.MessageAuthenticator_Set(p, ma)
+}
+
+func radiusSend(p *radius.Packet, addr string) *radius.Packet {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := radius.Exchange(ctx, p, addr)
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+func TestRADIUS(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	//Set up the MFA config
+	c := config.NewConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	c.WithRADIUSListener("127.0.0.1:0", "testapp", "testdom")
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	udata := enrolRequestData{Username: "validuser",
+		Domain:   "testdom",
+		Issuer:   "testapp",
+		Password: "validpassword"}
+	secret, _ := createAndStoreSecret(c, &udata)
+
+	for _, mode := range []string{config.RADIUSOTPConcatenated, config.RADIUSOTPChallenge} {
+		_, err := c.WithRADIUSClient("127.0.0.1", testRADIUSSecret, mode)
+		if err != nil {
+			t.Fatalf("Error configuring RADIUS client: %v", err)
+		}
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error starting RADIUS listener: %v", err)
+		}
+		s := RADIUS(func() *config.Config { return c })
+		go s.Serve(pc)
+		a := pc.LocalAddr().String()
+
+		var tests = []struct {
+			Username string
+			Password string
+			OTP      string
+			Code     radius.Code
+		}{
+			{"validuser", "validpassword", "", radius.CodeAccessAccept},
+			{"validuser@testdom", "validpassword", "", radius.CodeAccessAccept},
+			{`testdom\validuser`, "validpassword", "", radius.CodeAccessAccept},
+			{"validuser", "validpassword", "123456", radius.CodeAccessReject},
+			{"validuser", "invalidpassword", "", radius.CodeAccessReject},
+			{"invaliduser", "validpassword", "", radius.CodeAccessReject},
+			{"validuser@somethingelse", "validpassword", "", radius.CodeAccessReject},
+		}
+		for _, test := range tests {
+			otp := test.OTP
+			if otp == "" {
+				otp, _, _ = gootp.GetTOTPNow(secret, sha1.New, 6)
+			}
+			var resp *radius.Packet
+			if mode == config.RADIUSOTPConcatenated {
+				resp = radiusExchange(t, a, testRADIUSSecret, test.Username, test.Password+otp, nil)
+			} else {
+				resp = radiusExchange(t, a, testRADIUSSecret, test.Username, test.Password, nil)
+				if resp != nil && resp.Code == radius.CodeAccessChallenge {
+					resp = radiusExchange(t, a, testRADIUSSecret, test.Username, otp, rfc2865.State_Get(resp))
+				}
+			}
+			if assert.NotNil(t, resp, "No RADIUS response received for %s in %s mode", test.Username, mode) {
+				assert.Equal(t, test.Code, resp.Code, "RADIUS response code not as expected for %s in %s mode", test.Username, mode)
+			}
+		}
+
+		//Requests signed with the wrong secret are discarded
+		assert.Nil(t, radiusExchange(t, a, "wrongsecret", "validuser", "validpassword", nil), "Request signed with the wrong secret should not be answered")
+		s.Shutdown(context.Background())
+	}
+}
+
+func TestRADIUSChallengeState(t *testing.T) {
+	c := config.NewConfig()
+	c.WithRADIUSListener("127.0.0.1:0", "testapp", "testdom")
+	c.WithRADIUSClient("127.0.0.1", testRADIUSSecret, config.RADIUSOTPChallenge)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting RADIUS listener: %v", err)
+	}
+	s := RADIUS(func() *config.Config { return c })
+	go s.Serve(pc)
+	defer s.Shutdown(context.Background())
+
+	resp := radiusExchange(t, pc.LocalAddr().String(), testRADIUSSecret, "validuser", "123456", []byte("unknownstate"))
+	if assert.NotNil(t, resp, "No RADIUS response received") {
+		assert.Equal(t, radius.CodeAccessReject, resp.Code, "Unknown challenge state should be rejected")
+	}
+}
+
+func TestRADIUSReload(t *testing.T) {
+	c := config.NewConfig()
+	c.WithRADIUSListener("127.0.0.1:0", "testapp", "testdom")
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+	var cur atomic.Value
+	cur.Store(c)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting RADIUS listener: %v", err)
+	}
+	s := RADIUS(func() *config.Config { return cur.Load().(*config.Config) })
+	go s.Serve(pc)
+	defer s.Shutdown(context.Background())
+	a := pc.LocalAddr().String()
+
+	assert.Nil(t, radiusExchange(t, a, testRADIUSSecret, "validuser", "123456", []byte("unknownstate")), "Request from an unknown client should not be answered")
+
+	//A client added by reloading the configuration is answered without restarting the listener
+	nc := *c
+	nc.WithRADIUSClient("127.0.0.1", testRADIUSSecret, config.RADIUSOTPChallenge)
+	cur.Store(&nc)
+	resp := radiusExchange(t, a, testRADIUSSecret, "validuser", "123456", []byte("unknownstate"))
+	if assert.NotNil(t, resp, "Request from a client added by a reload should be answered") {
+		assert.Equal(t, radius.CodeAccessReject, resp.Code, "Unknown challenge state should be rejected")
+	}
+}
+
+func TestRADIUSUserDomain(t *testing.T) {
+	c := config.NewConfig()
+	var tests = []struct {
+		Name     string
+		Username string
+		Domain   string
+		OK       bool
+	}{
+		{"user@dom", "user", "dom", true},
+		{`DOM\user`, "user", "DOM", true},
+		{"user", "user", "", false},
+		{"@dom", "@dom", "", false},
+	}
+	for _, test := range tests {
+		u, d, ok := userDomain(c, test.Name)
+		assert.Equal(t, test.OK, ok, "Result not as expected for %s", test.Name)
+		if ok {
+			assert.Equal(t, test.Username, u, "Username not as expected for %s", test.Name)
+			assert.Equal(t, test.Domain, d, "Domain not as expected for %s", test.Name)
+		}
+	}
+	c.WithRADIUSListener("127.0.0.1:0", "testapp", "defaultdom")
+	u, d, ok := userDomain(c, "user")
+	assert.True(t, ok, "Default domain should be used when none is in the User-Name")
+	assert.Equal(t, "user", u)
+	assert.Equal(t, "defaultdom", d)
+}
+
+func TestRADIUSMessageAuthenticator(t *testing.T) {
+	c := config.NewConfig()
+	c.WithRADIUSListener("127.0.0.1:0", "testapp", "testdom")
+	c.WithRADIUSClient("127.0.0.1", testRADIUSSecret, config.RADIUSOTPChallenge)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+	var cur atomic.Value
+	cur.Store(c)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting RADIUS listener: %v", err)
+	}
+	s := RADIUS(func() *config.Config { return cur.Load().(*config.Config) })
+	go s.Serve(pc)
+	defer s.Shutdown(context.Background())
+	a := pc.LocalAddr().String()
+
+	//The response to a signed request includes a valid Message-Authenticator as its first attribute
+	p := radiusRequest("validuser", "123456", testRADIUSSecret, []byte("unknownstate"))
+	signRADIUSRequest(t, p)
+	resp := radiusSend(p, a)
+	if assert.NotNil(t, resp, "Request with a Message-Authenticator should be answered") {
+		assert.Equal(t, radius.CodeAccessReject, resp.Code, "Unknown challenge state should be rejected")
+		if assert.NotEmpty(t, resp.Attributes, "Response has no attributes") {
+			assert.Equal(t, rfc2869.MessageAuthenticator_Type, resp.Attributes[0].Type, "Message-Authenticator should be the first attribute of the response")
+		}
+		exp, err := radiusMessageAuthenticator(resp, p.Authenticator)
+		assert.NoError(t, err, "Error calculating the Message-Authenticator of the response")
+		assert.True(t, hmac.Equal(exp, rfc2869.MessageAuthenticator_Get(resp)), "Message-Authenticator of the response is not valid")
+	}
+
+	//Requests without a Message-Authenticator, or with an invalid one, are discarded
+	assert.Nil(t, radiusSend(radiusRequest("validuser", "123456", testRADIUSSecret, []byte("unknownstate")), a), "Request without a Message-Authenticator should not be answered")
+	p = radiusRequest("validuser", "123456", testRADIUSSecret, []byte("unknownstate"))
+	rfc2869.MessageAuthenticator_Set(p, make([]byte, md5.Size))
+	assert.Nil(t, radiusSend(p, a), "Request with an invalid Message-Authenticator should not be answered")
+
+	//Unless the client is permitted to omit it
+	nc := *c
+	nc.RADIUS.Clients = nil
+	nc.WithRADIUSClient("127.0.0.1", testRADIUSSecret, config.RADIUSOTPChallenge)
+	nc.RADIUS.Clients["127.0.0.1"].AllowMissingMessageAuthenticator = true
+	cur.Store(&nc)
+	resp = radiusSend(radiusRequest("validuser", "123456", testRADIUSSecret, []byte("unknownstate")), a)
+	if assert.NotNil(t, resp, "Request without a Message-Authenticator from a client permitted to omit it should be answered") {
+		assert.Equal(t, radius.CodeAccessReject, resp.Code, "Unknown challenge state should be rejected")
+	}
+	p = radiusRequest("validuser", "123456", testRADIUSSecret, []byte("unknownstate"))
+	rfc2869.MessageAuthenticator_Set(p, make([]byte, md5.Size))
+	assert.Nil(t, radiusSend(p, a), "Request with an invalid Message-Authenticator should not be answered from any client")
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/metrics"
+	"github.com/jcmturner/mfaserver/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
+	"time"
+)
+
+// RequestIDHeader is the header that identifies a request in the MFA server's logs. The ID is taken from the request if
+// the client provided one, otherwise generated, and is returned in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// Maximum length of a request ID accepted from a client
+const maxRequestIDLength = 128
+
+// Logged serves requests to the endpoint with a copy of the current configuration whose loggers include the request's
+// ID, and whose calls to LDAP and the Vault are traced in the request's span, then logs the request once it has been
+// handled and records it in the audit log if it is a security event.
+func Logged(endpoint string, conf func() *config.Config, f func(http.ResponseWriter, *http.Request, *config.Config)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set(RequestIDHeader, id)
+		c := conf().ForRequest(id, r.RemoteAddr)
+		defer c.Done()
+		c.SetRequestContext(r.Context())
+		c.Request.Endpoint = endpoint
+		sw := &metrics.StatusWriter{ResponseWriter: w}
+		f(sw, r, c)
+		c.Request.Status = sw.Status()
+		c.Request.Outcome = metrics.Outcome(r, c.Request.Status)
+		c.Request.Duration = time.Since(start)
+		c.MFAServer.Loggers.Request(c.Request)
+		recordAuditEvent(c, r, c.Request)
+		tracing.SetAttributes(r.Context(), attribute.String("mfaserver.request_id", id), attribute.String("mfaserver.outcome", c.Request.Outcome))
+	}
+}
+
+// requestID returns the ID the client provided for the request if it is valid, otherwise a new random ID.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); validRequestID(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID checks the ID is safe to include in logs and response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, ch := range id {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-' || ch == '_' || ch == '.' || ch == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
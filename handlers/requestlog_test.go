@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogged(t *testing.T) {
+	logfile, err := ioutil.TempFile(os.TempDir(), "mfaserver-log")
+	if err != nil {
+		t.Fatalf("Error creating log file: %v", err)
+	}
+	defer os.Remove(logfile.Name())
+	c := config.NewConfig()
+	n := logfile.Name()
+	c.MFAServer.LogFilePath = &n
+	c.WithLogFormat(config.LogFormatJSON)
+	c.WithLogLevel("INFO")
+
+	var handled *config.Config
+	h := Logged("/test", func() *config.Config { return c }, func(w http.ResponseWriter, r *http.Request, rc *config.Config) {
+		handled = rc
+		rc.SetRequestUser("testapp", "test.com", "validuser")
+		rc.MFAServer.Loggers.Info.Printf("%s, Handling request", r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	var tests = []struct {
+		header  string
+		reuseID bool
+	}{
+		{"client-id_1.2:3", true},
+		{"", false},
+		{"id with spaces", false},
+		{"id\nX-Injected: true", false},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/test", nil)
+		if test.header != "" {
+			r.Header.Set(RequestIDHeader, test.header)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		id := w.Header().Get(RequestIDHeader)
+		if test.reuseID {
+			assert.Equal(t, test.header, id, "Request ID provided by the client not returned")
+		} else {
+			assert.Len(t, id, 32, "Generated request ID not as expected for header %q", test.header)
+		}
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "Status code not as expected")
+		assert.Equal(t, id, handled.Request.ID, "Request ID not passed to the handler's configuration")
+		assert.Nil(t, c.Request, "Request fields set on the loaded configuration")
+	}
+
+	b, _ := ioutil.ReadFile(n)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2*len(tests) {
+		t.Fatalf("Expected %d log entries, got %d: %s", 2*len(tests), len(lines), b)
+	}
+	var e map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatalf("Log entry is not valid JSON: %v", err)
+	}
+	assert.Equal(t, "client-id_1.2:3", e["request_id"], "Request ID not included in the handler's log entries")
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatalf("Request log entry is not valid JSON: %v", err)
+	}
+	for k, v := range map[string]interface{}{"request_id": "client-id_1.2:3", "remote_addr": "192.0.2.1:1234", "endpoint": "/test",
+		"issuer": "testapp", "domain": "test.com", "username": "validuser", "outcome": "unauthorized", "status": float64(401)} {
+		assert.Equal(t, v, e[k], "Request log field %s not as expected", k)
+	}
+}
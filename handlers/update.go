@@ -2,48 +2,34 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/metrics"
 	"net/http"
-	"net/url"
 )
 
 func Update(w http.ResponseWriter, r *http.Request, c *config.Config) {
-	data, err, HTTPCode := processValidateRequestData(r, false)
+	app, ok := checkApplication(w, r, c)
+	if !ok {
+		return
+	}
+	data, err, HTTPCode := processValidateRequestData(r, false, c)
 	setNoCacheHeaders(w)
 	if err != nil {
 		c.MFAServer.Loggers.Error.Println(err.Error())
-		w.WriteHeader(HTTPCode)
-		return
-	}
-	c.MFAServer.Loggers.Info.Printf("%s, OTP update request received for %s/%s\n", r.RemoteAddr, data.Domain, data.Username)
-
-	ok, HTTPCode := twoFactorAuthenticate(c, r, &data)
-	if !ok {
-		w.WriteHeader(HTTPCode)
-		d := messageResponseData{Message: "Cannot update user's secret as either 2FA failed or user has not been enroled"}
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		json.NewEncoder(w).Encode(d)
+		writeError(w, c, HTTPCode, ErrorInvalidRequest, requestErrorMessage(r, err))
 		return
 	}
-
-	udata := enrolRequestData{Username: data.Username,
-		Domain:   data.Domain,
-		Issuer:   data.Issuer,
-		Password: data.Password}
-	s, err := createAndStoreSecret(c, &udata)
-	if err != nil {
-		c.MFAServer.Loggers.Error.Printf("%s, OTP update failed for %s/%s whilst generating and storing secret: %v", r.RemoteAddr, data.Domain, data.Username, err)
-		w.WriteHeader(http.StatusInternalServerError)
+	s, aerr := update(c, r, app, &data)
+	if aerr != nil {
+		writeAPIError(w, c, aerr)
 		return
 	}
 
 	if r.Header.Get("Accept-Encoding") == "image/png" {
-		gAuthURL := fmt.Sprintf("otpauth://totp/%s:%s@%s?secret=%s&issuer=%s&algorithm=SHA1&digits=6&period=30", url.QueryEscape(data.Issuer), data.Username, data.Domain, s, url.QueryEscape(data.Issuer))
-		img, err := getQRCodeBytes(gAuthURL)
+		img, err := secretQRCode(&enrolRequestData{Issuer: data.Issuer, Domain: data.Domain, Username: data.Username}, s)
 		if err != nil {
 			c.MFAServer.Loggers.Error.Printf("%s, OTP update failed for %s/%s whilst generating QR code: %v", r.RemoteAddr, data.Domain, data.Username, err)
-			w.WriteHeader(http.StatusInternalServerError)
+			writeError(w, c, http.StatusInternalServerError, ErrorInternal, "The QR code could not be generated")
 			return
 		}
 		w.Header().Set("Content-Type", "image/png")
@@ -56,3 +42,28 @@ func Update(w http.ResponseWriter, r *http.Request, c *config.Config) {
 		}
 	}
 }
+
+// update replaces the secret of an enroled user once they have completed two factor authentication with the current one.
+func update(c *config.Config, r *http.Request, app *application, data *validateRequestData) (string, *apiError) {
+	c.SetRequestUser(data.Issuer, data.Domain, data.Username)
+	if !applicationPermitted(c, r, app, data.Issuer) {
+		return "", &apiError{http.StatusForbidden, ErrorForbidden, "The application is not permitted to use the issuer"}
+	}
+	c.MFAServer.Loggers.Info.Printf("%s, OTP update request received for %s/%s\n", r.RemoteAddr, data.Domain, data.Username)
+
+	if ok, HTTPCode := twoFactorAuthenticate(c, r, app, data); !ok {
+		return "", &apiError{HTTPCode, ErrorUnauthorized, "Cannot update user's secret as either 2FA failed or user has not been enroled"}
+	}
+
+	udata := enrolRequestData{Username: data.Username,
+		Domain:   data.Domain,
+		Issuer:   data.Issuer,
+		Password: data.Password}
+	s, err := createAndStoreSecret(c, &udata)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, OTP update failed for %s/%s whilst generating and storing secret: %v", r.RemoteAddr, data.Domain, data.Username, err)
+		metrics.SetOutcome(r, metrics.OutcomeStoreError)
+		return "", &apiError{http.StatusInternalServerError, ErrorInternal, "The user's secret could not be stored"}
+	}
+	return s, nil
+}
@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jcmturner/gootp"
+	"github.com/jcmturner/mfaserver/assertion"
 	"github.com/jcmturner/mfaserver/config"
-	"github.com/jcmturner/mfaserver/ldap"
+	"github.com/jcmturner/mfaserver/metrics"
 	"github.com/jcmturner/mfaserver/secrets"
+	"github.com/jcmturner/mfaserver/verifier"
 	"io"
 	"net/http"
 )
@@ -23,21 +25,44 @@ type validateRequestData struct {
 
 func ValidateOTP(w http.ResponseWriter, r *http.Request, c *config.Config) {
 	//Process the request data
-	data, err, HTTPCode := processValidateRequestData(r, false)
+	app, ok := checkApplication(w, r, c)
+	if !ok {
+		return
+	}
+	data, err, HTTPCode := processValidateRequestData(r, false, c)
 	setNoCacheHeaders(w)
 	if err != nil {
 		c.MFAServer.Loggers.Error.Println(err.Error())
-		w.WriteHeader(HTTPCode)
+		writeError(w, c, HTTPCode, ErrorInvalidRequest, requestErrorMessage(r, err))
+		return
+	}
+	if aerr := validate(c, r, app, &data); aerr != nil {
+		writeAPIError(w, c, aerr)
 		return
 	}
+	if wantsAssertion(r) {
+		writeAssertion(w, r, c, &data)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
+
+// validate checks the user's password and OTP.
+func validate(c *config.Config, r *http.Request, app *application, data *validateRequestData) *apiError {
+	c.SetRequestUser(data.Issuer, data.Domain, data.Username)
+	if !applicationPermitted(c, r, app, data.Issuer) {
+		return &apiError{http.StatusForbidden, ErrorForbidden, "The application is not permitted to use the issuer"}
+	}
 	c.MFAServer.Loggers.Info.Printf("%s, OTP vaidation request received for %s/%s", r.RemoteAddr, data.Domain, data.Username)
 
-	_, HTTPCode = twoFactorAuthenticate(c, r, &data)
-	w.WriteHeader(HTTPCode)
-	return
+	if ok, HTTPCode := twoFactorAuthenticate(c, r, app, data); !ok {
+		return &apiError{HTTPCode, ErrorUnauthorized, "The password or OTP is not valid"}
+	}
+	return nil
 }
 
-func processValidateRequestData(r *http.Request, admin bool) (validateRequestData, error, int) {
+func processValidateRequestData(r *http.Request, admin bool, c *config.Config) (validateRequestData, error, int) {
 	//Process the JSON body
 	var data validateRequestData
 	defer r.Body.Close()
@@ -49,20 +74,37 @@ func processValidateRequestData(r *http.Request, admin bool) (validateRequestDat
 		//We should fail safe
 		return data, errors.New(fmt.Sprintf("%s, Could not parse data posted from client : %v", r.RemoteAddr, err)), http.StatusBadRequest
 	}
+	if err := checkValidateRequestData(r, admin, c, &data); err != nil {
+		return data, err, http.StatusBadRequest
+	}
+	return data, nil, 0
+}
+
+// checkValidateRequestData checks that the request identifies the user and, unless it is made by an administrator,
+// has the user's credentials, whichever API it was made with.
+func checkValidateRequestData(r *http.Request, admin bool, c *config.Config, data *validateRequestData) error {
+	//A user authenticated by Kerberos is identified by their principal
+	if u, d, ok := negotiatedIdentity(c, r); ok {
+		data.Username = u
+		data.Domain = d
+	}
 	if data.Domain == "" || data.Username == "" || data.Issuer == "" {
-		return data, errors.New(fmt.Sprintf("%s, Could not extract values correctly from the validation request.", r.RemoteAddr)), http.StatusBadRequest
+		return errors.New(fmt.Sprintf("%s, Could not extract values correctly from the validation request.", r.RemoteAddr))
 	}
-	if !admin && (data.Password == "" || data.OTP == "") {
-		return data, errors.New(fmt.Sprintf("%s, Could not extract values correctly from the validation request.", r.RemoteAddr)), http.StatusBadRequest
+	if !admin && !hasUserCredentials(c, r, data) {
+		return errors.New(fmt.Sprintf("%s, Could not extract values correctly from the validation request.", r.RemoteAddr))
 	}
-	return data, nil, 0
+	return nil
 }
 
-func twoFactorAuthenticate(c *config.Config, r *http.Request, data *validateRequestData) (bool, int) {
+// twoFactorAuthenticate checks the user's password and OTP. The application is the calling application authenticated for
+// the request, which is trusted to have verified the password for issuers using delegated verification.
+func twoFactorAuthenticate(c *config.Config, r *http.Request, app *application, data *validateRequestData) (bool, int) {
 	//Check user password
-	err := ldap.Authenticate(data.Username, data.Password, c)
+	err := verifyPassword(c, r, app, data.Issuer, data.Domain, data.Username, data.Password)
 	if err != nil {
-		c.MFAServer.Loggers.Info.Printf("%s, OTP validation failed for %s/%s. LDAP authentication failed: %v", r.RemoteAddr, data.Domain, data.Username, err)
+		c.MFAServer.Loggers.Info.Printf("%s, OTP validation failed for %s/%s. Password verification failed: %v", r.RemoteAddr, data.Domain, data.Username, err)
+		metrics.SetOutcome(r, metrics.OutcomeLDAPFail)
 		return false, http.StatusUnauthorized
 	}
 
@@ -71,6 +113,7 @@ func twoFactorAuthenticate(c *config.Config, r *http.Request, data *validateRequ
 	if err != nil {
 		//We should fail safe
 		c.MFAServer.Loggers.Error.Printf("%s, Error during the validation of OTP for %s/%s : %v", r.RemoteAddr, data.Domain, data.Username, err)
+		metrics.SetOutcome(r, metrics.OutcomeStoreError)
 		return false, http.StatusUnauthorized
 	}
 	if ok {
@@ -81,10 +124,46 @@ func twoFactorAuthenticate(c *config.Config, r *http.Request, data *validateRequ
 
 	//Fail safe
 	c.MFAServer.Loggers.Info.Printf("%s, OTP validation failed for %s/%s", r.RemoteAddr, data.Domain, data.Username)
+	metrics.SetOutcome(r, metrics.OutcomeOTPFail)
 	//Respond with 401 to indicate the check failed
 	return false, http.StatusUnauthorized
 }
 
+// hasUserCredentials reports whether the request contains the credentials needed for the user's two factor authentication.
+func hasUserCredentials(c *config.Config, r *http.Request, data *validateRequestData) bool {
+	return data.OTP != "" && (data.Password != "" || !passwordRequired(c, r, data.Issuer))
+}
+
+// verifyPassword checks the first factor using the password verifier configured for the issuer.
+// If the user has already been authenticated by Kerberos the first factor has been satisfied.
+func verifyPassword(c *config.Config, r *http.Request, app *application, issuer, d, u, p string) error {
+	if _, _, ok := negotiatedIdentity(c, r); ok {
+		return nil
+	}
+	var a *config.ApplicationConf
+	if app != nil {
+		a = app.Conf
+	}
+	v, err := verifier.ForIssuer(c, issuer, a)
+	if err != nil {
+		return err
+	}
+	return v.Verify(r, d, u, p)
+}
+
+// authenticationMethods returns the authentication method references of the first factor verified by verifyPassword
+// and the OTP.
+func authenticationMethods(c *config.Config, r *http.Request, issuer string) []string {
+	if _, _, ok := negotiatedIdentity(c, r); ok {
+		return []string{assertion.AMRKerberos, assertion.AMROTP}
+	}
+	if c.Issuer(issuer).PasswordVerifier == config.VerifierDelegated {
+		//No password was checked by the MFA server
+		return []string{assertion.AMROTP, assertion.AMRDelegated}
+	}
+	return []string{assertion.AMRPassword, assertion.AMROTP}
+}
+
 func checkOTP(c *config.Config, data *validateRequestData) (bool, error) {
 	m, err := secrets.Read(c, "/"+data.Issuer+"/"+data.Domain+"/"+data.Username)
 	if err != nil || m == nil {
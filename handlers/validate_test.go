@@ -2,16 +2,24 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha1"
 	"fmt"
+	"github.com/jcmturner/goidentity/v6"
+	"github.com/jcmturner/gokrb5/v8/credentials"
 	"github.com/jcmturner/gootp"
+	"github.com/jcmturner/mfaserver/assertion"
 	"github.com/jcmturner/mfaserver/config"
 	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestValidateOTP(t *testing.T) {
@@ -75,3 +83,150 @@ func TestValidateOTP(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateOTPDelegated(t *testing.T) {
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	//Set up the MFA config
+	c := config.NewConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithIssuerDelegated("testapp")
+	c.WithApplication("trustedapp", []string{"testapikey"}, "", []string{"testapp", "otherapp"})
+	c.WithApplication("otherclient", []string{"otherkey"}, "", []string{"thirdapp"})
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ValidateOTP(w, r, c) }))
+	defer s.Close()
+
+	udata := enrolRequestData{Username: "validuser",
+		Domain: "testdom",
+		Issuer: "testapp"}
+
+	secret, _ := createAndStoreSecret(c, &udata)
+
+	var tests = []struct {
+		Application string
+		APIKey      string
+		Json        string
+		HttpCode    int
+	}{
+		{"trustedapp", "testapikey", `{"domain": "testdom", "username": "validuser", "issuer": "testapp", "otp": "%s"}`, http.StatusNoContent},
+		{"trustedapp", "testapikey", `{"domain": "testdom", "username": "validuser", "issuer": "testapp", "otp": "1234567"}`, http.StatusUnauthorized},
+		{"trustedapp", "invalidkey", `{"domain": "testdom", "username": "validuser", "issuer": "testapp", "otp": "%s"}`, http.StatusUnauthorized},
+		{"", "", `{"domain": "testdom", "username": "validuser", "issuer": "testapp", "otp": "%s"}`, http.StatusUnauthorized},
+		//Only applications permitted to use the issuer are trusted to have verified the password
+		{"otherclient", "otherkey", `{"domain": "testdom", "username": "validuser", "issuer": "testapp", "otp": "%s"}`, http.StatusForbidden},
+		{"trustedapp", "testapikey", `{"domain": "testdom", "username": "validuser", "issuer": "testapp"}`, http.StatusBadRequest},
+		//Password is still required for issuers not using delegated verification
+		{"trustedapp", "testapikey", `{"domain": "testdom", "username": "validuser", "issuer": "otherapp", "otp": "%s"}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		otp, _, _ := gootp.GetTOTPNow(secret, sha1.New, 6)
+		rdata := []byte(fmt.Sprintf(test.Json, otp))
+		r, err := http.NewRequest("POST", s.URL+"/validate", bytes.NewBuffer(rdata))
+		if err != nil {
+			t.Errorf("Error returned from creating request: %v", err)
+		}
+		if test.Application != "" {
+			r.Header.Set(ApplicationHeader, test.Application)
+			r.Header.Set(ApplicationKeyHeader, test.APIKey)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("Error returned from sending request: %v", err)
+		}
+		if resp.StatusCode != test.HttpCode {
+			t.Errorf("Expected code %v, got %v for post data %v", test.HttpCode, resp.StatusCode, test.Json)
+		}
+	}
+}
+
+func TestValidateOTPAssertion(t *testing.T) {
+	//Set up mock Vault instance
+	ln, addr, appID, userID := testtools.RunMockVault(t)
+	defer ln.Close()
+
+	//Set up the MFA config
+	c := config.NewConfig()
+	c.WithVaultAppIdWrite(appID).WithVaultAppIdRead(appID).WithVaultUserId(userID).WithVaultEndPoint(addr)
+	c.WithIssuerDelegated("testapp")
+	c.WithApplication("trustedapp", []string{"testapikey"}, "", []string{"testapp"})
+	_, p, _ := ed25519.GenerateKey(rand.Reader)
+	k, _ := assertion.NewKey(p)
+	c.WithAssertionKey(k).WithAssertionIssuer("https://mfa.test", time.Minute)
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ValidateOTP(w, r, c) }))
+	defer s.Close()
+
+	udata := enrolRequestData{Username: "validuser",
+		Domain: "testdom",
+		Issuer: "testapp"}
+	secret, _ := createAndStoreSecret(c, &udata)
+
+	var tests = []struct {
+		Accept   string
+		OTP      string
+		HttpCode int
+	}{
+		{"application/jwt", "", http.StatusOK},
+		{"", "", http.StatusNoContent},
+		{"application/jwt", "1234567", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		otp := test.OTP
+		if otp == "" {
+			otp, _, _ = gootp.GetTOTPNow(secret, sha1.New, 6)
+		}
+		rdata := []byte(fmt.Sprintf(`{"domain": "testdom", "username": "validuser", "issuer": "testapp", "otp": "%s"}`, otp))
+		r, _ := http.NewRequest("POST", s.URL+"/validate", bytes.NewBuffer(rdata))
+		r.Header.Set(ApplicationHeader, "trustedapp")
+		r.Header.Set(ApplicationKeyHeader, "testapikey")
+		if test.Accept != "" {
+			r.Header.Set("Accept", test.Accept)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Error returned from sending request: %v", err)
+		}
+		if resp.StatusCode != test.HttpCode {
+			t.Errorf("Expected code %v, got %v for Accept %v", test.HttpCode, resp.StatusCode, test.Accept)
+		}
+		if resp.StatusCode == http.StatusOK {
+			b, _ := ioutil.ReadAll(resp.Body)
+			var claims assertion.Claims
+			err := assertion.Verify(string(b), assertion.NewJSONWebKeySet([]*assertion.Key{k}), &claims)
+			if err != nil {
+				t.Fatalf("Assertion returned could not be verified: %v", err)
+			}
+			if claims.Issuer != "https://mfa.test" || claims.Audience != "testapp" || claims.Domain != "testdom" || claims.Subject != "validuser@testdom" {
+				t.Errorf("Assertion claims not as expected: %+v", claims)
+			}
+			//The password was verified by the application, not the MFA server
+			assert.Equal(t, []string{"otp", "delegated"}, claims.AMR, "Authentication methods not as expected")
+		}
+	}
+}
+
+func TestAuthenticationMethods(t *testing.T) {
+	c := config.NewConfig()
+	c.WithIssuerDelegated("delegatedapp")
+	c.WithKerberosRealmDomain(testRealm, "testdom")
+	r, _ := http.NewRequest("POST", "/validate", nil)
+	cred := credentials.New("validuser", testRealm)
+	cred.SetAuthenticated(true)
+	kr := goidentity.AddToHTTPRequestContext(cred, r)
+
+	assert.Equal(t, []string{"pwd", "otp"}, authenticationMethods(c, r, "testapp"), "Password verified by LDAP")
+	assert.Equal(t, []string{"otp", "delegated"}, authenticationMethods(c, r, "delegatedapp"), "Password verified by the application")
+	assert.Equal(t, []string{"kerberos", "otp"}, authenticationMethods(c, kr, "testapp"), "Authenticated by Kerberos")
+	assert.Equal(t, []string{"kerberos", "otp"}, authenticationMethods(c, kr, "delegatedapp"), "Authenticated by Kerberos for a delegated issuer")
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/webhook"
+	"io"
+	"net/http"
+)
+
+// Deliveries returned from the delivery log if no limit is requested, and the most that can be
+const (
+	defaultDeliveriesLimit = 100
+	maxDeliveriesLimit     = 1000
+)
+
+type webhookDeliveriesRequestData struct {
+	Domain       string `json:"domain"`
+	Subscription string `json:"subscription"`
+	Status       string `json:"status"`
+	Limit        int    `json:"limit"`
+}
+
+type webhookDeliveriesResponseData struct {
+	Deliveries []webhook.Delivery `json:"deliveries"`
+}
+
+// WebhookDeliveries returns the webhook deliveries of lifecycle events for users in a domain, the most recent first.
+func WebhookDeliveries(w http.ResponseWriter, r *http.Request, c *config.Config) {
+	app, ok := checkApplication(w, r, c)
+	if !ok {
+		return
+	}
+	data, err, HTTPCode := processWebhookDeliveriesRequestData(r)
+	setNoCacheHeaders(w)
+	if err != nil {
+		c.MFAServer.Loggers.Error.Println(err.Error())
+		writeError(w, c, HTTPCode, ErrorInvalidRequest, requestErrorMessage(r, err))
+		return
+	}
+	ds, aerr := webhookDeliveries(c, r, app, &data)
+	if aerr != nil {
+		writeAPIError(w, c, aerr)
+		return
+	}
+	d := webhookDeliveriesResponseData{Deliveries: ds}
+	if d.Deliveries == nil {
+		d.Deliveries = []webhook.Delivery{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		c.MFAServer.Loggers.Error.Printf("%s, Webhook deliveries for %s failed whilst returning body data: %v", r.RemoteAddr, data.Domain, err)
+	}
+}
+
+// webhookDeliveries returns the deliveries matching the request if it was made by an administrator permitted to manage
+// the domain. Only the deliveries of events for the issuers the application is permitted to use are returned.
+func webhookDeliveries(c *config.Config, r *http.Request, app *application, data *webhookDeliveriesRequestData) ([]webhook.Delivery, *apiError) {
+	c.SetRequestUser("", data.Domain, "")
+	if !applicationPermitted(c, r, app, "") {
+		return nil, &apiError{http.StatusUnauthorized, ErrorUnauthorized, "The application could not be authenticated"}
+	}
+	if !checkAdminAuth(c, r, config.PermissionManage, "", data.Domain) {
+		return nil, &apiError{http.StatusUnauthorized, ErrorUnauthorized, "The administrator is not authorised to manage the domain"}
+	}
+	if c.Webhooks.Dispatcher == nil {
+		return nil, &apiError{http.StatusNotFound, ErrorNotFound, "No webhooks are configured"}
+	}
+	f := webhook.Filter{
+		Subscription: data.Subscription,
+		Status:       data.Status,
+		Domain:       data.Domain,
+		Limit:        data.Limit,
+	}
+	if c.ApplicationAuthRequired() {
+		f.Issuer = app.Conf.IssuerPermitted
+	}
+	return c.Webhooks.Dispatcher.Deliveries(f), nil
+}
+
+func processWebhookDeliveriesRequestData(r *http.Request) (webhookDeliveriesRequestData, error, int) {
+	var data webhookDeliveriesRequestData
+	defer r.Body.Close()
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1024))
+	err := dec.Decode(&data)
+	if err != nil {
+		return data, errors.New(fmt.Sprintf("%s, Could not parse data posted from client to the webhook deliveries api : %v", r.RemoteAddr, err)), http.StatusBadRequest
+	}
+	if err := checkWebhookDeliveriesRequestData(r, &data); err != nil {
+		return data, err, http.StatusBadRequest
+	}
+	return data, nil, 0
+}
+
+// checkWebhookDeliveriesRequestData checks the values of the webhook deliveries request, defaulting the limit if none
+// was requested.
+func checkWebhookDeliveriesRequestData(r *http.Request, data *webhookDeliveriesRequestData) error {
+	if data.Domain == "" {
+		return errors.New(fmt.Sprintf("%s, Could not extract values correctly from the webhook deliveries request.", r.RemoteAddr))
+	}
+	switch data.Status {
+	case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusFailed:
+	default:
+		return errors.New(fmt.Sprintf("%s, Unknown status %s in the webhook deliveries request.", r.RemoteAddr, data.Status))
+	}
+	if data.Limit < 0 || data.Limit > maxDeliveriesLimit {
+		return errors.New(fmt.Sprintf("%s, Limit in the webhook deliveries request must be between 0 and %d.", r.RemoteAddr, maxDeliveriesLimit))
+	}
+	if data.Limit == 0 {
+		data.Limit = defaultDeliveriesLimit
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/jcmturner/mfaserver/webhook"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-webhook")
+	defer os.RemoveAll(dir)
+	//The subscriber is not listening so deliveries remain pending
+	sub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	sub.Close()
+
+	//Set up the MFA config
+	c := config.NewConfig()
+	c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+	c.WithLDAPAdminSettings("cn=mfaadmin,ou=groups,dc=example,dc=com", "memberUid", "{username}")
+	c.MFAServer.Loggers.Debug = log.New(os.Stdout, "MFA Debug: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Info = log.New(os.Stdout, "MFA Info: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Warning = log.New(os.Stdout, "MFA Warn: ", log.Ldate|log.Ltime|log.Lshortfile)
+	c.MFAServer.Loggers.Error = log.New(os.Stderr, "MFA Error: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/webhooks/deliveries", func(w http.ResponseWriter, r *http.Request) { WebhookDeliveries(w, r, c) })
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	var tests = []struct {
+		AdminUser     string
+		AdminPassword string
+		Json          string
+		HttpCode      int
+		Deliveries    int
+	}{
+		//No webhooks configured
+		{"validuser", "validpassword", `{"domain": "testdom"}`, http.StatusNotFound, 0},
+		{"validuser", "validpassword", `{"domain": "testdom"}`, http.StatusOK, 2},
+		{"validuser", "validpassword", `{"domain": "testdom", "limit": 1}`, http.StatusOK, 1},
+		{"validuser", "validpassword", `{"domain": "testdom", "status": "delivered"}`, http.StatusOK, 0},
+		{"validuser", "validpassword", `{"domain": "otherdom"}`, http.StatusOK, 0},
+		{"validuser", "validpassword", `{"domain": "testdom", "status": "lost"}`, http.StatusBadRequest, 0},
+		{"validuser", "validpassword", `{"domain": "testdom", "limit": 5000}`, http.StatusBadRequest, 0},
+		{"validuser", "validpassword", `{"status": "pending"}`, http.StatusBadRequest, 0},
+		{"validuser", "invalidpassword", `{"domain": "testdom"}`, http.StatusUnauthorized, 0},
+		{"", "", `{"domain": "testdom"}`, http.StatusUnauthorized, 0},
+	}
+	for i, test := range tests {
+		if i == 1 {
+			c.WithWebhookOutbox(filepath.Join(dir, "outbox"))
+			if _, err := c.WithWebhookSubscription("tickets", sub.URL, "0123456789abcdef", nil); err != nil {
+				t.Fatalf("Error adding webhook subscription: %v", err)
+			}
+			defer c.CloseWebhooks()
+			for _, u := range []string{"validuser", "otheruser"} {
+				e := webhook.NewEvent(webhook.EventEnrolled)
+				e.Issuer = "testapp"
+				e.Domain = "testdom"
+				e.Username = u
+				c.Webhooks.Dispatcher.Send(e)
+			}
+		}
+		r, err := http.NewRequest("POST", s.URL+"/admin/webhooks/deliveries", bytes.NewBuffer([]byte(test.Json)))
+		if err != nil {
+			t.Errorf("Error returned from creating request: %v", err)
+		}
+		if test.AdminUser != "" {
+			r.SetBasicAuth(test.AdminUser, test.AdminPassword)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("Error returned from sending request: %v", err)
+		}
+		if resp.StatusCode != test.HttpCode {
+			t.Errorf("Expected code %v, got %v for post data %v", test.HttpCode, resp.StatusCode, test.Json)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var j webhookDeliveriesResponseData
+			err = json.NewDecoder(resp.Body).Decode(&j)
+			if err != nil {
+				t.Errorf("Failed to marshal the response into the JSON object: %v", err)
+			}
+			assert.Len(t, j.Deliveries, test.Deliveries, "Number of deliveries not as expected for post data %v", test.Json)
+			for _, d := range j.Deliveries {
+				assert.Equal(t, "tickets", d.Subscription, "Subscription of delivery not as expected")
+				assert.Equal(t, "testdom", d.Event.Domain, "Domain of delivery not as expected")
+			}
+		}
+		resp.Body.Close()
+	}
+
+	//Applications only see the deliveries of events for the issuers they are permitted to use
+	e := webhook.NewEvent(webhook.EventEnrolled)
+	e.Issuer = "otherapp"
+	e.Domain = "testdom"
+	e.Username = "validuser"
+	c.Webhooks.Dispatcher.Send(e)
+	c.WithApplication("app1", []string{"app1key"}, "", []string{"testapp"})
+	c.WithApplication("app2", []string{"app2key"}, "", []string{"otherapp"})
+	var appTests = []struct {
+		App        string
+		Key        string
+		HttpCode   int
+		Deliveries int
+		Issuer     string
+	}{
+		{"app1", "app1key", http.StatusOK, 2, "testapp"},
+		{"app2", "app2key", http.StatusOK, 1, "otherapp"},
+		{"app2", "wrongkey", http.StatusUnauthorized, 0, ""},
+		{"", "", http.StatusUnauthorized, 0, ""},
+	}
+	for _, test := range appTests {
+		r, _ := http.NewRequest("POST", s.URL+"/admin/webhooks/deliveries", bytes.NewBuffer([]byte(`{"domain": "testdom"}`)))
+		r.SetBasicAuth("validuser", "validpassword")
+		if test.App != "" {
+			r.Header.Set(ApplicationHeader, test.App)
+			r.Header.Set(ApplicationKeyHeader, test.Key)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Error returned from sending request: %v", err)
+		}
+		assert.Equal(t, test.HttpCode, resp.StatusCode, "Response code not as expected for application %s", test.App)
+		if resp.StatusCode == http.StatusOK {
+			var j webhookDeliveriesResponseData
+			json.NewDecoder(resp.Body).Decode(&j)
+			assert.Len(t, j.Deliveries, test.Deliveries, "Number of deliveries not as expected for application %s", test.App)
+			for _, d := range j.Deliveries {
+				assert.Equal(t, test.Issuer, d.Event.Issuer, "Delivery for an issuer the application is not permitted to use returned")
+			}
+		}
+		resp.Body.Close()
+	}
+}
@@ -1,50 +1,256 @@
 package ldap
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/metrics"
+	"github.com/jcmturner/mfaserver/tracing"
 	"github.com/mavricknz/ldap"
+	"go.opentelemetry.io/otel/attribute"
+	"net"
 	"strings"
+	"time"
 )
 
-func Authenticate(u, p string, c *config.Config) error {
-	err := c.LDAP.LDAPConnection.Connect()
+func Authenticate(d, u, p string, c *config.Config) (err error) {
+	ctx, span := tracing.Start(c.Context(), "ldap.Authenticate", attribute.String("ldap.domain", d))
+	defer func() { tracing.End(span, err) }()
+	l, err := c.LDAPForDomain(d)
 	if err != nil {
 		return err
 	}
-	u = strings.Replace(*c.LDAP.UserDN, "{username}", u, -1)
-	//defer c.LDAP.LDAPConnection.Close()
-	err = c.LDAP.LDAPConnection.Bind(u, p)
-	return err
+	defer metrics.LDAPInUse(*l.EndPoint)()
+	return bind(ctx, c, l, strings.Replace(*l.UserDN, "{username}", u, -1), p)
 }
 
-func AdminAuthorise(u, p string, c *config.Config) error {
-	var attributes []string = []string{*c.LDAP.AdminMembershipAttr}
-	m := strings.Replace(*c.LDAP.AdminMemberUserDN, "{username}", u, -1)
-	f := fmt.Sprintf("(%s=%s)", *c.LDAP.AdminMembershipAttr, m)
-	r := ldap.NewSimpleSearchRequest(*c.LDAP.AdminGroupDN, ldap.ScopeBaseObject, f, attributes)
-
-	err := c.LDAP.LDAPConnection.Connect()
+// bind connects to the LDAP directory and binds as the user, recording how long this takes.
+func bind(ctx context.Context, c *config.Config, l *config.LDAPConf, dn, p string) (err error) {
+	c.MFAServer.Loggers.Debug.Printf("Binding to LDAP at %s as %s", *l.EndPoint, dn)
+	_, span := tracing.Start(ctx, "ldap.bind", attribute.String("ldap.endpoint", *l.EndPoint))
+	defer func(start time.Time) {
+		metrics.ObserveLDAPBind(start, err)
+		tracing.End(span, err)
+		c.MFAServer.Loggers.Debug.Printf("LDAP bind to %s as %s completed in %v: %v", *l.EndPoint, dn, time.Since(start), err)
+	}(time.Now())
+	err = l.LDAPConnection.Connect()
 	if err != nil {
 		return err
 	}
+	//defer l.LDAPConnection.Close()
+	return l.LDAPConnection.Bind(dn, p)
+}
+
+// Object identifier of the Active Directory LDAP_MATCHING_RULE_IN_CHAIN matching rule which resolves nested group membership
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
 
-	err = c.LDAP.LDAPConnection.Bind(u, p)
+// Limit on how deep nested groups are followed when resolving membership recursively
+const maxNestedGroupDepth = 10
+
+func AdminAuthorise(d, u, p string, c *config.Config) (err error) {
+	ctx, span := tracing.Start(c.Context(), "ldap.AdminAuthorise", attribute.String("ldap.domain", d))
+	defer func() { tracing.End(span, err) }()
+	l, err := c.LDAPForDomain(d)
 	if err != nil {
 		return err
 	}
+	m, err := memberGroups(ctx, d, u, p, l.AdminGroups(), c)
+	if err != nil {
+		return err
+	}
+	if len(m) == 0 {
+		return errors.New("Admin authorisation failed.")
+	}
+	return nil
+}
 
-	sr, err := c.LDAP.LDAPConnection.Search(r)
+// MemberGroups authenticates the user, binding with the username as provided, and returns which of the groups provided they are a member of.
+func MemberGroups(d, u, p string, groups []string, c *config.Config) (member []string, err error) {
+	ctx, span := tracing.Start(c.Context(), "ldap.MemberGroups", attribute.String("ldap.domain", d))
+	defer func() { tracing.End(span, err) }()
+	return memberGroups(ctx, d, u, p, groups, c)
+}
+
+func memberGroups(ctx context.Context, d, u, p string, groups []string, c *config.Config) ([]string, error) {
+	l, err := c.LDAPForDomain(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 || l.AdminMemberUserDN == nil {
+		return nil, errors.New("No administrator groups configured for domain " + d)
+	}
+	if l.AdminMemberOfAttr == nil && l.AdminMembershipAttr == nil {
+		return nil, errors.New("No administrator group membership attribute configured for domain " + d)
+	}
+	m := strings.Replace(*l.AdminMemberUserDN, "{username}", u, -1)
+
+	defer metrics.LDAPInUse(*l.EndPoint)()
+	//Administrators bind with the username exactly as provided, such as a full DN or UPN
+	err = bind(ctx, c, l, u, p)
+	if err != nil {
+		return nil, err
+	}
+
+	//Only the membership decisions are cached, the bind above must always succeed first
+	key := cacheKey(l, m)
+	if ac := c.AdminCache.Cache; ac != nil {
+		if member, ok := ac.Get(key, groups); ok {
+			tracing.SetAttributes(ctx, attribute.Bool("mfaserver.admin_cache_hit", true))
+			return member, nil
+		}
+	}
+
+	//A group that cannot be checked does not prevent the remaining groups being checked
+	var member []string
+	var gerr error
+	for _, g := range groups {
+		ok, err := isAdminGroupMember(l, g, m)
+		if err != nil {
+			gerr = err
+			continue
+		}
+		if ok {
+			member = append(member, g)
+		}
+	}
+	if len(member) == 0 && gerr != nil {
+		return nil, errors.New("Admin authorisation failed: " + gerr.Error())
+	}
+	if ac := c.AdminCache.Cache; ac != nil && gerr == nil {
+		ac.Set(key, groups, member)
+	}
+	return member, nil
+}
+
+// InvalidateAdminCache removes the cached group membership decisions for the user.
+// If no username is provided the cached decisions for all users of the domain's directory are removed.
+func InvalidateAdminCache(d, u string, c *config.Config) error {
+	if c.AdminCache.Cache == nil {
+		return errors.New("The admin group cache is not enabled")
+	}
+	l, err := c.LDAPForDomain(d)
 	if err != nil {
 		return err
 	}
+	if u == "" {
+		c.AdminCache.Cache.InvalidatePrefix(cacheKey(l, ""))
+		return nil
+	}
+	if l.AdminMemberUserDN == nil {
+		return errors.New("No administrator groups configured for domain " + d)
+	}
+	c.AdminCache.Cache.Invalidate(cacheKey(l, strings.Replace(*l.AdminMemberUserDN, "{username}", u, -1)))
+	return nil
+}
+
+// cacheKey qualifies the user DN with the directory's endpoint as the same DN could exist in more than one directory.
+func cacheKey(l *config.LDAPConf, dn string) string {
+	return *l.EndPoint + " " + dn
+}
+
+func isAdminGroupMember(l *config.LDAPConf, g, m string) (bool, error) {
+	recursive := l.AdminNestedGroups == config.NestedGroupsRecursive
+	if l.AdminMemberOfAttr != nil {
+		//Check the groups listed on the user's own entry
+		if l.AdminNestedGroups == config.NestedGroupsInChain {
+			f := fmt.Sprintf("(%s:%s:=%s)", *l.AdminMemberOfAttr, matchingRuleInChain, escapeFilterValue(g))
+			return entryExists(l.LDAPConnection, m, f)
+		}
+		return hasValue(l.LDAPConnection, m, *l.AdminMemberOfAttr, g, recursive, make(map[string]bool), 0)
+	}
+	//Check the members listed on the group's entry
+	if l.AdminNestedGroups == config.NestedGroupsInChain {
+		f := fmt.Sprintf("(%s:%s:=%s)", *l.AdminMembershipAttr, matchingRuleInChain, escapeFilterValue(m))
+		return entryExists(l.LDAPConnection, g, f)
+	}
+	return hasValue(l.LDAPConnection, g, *l.AdminMembershipAttr, m, recursive, make(map[string]bool), 0)
+}
 
-	members := sr.Entries[0].GetAttributeValues(*c.LDAP.AdminMembershipAttr)
-	for _, b := range members {
-		if b == m {
-			return nil
+// hasValue reports whether the target is one of the values of the attribute on the entry with the DN given.
+// If recursive, values that are themselves DNs are followed to resolve membership through nested groups.
+func hasValue(conn *ldap.LDAPConnection, dn, attr, target string, recursive bool, visited map[string]bool, depth int) (bool, error) {
+	visited[strings.ToLower(dn)] = true
+	values, err := attributeValues(conn, dn, attr)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, target) {
+			return true, nil
 		}
 	}
-	return errors.New("Admin authorisation failed.")
+	if !recursive || depth >= maxNestedGroupDepth {
+		return false, nil
+	}
+	for _, v := range values {
+		if !strings.Contains(v, "=") || visited[strings.ToLower(v)] {
+			continue
+		}
+		//Values that cannot be looked up are not groups so failures here are not fatal
+		if ok, _ := hasValue(conn, v, attr, target, recursive, visited, depth+1); ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func attributeValues(conn *ldap.LDAPConnection, dn, attr string) ([]string, error) {
+	r := ldap.NewSimpleSearchRequest(dn, ldap.ScopeBaseObject, "(objectClass=*)", []string{attr})
+	sr, err := conn.Search(r)
+	if err != nil {
+		return nil, err
+	}
+	//Fail safe if the entry is not found
+	if sr == nil || len(sr.Entries) == 0 {
+		return nil, nil
+	}
+	return sr.Entries[0].GetAttributeValues(attr), nil
+}
+
+func entryExists(conn *ldap.LDAPConnection, dn, f string) (bool, error) {
+	r := ldap.NewSimpleSearchRequest(dn, ldap.ScopeBaseObject, f, []string{"1.1"})
+	sr, err := conn.Search(r)
+	if err != nil {
+		return false, err
+	}
+	return sr != nil && len(sr.Entries) > 0, nil
+}
+
+// escapeFilterValue escapes the characters that are special in an LDAP search filter as defined by RFC 4515.
+func escapeFilterValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", v[i])
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	return b.String()
+}
+
+// Time allowed to connect to an LDAP directory when checking it is available
+const checkConnectionTimeout = 5 * time.Second
+
+// CheckConnection reports whether the LDAP directory accepts a connection, including the TLS handshake for ldaps://.
+// A new connection is made so that the connection shared by requests is not disturbed.
+func CheckConnection(l *config.LDAPConf) error {
+	if l == nil || l.LDAPConnection == nil {
+		return errors.New("No LDAP connection configured")
+	}
+	d := &net.Dialer{Timeout: checkConnectionTimeout}
+	var conn net.Conn
+	var err error
+	if l.LDAPConnection.IsTLS {
+		conn, err = tls.DialWithDialer(d, "tcp", l.LDAPConnection.Addr, l.LDAPConnection.TlsConfig)
+	} else {
+		conn, err = d.Dial("tcp", l.LDAPConnection.Addr)
+	}
+	if err != nil {
+		return errors.New("Could not connect to LDAP at " + l.LDAPConnection.Addr + ": " + err.Error())
+	}
+	return conn.Close()
 }
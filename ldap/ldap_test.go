@@ -0,0 +1,84 @@
+package ldap
+
+import (
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/testtools"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAdminAuthorise(t *testing.T) {
+	//Set up mock LDAP server
+	l := testtools.NewLDAPServer(t)
+	defer l.Stop()
+
+	var tests = []struct {
+		Description string
+		Groups      []string
+		MemberAttr  string
+		MemberOf    bool
+		MemberDN    string
+		Nested      string
+		Password    string
+		Authorised  bool
+	}{
+		{"Direct member", []string{"cn=mfaadmin,ou=groups,dc=example,dc=com"}, "memberUid", false, "{username}", config.NestedGroupsNone, "validpassword", true},
+		{"Invalid password", []string{"cn=mfaadmin,ou=groups,dc=example,dc=com"}, "memberUid", false, "{username}", config.NestedGroupsNone, "invalidpassword", false},
+		{"No entries returned", []string{"cn=emptygroup,ou=groups,dc=example,dc=com"}, "memberUid", false, "{username}", config.NestedGroupsNone, "validpassword", false},
+		{"Member of second group", []string{"cn=emptygroup,ou=groups,dc=example,dc=com", "cn=mfaadmin,ou=groups,dc=example,dc=com"}, "memberUid", false, "{username}", config.NestedGroupsNone, "validpassword", true},
+		{"Nested member not resolved", []string{"cn=parentadmin,ou=groups,dc=example,dc=com"}, "member", false, "cn={username},ou=users,dc=example,dc=com", config.NestedGroupsNone, "validpassword", false},
+		{"Nested member resolved recursively", []string{"cn=parentadmin,ou=groups,dc=example,dc=com"}, "member", false, "cn={username},ou=users,dc=example,dc=com", config.NestedGroupsRecursive, "validpassword", true},
+		{"Nested member resolved in chain", []string{"cn=parentadmin,ou=groups,dc=example,dc=com"}, "member", false, "cn={username},ou=users,dc=example,dc=com", config.NestedGroupsInChain, "validpassword", true},
+		{"No entries returned in chain", []string{"cn=emptygroup,ou=groups,dc=example,dc=com"}, "member", false, "cn={username},ou=users,dc=example,dc=com", config.NestedGroupsInChain, "validpassword", false},
+		{"Nested member not in chain", []string{"cn=parentadmin,ou=groups,dc=example,dc=com"}, "member", false, "cn={username},ou=contractors,dc=example,dc=com", config.NestedGroupsInChain, "validpassword", false},
+		{"User memberOf", []string{"cn=mfaadmin,ou=groups,dc=example,dc=com"}, "memberOf", true, "cn={username},ou=users,dc=example,dc=com", config.NestedGroupsNone, "validpassword", true},
+		{"User memberOf nested not resolved", []string{"cn=parentadmin,ou=groups,dc=example,dc=com"}, "memberOf", true, "cn={username},ou=users,dc=example,dc=com", config.NestedGroupsNone, "validpassword", false},
+		{"User memberOf nested resolved recursively", []string{"cn=parentadmin,ou=groups,dc=example,dc=com"}, "memberOf", true, "cn={username},ou=users,dc=example,dc=com", config.NestedGroupsRecursive, "validpassword", true},
+		{"User memberOf nested resolved in chain", []string{"cn=parentadmin,ou=groups,dc=example,dc=com"}, "memberOf", true, "cn={username},ou=users,dc=example,dc=com", config.NestedGroupsInChain, "validpassword", true},
+		{"User memberOf not in chain", []string{"cn=othergroup,ou=groups,dc=example,dc=com"}, "memberOf", true, "cn={username},ou=users,dc=example,dc=com", config.NestedGroupsInChain, "validpassword", false},
+	}
+	for _, test := range tests {
+		c := config.NewConfig()
+		c.WithLDAPConnection("ldap://"+l.Listener.Addr().String(), "", "{username}")
+		groups := test.Groups
+		if test.MemberOf {
+			c.WithLDAPAdminMemberOf(test.MemberAttr, test.MemberDN)
+		} else {
+			c.WithLDAPAdminSettings(groups[0], test.MemberAttr, test.MemberDN)
+			groups = groups[1:]
+		}
+		_, err := c.WithLDAPAdminGroups(groups, test.Nested)
+		if err != nil {
+			t.Fatalf("Error configuring admin groups: %v", err)
+		}
+		err = AdminAuthorise("testdom", "validuser", test.Password, c)
+		if test.Authorised && err != nil {
+			t.Errorf("%s: expected admin authorisation to pass, got error: %v", test.Description, err)
+		}
+		if !test.Authorised && err == nil {
+			t.Errorf("%s: expected admin authorisation to fail", test.Description)
+		}
+	}
+}
+
+func TestInvalidateAdminCache(t *testing.T) {
+	c := config.NewConfig()
+	c.WithLDAPDomain("testdom", "ldap://127.0.0.1:389", "", "{username}")
+	c.WithLDAPDomain("otherdom", "ldap://127.0.0.2:389", "", "{username}")
+	c.WithAdminGroupCache(time.Minute, time.Minute, 10)
+	groups := []string{"cn=mfaadmin,ou=groups,dc=example,dc=com"}
+	for _, d := range []string{"testdom", "otherdom"} {
+		l, _ := c.LDAPForDomain(d)
+		c.AdminCache.Cache.Set(cacheKey(l, "validuser"), groups, groups)
+	}
+	//Only the cached decisions of the domain's directory are removed
+	if err := InvalidateAdminCache("testdom", "", c); err != nil {
+		t.Fatalf("Error invalidating the admin group cache: %v", err)
+	}
+	assert.Equal(t, 1, c.AdminCache.Cache.Stats().Entries, "Cached decisions of other directories removed")
+	l, _ := c.LDAPForDomain("otherdom")
+	_, ok := c.AdminCache.Cache.Get(cacheKey(l, "validuser"), groups)
+	assert.True(t, ok, "Cached decision of another directory removed")
+	assert.Error(t, InvalidateAdminCache("unknowndom", "", c), "Unknown domain not rejected")
+}
@@ -1,41 +1,113 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"github.com/jcmturner/mfaserver/config"
 	"github.com/jcmturner/mfaserver/handlers"
+	"github.com/jcmturner/mfaserver/metrics"
+	"github.com/jcmturner/mfaserver/secrets"
+	"github.com/jcmturner/mfaserver/tracing"
 	"github.com/jcmturner/mfaserver/version"
+	"google.golang.org/grpc"
+	"layeh.com/radius"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"os/user"
+	"syscall"
+	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(auditCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
 	//Locate config file
 	usr, _ := user.Current()
 	dir := usr.HomeDir
 	configPath := flag.String("config", dir+"/mfaserver-config.json", "Specify the path to the configuration file")
+	watch := flag.Duration("watch", 0, "Check the configuration file and TLS certificate for changes at this interval and reload them. 0 disables checking, the configuration is still reloaded on SIGHUP")
+	flag.Parse()
 	//Load config
-	c, err := config.Load(*configPath)
+	h, err := config.NewHolder(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to configure MFA Server: %v\n", err)
 	}
+	c := h.Config()
+	stopTracing, err := c.StartTracing()
+	if err != nil {
+		log.Fatalf("Failed to configure tracing: %v\n", err)
+	}
 
-	//Set up handlers
+	//Set up handlers. The current configuration is passed to each request so that it can be reloaded.
 	mux := http.NewServeMux()
-	mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
-		handlers.ValidateOTP(w, r, c)
-	})
-	mux.HandleFunc("/enrol", func(w http.ResponseWriter, r *http.Request) {
-		handlers.Enrol(w, r, c)
-	})
-	mux.HandleFunc("/update", func(w http.ResponseWriter, r *http.Request) {
-		handlers.Update(w, r, c)
-	})
-	mux.HandleFunc("/delete", func(w http.ResponseWriter, r *http.Request) {
-		handlers.DeleteOTP(w, r, c)
+	handle := func(pattern string, f handlers.HandlerFunc) {
+		mux.HandleFunc(pattern, metrics.Instrument(pattern, tracing.Middleware(pattern, handlers.Logged(pattern, h.Config, f))))
+	}
+	negotiate := func(f handlers.HandlerFunc) handlers.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, c *config.Config) {
+			handlers.Negotiate(c, func(w http.ResponseWriter, r *http.Request) {
+				f(w, r, c)
+			})(w, r)
+		}
+	}
+	//The REST API is served under its version prefix and, deprecated, at the original paths
+	api := func(path string, f handlers.HandlerFunc) {
+		f = handlers.Methods(f, "POST")
+		handle(handlers.APIVersionPrefix+path, f)
+		handle(path, handlers.Deprecated(handlers.APIVersionPrefix+path, f))
+	}
+	api("/validate", negotiate(handlers.ValidateOTP))
+	api("/enrol", negotiate(handlers.Enrol))
+	api("/update", handlers.Update)
+	api("/delete", handlers.DeleteOTP)
+	api("/list", handlers.ListUsers)
+	api("/admin/cache/invalidate", handlers.AdminCacheInvalidate)
+	api("/admin/cache/stats", handlers.AdminCacheStats)
+	api("/admin/webhooks/deliveries", handlers.WebhookDeliveries)
+	handle(handlers.OpenAPIPath, handlers.Methods(handlers.OpenAPI, "GET", "HEAD"))
+	handle(handlers.JWKSPath, handlers.Methods(handlers.JWKS, "GET", "HEAD"))
+	handle(handlers.OIDCDiscoveryPath, handlers.Methods(handlers.OIDCDiscovery, "GET", "HEAD"))
+	handle(handlers.OIDCAuthorizationPath, handlers.Methods(handlers.Authorize, "GET", "POST"))
+	//The token endpoint rejects other methods with an OpenID Connect error response
+	handle(handlers.OIDCTokenPath, handlers.Token)
+	//Reverse proxies forward the method of the request being authorised
+	handle("/auth", handlers.ForwardAuth)
+	handle("/login", handlers.Methods(handlers.Login, "GET", "POST"))
+	handle("/logout", handlers.Methods(handlers.Logout, "GET", "POST"))
+	handle("/healthz", handlers.Methods(handlers.Healthz, "GET", "HEAD"))
+	handle("/readyz", handlers.Methods(handlers.Readyz, "GET", "HEAD"))
+	mux.Handle("/metrics", metrics.Handler())
+	metrics.RegisterVaultTokenTTL(func() float64 {
+		l := h.Config().Vault.VaultLogin
+		if l == nil {
+			return 0
+		}
+		ttl := l.TTL()
+		if ttl < 0 {
+			return math.Inf(1)
+		}
+		return ttl.Seconds()
 	})
 
+	//Reload the configuration on SIGHUP and, if requested, when the files change
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			h.Config().MFAServer.Loggers.Info.Println("SIGHUP received, reloading configuration")
+			h.Reload()
+		}
+	}()
+	if *watch > 0 {
+		go h.Watch(*watch)
+	}
+
 	c.MFAServer.Loggers.Info.Printf(`MFA Server - Configuration Complete:
 	Version: %s
 	Listenning socket: %s
@@ -45,11 +117,137 @@ func main() {
 		c.MFAServer.Loggers.Warning.Println("It is not recommended to run with TLS disabled as passwords will be sent unencrypted over the network.")
 	}
 
+	//Start the optional RADIUS listener
+	var rs *radius.PacketServer
+	if c.RADIUS.ListenerSocket != nil {
+		c.MFAServer.Loggers.Info.Printf("RADIUS listenning socket: %s", *c.RADIUS.ListenerSocket)
+		rs = handlers.RADIUS(h.Config)
+		go func() {
+			if err := rs.ListenAndServe(); err != radius.ErrServerShutdown {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	//Start the optional gRPC listener
+	var gs *grpc.Server
+	if c.MFAServer.GRPCListenerSocket != nil {
+		c.MFAServer.Loggers.Info.Printf("gRPC listenning socket: %s", *c.MFAServer.GRPCListenerSocket)
+		l, err := net.Listen("tcp", *c.MFAServer.GRPCListenerSocket)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gs = handlers.NewGRPCServer(h.Config)
+		go func() {
+			if err := gs.Serve(l); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:         *c.MFAServer.ListenerSocket,
+		Handler:      mux,
+		ReadTimeout:  c.ReadTimeout(),
+		WriteTimeout: c.WriteTimeout(),
+		IdleTimeout:  c.IdleTimeout(),
+	}
+	if c.MFAServer.TLS.Enabled && c.MFAServer.TLS.ClientCAFile != nil {
+		c.MFAServer.Loggers.Info.Printf("Client certificates verified against: %s (required: %t)", *c.MFAServer.TLS.ClientCAFile, c.MFAServer.TLS.RequireClientCertificate)
+	}
+
+	//Stop accepting requests and drain those in progress on SIGTERM or SIGINT
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	exit := make(chan int, 1)
+	go func() {
+		exit <- shutdown(h, srv, rs, gs, stopTracing, <-stop)
+	}()
+
 	//Start server
 	if c.MFAServer.TLS.Enabled {
-		err = http.ListenAndServeTLS(*c.MFAServer.ListenerSocket, *c.MFAServer.TLS.CertificateFile, *c.MFAServer.TLS.KeyFile, mux)
+		//The TLS settings, including the certificate, are taken from the current configuration for each connection
+		srv.TLSConfig = &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return h.Config().MFATLSConfig(), nil
+			},
+		}
+		err = srv.ListenAndServeTLS("", "")
 	} else {
-		err = http.ListenAndServe(*c.MFAServer.ListenerSocket, mux)
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	os.Exit(<-exit)
+}
+
+// Exit codes once the MFA server has been asked to stop
+const (
+	// All requests in progress completed
+	exitClean = 0
+	// Requests were still in progress when the shutdown timeout was reached and were abandoned
+	exitForced = 2
+)
+
+// Time allowed to export the spans not yet exported on shutdown
+const tracingFlushTimeout = 5 * time.Second
+
+// shutdown reports the MFA server is not ready, stops the listeners, waits up to the shutdown timeout for requests in progress to complete, then closes the
+// LDAP connections and audit log, revokes the Vault token and flushes the spans not yet exported.
+func shutdown(h *config.Holder, srv *http.Server, rs *radius.PacketServer, gs *grpc.Server, stopTracing func(context.Context) error, s os.Signal) int {
+	c := h.Config()
+	handlers.Drain()
+	if d := c.ShutdownDelay(); d > 0 {
+		c.MFAServer.Loggers.Info.Printf("%v received, reporting not ready for %v before shutting down", s, d)
+		time.Sleep(d)
+	}
+	c.MFAServer.Loggers.Info.Printf("%v received, shutting down. Waiting up to %v for requests in progress to complete", s, c.ShutdownTimeout())
+	ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout())
+	defer cancel()
+	code := exitClean
+	if rs != nil {
+		if err := rs.Shutdown(ctx); err != nil {
+			c.MFAServer.Loggers.Error.Printf("RADIUS requests still in progress at the shutdown deadline were abandoned: %v", err)
+			code = exitForced
+		}
+	}
+	if gs != nil {
+		stopped := make(chan struct{})
+		go func() {
+			gs.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			c.MFAServer.Loggers.Error.Println("gRPC calls still in progress at the shutdown deadline were abandoned")
+			gs.Stop()
+			code = exitForced
+		}
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		c.MFAServer.Loggers.Error.Printf("Requests still in progress at the shutdown deadline were abandoned: %v", err)
+		srv.Close()
+		code = exitForced
+	}
+	c.CloseLDAPConnections()
+	c.CloseSIEM()
+	c.CloseWebhooks()
+	if c.Audit.Log != nil {
+		if err := c.Audit.Log.Close(); err != nil {
+			c.MFAServer.Loggers.Error.Println(err.Error())
+		}
+	}
+	if err := secrets.RevokeToken(c); err != nil {
+		c.MFAServer.Loggers.Error.Println(err.Error())
+	}
+	//Spans are flushed even if the shutdown timeout has been reached
+	tctx, tcancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer tcancel()
+	if err := stopTracing(tctx); err != nil {
+		c.MFAServer.Loggers.Error.Printf("Spans could not be exported: %v", err)
 	}
-	log.Fatal(err)
+	c.MFAServer.Loggers.Info.Printf("MFA Server stopped with exit code %d", code)
+	return code
 }
@@ -1 +1,63 @@
 package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/jcmturner/mfaserver/assertion"
+	"github.com/jcmturner/mfaserver/audit"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditCommand(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-audit")
+	defer os.RemoveAll(dir)
+	p, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(p)
+	keyFile := filepath.Join(dir, "auditkey.pem")
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	k, err := assertion.LoadKey(keyFile)
+	if err != nil {
+		t.Fatalf("Error loading signing key: %v", err)
+	}
+	logFile := filepath.Join(dir, "audit.log")
+	l := audit.New(k, time.Hour)
+	if err := l.WithFile(logFile); err != nil {
+		t.Fatalf("Error opening audit log: %v", err)
+	}
+	l.Write(audit.Event{Type: audit.EventEnrol, Outcome: "success", Domain: "test.com", Username: "validuser"})
+	l.Write(audit.Event{Type: audit.EventValidate, Outcome: "success", Domain: "test.com", Username: "validuser"})
+	l.Close()
+
+	var tests = []struct {
+		args   []string
+		code   int
+		output string
+	}{
+		{[]string{"verify", "-file=" + logFile, "-key=" + keyFile}, auditValid, "3 records: 2 events and 1 checkpoints. 0 events after the last valid checkpoint."},
+		{[]string{"verify", "-file=" + logFile}, auditValid, "Checkpoint signatures were not verified"},
+		{[]string{"verify"}, auditError, ""},
+		{[]string{"check"}, auditError, ""},
+		{[]string{"verify", "-file=" + filepath.Join(dir, "missing.log")}, auditError, ""},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, test.code, auditCommand(test.args, &stdout, &stderr), "Exit code not as expected for %v", test.args)
+		assert.Contains(t, stdout.String(), test.output, "Output not as expected for %v", test.args)
+	}
+
+	b, _ := ioutil.ReadFile(logFile)
+	ioutil.WriteFile(logFile, []byte(strings.Replace(string(b), "enrol", "update", 1)), 0640)
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, auditTampered, auditCommand([]string{"verify", "-file=" + logFile, "-key=" + keyFile}, &stdout, &stderr), "Modified audit log not detected")
+	assert.Contains(t, stdout.String(), "record with seq 1 has been modified", "Output not as expected for a modified audit log")
+}
@@ -0,0 +1,188 @@
+// Package metrics collects the MFA server's metrics for scraping by Prometheus.
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+// Outcomes of requests
+const (
+	OutcomeSuccess      = "success"
+	OutcomeBadRequest   = "bad_request"
+	OutcomeLDAPFail     = "ldap_fail"
+	OutcomeOTPFail      = "otp_fail"
+	OutcomeStoreError   = "store_error"
+	OutcomeUnauthorized = "unauthorized"
+	OutcomeForbidden    = "forbidden"
+	OutcomeNotFound     = "not_found"
+	OutcomeError        = "error"
+)
+
+// Results of calls to LDAP and the Vault
+const (
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mfaserver",
+		Name:      "requests_total",
+		Help:      "Requests handled by endpoint and outcome.",
+	}, []string{"endpoint", "outcome"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mfaserver",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle requests by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+	ldapBindDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mfaserver",
+		Name:      "ldap_bind_duration_seconds",
+		Help:      "Time taken to connect and bind to LDAP by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+	vaultDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mfaserver",
+		Name:      "vault_request_duration_seconds",
+		Help:      "Time taken by calls to the Vault by operation and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+	ldapInUse = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "mfaserver",
+		Name:      "ldap_connections_in_use",
+		Help:      "LDAP operations in progress on the connection to each directory.",
+	}, []string{"directory"})
+)
+
+func init() {
+	registry.MustRegister(requests, requestDuration, ldapBindDuration, vaultDuration, ldapInUse,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RegisterVaultTokenTTL reports the seconds until the MFA server's Vault token expires, as returned by the function.
+func RegisterVaultTokenTTL(f func() float64) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "mfaserver",
+		Name:      "vault_token_ttl_seconds",
+		Help:      "Seconds until the Vault token expires. +Inf if the token does not expire, 0 if there is no token.",
+	}, f))
+}
+
+// ObserveRequest counts a request to the endpoint with the outcome and records how long it took to handle.
+func ObserveRequest(endpoint, outcome string, start time.Time) {
+	requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	requests.WithLabelValues(endpoint, outcome).Inc()
+}
+
+// ObserveLDAPBind records the time taken to connect and bind to LDAP.
+func ObserveLDAPBind(start time.Time, err error) {
+	ldapBindDuration.WithLabelValues(result(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveVault records the time taken by a call to the Vault.
+func ObserveVault(operation string, start time.Time, err error) {
+	vaultDuration.WithLabelValues(operation, result(err)).Observe(time.Since(start).Seconds())
+}
+
+// LDAPInUse marks an operation as in progress on the connection to the LDAP directory. The function returned must be
+// called when the operation completes.
+func LDAPInUse(directory string) func() {
+	g := ldapInUse.WithLabelValues(directory)
+	g.Inc()
+	return g.Dec
+}
+
+type outcomeKey struct{}
+
+// WithOutcome returns a copy of the request in which the handler can record the outcome of the request.
+func WithOutcome(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), outcomeKey{}, new(string)))
+}
+
+// SetOutcome records why the request failed where the HTTP response code alone does not tell it, such as whether a 401
+// was due to the password or the OTP. It is ignored if the request then succeeds.
+func SetOutcome(r *http.Request, outcome string) {
+	if o, ok := r.Context().Value(outcomeKey{}).(*string); ok {
+		*o = outcome
+	}
+}
+
+// Outcome returns the outcome recorded for a failed request, or otherwise the outcome implied by the HTTP response code.
+func Outcome(r *http.Request, code int) string {
+	if code < 400 {
+		return OutcomeSuccess
+	}
+	if o, ok := r.Context().Value(outcomeKey{}).(*string); ok && *o != "" {
+		return *o
+	}
+	switch {
+	case code == http.StatusBadRequest || code == http.StatusMethodNotAllowed || code == http.StatusRequestEntityTooLarge:
+		return OutcomeBadRequest
+	case code == http.StatusUnauthorized:
+		return OutcomeUnauthorized
+	case code == http.StatusForbidden:
+		return OutcomeForbidden
+	case code == http.StatusNotFound:
+		return OutcomeNotFound
+	}
+	return OutcomeError
+}
+
+// Instrument counts the requests handled by the handler and how long they take.
+func Instrument(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r = WithOutcome(r)
+		sw := &StatusWriter{ResponseWriter: w}
+		h(sw, r)
+		ObserveRequest(endpoint, Outcome(r, sw.Status()), start)
+	}
+}
+
+// StatusWriter records the HTTP response code written.
+type StatusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *StatusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Status returns the HTTP response code written, which is 200 if the handler did not write one.
+func (w *StatusWriter) Status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func result(err error) string {
+	if err != nil {
+		return resultError
+	}
+	return resultSuccess
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutcome(t *testing.T) {
+	var tests = []struct {
+		code    int
+		set     string
+		outcome string
+	}{
+		{http.StatusNoContent, "", OutcomeSuccess},
+		{http.StatusCreated, OutcomeLDAPFail, OutcomeSuccess},
+		{http.StatusBadRequest, "", OutcomeBadRequest},
+		{http.StatusMethodNotAllowed, "", OutcomeBadRequest},
+		{http.StatusUnauthorized, "", OutcomeUnauthorized},
+		{http.StatusUnauthorized, OutcomeLDAPFail, OutcomeLDAPFail},
+		{http.StatusUnauthorized, OutcomeOTPFail, OutcomeOTPFail},
+		{http.StatusForbidden, "", OutcomeForbidden},
+		{http.StatusNotFound, "", OutcomeNotFound},
+		{http.StatusInternalServerError, "", OutcomeError},
+		{http.StatusInternalServerError, OutcomeStoreError, OutcomeStoreError},
+	}
+	for _, test := range tests {
+		r := WithOutcome(httptest.NewRequest("GET", "/validate", nil))
+		if test.set != "" {
+			SetOutcome(r, test.set)
+		}
+		assert.Equal(t, test.outcome, Outcome(r, test.code), "Outcome not as expected for code %d with %q recorded", test.code, test.set)
+	}
+	//Recording an outcome is ignored if the request was not instrumented
+	r := httptest.NewRequest("GET", "/validate", nil)
+	SetOutcome(r, OutcomeOTPFail)
+	assert.Equal(t, OutcomeUnauthorized, Outcome(r, http.StatusUnauthorized), "Outcome of uninstrumented request not as expected")
+}
+
+func TestInstrument(t *testing.T) {
+	h := Instrument("/test", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("result") {
+		case "otp":
+			SetOutcome(r, OutcomeOTPFail)
+			w.WriteHeader(http.StatusUnauthorized)
+		case "body":
+			w.Write([]byte("ok"))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	for _, q := range []string{"", "body", "otp"} {
+		h(httptest.NewRecorder(), httptest.NewRequest("GET", "/test?result="+q, nil))
+	}
+	assert.Equal(t, float64(2), testutil.ToFloat64(requests.WithLabelValues("/test", OutcomeSuccess)), "Successful requests not counted")
+	assert.Equal(t, float64(1), testutil.ToFloat64(requests.WithLabelValues("/test", OutcomeOTPFail)), "Failed requests not counted")
+	assert.Equal(t, 1, testutil.CollectAndCount(requestDuration, "mfaserver_request_duration_seconds"), "Request duration not recorded")
+}
+
+func TestHandler(t *testing.T) {
+	ObserveLDAPBind(time.Now(), nil)
+	ObserveVault("read", time.Now(), errors.New("permission denied"))
+	done := LDAPInUse("ldap://127.0.0.1:389")
+	assert.Equal(t, float64(1), testutil.ToFloat64(ldapInUse.WithLabelValues("ldap://127.0.0.1:389")), "LDAP operation in progress not counted")
+	done()
+	assert.Equal(t, float64(0), testutil.ToFloat64(ldapInUse.WithLabelValues("ldap://127.0.0.1:389")), "LDAP operation completed still counted")
+	RegisterVaultTokenTTL(func() float64 { return 3600 })
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "Status code not as expected")
+	for _, m := range []string{
+		`mfaserver_ldap_bind_duration_seconds_count{result="success"} 1`,
+		`mfaserver_vault_request_duration_seconds_count{operation="read",result="error"} 1`,
+		`mfaserver_ldap_connections_in_use{directory="ldap://127.0.0.1:389"} 0`,
+		`mfaserver_vault_token_ttl_seconds 3600`,
+		`go_goroutines`,
+	} {
+		assert.True(t, strings.Contains(w.Body.String(), m), "Metrics do not contain %s", m)
+	}
+}
@@ -0,0 +1,4 @@
+// Package mfaserverpb contains the gRPC service definition of the MFA server and the code generated from it.
+package mfaserverpb
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative mfaserverpb/mfaserver.proto
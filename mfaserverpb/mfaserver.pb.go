@@ -0,0 +1,1321 @@
+// The gRPC API of the MFA server. It provides the same operations as the REST API.
+//
+// If applications are registered the calling application authenticates with a client certificate or with its name and
+// an API key in the x-mfa-application and x-mfa-application-key metadata. Unlike the REST API, request signatures and
+// Kerberos authentication are not supported. Administrators provide basic authentication credentials in the
+// authorization metadata. The ID of the request in the MFA server's logs is returned in the x-request-id header
+// metadata.
+//
+// Errors have the status code for the failure and an ErrorInfo detail with the domain "mfaserver", the reason set to
+// the REST API's error code, such as "already_enrolled", and the request ID in the metadata.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        v5.29.3
+// source: mfaserverpb/mfaserver.proto
+
+package mfaserverpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type EnrolRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Issuer   string                 `protobuf:"bytes,1,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	Domain   string                 `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	Username string                 `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
+	Password string                 `protobuf:"bytes,4,opt,name=password,proto3" json:"password,omitempty"`
+	// Return a PNG QR code of the secret, suitable for authenticator applications.
+	QrCode        bool `protobuf:"varint,5,opt,name=qr_code,json=qrCode,proto3" json:"qr_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnrolRequest) Reset() {
+	*x = EnrolRequest{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnrolRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrolRequest) ProtoMessage() {}
+
+func (x *EnrolRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrolRequest.ProtoReflect.Descriptor instead.
+func (*EnrolRequest) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *EnrolRequest) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *EnrolRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *EnrolRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *EnrolRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *EnrolRequest) GetQrCode() bool {
+	if x != nil {
+		return x.QrCode
+	}
+	return false
+}
+
+type SecretResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The base32 encoded TOTP secret.
+	Secret string `protobuf:"bytes,1,opt,name=secret,proto3" json:"secret,omitempty"`
+	// The PNG QR code of the secret, if it was requested.
+	QrCodePng     []byte `protobuf:"bytes,2,opt,name=qr_code_png,json=qrCodePng,proto3" json:"qr_code_png,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SecretResponse) Reset() {
+	*x = SecretResponse{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SecretResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SecretResponse) ProtoMessage() {}
+
+func (x *SecretResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SecretResponse.ProtoReflect.Descriptor instead.
+func (*SecretResponse) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SecretResponse) GetSecret() string {
+	if x != nil {
+		return x.Secret
+	}
+	return ""
+}
+
+func (x *SecretResponse) GetQrCodePng() []byte {
+	if x != nil {
+		return x.QrCodePng
+	}
+	return nil
+}
+
+type ValidateRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Issuer   string                 `protobuf:"bytes,1,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	Domain   string                 `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	Username string                 `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
+	Password string                 `protobuf:"bytes,4,opt,name=password,proto3" json:"password,omitempty"`
+	Otp      string                 `protobuf:"bytes,5,opt,name=otp,proto3" json:"otp,omitempty"`
+	// Return a signed assertion that the user has been authenticated, if assertions are configured.
+	Assertion     bool `protobuf:"varint,6,opt,name=assertion,proto3" json:"assertion,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ValidateRequest) Reset() {
+	*x = ValidateRequest{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ValidateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValidateRequest) ProtoMessage() {}
+
+func (x *ValidateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValidateRequest.ProtoReflect.Descriptor instead.
+func (*ValidateRequest) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ValidateRequest) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *ValidateRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *ValidateRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *ValidateRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *ValidateRequest) GetOtp() string {
+	if x != nil {
+		return x.Otp
+	}
+	return ""
+}
+
+func (x *ValidateRequest) GetAssertion() bool {
+	if x != nil {
+		return x.Assertion
+	}
+	return false
+}
+
+type ValidateResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The signed JWT assertion, if it was requested and assertions are configured.
+	Assertion     string `protobuf:"bytes,1,opt,name=assertion,proto3" json:"assertion,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ValidateResponse) Reset() {
+	*x = ValidateResponse{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ValidateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValidateResponse) ProtoMessage() {}
+
+func (x *ValidateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValidateResponse.ProtoReflect.Descriptor instead.
+func (*ValidateResponse) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ValidateResponse) GetAssertion() string {
+	if x != nil {
+		return x.Assertion
+	}
+	return ""
+}
+
+type UpdateRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Issuer   string                 `protobuf:"bytes,1,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	Domain   string                 `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	Username string                 `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
+	Password string                 `protobuf:"bytes,4,opt,name=password,proto3" json:"password,omitempty"`
+	Otp      string                 `protobuf:"bytes,5,opt,name=otp,proto3" json:"otp,omitempty"`
+	// Return a PNG QR code of the new secret, suitable for authenticator applications.
+	QrCode        bool `protobuf:"varint,6,opt,name=qr_code,json=qrCode,proto3" json:"qr_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateRequest) Reset() {
+	*x = UpdateRequest{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateRequest) ProtoMessage() {}
+
+func (x *UpdateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateRequest.ProtoReflect.Descriptor instead.
+func (*UpdateRequest) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UpdateRequest) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *UpdateRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *UpdateRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *UpdateRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *UpdateRequest) GetOtp() string {
+	if x != nil {
+		return x.Otp
+	}
+	return ""
+}
+
+func (x *UpdateRequest) GetQrCode() bool {
+	if x != nil {
+		return x.QrCode
+	}
+	return false
+}
+
+type DeleteRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Issuer   string                 `protobuf:"bytes,1,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	Domain   string                 `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	Username string                 `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
+	// The user's password and OTP are not required if an administrator's credentials are provided.
+	Password      string `protobuf:"bytes,4,opt,name=password,proto3" json:"password,omitempty"`
+	Otp           string `protobuf:"bytes,5,opt,name=otp,proto3" json:"otp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRequest) Reset() {
+	*x = DeleteRequest{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRequest) ProtoMessage() {}
+
+func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeleteRequest) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetOtp() string {
+	if x != nil {
+		return x.Otp
+	}
+	return ""
+}
+
+type DeleteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteResponse) Reset() {
+	*x = DeleteResponse{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteResponse) ProtoMessage() {}
+
+func (x *DeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteResponse.ProtoReflect.Descriptor instead.
+func (*DeleteResponse) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{6}
+}
+
+type ListUsersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Issuer        string                 `protobuf:"bytes,1,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	Domain        string                 `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUsersRequest) Reset() {
+	*x = ListUsersRequest{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUsersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUsersRequest) ProtoMessage() {}
+
+func (x *ListUsersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUsersRequest.ProtoReflect.Descriptor instead.
+func (*ListUsersRequest) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListUsersRequest) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *ListUsersRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+type ListUsersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Usernames     []string               `protobuf:"bytes,1,rep,name=usernames,proto3" json:"usernames,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUsersResponse) Reset() {
+	*x = ListUsersResponse{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUsersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUsersResponse) ProtoMessage() {}
+
+func (x *ListUsersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUsersResponse.ProtoReflect.Descriptor instead.
+func (*ListUsersResponse) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListUsersResponse) GetUsernames() []string {
+	if x != nil {
+		return x.Usernames
+	}
+	return nil
+}
+
+type InvalidateAdminCacheRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Domain        string                 `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InvalidateAdminCacheRequest) Reset() {
+	*x = InvalidateAdminCacheRequest{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvalidateAdminCacheRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvalidateAdminCacheRequest) ProtoMessage() {}
+
+func (x *InvalidateAdminCacheRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvalidateAdminCacheRequest.ProtoReflect.Descriptor instead.
+func (*InvalidateAdminCacheRequest) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *InvalidateAdminCacheRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *InvalidateAdminCacheRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+type InvalidateAdminCacheResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InvalidateAdminCacheResponse) Reset() {
+	*x = InvalidateAdminCacheResponse{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvalidateAdminCacheResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvalidateAdminCacheResponse) ProtoMessage() {}
+
+func (x *InvalidateAdminCacheResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvalidateAdminCacheResponse.ProtoReflect.Descriptor instead.
+func (*InvalidateAdminCacheResponse) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{10}
+}
+
+type GetAdminCacheStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Domain        string                 `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetAdminCacheStatsRequest) Reset() {
+	*x = GetAdminCacheStatsRequest{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetAdminCacheStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetAdminCacheStatsRequest) ProtoMessage() {}
+
+func (x *GetAdminCacheStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetAdminCacheStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetAdminCacheStatsRequest) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *GetAdminCacheStatsRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+type AdminCacheStats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Entries       int64                  `protobuf:"varint,1,opt,name=entries,proto3" json:"entries,omitempty"`
+	Hits          uint64                 `protobuf:"varint,2,opt,name=hits,proto3" json:"hits,omitempty"`
+	Misses        uint64                 `protobuf:"varint,3,opt,name=misses,proto3" json:"misses,omitempty"`
+	Evictions     uint64                 `protobuf:"varint,4,opt,name=evictions,proto3" json:"evictions,omitempty"`
+	HitRate       float64                `protobuf:"fixed64,5,opt,name=hit_rate,json=hitRate,proto3" json:"hit_rate,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AdminCacheStats) Reset() {
+	*x = AdminCacheStats{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AdminCacheStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdminCacheStats) ProtoMessage() {}
+
+func (x *AdminCacheStats) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdminCacheStats.ProtoReflect.Descriptor instead.
+func (*AdminCacheStats) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *AdminCacheStats) GetEntries() int64 {
+	if x != nil {
+		return x.Entries
+	}
+	return 0
+}
+
+func (x *AdminCacheStats) GetHits() uint64 {
+	if x != nil {
+		return x.Hits
+	}
+	return 0
+}
+
+func (x *AdminCacheStats) GetMisses() uint64 {
+	if x != nil {
+		return x.Misses
+	}
+	return 0
+}
+
+func (x *AdminCacheStats) GetEvictions() uint64 {
+	if x != nil {
+		return x.Evictions
+	}
+	return 0
+}
+
+func (x *AdminCacheStats) GetHitRate() float64 {
+	if x != nil {
+		return x.HitRate
+	}
+	return 0
+}
+
+type ListWebhookDeliveriesRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Domain string                 `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	// Only return deliveries to this subscription.
+	Subscription string `protobuf:"bytes,2,opt,name=subscription,proto3" json:"subscription,omitempty"`
+	// Only return deliveries with this status: pending, delivered or failed.
+	Status string `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	// The most deliveries to return. Defaults to 100 and can be at most 1000.
+	Limit         int32 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWebhookDeliveriesRequest) Reset() {
+	*x = ListWebhookDeliveriesRequest{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWebhookDeliveriesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWebhookDeliveriesRequest) ProtoMessage() {}
+
+func (x *ListWebhookDeliveriesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWebhookDeliveriesRequest.ProtoReflect.Descriptor instead.
+func (*ListWebhookDeliveriesRequest) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ListWebhookDeliveriesRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *ListWebhookDeliveriesRequest) GetSubscription() string {
+	if x != nil {
+		return x.Subscription
+	}
+	return ""
+}
+
+func (x *ListWebhookDeliveriesRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ListWebhookDeliveriesRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListWebhookDeliveriesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Deliveries    []*WebhookDelivery     `protobuf:"bytes,1,rep,name=deliveries,proto3" json:"deliveries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWebhookDeliveriesResponse) Reset() {
+	*x = ListWebhookDeliveriesResponse{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWebhookDeliveriesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWebhookDeliveriesResponse) ProtoMessage() {}
+
+func (x *ListWebhookDeliveriesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWebhookDeliveriesResponse.ProtoReflect.Descriptor instead.
+func (*ListWebhookDeliveriesResponse) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ListWebhookDeliveriesResponse) GetDeliveries() []*WebhookDelivery {
+	if x != nil {
+		return x.Deliveries
+	}
+	return nil
+}
+
+type WebhookDelivery struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Subscription  string                 `protobuf:"bytes,2,opt,name=subscription,proto3" json:"subscription,omitempty"`
+	Event         *WebhookEvent          `protobuf:"bytes,3,opt,name=event,proto3" json:"event,omitempty"`
+	Status        string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	Attempts      int32                  `protobuf:"varint,5,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	Created       *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=created,proto3" json:"created,omitempty"`
+	NextAttempt   *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=next_attempt,json=nextAttempt,proto3" json:"next_attempt,omitempty"`
+	LastAttempt   *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=last_attempt,json=lastAttempt,proto3" json:"last_attempt,omitempty"`
+	ResponseCode  int32                  `protobuf:"varint,9,opt,name=response_code,json=responseCode,proto3" json:"response_code,omitempty"`
+	LastError     string                 `protobuf:"bytes,10,opt,name=last_error,json=lastError,proto3" json:"last_error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WebhookDelivery) Reset() {
+	*x = WebhookDelivery{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WebhookDelivery) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WebhookDelivery) ProtoMessage() {}
+
+func (x *WebhookDelivery) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WebhookDelivery.ProtoReflect.Descriptor instead.
+func (*WebhookDelivery) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *WebhookDelivery) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *WebhookDelivery) GetSubscription() string {
+	if x != nil {
+		return x.Subscription
+	}
+	return ""
+}
+
+func (x *WebhookDelivery) GetEvent() *WebhookEvent {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *WebhookDelivery) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *WebhookDelivery) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *WebhookDelivery) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *WebhookDelivery) GetNextAttempt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NextAttempt
+	}
+	return nil
+}
+
+func (x *WebhookDelivery) GetLastAttempt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastAttempt
+	}
+	return nil
+}
+
+func (x *WebhookDelivery) GetResponseCode() int32 {
+	if x != nil {
+		return x.ResponseCode
+	}
+	return 0
+}
+
+func (x *WebhookDelivery) GetLastError() string {
+	if x != nil {
+		return x.LastError
+	}
+	return ""
+}
+
+type WebhookEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// mfa.enrolled, mfa.rotated or mfa.deleted
+	Type string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	// RFC 3339 time of the event
+	Time          string `protobuf:"bytes,3,opt,name=time,proto3" json:"time,omitempty"`
+	Issuer        string `protobuf:"bytes,4,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	Domain        string `protobuf:"bytes,5,opt,name=domain,proto3" json:"domain,omitempty"`
+	Username      string `protobuf:"bytes,6,opt,name=username,proto3" json:"username,omitempty"`
+	Admin         string `protobuf:"bytes,7,opt,name=admin,proto3" json:"admin,omitempty"`
+	Application   string `protobuf:"bytes,8,opt,name=application,proto3" json:"application,omitempty"`
+	RequestId     string `protobuf:"bytes,9,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WebhookEvent) Reset() {
+	*x = WebhookEvent{}
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WebhookEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WebhookEvent) ProtoMessage() {}
+
+func (x *WebhookEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_mfaserverpb_mfaserver_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WebhookEvent.ProtoReflect.Descriptor instead.
+func (*WebhookEvent) Descriptor() ([]byte, []int) {
+	return file_mfaserverpb_mfaserver_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *WebhookEvent) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *WebhookEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *WebhookEvent) GetTime() string {
+	if x != nil {
+		return x.Time
+	}
+	return ""
+}
+
+func (x *WebhookEvent) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *WebhookEvent) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *WebhookEvent) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *WebhookEvent) GetAdmin() string {
+	if x != nil {
+		return x.Admin
+	}
+	return ""
+}
+
+func (x *WebhookEvent) GetApplication() string {
+	if x != nil {
+		return x.Application
+	}
+	return ""
+}
+
+func (x *WebhookEvent) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+var File_mfaserverpb_mfaserver_proto protoreflect.FileDescriptor
+
+const file_mfaserverpb_mfaserver_proto_rawDesc = "" +
+	"\n" +
+	"\x1bmfaserverpb/mfaserver.proto\x12\fmfaserver.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x8f\x01\n" +
+	"\fEnrolRequest\x12\x16\n" +
+	"\x06issuer\x18\x01 \x01(\tR\x06issuer\x12\x16\n" +
+	"\x06domain\x18\x02 \x01(\tR\x06domain\x12\x1a\n" +
+	"\busername\x18\x03 \x01(\tR\busername\x12\x1a\n" +
+	"\bpassword\x18\x04 \x01(\tR\bpassword\x12\x17\n" +
+	"\aqr_code\x18\x05 \x01(\bR\x06qrCode\"H\n" +
+	"\x0eSecretResponse\x12\x16\n" +
+	"\x06secret\x18\x01 \x01(\tR\x06secret\x12\x1e\n" +
+	"\vqr_code_png\x18\x02 \x01(\fR\tqrCodePng\"\xa9\x01\n" +
+	"\x0fValidateRequest\x12\x16\n" +
+	"\x06issuer\x18\x01 \x01(\tR\x06issuer\x12\x16\n" +
+	"\x06domain\x18\x02 \x01(\tR\x06domain\x12\x1a\n" +
+	"\busername\x18\x03 \x01(\tR\busername\x12\x1a\n" +
+	"\bpassword\x18\x04 \x01(\tR\bpassword\x12\x10\n" +
+	"\x03otp\x18\x05 \x01(\tR\x03otp\x12\x1c\n" +
+	"\tassertion\x18\x06 \x01(\bR\tassertion\"0\n" +
+	"\x10ValidateResponse\x12\x1c\n" +
+	"\tassertion\x18\x01 \x01(\tR\tassertion\"\xa2\x01\n" +
+	"\rUpdateRequest\x12\x16\n" +
+	"\x06issuer\x18\x01 \x01(\tR\x06issuer\x12\x16\n" +
+	"\x06domain\x18\x02 \x01(\tR\x06domain\x12\x1a\n" +
+	"\busername\x18\x03 \x01(\tR\busername\x12\x1a\n" +
+	"\bpassword\x18\x04 \x01(\tR\bpassword\x12\x10\n" +
+	"\x03otp\x18\x05 \x01(\tR\x03otp\x12\x17\n" +
+	"\aqr_code\x18\x06 \x01(\bR\x06qrCode\"\x89\x01\n" +
+	"\rDeleteRequest\x12\x16\n" +
+	"\x06issuer\x18\x01 \x01(\tR\x06issuer\x12\x16\n" +
+	"\x06domain\x18\x02 \x01(\tR\x06domain\x12\x1a\n" +
+	"\busername\x18\x03 \x01(\tR\busername\x12\x1a\n" +
+	"\bpassword\x18\x04 \x01(\tR\bpassword\x12\x10\n" +
+	"\x03otp\x18\x05 \x01(\tR\x03otp\"\x10\n" +
+	"\x0eDeleteResponse\"B\n" +
+	"\x10ListUsersRequest\x12\x16\n" +
+	"\x06issuer\x18\x01 \x01(\tR\x06issuer\x12\x16\n" +
+	"\x06domain\x18\x02 \x01(\tR\x06domain\"1\n" +
+	"\x11ListUsersResponse\x12\x1c\n" +
+	"\tusernames\x18\x01 \x03(\tR\tusernames\"Q\n" +
+	"\x1bInvalidateAdminCacheRequest\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\"\x1e\n" +
+	"\x1cInvalidateAdminCacheResponse\"3\n" +
+	"\x19GetAdminCacheStatsRequest\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\"\x90\x01\n" +
+	"\x0fAdminCacheStats\x12\x18\n" +
+	"\aentries\x18\x01 \x01(\x03R\aentries\x12\x12\n" +
+	"\x04hits\x18\x02 \x01(\x04R\x04hits\x12\x16\n" +
+	"\x06misses\x18\x03 \x01(\x04R\x06misses\x12\x1c\n" +
+	"\tevictions\x18\x04 \x01(\x04R\tevictions\x12\x19\n" +
+	"\bhit_rate\x18\x05 \x01(\x01R\ahitRate\"\x88\x01\n" +
+	"\x1cListWebhookDeliveriesRequest\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\x12\"\n" +
+	"\fsubscription\x18\x02 \x01(\tR\fsubscription\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\x05R\x05limit\"^\n" +
+	"\x1dListWebhookDeliveriesResponse\x12=\n" +
+	"\n" +
+	"deliveries\x18\x01 \x03(\v2\x1d.mfaserver.v1.WebhookDeliveryR\n" +
+	"deliveries\"\xa3\x03\n" +
+	"\x0fWebhookDelivery\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\"\n" +
+	"\fsubscription\x18\x02 \x01(\tR\fsubscription\x120\n" +
+	"\x05event\x18\x03 \x01(\v2\x1a.mfaserver.v1.WebhookEventR\x05event\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x1a\n" +
+	"\battempts\x18\x05 \x01(\x05R\battempts\x124\n" +
+	"\acreated\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x12=\n" +
+	"\fnext_attempt\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\vnextAttempt\x12=\n" +
+	"\flast_attempt\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\vlastAttempt\x12#\n" +
+	"\rresponse_code\x18\t \x01(\x05R\fresponseCode\x12\x1d\n" +
+	"\n" +
+	"last_error\x18\n" +
+	" \x01(\tR\tlastError\"\xe9\x01\n" +
+	"\fWebhookEvent\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x12\n" +
+	"\x04time\x18\x03 \x01(\tR\x04time\x12\x16\n" +
+	"\x06issuer\x18\x04 \x01(\tR\x06issuer\x12\x16\n" +
+	"\x06domain\x18\x05 \x01(\tR\x06domain\x12\x1a\n" +
+	"\busername\x18\x06 \x01(\tR\busername\x12\x14\n" +
+	"\x05admin\x18\a \x01(\tR\x05admin\x12 \n" +
+	"\vapplication\x18\b \x01(\tR\vapplication\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\t \x01(\tR\trequestId2\xb0\x05\n" +
+	"\tMFAServer\x12A\n" +
+	"\x05Enrol\x12\x1a.mfaserver.v1.EnrolRequest\x1a\x1c.mfaserver.v1.SecretResponse\x12I\n" +
+	"\bValidate\x12\x1d.mfaserver.v1.ValidateRequest\x1a\x1e.mfaserver.v1.ValidateResponse\x12C\n" +
+	"\x06Update\x12\x1b.mfaserver.v1.UpdateRequest\x1a\x1c.mfaserver.v1.SecretResponse\x12C\n" +
+	"\x06Delete\x12\x1b.mfaserver.v1.DeleteRequest\x1a\x1c.mfaserver.v1.DeleteResponse\x12L\n" +
+	"\tListUsers\x12\x1e.mfaserver.v1.ListUsersRequest\x1a\x1f.mfaserver.v1.ListUsersResponse\x12m\n" +
+	"\x14InvalidateAdminCache\x12).mfaserver.v1.InvalidateAdminCacheRequest\x1a*.mfaserver.v1.InvalidateAdminCacheResponse\x12\\\n" +
+	"\x12GetAdminCacheStats\x12'.mfaserver.v1.GetAdminCacheStatsRequest\x1a\x1d.mfaserver.v1.AdminCacheStats\x12p\n" +
+	"\x15ListWebhookDeliveries\x12*.mfaserver.v1.ListWebhookDeliveriesRequest\x1a+.mfaserver.v1.ListWebhookDeliveriesResponseB,Z*github.com/jcmturner/mfaserver/mfaserverpbb\x06proto3"
+
+var (
+	file_mfaserverpb_mfaserver_proto_rawDescOnce sync.Once
+	file_mfaserverpb_mfaserver_proto_rawDescData []byte
+)
+
+func file_mfaserverpb_mfaserver_proto_rawDescGZIP() []byte {
+	file_mfaserverpb_mfaserver_proto_rawDescOnce.Do(func() {
+		file_mfaserverpb_mfaserver_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_mfaserverpb_mfaserver_proto_rawDesc), len(file_mfaserverpb_mfaserver_proto_rawDesc)))
+	})
+	return file_mfaserverpb_mfaserver_proto_rawDescData
+}
+
+var file_mfaserverpb_mfaserver_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
+var file_mfaserverpb_mfaserver_proto_goTypes = []any{
+	(*EnrolRequest)(nil),                  // 0: mfaserver.v1.EnrolRequest
+	(*SecretResponse)(nil),                // 1: mfaserver.v1.SecretResponse
+	(*ValidateRequest)(nil),               // 2: mfaserver.v1.ValidateRequest
+	(*ValidateResponse)(nil),              // 3: mfaserver.v1.ValidateResponse
+	(*UpdateRequest)(nil),                 // 4: mfaserver.v1.UpdateRequest
+	(*DeleteRequest)(nil),                 // 5: mfaserver.v1.DeleteRequest
+	(*DeleteResponse)(nil),                // 6: mfaserver.v1.DeleteResponse
+	(*ListUsersRequest)(nil),              // 7: mfaserver.v1.ListUsersRequest
+	(*ListUsersResponse)(nil),             // 8: mfaserver.v1.ListUsersResponse
+	(*InvalidateAdminCacheRequest)(nil),   // 9: mfaserver.v1.InvalidateAdminCacheRequest
+	(*InvalidateAdminCacheResponse)(nil),  // 10: mfaserver.v1.InvalidateAdminCacheResponse
+	(*GetAdminCacheStatsRequest)(nil),     // 11: mfaserver.v1.GetAdminCacheStatsRequest
+	(*AdminCacheStats)(nil),               // 12: mfaserver.v1.AdminCacheStats
+	(*ListWebhookDeliveriesRequest)(nil),  // 13: mfaserver.v1.ListWebhookDeliveriesRequest
+	(*ListWebhookDeliveriesResponse)(nil), // 14: mfaserver.v1.ListWebhookDeliveriesResponse
+	(*WebhookDelivery)(nil),               // 15: mfaserver.v1.WebhookDelivery
+	(*WebhookEvent)(nil),                  // 16: mfaserver.v1.WebhookEvent
+	(*timestamppb.Timestamp)(nil),         // 17: google.protobuf.Timestamp
+}
+var file_mfaserverpb_mfaserver_proto_depIdxs = []int32{
+	15, // 0: mfaserver.v1.ListWebhookDeliveriesResponse.deliveries:type_name -> mfaserver.v1.WebhookDelivery
+	16, // 1: mfaserver.v1.WebhookDelivery.event:type_name -> mfaserver.v1.WebhookEvent
+	17, // 2: mfaserver.v1.WebhookDelivery.created:type_name -> google.protobuf.Timestamp
+	17, // 3: mfaserver.v1.WebhookDelivery.next_attempt:type_name -> google.protobuf.Timestamp
+	17, // 4: mfaserver.v1.WebhookDelivery.last_attempt:type_name -> google.protobuf.Timestamp
+	0,  // 5: mfaserver.v1.MFAServer.Enrol:input_type -> mfaserver.v1.EnrolRequest
+	2,  // 6: mfaserver.v1.MFAServer.Validate:input_type -> mfaserver.v1.ValidateRequest
+	4,  // 7: mfaserver.v1.MFAServer.Update:input_type -> mfaserver.v1.UpdateRequest
+	5,  // 8: mfaserver.v1.MFAServer.Delete:input_type -> mfaserver.v1.DeleteRequest
+	7,  // 9: mfaserver.v1.MFAServer.ListUsers:input_type -> mfaserver.v1.ListUsersRequest
+	9,  // 10: mfaserver.v1.MFAServer.InvalidateAdminCache:input_type -> mfaserver.v1.InvalidateAdminCacheRequest
+	11, // 11: mfaserver.v1.MFAServer.GetAdminCacheStats:input_type -> mfaserver.v1.GetAdminCacheStatsRequest
+	13, // 12: mfaserver.v1.MFAServer.ListWebhookDeliveries:input_type -> mfaserver.v1.ListWebhookDeliveriesRequest
+	1,  // 13: mfaserver.v1.MFAServer.Enrol:output_type -> mfaserver.v1.SecretResponse
+	3,  // 14: mfaserver.v1.MFAServer.Validate:output_type -> mfaserver.v1.ValidateResponse
+	1,  // 15: mfaserver.v1.MFAServer.Update:output_type -> mfaserver.v1.SecretResponse
+	6,  // 16: mfaserver.v1.MFAServer.Delete:output_type -> mfaserver.v1.DeleteResponse
+	8,  // 17: mfaserver.v1.MFAServer.ListUsers:output_type -> mfaserver.v1.ListUsersResponse
+	10, // 18: mfaserver.v1.MFAServer.InvalidateAdminCache:output_type -> mfaserver.v1.InvalidateAdminCacheResponse
+	12, // 19: mfaserver.v1.MFAServer.GetAdminCacheStats:output_type -> mfaserver.v1.AdminCacheStats
+	14, // 20: mfaserver.v1.MFAServer.ListWebhookDeliveries:output_type -> mfaserver.v1.ListWebhookDeliveriesResponse
+	13, // [13:21] is the sub-list for method output_type
+	5,  // [5:13] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_mfaserverpb_mfaserver_proto_init() }
+func file_mfaserverpb_mfaserver_proto_init() {
+	if File_mfaserverpb_mfaserver_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_mfaserverpb_mfaserver_proto_rawDesc), len(file_mfaserverpb_mfaserver_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   17,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_mfaserverpb_mfaserver_proto_goTypes,
+		DependencyIndexes: file_mfaserverpb_mfaserver_proto_depIdxs,
+		MessageInfos:      file_mfaserverpb_mfaserver_proto_msgTypes,
+	}.Build()
+	File_mfaserverpb_mfaserver_proto = out.File
+	file_mfaserverpb_mfaserver_proto_goTypes = nil
+	file_mfaserverpb_mfaserver_proto_depIdxs = nil
+}
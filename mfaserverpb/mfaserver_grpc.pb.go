@@ -0,0 +1,420 @@
+// The gRPC API of the MFA server. It provides the same operations as the REST API.
+//
+// If applications are registered the calling application authenticates with a client certificate or with its name and
+// an API key in the x-mfa-application and x-mfa-application-key metadata. Unlike the REST API, request signatures and
+// Kerberos authentication are not supported. Administrators provide basic authentication credentials in the
+// authorization metadata. The ID of the request in the MFA server's logs is returned in the x-request-id header
+// metadata.
+//
+// Errors have the status code for the failure and an ErrorInfo detail with the domain "mfaserver", the reason set to
+// the REST API's error code, such as "already_enrolled", and the request ID in the metadata.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v5.29.3
+// source: mfaserverpb/mfaserver.proto
+
+package mfaserverpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	MFAServer_Enrol_FullMethodName                 = "/mfaserver.v1.MFAServer/Enrol"
+	MFAServer_Validate_FullMethodName              = "/mfaserver.v1.MFAServer/Validate"
+	MFAServer_Update_FullMethodName                = "/mfaserver.v1.MFAServer/Update"
+	MFAServer_Delete_FullMethodName                = "/mfaserver.v1.MFAServer/Delete"
+	MFAServer_ListUsers_FullMethodName             = "/mfaserver.v1.MFAServer/ListUsers"
+	MFAServer_InvalidateAdminCache_FullMethodName  = "/mfaserver.v1.MFAServer/InvalidateAdminCache"
+	MFAServer_GetAdminCacheStats_FullMethodName    = "/mfaserver.v1.MFAServer/GetAdminCacheStats"
+	MFAServer_ListWebhookDeliveries_FullMethodName = "/mfaserver.v1.MFAServer/ListWebhookDeliveries"
+)
+
+// MFAServerClient is the client API for MFAServer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MFAServerClient interface {
+	// Create and store a new OTP secret for a user.
+	Enrol(ctx context.Context, in *EnrolRequest, opts ...grpc.CallOption) (*SecretResponse, error)
+	// Validate a user's password and OTP. An OTP that is not valid fails with UNAUTHENTICATED.
+	Validate(ctx context.Context, in *ValidateRequest, opts ...grpc.CallOption) (*ValidateResponse, error)
+	// Replace the OTP secret of an enroled user.
+	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*SecretResponse, error)
+	// Delete the OTP secret of a user. The user provides their password and OTP or an administrator with the reset
+	// permission provides their credentials.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// List the users enroled for an issuer and domain. The administrator needs the list permission.
+	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	// Remove the cached admin group membership decisions for a user, or all users if no username is given. The
+	// administrator needs the manage permission.
+	InvalidateAdminCache(ctx context.Context, in *InvalidateAdminCacheRequest, opts ...grpc.CallOption) (*InvalidateAdminCacheResponse, error)
+	// Return the hit rate and size of the admin group membership cache. The administrator needs the manage permission.
+	GetAdminCacheStats(ctx context.Context, in *GetAdminCacheStatsRequest, opts ...grpc.CallOption) (*AdminCacheStats, error)
+	// Return the webhook deliveries of events for users in a domain, the most recent first. The administrator needs the
+	// manage permission. Only the deliveries of events for the issuers the application is permitted to use are returned.
+	ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (*ListWebhookDeliveriesResponse, error)
+}
+
+type mFAServerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMFAServerClient(cc grpc.ClientConnInterface) MFAServerClient {
+	return &mFAServerClient{cc}
+}
+
+func (c *mFAServerClient) Enrol(ctx context.Context, in *EnrolRequest, opts ...grpc.CallOption) (*SecretResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SecretResponse)
+	err := c.cc.Invoke(ctx, MFAServer_Enrol_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mFAServerClient) Validate(ctx context.Context, in *ValidateRequest, opts ...grpc.CallOption) (*ValidateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ValidateResponse)
+	err := c.cc.Invoke(ctx, MFAServer_Validate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mFAServerClient) Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*SecretResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SecretResponse)
+	err := c.cc.Invoke(ctx, MFAServer_Update_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mFAServerClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, MFAServer_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mFAServerClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListUsersResponse)
+	err := c.cc.Invoke(ctx, MFAServer_ListUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mFAServerClient) InvalidateAdminCache(ctx context.Context, in *InvalidateAdminCacheRequest, opts ...grpc.CallOption) (*InvalidateAdminCacheResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InvalidateAdminCacheResponse)
+	err := c.cc.Invoke(ctx, MFAServer_InvalidateAdminCache_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mFAServerClient) GetAdminCacheStats(ctx context.Context, in *GetAdminCacheStatsRequest, opts ...grpc.CallOption) (*AdminCacheStats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AdminCacheStats)
+	err := c.cc.Invoke(ctx, MFAServer_GetAdminCacheStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mFAServerClient) ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (*ListWebhookDeliveriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListWebhookDeliveriesResponse)
+	err := c.cc.Invoke(ctx, MFAServer_ListWebhookDeliveries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MFAServerServer is the server API for MFAServer service.
+// All implementations must embed UnimplementedMFAServerServer
+// for forward compatibility.
+type MFAServerServer interface {
+	// Create and store a new OTP secret for a user.
+	Enrol(context.Context, *EnrolRequest) (*SecretResponse, error)
+	// Validate a user's password and OTP. An OTP that is not valid fails with UNAUTHENTICATED.
+	Validate(context.Context, *ValidateRequest) (*ValidateResponse, error)
+	// Replace the OTP secret of an enroled user.
+	Update(context.Context, *UpdateRequest) (*SecretResponse, error)
+	// Delete the OTP secret of a user. The user provides their password and OTP or an administrator with the reset
+	// permission provides their credentials.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// List the users enroled for an issuer and domain. The administrator needs the list permission.
+	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	// Remove the cached admin group membership decisions for a user, or all users if no username is given. The
+	// administrator needs the manage permission.
+	InvalidateAdminCache(context.Context, *InvalidateAdminCacheRequest) (*InvalidateAdminCacheResponse, error)
+	// Return the hit rate and size of the admin group membership cache. The administrator needs the manage permission.
+	GetAdminCacheStats(context.Context, *GetAdminCacheStatsRequest) (*AdminCacheStats, error)
+	// Return the webhook deliveries of events for users in a domain, the most recent first. The administrator needs the
+	// manage permission. Only the deliveries of events for the issuers the application is permitted to use are returned.
+	ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesRequest) (*ListWebhookDeliveriesResponse, error)
+	mustEmbedUnimplementedMFAServerServer()
+}
+
+// UnimplementedMFAServerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMFAServerServer struct{}
+
+func (UnimplementedMFAServerServer) Enrol(context.Context, *EnrolRequest) (*SecretResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Enrol not implemented")
+}
+func (UnimplementedMFAServerServer) Validate(context.Context, *ValidateRequest) (*ValidateResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Validate not implemented")
+}
+func (UnimplementedMFAServerServer) Update(context.Context, *UpdateRequest) (*SecretResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedMFAServerServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedMFAServerServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (UnimplementedMFAServerServer) InvalidateAdminCache(context.Context, *InvalidateAdminCacheRequest) (*InvalidateAdminCacheResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method InvalidateAdminCache not implemented")
+}
+func (UnimplementedMFAServerServer) GetAdminCacheStats(context.Context, *GetAdminCacheStatsRequest) (*AdminCacheStats, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetAdminCacheStats not implemented")
+}
+func (UnimplementedMFAServerServer) ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesRequest) (*ListWebhookDeliveriesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListWebhookDeliveries not implemented")
+}
+func (UnimplementedMFAServerServer) mustEmbedUnimplementedMFAServerServer() {}
+func (UnimplementedMFAServerServer) testEmbeddedByValue()                   {}
+
+// UnsafeMFAServerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MFAServerServer will
+// result in compilation errors.
+type UnsafeMFAServerServer interface {
+	mustEmbedUnimplementedMFAServerServer()
+}
+
+func RegisterMFAServerServer(s grpc.ServiceRegistrar, srv MFAServerServer) {
+	// If the following call panics, it indicates UnimplementedMFAServerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&MFAServer_ServiceDesc, srv)
+}
+
+func _MFAServer_Enrol_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrolRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MFAServerServer).Enrol(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MFAServer_Enrol_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MFAServerServer).Enrol(ctx, req.(*EnrolRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MFAServer_Validate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MFAServerServer).Validate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MFAServer_Validate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MFAServerServer).Validate(ctx, req.(*ValidateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MFAServer_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MFAServerServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MFAServer_Update_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MFAServerServer).Update(ctx, req.(*UpdateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MFAServer_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MFAServerServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MFAServer_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MFAServerServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MFAServer_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MFAServerServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MFAServer_ListUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MFAServerServer).ListUsers(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MFAServer_InvalidateAdminCache_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InvalidateAdminCacheRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MFAServerServer).InvalidateAdminCache(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MFAServer_InvalidateAdminCache_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MFAServerServer).InvalidateAdminCache(ctx, req.(*InvalidateAdminCacheRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MFAServer_GetAdminCacheStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAdminCacheStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MFAServerServer).GetAdminCacheStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MFAServer_GetAdminCacheStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MFAServerServer).GetAdminCacheStats(ctx, req.(*GetAdminCacheStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MFAServer_ListWebhookDeliveries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhookDeliveriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MFAServerServer).ListWebhookDeliveries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MFAServer_ListWebhookDeliveries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MFAServerServer).ListWebhookDeliveries(ctx, req.(*ListWebhookDeliveriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MFAServer_ServiceDesc is the grpc.ServiceDesc for MFAServer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MFAServer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mfaserver.v1.MFAServer",
+	HandlerType: (*MFAServerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Enrol",
+			Handler:    _MFAServer_Enrol_Handler,
+		},
+		{
+			MethodName: "Validate",
+			Handler:    _MFAServer_Validate_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _MFAServer_Update_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _MFAServer_Delete_Handler,
+		},
+		{
+			MethodName: "ListUsers",
+			Handler:    _MFAServer_ListUsers_Handler,
+		},
+		{
+			MethodName: "InvalidateAdminCache",
+			Handler:    _MFAServer_InvalidateAdminCache_Handler,
+		},
+		{
+			MethodName: "GetAdminCacheStats",
+			Handler:    _MFAServer_GetAdminCacheStats_Handler,
+		},
+		{
+			MethodName: "ListWebhookDeliveries",
+			Handler:    _MFAServer_ListWebhookDeliveries_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "mfaserverpb/mfaserver.proto",
+}
@@ -1,13 +1,26 @@
 package secrets
 
 import (
+	"context"
 	"errors"
 	vaultAPI "github.com/hashicorp/vault/api"
 	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/metrics"
+	"github.com/jcmturner/mfaserver/tracing"
 	"github.com/jcmturner/mfaserver/vault"
+	"go.opentelemetry.io/otel/attribute"
+	"strings"
+	"time"
 )
 
-func vaultClientLogin(conf *config.Config) error {
+// observe records the Vault operation, which started at the time, in the metrics and as a span of the trace in the
+// context.
+func observe(ctx context.Context, op string, start time.Time, err error) {
+	metrics.ObserveVault(op, start, err)
+	tracing.Record(ctx, "vault."+op, start, err, attribute.String("vault.operation", op))
+}
+
+func vaultClientLogin(ctx context.Context, conf *config.Config) error {
 	conf.MFAServer.Loggers.Debug.Println("Call to get login token to the Vault")
 	if conf.Vault.VaultLogin == nil {
 		conf.MFAServer.Loggers.Debug.Println("No cached login token, will perform new login request to the Vault.")
@@ -18,7 +31,7 @@ func vaultClientLogin(conf *config.Config) error {
 		}
 		conf.Vault.VaultLogin = &l
 	}
-	token, err := conf.Vault.VaultLogin.GetToken()
+	token, err := conf.Vault.VaultLogin.GetToken(ctx)
 	if err != nil {
 		return errors.New("Error getting login token to the Vault: " + err.Error())
 	}
@@ -37,8 +50,10 @@ func vaultClientLogin(conf *config.Config) error {
 	return nil
 }
 
-func Store(conf *config.Config, p string, k string, v string) error {
-	if err := vaultClientLogin(conf); err != nil {
+func Store(conf *config.Config, p string, k string, v string) (err error) {
+	ctx, span := tracing.Start(conf.Context(), "secrets.Store")
+	defer func() { tracing.End(span, err) }()
+	if err := vaultClientLogin(ctx, conf); err != nil {
 		conf.MFAServer.Loggers.Error.Printf("Problem logging into the Vault during write/store operation: %v\n", err)
 		return err
 	}
@@ -46,7 +61,9 @@ func Store(conf *config.Config, p string, k string, v string) error {
 	toWrite := map[string]interface{}{
 		k: v,
 	}
-	_, err := logical.Write(*conf.Vault.MFASecretsPath+p, toWrite)
+	start := time.Now()
+	_, err = logical.Write(*conf.Vault.MFASecretsPath+p, toWrite)
+	observe(ctx, "write", start, err)
 	if err != nil {
 		conf.MFAServer.Loggers.Error.Printf("Could not write secret into the Vault at %s: %v\n", *conf.Vault.MFASecretsPath+p, err)
 		return err
@@ -55,13 +72,17 @@ func Store(conf *config.Config, p string, k string, v string) error {
 	return nil
 }
 
-func Read(conf *config.Config, p string) (map[string]interface{}, error) {
-	if err := vaultClientLogin(conf); err != nil {
+func Read(conf *config.Config, p string) (data map[string]interface{}, err error) {
+	ctx, span := tracing.Start(conf.Context(), "secrets.Read")
+	defer func() { tracing.End(span, err) }()
+	if err := vaultClientLogin(ctx, conf); err != nil {
 		conf.MFAServer.Loggers.Error.Printf("Problem logging into the Vault during read operation: %v\n", err)
 		return nil, err
 	}
 	logical := conf.Vault.VaultClient.Logical()
+	start := time.Now()
 	s, err := logical.Read(*conf.Vault.MFASecretsPath + p)
+	observe(ctx, "read", start, err)
 	if err != nil {
 		conf.MFAServer.Loggers.Error.Printf("Issue when reading secret from Vault at %s: %v\n", *conf.Vault.MFASecretsPath+p, err)
 	}
@@ -71,16 +92,20 @@ func Read(conf *config.Config, p string) (map[string]interface{}, error) {
 	return s.Data, err
 }
 
-func Delete(conf *config.Config, p string) error {
-	if !Exists(conf, p, "mfa") {
+func Delete(conf *config.Config, p string) (err error) {
+	ctx, span := tracing.Start(conf.Context(), "secrets.Delete")
+	defer func() { tracing.End(span, err) }()
+	if !exists(ctx, conf, p, "mfa") {
 		return errors.New("User does not exist in secrets store.")
 	}
-	if err := vaultClientLogin(conf); err != nil {
+	if err := vaultClientLogin(ctx, conf); err != nil {
 		conf.MFAServer.Loggers.Error.Printf("Problem logging into the Vault during delete operation: %v\n", err)
 		return err
 	}
 	logical := conf.Vault.VaultClient.Logical()
-	_, err := logical.Delete(*conf.Vault.MFASecretsPath + p)
+	start := time.Now()
+	_, err = logical.Delete(*conf.Vault.MFASecretsPath + p)
+	observe(ctx, "delete", start, err)
 	if err != nil {
 		conf.MFAServer.Loggers.Error.Printf("Issue when deleting secret from Vault at %s: %v\n", *conf.Vault.MFASecretsPath+p, err)
 	}
@@ -88,13 +113,21 @@ func Delete(conf *config.Config, p string) error {
 }
 
 func Exists(conf *config.Config, p string, k string) bool {
-	if err := vaultClientLogin(conf); err != nil {
+	ctx, span := tracing.Start(conf.Context(), "secrets.Exists")
+	defer span.End()
+	return exists(ctx, conf, p, k)
+}
+
+func exists(ctx context.Context, conf *config.Config, p string, k string) bool {
+	if err := vaultClientLogin(ctx, conf); err != nil {
 		conf.MFAServer.Loggers.Error.Printf("Problem logging into the Vault during list operation: %v\n", err)
 		return false
 	}
 	logical := conf.Vault.VaultClient.Logical()
 	//Tried using the List method in the following line but it did not return any data when it should have.
+	start := time.Now()
 	s, err := logical.Read(*conf.Vault.MFASecretsPath + p)
+	observe(ctx, "read", start, err)
 	if err != nil {
 		conf.MFAServer.Loggers.Error.Printf("Issue when listing secrets from Vault at %s: %v\n", *conf.Vault.MFASecretsPath+p, err)
 		return false
@@ -105,3 +138,84 @@ func Exists(conf *config.Config, p string, k string) bool {
 	_, ok := s.Data[k]
 	return ok
 }
+
+func List(conf *config.Config, p string) (l []string, err error) {
+	ctx, span := tracing.Start(conf.Context(), "secrets.List")
+	defer func() { tracing.End(span, err) }()
+	if err := vaultClientLogin(ctx, conf); err != nil {
+		conf.MFAServer.Loggers.Error.Printf("Problem logging into the Vault during list operation: %v\n", err)
+		return nil, err
+	}
+	logical := conf.Vault.VaultClient.Logical()
+	start := time.Now()
+	s, err := logical.List(*conf.Vault.MFASecretsPath + p)
+	observe(ctx, "list", start, err)
+	if err != nil {
+		conf.MFAServer.Loggers.Error.Printf("Issue when listing secrets from Vault at %s: %v\n", *conf.Vault.MFASecretsPath+p, err)
+		return nil, err
+	}
+	if s == nil {
+		return l, nil
+	}
+	keys, _ := s.Data["keys"].([]interface{})
+	for _, k := range keys {
+		//Keys ending in a slash are sub paths rather than secrets
+		if ks, ok := k.(string); ok && !strings.HasSuffix(ks, "/") {
+			l = append(l, ks)
+		}
+	}
+	return l, nil
+}
+
+// RevokeToken revokes the Vault token of the MFA server, if it has logged in, so that it cannot be used once the MFA
+// server has stopped.
+func RevokeToken(conf *config.Config) error {
+	if conf.Vault.VaultClient == nil || conf.Vault.VaultLogin == nil || conf.Vault.VaultClient.Token() == "" {
+		return nil
+	}
+	start := time.Now()
+	err := conf.Vault.VaultClient.Auth().Token().RevokeSelf("")
+	observe(conf.Context(), "revoke", start, err)
+	if err != nil {
+		return errors.New("Could not revoke the Vault token: " + err.Error())
+	}
+	conf.Vault.VaultClient.ClearToken()
+	conf.Vault.VaultLogin = nil
+	return nil
+}
+
+// Health checks that the Vault is reachable, initialised and unsealed and that the MFA server can log in with a valid token.
+func Health(conf *config.Config) (err error) {
+	ctx, span := tracing.Start(conf.Context(), "secrets.Health")
+	defer func() { tracing.End(span, err) }()
+	vc := conf.Vault.VaultClient
+	if vc == nil {
+		var err error
+		vc, err = vaultAPI.NewClient(conf.Vault.VaultConfig)
+		if err != nil {
+			return errors.New("Unable to create Vault client: " + err.Error())
+		}
+	}
+	start := time.Now()
+	h, err := vc.Sys().Health()
+	observe(ctx, "health", start, err)
+	if err != nil {
+		return errors.New("Vault is not reachable: " + err.Error())
+	}
+	if !h.Initialized {
+		return errors.New("Vault is not initialised")
+	}
+	if h.Sealed {
+		return errors.New("Vault is sealed")
+	}
+	if err := vaultClientLogin(ctx, conf); err != nil {
+		return err
+	}
+	start = time.Now()
+	_, err = conf.Vault.VaultClient.Auth().Token().LookupSelf()
+	observe(ctx, "lookup_token", start, err)
+	if err != nil {
+		return errors.New("Vault token is not valid: " + err.Error())
+	}
+	return nil
+}
@@ -52,3 +52,19 @@ func TestStoreReadExists(t *testing.T) {
 		t.Errorf("Secret is known to be in the Vault but method thinks it doesn't exist: %v", err)
 	}
 }
+
+func TestList(t *testing.T) {
+	conf, ln := mockVault(t)
+	defer ln.Close()
+
+	for _, u := range []string{"user1", "user2"} {
+		if err := Store(conf, "/listtest/"+u, testMFARef, testMFASecret); err != nil {
+			t.Fatalf("Error when storing secret")
+		}
+	}
+	l, err := List(conf, "/listtest")
+	if err != nil {
+		t.Fatalf("Could not list secrets from vault: %v", err)
+	}
+	assert.ElementsMatch(t, []string{"user1", "user2"}, l, "Secrets listed are not the values expected")
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Session identifies a user who has completed two factor authentication.
+type Session struct {
+	Issuer   string `json:"iss"`
+	Domain   string `json:"dom"`
+	Username string `json:"sub"`
+	AuthTime int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+// New creates a session for the user that expires after the timeout.
+func New(issuer, d, u string, timeout time.Duration) Session {
+	now := time.Now().UTC()
+	return Session{
+		Issuer:   issuer,
+		Domain:   d,
+		Username: u,
+		AuthTime: now.Unix(),
+		Expires:  now.Add(timeout).Unix(),
+	}
+}
+
+// Valid reports whether the session has not yet expired.
+func (s Session) Valid() bool {
+	return time.Now().UTC().Unix() < s.Expires
+}
+
+// Sign encodes the session into a value, suitable for a cookie, protected by an HMAC-SHA256 of the key.
+func Sign(key []byte, s Session) (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", errors.New("Could not encode session: " + err.Error())
+	}
+	p := base64.RawURLEncoding.EncodeToString(b)
+	return p + "." + base64.RawURLEncoding.EncodeToString(mac(key, p)), nil
+}
+
+// Verify checks the value was signed with the key and returns the session if it has not expired.
+func Verify(key []byte, v string) (Session, error) {
+	var s Session
+	i := strings.LastIndex(v, ".")
+	if i < 1 {
+		return s, errors.New("Session value is not in the expected format")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(v[i+1:])
+	if err != nil || !hmac.Equal(sig, mac(key, v[:i])) {
+		return s, errors.New("Session signature is not valid")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(v[:i])
+	if err != nil {
+		return s, errors.New("Session value could not be decoded: " + err.Error())
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, errors.New("Session value could not be decoded: " + err.Error())
+	}
+	if !s.Valid() {
+		return s, errors.New("Session has expired")
+	}
+	return s, nil
+}
+
+func mac(key []byte, p string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(p))
+	return h.Sum(nil)
+}
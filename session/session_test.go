@@ -0,0 +1,65 @@
+package session
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSignVerify(t *testing.T) {
+	s := New("testapp", "testdom", "validuser", time.Minute)
+	v, err := Sign(testKey, s)
+	if err != nil {
+		t.Fatalf("Error signing session: %v", err)
+	}
+	vs, err := Verify(testKey, v)
+	assert.NoError(t, err)
+	assert.Equal(t, s, vs, "Verified session not as signed")
+
+	_, err = Verify([]byte("adifferentkeyadifferentkeyadiffe"), v)
+	assert.Error(t, err, "Session signed with a different key should not verify")
+
+	//Tamper with the payload keeping the original signature
+	i := strings.LastIndex(v, ".")
+	ts := s
+	ts.Username = "validadmin"
+	tv, _ := Sign(testKey, ts)
+	_, err = Verify(testKey, tv[:strings.LastIndex(tv, ".")]+v[i:])
+	assert.Error(t, err, "Tampered session should not verify")
+
+	for _, bad := range []string{"", ".", "nodot", v[:i] + ".!!!"} {
+		_, err = Verify(testKey, bad)
+		assert.Error(t, err, "Malformed value %q should not verify", bad)
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	s := New("testapp", "testdom", "validuser", -time.Second)
+	assert.False(t, s.Valid(), "Session should have expired")
+	v, _ := Sign(testKey, s)
+	_, err := Verify(testKey, v)
+	assert.Error(t, err, "Expired session should not verify")
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore(time.Minute)
+	k, err := s.Put("value")
+	if err != nil {
+		t.Fatalf("Error storing value: %v", err)
+	}
+	v, ok := s.Take(k)
+	assert.True(t, ok, "Stored value not returned")
+	assert.Equal(t, "value", v, "Stored value not as expected")
+	_, ok = s.Take(k)
+	assert.False(t, ok, "Value should only be returned once")
+	_, ok = s.Take("unknown")
+	assert.False(t, ok, "Unknown key should not return a value")
+
+	s = NewStore(-time.Second)
+	k, _ = s.Put("value")
+	_, ok = s.Take(k)
+	assert.False(t, ok, "Expired value should not be returned")
+}
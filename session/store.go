@@ -0,0 +1,63 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Store holds short lived, single use values, such as OAuth authorization codes, in memory.
+type Store struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]storeEntry
+}
+
+type storeEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// NewStore creates a store whose values expire after the TTL.
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:     ttl,
+		entries: make(map[string]storeEntry),
+	}
+}
+
+// Put stores the value and returns the random key it can be taken with.
+func (s *Store) Put(v interface{}) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("Could not generate key: " + err.Error())
+	}
+	k := base64.RawURLEncoding.EncodeToString(b)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for ek, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, ek)
+		}
+	}
+	s.entries[k] = storeEntry{value: v, expires: now.Add(s.ttl)}
+	return k, nil
+}
+
+// Take returns the value for the key and removes it so that it cannot be used again.
+func (s *Store) Take(k string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[k]
+	if !ok {
+		return nil, false
+	}
+	delete(s.entries, k)
+	if time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.value, true
+}
@@ -0,0 +1,359 @@
+// Package siem sends security events to a SIEM as RFC 5424 syslog messages in the ArcSight Common Event Format (CEF) or
+// the IBM QRadar Log Event Extended Format (LEEF).
+package siem
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/mfaserver/audit"
+	"github.com/jcmturner/mfaserver/version"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the messages
+const (
+	FormatCEF  = "CEF"
+	FormatLEEF = "LEEF"
+)
+
+// Transports of the syslog messages
+const (
+	NetworkUDP = "udp"
+	NetworkTCP = "tcp"
+	NetworkTLS = "tls"
+)
+
+const (
+	vendor  = "jcmturner"
+	product = "mfaserver"
+	// Syslog facility security/authorization (authpriv)
+	facilityAuthPriv = 10
+	// Events waiting to be sent before further events are dropped
+	queueSize = 1024
+	// How long to wait before reconnecting after a connection fails
+	reconnectDelay = 5 * time.Second
+	dialTimeout    = 10 * time.Second
+	writeTimeout   = 10 * time.Second
+)
+
+// Syslog severities (RFC 5424)
+const (
+	syslogError   = 3
+	syslogWarning = 4
+	syslogNotice  = 5
+	syslogInfo    = 6
+)
+
+// Sink sends events to the SIEM. Events are queued and sent in the background so that a slow or unavailable SIEM does
+// not delay requests.
+type Sink struct {
+	network   string
+	address   string
+	format    string
+	tlsConfig *tls.Config
+	hostname  string
+	queue     chan []byte
+	closing   chan struct{}
+	done      chan struct{}
+	mux       sync.RWMutex
+	closed    bool
+	// Reports errors sending events, which are otherwise not returned
+	OnError func(error)
+}
+
+// New creates a sink that sends events to the syslog server at the address. The tls configuration is only used with
+// the tls network.
+func New(network, address, format string, tlsConfig *tls.Config) (*Sink, error) {
+	if network != NetworkUDP && network != NetworkTCP && network != NetworkTLS {
+		return nil, errors.New("Network must be one of udp, tcp or tls")
+	}
+	if format != FormatCEF && format != FormatLEEF {
+		return nil, errors.New("Format must be CEF or LEEF")
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, errors.New("Address not valid: " + err.Error())
+	}
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		h = "-"
+	}
+	s := &Sink{
+		network:   network,
+		address:   address,
+		format:    format,
+		tlsConfig: tlsConfig,
+		hostname:  h,
+		queue:     make(chan []byte, queueSize),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go s.send()
+	return s, nil
+}
+
+// Send queues the event to be sent. An error is returned if the queue is full and the event is dropped.
+func (s *Sink) Send(e audit.Event) error {
+	m := s.message(e, time.Now())
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.closed {
+		return errors.New("SIEM sink is closed, event dropped")
+	}
+	select {
+	case s.queue <- m:
+		return nil
+	default:
+		return errors.New("SIEM queue is full, event dropped")
+	}
+}
+
+// Close sends the events queued, waiting up to the timeout, and closes the connection. Events that cannot be sent
+// because the SIEM is unavailable are dropped.
+func (s *Sink) Close(timeout time.Duration) {
+	s.mux.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.closing)
+		close(s.queue)
+	}
+	s.mux.Unlock()
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+	}
+}
+
+func (s *Sink) send() {
+	defer close(s.done)
+	var conn net.Conn
+	for m := range s.queue {
+		for {
+			if conn == nil {
+				var err error
+				conn, err = s.dial()
+				if err != nil {
+					s.error(errors.New("Could not connect to the SIEM at " + s.address + ": " + err.Error()))
+					if s.wait() {
+						continue
+					}
+					break
+				}
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := conn.Write(m); err != nil {
+				s.error(errors.New("Could not send event to the SIEM at " + s.address + ": " + err.Error()))
+				conn.Close()
+				conn = nil
+				if s.wait() {
+					continue
+				}
+			}
+			break
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// wait pauses before reconnecting. It reports false if the sink is closing, in which case the event is dropped rather
+// than delay shutdown.
+func (s *Sink) wait() bool {
+	select {
+	case <-time.After(reconnectDelay):
+		return true
+	case <-s.closing:
+		return false
+	}
+}
+
+func (s *Sink) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: dialTimeout}
+	if s.network == NetworkTLS {
+		return tls.DialWithDialer(d, "tcp", s.address, s.tlsConfig)
+	}
+	return d.Dial(s.network, s.address)
+}
+
+func (s *Sink) error(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// message formats the event as an RFC 5424 syslog message. Over TCP and TLS messages are framed by octet counting
+// (RFC 6587, RFC 5425).
+func (s *Sink) message(e audit.Event, t time.Time) []byte {
+	sev := severity(e)
+	var msg string
+	if s.format == FormatLEEF {
+		msg = leef(e, t, sev)
+	} else {
+		msg = cef(e, t, sev)
+	}
+	m := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", facilityAuthPriv*8+sev.syslog, t.UTC().Format(time.RFC3339Nano),
+		s.hostname, product, os.Getpid(), msgID(e.Type), msg)
+	if s.network == NetworkUDP {
+		return []byte(m)
+	}
+	return []byte(strconv.Itoa(len(m)) + " " + m)
+}
+
+// eventSeverity is the severity of an event on the SIEM's 0 to 10 scale and as a syslog severity.
+type eventSeverity struct {
+	siem   int
+	syslog int
+}
+
+// severity maps the event to a severity. Failed authentication is more severe than routine use, and administrators
+// removing users or being refused is more severe again.
+func severity(e audit.Event) eventSeverity {
+	switch e.Outcome {
+	case "success":
+		switch {
+		case e.Type == audit.EventDelete && e.Admin != "":
+			return eventSeverity{6, syslogNotice}
+		case e.Type == audit.EventValidate || e.Type == audit.EventLogin:
+			return eventSeverity{1, syslogInfo}
+		}
+		return eventSeverity{3, syslogNotice}
+	case "otp_fail", "ldap_fail", "unauthorized":
+		if e.Type == audit.EventList || e.Type == audit.EventAdminCacheInvalidate || e.Admin != "" {
+			return eventSeverity{7, syslogWarning}
+		}
+		return eventSeverity{5, syslogWarning}
+	case "forbidden":
+		return eventSeverity{7, syslogWarning}
+	case "store_error", "error":
+		return eventSeverity{4, syslogError}
+	}
+	return eventSeverity{3, syslogNotice}
+}
+
+// name describes the event for the SIEM's analysts.
+func name(e audit.Event) string {
+	n := map[string]string{
+		audit.EventValidate:             "OTP validation",
+		audit.EventEnrol:                "OTP enrolment",
+		audit.EventUpdate:               "OTP secret update",
+		audit.EventDelete:               "OTP deletion",
+		audit.EventList:                 "Enrolled users listed",
+		audit.EventLogin:                "Login",
+		audit.EventAdminCacheInvalidate: "Admin group cache invalidation",
+	}[e.Type]
+	if n == "" {
+		n = e.Type
+	}
+	if e.Type == audit.EventDelete && e.Admin != "" {
+		n = "OTP deletion by administrator"
+	}
+	if e.Outcome == "success" {
+		return n + " succeeded"
+	}
+	return n + " failed: " + e.Outcome
+}
+
+// msgID returns the syslog MSGID, which is limited to 32 printable characters.
+func msgID(t string) string {
+	if t == "" {
+		return "-"
+	}
+	if len(t) > 32 {
+		return t[:32]
+	}
+	return t
+}
+
+// users returns the user that acted and the user acted upon. An administrator acts upon the user.
+func users(e audit.Event) (src, dst string) {
+	if e.Admin != "" {
+		return e.Admin, e.Username
+	}
+	return e.Username, ""
+}
+
+func cef(e audit.Event, t time.Time, sev eventSeverity) string {
+	src, dst := users(e)
+	ext := [][2]string{
+		{"rt", strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)},
+		{"act", e.Type},
+		{"outcome", e.Outcome},
+	}
+	if ip, port, err := net.SplitHostPort(e.RemoteAddr); err == nil {
+		ext = append(ext, [2]string{"src", ip}, [2]string{"spt", port})
+	}
+	ext = append(ext,
+		[2]string{"suser", src},
+		[2]string{"duser", dst},
+		[2]string{"sntdom", e.Domain},
+		[2]string{"cs1Label", "issuer"}, [2]string{"cs1", e.Issuer},
+		[2]string{"cs2Label", "application"}, [2]string{"cs2", e.Application},
+		[2]string{"cs3Label", "requestId"}, [2]string{"cs3", e.RequestID},
+	)
+	var pairs []string
+	for _, kv := range ext {
+		if kv[1] != "" {
+			pairs = append(pairs, kv[0]+"="+cefExtensionEscaper.Replace(kv[1]))
+		}
+	}
+	return strings.Join([]string{
+		"CEF:0",
+		cefHeaderEscaper.Replace(vendor),
+		cefHeaderEscaper.Replace(product),
+		cefHeaderEscaper.Replace(version.Version),
+		cefHeaderEscaper.Replace(e.Type + ":" + e.Outcome),
+		cefHeaderEscaper.Replace(name(e)),
+		strconv.Itoa(sev.siem),
+		strings.Join(pairs, " "),
+	}, "|")
+}
+
+func leef(e audit.Event, t time.Time, sev eventSeverity) string {
+	src, dst := users(e)
+	attrs := [][2]string{
+		{"devTime", strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)},
+		{"devTimeFormat", "epoch"},
+		{"cat", e.Type},
+		{"sev", strconv.Itoa(sev.siem)},
+		{"outcome", e.Outcome},
+	}
+	if ip, port, err := net.SplitHostPort(e.RemoteAddr); err == nil {
+		attrs = append(attrs, [2]string{"src", ip}, [2]string{"srcPort", port})
+	}
+	attrs = append(attrs,
+		[2]string{"usrName", src},
+		[2]string{"dstUsrName", dst},
+		[2]string{"domain", e.Domain},
+		[2]string{"issuer", e.Issuer},
+		[2]string{"application", e.Application},
+		[2]string{"requestId", e.RequestID},
+		[2]string{"msg", name(e)},
+	)
+	var pairs []string
+	for _, kv := range attrs {
+		if kv[1] != "" {
+			pairs = append(pairs, kv[0]+"="+leefValueEscaper.Replace(kv[1]))
+		}
+	}
+	return strings.Join([]string{
+		"LEEF:1.0",
+		leefHeaderEscaper.Replace(vendor),
+		leefHeaderEscaper.Replace(product),
+		leefHeaderEscaper.Replace(version.Version),
+		leefHeaderEscaper.Replace(e.Type + ":" + e.Outcome),
+		strings.Join(pairs, "\t"),
+	}, "|")
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	leefHeaderEscaper   = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ", "\t", " ")
+	leefValueEscaper    = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+)
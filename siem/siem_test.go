@@ -0,0 +1,163 @@
+package siem
+
+import (
+	"bufio"
+	"github.com/jcmturner/mfaserver/audit"
+	"github.com/jcmturner/mfaserver/version"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestSeverity(t *testing.T) {
+	var tests = []struct {
+		event  audit.Event
+		siem   int
+		syslog int
+	}{
+		{audit.Event{Type: audit.EventValidate, Outcome: "success"}, 1, syslogInfo},
+		{audit.Event{Type: audit.EventLogin, Outcome: "success"}, 1, syslogInfo},
+		{audit.Event{Type: audit.EventEnrol, Outcome: "success"}, 3, syslogNotice},
+		{audit.Event{Type: audit.EventDelete, Outcome: "success"}, 3, syslogNotice},
+		{audit.Event{Type: audit.EventDelete, Outcome: "success", Admin: "test.com/mfaadmin"}, 6, syslogNotice},
+		{audit.Event{Type: audit.EventValidate, Outcome: "otp_fail"}, 5, syslogWarning},
+		{audit.Event{Type: audit.EventEnrol, Outcome: "ldap_fail"}, 5, syslogWarning},
+		{audit.Event{Type: audit.EventList, Outcome: "ldap_fail"}, 7, syslogWarning},
+		{audit.Event{Type: audit.EventDelete, Outcome: "unauthorized", Admin: "test.com/mfaadmin"}, 7, syslogWarning},
+		{audit.Event{Type: audit.EventDelete, Outcome: "forbidden"}, 7, syslogWarning},
+		{audit.Event{Type: audit.EventValidate, Outcome: "store_error"}, 4, syslogError},
+	}
+	for _, test := range tests {
+		sev := severity(test.event)
+		assert.Equal(t, test.siem, sev.siem, "SIEM severity not as expected for %s %s", test.event.Type, test.event.Outcome)
+		assert.Equal(t, test.syslog, sev.syslog, "Syslog severity not as expected for %s %s", test.event.Type, test.event.Outcome)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	e := audit.Event{
+		Type:        audit.EventDelete,
+		Outcome:     "success",
+		RequestID:   "abc123",
+		RemoteAddr:  "192.0.2.1:51234",
+		Application: "app|one",
+		Admin:       "test.com/mfa=admin",
+		Issuer:      "testapp",
+		Domain:      "test.com",
+		Username:    "validuser",
+	}
+	var tests = []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"CEF", cef(e, testTime, severity(e)),
+			"CEF:0|jcmturner|mfaserver|" + version.Version + "|delete:success|OTP deletion by administrator succeeded|6|" +
+				`rt=1483326245000 act=delete outcome=success src=192.0.2.1 spt=51234 suser=test.com/mfa\=admin duser=validuser ` +
+				"sntdom=test.com cs1Label=issuer cs1=testapp cs2Label=application cs2=app|one cs3Label=requestId cs3=abc123"},
+		{"LEEF", leef(e, testTime, severity(e)),
+			"LEEF:1.0|jcmturner|mfaserver|" + version.Version + "|delete:success|" +
+				"devTime=1483326245000\tdevTimeFormat=epoch\tcat=delete\tsev=6\toutcome=success\tsrc=192.0.2.1\tsrcPort=51234\t" +
+				"usrName=test.com/mfa=admin\tdstUsrName=validuser\tdomain=test.com\tissuer=testapp\tapplication=app|one\t" +
+				"requestId=abc123\tmsg=OTP deletion by administrator succeeded"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, test.got, "%s message not as expected", test.name)
+	}
+
+	//Values that could break the header are escaped
+	e = audit.Event{Type: "a|b", Outcome: "otp_fail", Username: "user\nname"}
+	assert.Contains(t, cef(e, testTime, severity(e)), `|a\|b:otp_fail|`, "CEF header not escaped")
+	assert.Contains(t, cef(e, testTime, severity(e)), `suser=user\nname`, "CEF extension not escaped")
+	assert.Contains(t, leef(e, testTime, severity(e)), "usrName=user name", "LEEF attribute not escaped")
+}
+
+func TestSink_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening for syslog messages: %v", err)
+	}
+	defer pc.Close()
+	s, err := New(NetworkUDP, pc.LocalAddr().String(), FormatLEEF, nil)
+	if err != nil {
+		t.Fatalf("Error creating SIEM sink: %v", err)
+	}
+	defer s.Close(time.Second)
+	if err := s.Send(audit.Event{Type: audit.EventValidate, Outcome: "otp_fail", Domain: "test.com", Username: "validuser"}); err != nil {
+		t.Fatalf("Error sending event: %v", err)
+	}
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 4096)
+	n, _, err := pc.ReadFrom(b)
+	if err != nil {
+		t.Fatalf("Error reading syslog message: %v", err)
+	}
+	m := string(b[:n])
+	//authpriv.warning
+	assert.True(t, strings.HasPrefix(m, "<84>1 "), "Syslog header not as expected: %s", m)
+	assert.Contains(t, m, " mfaserver ", "Syslog APP-NAME not in message")
+	assert.Contains(t, m, " validate - LEEF:1.0|jcmturner|mfaserver|", "Syslog MSGID or LEEF header not as expected")
+	assert.Contains(t, m, "usrName=validuser", "LEEF attributes not in message")
+}
+
+func TestSink_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening for syslog messages: %v", err)
+	}
+	defer ln.Close()
+	s, err := New(NetworkTCP, ln.Addr().String(), FormatCEF, nil)
+	if err != nil {
+		t.Fatalf("Error creating SIEM sink: %v", err)
+	}
+	s.Send(audit.Event{Type: audit.EventEnrol, Outcome: "success", Username: "user1"})
+	s.Send(audit.Event{Type: audit.EventDelete, Outcome: "success", Admin: "test.com/mfaadmin", Username: "user2"})
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting connection: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	//Messages are framed by their length
+	for _, want := range []string{"suser=user1", "suser=test.com/mfaadmin duser=user2"} {
+		l, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatalf("Error reading message length: %v", err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(l))
+		if err != nil {
+			t.Fatalf("Message not framed by its length: %q", l)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatalf("Error reading message: %v", err)
+		}
+		assert.Contains(t, string(b), "CEF:0|jcmturner|mfaserver|", "CEF header not in message")
+		assert.Contains(t, string(b), want, "CEF extension not as expected")
+	}
+	s.Close(time.Second)
+	assert.Error(t, s.Send(audit.Event{Type: audit.EventValidate}), "Send to a closed sink did not return an error")
+}
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		network string
+		address string
+		format  string
+	}{
+		{"http", "127.0.0.1:514", FormatCEF},
+		{NetworkUDP, "127.0.0.1", FormatCEF},
+		{NetworkUDP, "127.0.0.1:514", "JSON"},
+	}
+	for _, test := range tests {
+		_, err := New(test.network, test.address, test.format, nil)
+		assert.Error(t, err, "No error for network %s, address %s and format %s", test.network, test.address, test.format)
+	}
+}
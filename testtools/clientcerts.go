@@ -0,0 +1,75 @@
+package testtools
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// GenerateTestCA creates a CA certificate and key for issuing client certificates.
+func GenerateTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"Acme Co"}, CommonName: "Test Client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("Error creating CA certificate for testing: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(derBytes)
+	return cert, priv
+}
+
+// GenerateTestClientCertificate issues a client certificate from the CA with the common name and DNS names.
+func GenerateTestClientCertificate(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, cn string, dnsNames []string) tls.Certificate {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serialNumber, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{Organization: []string{"Acme Co"}, CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, ca, &priv.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Error creating client certificate for testing: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(derBytes)
+	return tls.Certificate{Certificate: [][]byte{derBytes}, PrivateKey: priv, Leaf: leaf}
+}
+
+// GenerateTestCRL creates a PEM encoded certificate revocation list from the CA revoking the certificates.
+func GenerateTestCRL(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, nextUpdate time.Time, revoked ...*x509.Certificate) []byte {
+	template := x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: nextUpdate,
+	}
+	for _, c := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   c.SerialNumber,
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	derBytes, err := x509.CreateRevocationList(rand.Reader, &template, ca, caKey)
+	if err != nil {
+		t.Fatalf("Error creating CRL for testing: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: derBytes})
+}
@@ -2,6 +2,7 @@ package testtools
 
 import (
 	ldap "github.com/vjeantet/ldapserver"
+	"strings"
 	"testing"
 )
 
@@ -45,14 +46,85 @@ func handleBind(w ldap.ResponseWriter, m *ldap.Message, t *testing.T) {
 	w.Write(res)
 }
 
+// Object identifier of the Active Directory LDAP_MATCHING_RULE_IN_CHAIN matching rule
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+// Attributes of the entries that nested group membership is resolved through
+var directory = map[string]map[string][]string{
+	"cn=parentadmin,ou=groups,dc=example,dc=com": {
+		"member": {"cn=mfaadmin,ou=groups,dc=example,dc=com"},
+	},
+	"cn=mfaadmin,ou=groups,dc=example,dc=com": {
+		"member":   {"cn=validuser,ou=users,dc=example,dc=com"},
+		"memberOf": {"cn=parentadmin,ou=groups,dc=example,dc=com"},
+	},
+	"cn=validuser,ou=users,dc=example,dc=com": {
+		"memberOf": {"cn=mfaadmin,ou=groups,dc=example,dc=com"},
+	},
+}
+
 func handleSearch(w ldap.ResponseWriter, m *ldap.Message, t *testing.T) {
 	r := m.GetSearchRequest()
-	t.Logf("Test LDAP search")
-	e := ldap.NewSearchResultEntry("cn=mfaadmin, " + string(r.BaseObject()))
-	e.AddAttribute("cn", "mfaadmin")
-	e.AddAttribute("memberUid", "validuser", "validadmin")
-	w.Write(e)
+	base := strings.ToLower(string(r.BaseObject()))
+	t.Logf("Test LDAP search on %s with filter %s", string(r.BaseObject()), r.FilterString())
+	if attr, value, ok := inChainFilter(r.FilterString()); ok {
+		//The entry is only returned if the value is reached by following the attribute through the nested entries
+		if inChain(base, attr, value, make(map[string]bool)) {
+			w.Write(ldap.NewSearchResultEntry(string(r.BaseObject())))
+		}
+		w.Write(ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess))
+		return
+	}
+	switch base {
+	case "cn=parentadmin,ou=groups,dc=example,dc=com":
+		//Group that only contains the mfaadmin group for testing nested group resolution
+		e := ldap.NewSearchResultEntry("cn=parentadmin,ou=groups,dc=example,dc=com")
+		e.AddAttribute("cn", "parentadmin")
+		e.AddAttribute("member", "cn=mfaadmin,ou=groups,dc=example,dc=com")
+		w.Write(e)
+	case "cn=validuser,ou=users,dc=example,dc=com":
+		e := ldap.NewSearchResultEntry("cn=validuser,ou=users,dc=example,dc=com")
+		e.AddAttribute("cn", "validuser")
+		e.AddAttribute("memberOf", "cn=mfaadmin,ou=groups,dc=example,dc=com")
+		w.Write(e)
+	case "cn=emptygroup,ou=groups,dc=example,dc=com":
+		//No entries are returned for this group
+	default:
+		e := ldap.NewSearchResultEntry("cn=mfaadmin, " + string(r.BaseObject()))
+		e.AddAttribute("cn", "mfaadmin")
+		e.AddAttribute("memberUid", "validuser", "validadmin")
+		e.AddAttribute("member", "cn=validuser,ou=users,dc=example,dc=com")
+		e.AddAttribute("memberOf", "cn=parentadmin,ou=groups,dc=example,dc=com")
+		w.Write(e)
+	}
 
 	res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess)
 	w.Write(res)
 }
+
+// inChainFilter returns the attribute and value of an extensible match filter, such as
+// (member:1.2.840.113556.1.4.1941:=cn=validuser,ou=users,dc=example,dc=com), that uses the in chain matching rule.
+func inChainFilter(f string) (attr, value string, ok bool) {
+	f = strings.TrimSuffix(strings.TrimPrefix(f, "("), ")")
+	i := strings.Index(f, ":=")
+	if i < 0 {
+		return "", "", false
+	}
+	p := strings.Split(f[:i], ":")
+	if len(p) != 2 || p[1] != matchingRuleInChain {
+		return "", "", false
+	}
+	return p[0], strings.ToLower(f[i+2:]), true
+}
+
+// inChain reports whether the value is reached by following the attribute from the entry with the DN given.
+func inChain(dn, attr, value string, visited map[string]bool) bool {
+	visited[dn] = true
+	for _, v := range directory[dn][attr] {
+		v = strings.ToLower(v)
+		if v == value || (!visited[v] && inChain(v, attr, value, visited)) {
+			return true
+		}
+	}
+	return false
+}
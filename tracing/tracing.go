@@ -0,0 +1,236 @@
+// Package tracing records spans of the work done to handle requests, such as LDAP binds and Vault calls, and exports
+// them to an OpenTelemetry collector over OTLP. W3C trace context is propagated from incoming requests so that the
+// spans join the callers' traces.
+package tracing
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/jcmturner/mfaserver/metrics"
+	"github.com/jcmturner/mfaserver/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Protocols the spans can be exported with
+const (
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+)
+
+// Name of the instrumentation that records the spans
+const instrumentationName = "github.com/jcmturner/mfaserver"
+
+// Default name of the service the spans are from
+const DefaultServiceName = "mfaserver"
+
+// Options define where spans are exported to.
+type Options struct {
+	// URL of the collector. An http URL exports without TLS. With the http protocol the path, if any, replaces the
+	// default of /v1/traces.
+	Endpoint string
+	// ProtocolHTTP (OTLP/HTTP with protobuf) or ProtocolGRPC
+	Protocol string
+	// Sent with each export, for example to authenticate to the collector
+	Headers map[string]string
+	// Used to verify the collector's certificate with an https URL
+	TLSConfig *tls.Config
+	// Name of the service the spans are from
+	ServiceName string
+	// Fraction of the traces started by the MFA server that are recorded. Traces started by callers are recorded if the
+	// caller recorded them.
+	SampleRatio float64
+}
+
+func init() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Init starts exporting spans to the collector. The function returned flushes the spans not yet exported and stops
+// exporting.
+func Init(o Options) (func(context.Context) error, error) {
+	u, err := url.Parse(o.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("Endpoint must be an absolute http or https URL")
+	}
+	if o.SampleRatio < 0 || o.SampleRatio > 1 {
+		return nil, errors.New("SampleRatio must be between 0 and 1")
+	}
+	var client otlptrace.Client
+	switch o.Protocol {
+	case ProtocolHTTP, "":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host), otlptracehttp.WithHeaders(o.Headers)}
+		if u.Path != "" && u.Path != "/" {
+			opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+		}
+		if u.Scheme == "http" {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		} else if o.TLSConfig != nil {
+			opts = append(opts, otlptracehttp.WithTLSClientConfig(o.TLSConfig))
+		}
+		client = otlptracehttp.NewClient(opts...)
+	case ProtocolGRPC:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(u.Host), otlptracegrpc.WithHeaders(o.Headers)}
+		if u.Scheme == "http" {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		} else if o.TLSConfig != nil {
+			opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(o.TLSConfig)))
+		}
+		client = otlptracegrpc.NewClient(opts...)
+	default:
+		return nil, errors.New("Protocol must be http or grpc")
+	}
+	//The exporter connects lazily so that an unavailable collector does not prevent the MFA server starting
+	exp, err := otlptrace.New(context.Background(), client)
+	if err != nil {
+		return nil, errors.New("Could not create OTLP exporter: " + err.Error())
+	}
+	name := o.ServiceName
+	if name == "" {
+		name = DefaultServiceName
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(o.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", name),
+			attribute.String("service.version", version.Version),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName, trace.WithInstrumentationVersion(version.Version))
+}
+
+// Start starts a span as a child of the span in the context.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends the span, marking it as failed if there is an error.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Record records a span of work that has already completed, which started at the time, as a child of the span in the
+// context.
+func Record(ctx context.Context, name string, start time.Time, err error, attrs ...attribute.KeyValue) {
+	_, span := tracer().Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	End(span, err)
+}
+
+// SetAttributes adds the attributes to the span in the context.
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
+
+// Middleware serves requests to the endpoint within a server span that continues the trace in the request's W3C
+// traceparent header, if it has one.
+func Middleware(endpoint string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method+" "+endpoint,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", endpoint),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", clientAddress(r.RemoteAddr)),
+			))
+		defer span.End()
+		sw := &metrics.StatusWriter{ResponseWriter: w}
+		f(sw, r.WithContext(ctx))
+		code := sw.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", code))
+		if code >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(code))
+		}
+	}
+}
+
+// UnaryServerInterceptor serves gRPC calls within a server span that continues the trace in the call's W3C traceparent
+// metadata, if it has one.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+		service, method := path.Split(strings.TrimPrefix(info.FullMethod, "/"))
+		attrs := []attribute.KeyValue{
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.service", strings.TrimSuffix(service, "/")),
+			attribute.String("rpc.method", method),
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			attrs = append(attrs, attribute.String("client.address", clientAddress(p.Addr.String())))
+		}
+		ctx, span := tracer().Start(ctx, strings.TrimPrefix(info.FullMethod, "/"),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...))
+		defer span.End()
+		resp, err := handler(ctx, req)
+		s := status.Convert(err)
+		span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(s.Code())))
+		switch s.Code() {
+		case grpccodes.Unknown, grpccodes.DeadlineExceeded, grpccodes.Unimplemented, grpccodes.Internal, grpccodes.Unavailable, grpccodes.DataLoss:
+			span.SetStatus(codes.Error, s.Message())
+		}
+		return resp, err
+	}
+}
+
+// metadataCarrier carries the trace context in gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	if v := metadata.MD(m).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (m metadataCarrier) Set(key, value string) {
+	metadata.MD(m).Set(key, value)
+}
+
+func (m metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// clientAddress removes the port from the remote address.
+func clientAddress(addr string) string {
+	if i := strings.LastIndex(addr, ":"); i != -1 {
+		return strings.Trim(addr[:i], "[]")
+	}
+	return addr
+}
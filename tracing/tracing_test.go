@@ -0,0 +1,187 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	coltrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is an in-process OTLP/HTTP collector that keeps the spans exported to it.
+type collector struct {
+	mux     sync.Mutex
+	spans   []*tracepb.Span
+	service string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	b, _ := ioutil.ReadAll(r.Body)
+	var req coltrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mux.Lock()
+	for _, rs := range req.ResourceSpans {
+		for _, a := range rs.Resource.Attributes {
+			if a.Key == "service.name" {
+				c.service = a.Value.GetStringValue()
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	c.mux.Unlock()
+	resp, _ := proto.Marshal(&coltrace.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+func (c *collector) span(name string) *tracepb.Span {
+	for _, s := range c.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func attr(s *tracepb.Span, key string) interface{} {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			if _, ok := a.Value.Value.(*commonpb.AnyValue_IntValue); ok {
+				return a.Value.GetIntValue()
+			}
+			return a.Value.GetStringValue()
+		}
+	}
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	col := &collector{}
+	s := httptest.NewServer(col)
+	defer s.Close()
+	stop, err := Init(Options{Endpoint: s.URL, ServiceName: "mfaserver-test", SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("Error initialising tracing: %v", err)
+	}
+
+	h := Middleware("/validate", func(w http.ResponseWriter, r *http.Request) {
+		Record(r.Context(), "vault.read", time.Now().Add(-time.Millisecond), nil)
+		_, span := Start(r.Context(), "ldap.bind")
+		End(span, errors.New("Invalid credentials"))
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	r := httptest.NewRequest("POST", "/validate", nil)
+	//A trace started by the caller
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01")
+	h(httptest.NewRecorder(), r)
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("Error flushing spans: %v", err)
+	}
+
+	col.mux.Lock()
+	defer col.mux.Unlock()
+	assert.Equal(t, "mfaserver-test", col.service, "Service name not as expected")
+	assert.Len(t, col.spans, 3, "Number of spans exported not as expected")
+	server := col.span("POST /validate")
+	if server == nil {
+		t.Fatal("Server span not exported")
+	}
+	assert.Equal(t, "4bf92f3577b34da6a3ce929b0e0e4736", hex.EncodeToString(server.TraceId), "Trace not continued from the traceparent header")
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(server.ParentSpanId), "Server span parent not as expected")
+	assert.Equal(t, tracepb.Span_SPAN_KIND_SERVER, server.Kind, "Server span kind not as expected")
+	assert.Equal(t, int64(http.StatusUnauthorized), attr(server, "http.response.status_code"), "Status code attribute not as expected")
+	assert.Equal(t, "/validate", attr(server, "http.route"), "Route attribute not as expected")
+	for _, name := range []string{"vault.read", "ldap.bind"} {
+		child := col.span(name)
+		if child == nil {
+			t.Fatalf("Span %s not exported", name)
+		}
+		assert.Equal(t, server.TraceId, child.TraceId, "Span %s not in the request's trace", name)
+		assert.Equal(t, server.SpanId, child.ParentSpanId, "Span %s not a child of the server span", name)
+	}
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, col.span("ldap.bind").Status.Code, "Failed span status not as expected")
+	assert.Equal(t, tracepb.Status_STATUS_CODE_UNSET, col.span("vault.read").Status.Code, "Span status not as expected")
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	col := &collector{}
+	s := httptest.NewServer(col)
+	defer s.Close()
+	stop, err := Init(Options{Endpoint: s.URL, SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("Error initialising tracing: %v", err)
+	}
+
+	i := UnaryServerInterceptor()
+	//A trace started by the caller
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", "00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01"))
+	i(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/mfaserver.v1.MFAServer/Validate"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, span := Start(ctx, "ldap.bind")
+		End(span, nil)
+		return nil, status.Error(grpccodes.Unauthenticated, "The password or OTP is not valid")
+	})
+	i(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/mfaserver.v1.MFAServer/Enrol"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(grpccodes.Internal, "The user's secret could not be stored")
+	})
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("Error flushing spans: %v", err)
+	}
+
+	col.mux.Lock()
+	defer col.mux.Unlock()
+	server := col.span("mfaserver.v1.MFAServer/Validate")
+	if server == nil {
+		t.Fatal("Server span not exported")
+	}
+	assert.Equal(t, "4bf92f3577b34da6a3ce929b0e0e4736", hex.EncodeToString(server.TraceId), "Trace not continued from the traceparent metadata")
+	assert.Equal(t, tracepb.Span_SPAN_KIND_SERVER, server.Kind, "Server span kind not as expected")
+	assert.Equal(t, "mfaserver.v1.MFAServer", attr(server, "rpc.service"), "Service attribute not as expected")
+	assert.Equal(t, "Validate", attr(server, "rpc.method"), "Method attribute not as expected")
+	assert.Equal(t, int64(grpccodes.Unauthenticated), attr(server, "rpc.grpc.status_code"), "Status code attribute not as expected")
+	assert.Equal(t, tracepb.Status_STATUS_CODE_UNSET, server.Status.Code, "A client error should not fail the span")
+	if child := col.span("ldap.bind"); assert.NotNil(t, child, "Child span not exported") {
+		assert.Equal(t, server.SpanId, child.ParentSpanId, "Span not a child of the server span")
+	}
+	if failed := col.span("mfaserver.v1.MFAServer/Enrol"); assert.NotNil(t, failed, "Failed call's span not exported") {
+		assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, failed.Status.Code, "Server error should fail the span")
+	}
+}
+
+func TestInit(t *testing.T) {
+	var tests = []Options{
+		{Endpoint: "collector:4318"},
+		{Endpoint: "ftp://collector:4318"},
+		{Endpoint: "http://collector:4318", Protocol: "thrift"},
+		{Endpoint: "http://collector:4318", SampleRatio: 1.5},
+	}
+	for _, o := range tests {
+		_, err := Init(o)
+		assert.Error(t, err, "Options not rejected: %+v", o)
+	}
+	stop, err := Init(Options{Endpoint: "http://127.0.0.1:4317", Protocol: ProtocolGRPC, SampleRatio: 0.5})
+	if assert.NoError(t, err, "Error initialising gRPC exporter") {
+		stop(context.Background())
+	}
+}
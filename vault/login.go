@@ -1,10 +1,14 @@
 package vault
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/jcmturner/mfaserver/metrics"
+	"github.com/jcmturner/mfaserver/tracing"
 	"github.com/jcmturner/restclient"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -12,6 +16,8 @@ type Login struct {
 	loginResponse
 	request    *restclient.Request
 	validUntil time.Time
+	//mux guards the response and validUntil, which are written on login while the token TTL metric reads them
+	mux sync.Mutex
 }
 
 type loginResponse struct {
@@ -44,7 +50,13 @@ func (l *Login) NewRequest(c *restclient.Config, a, u string) (err error) {
 	return
 }
 
-func (l *Login) process() (err error) {
+// process logs in to Vault. The caller must hold the lock.
+func (l *Login) process(ctx context.Context) (err error) {
+	_, span := tracing.Start(ctx, "vault.Login")
+	defer func(start time.Time) {
+		metrics.ObserveVault("login", start, err)
+		tracing.End(span, err)
+	}(time.Now())
 	httpCode, err := restclient.Send(l.request)
 	if err != nil {
 		return
@@ -58,17 +70,21 @@ func (l *Login) process() (err error) {
 	return
 }
 
-func (l *Login) GetToken() (token string, err error) {
+// GetToken returns the Vault token, logging in if there is no token or it has expired. A login is recorded as a span
+// of the trace in the context.
+func (l *Login) GetToken(ctx context.Context) (token string, err error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
 	// If token no longer valid re-request it first. A zero value for ValidUntil means it never expires
 	if !l.validUntil.IsZero() && time.Now().After(l.validUntil) {
-		err = l.process()
+		err = l.process(ctx)
 		if err != nil {
 			return
 		}
 	}
 	//First time login
 	if l.Auth.ClientToken == "" {
-		err = l.process()
+		err = l.process(ctx)
 		if err != nil {
 			return
 		}
@@ -79,3 +95,19 @@ func (l *Login) GetToken() (token string, err error) {
 	}
 	return
 }
+
+// TTL returns how long until the token expires. It is zero if there is no token and negative if the token does not expire.
+func (l *Login) TTL() time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.Auth.ClientToken == "" {
+		return 0
+	}
+	if l.validUntil.IsZero() {
+		return -1
+	}
+	if ttl := time.Until(l.validUntil); ttl > 0 {
+		return ttl
+	}
+	return 0
+}
@@ -1,9 +1,11 @@
 package vault
 
 import (
+	"context"
 	"github.com/jcmturner/mfaserver/testtools"
 	"github.com/jcmturner/restclient"
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
 	"time"
 )
@@ -21,7 +23,7 @@ func TestLogin_Process(t *testing.T) {
 	c := restclient.NewConfig().WithEndPoint(addr)
 	var l Login
 	l.NewRequest(c, test_app_id, test_user_id)
-	err := l.process()
+	err := l.process(context.Background())
 	assert.NoError(t, err, "Error processing the Login request: %v", err)
 }
 
@@ -31,14 +33,40 @@ func TestLogin_GetToken(t *testing.T) {
 	c := restclient.NewConfig().WithEndPoint(addr)
 	var l Login
 	l.NewRequest(c, test_app_id, test_user_id)
-	token, err := l.GetToken()
+	token, err := l.GetToken(context.Background())
 	assert.NoError(t, err, "Error getting token from the Login request: %v", err)
 	assert.Len(t, token, 36, "Length of the client token returned is not 36")
 	//Get token again. Should be the same as the previous one as the token will not yet have expired
-	token2, _ := l.GetToken()
+	token2, _ := l.GetToken(context.Background())
 	assert.Equal(t, token, token2, "Tokens are not the same, cached token should have been used. Token1: %s Token2: %s", token, token2)
 	//Force a token expiry to get a new one
 	l.validUntil = time.Now().Add(time.Second * -10)
-	token3, _ := l.GetToken()
+	token3, _ := l.GetToken(context.Background())
 	assert.NotEqual(t, token, token3, "Tokens are the same, cached token should NOT have been used. Token1: %s Token2: %s", token, token2)
 }
+
+func TestLogin_TTLWhileGettingToken(t *testing.T) {
+	ln, addr, test_app_id, test_user_id := testtools.RunMockVault(t)
+	defer ln.Close()
+	c := restclient.NewConfig().WithEndPoint(addr)
+	var l Login
+	l.NewRequest(c, test_app_id, test_user_id)
+	var wg sync.WaitGroup
+	//Scrape the TTL as the metrics endpoint would while tokens are got, forcing a login each time. Run with -race.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			l.TTL()
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		l.mux.Lock()
+		l.validUntil = time.Now().Add(time.Second * -10)
+		l.mux.Unlock()
+		_, err := l.GetToken(context.Background())
+		assert.NoError(t, err, "Error getting token from the Login request: %v", err)
+	}
+	wg.Wait()
+	assert.True(t, l.TTL() != 0, "TTL should be set once a token has been got")
+}
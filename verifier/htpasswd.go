@@ -0,0 +1,88 @@
+package verifier
+
+import (
+	"bufio"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Htpasswd verifies the password against the bcrypt hashes in an htpasswd file.
+// The file is re-read when it is modified.
+type Htpasswd struct {
+	Path string
+}
+
+type htpasswdFile struct {
+	modTime time.Time
+	hashes  map[string]string
+}
+
+var htpasswdFiles = struct {
+	sync.Mutex
+	m map[string]*htpasswdFile
+}{m: make(map[string]*htpasswdFile)}
+
+// Hash compared against when the user is not in the file so the response time does not reveal which users exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+func (v *Htpasswd) Verify(r *http.Request, d, u, p string) error {
+	hashes, err := loadHtpasswd(v.Path)
+	if err != nil {
+		return err
+	}
+	h, ok := hashes[u]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(p))
+		return errors.New("User not found in htpasswd file")
+	}
+	return bcrypt.CompareHashAndPassword([]byte(h), []byte(p))
+}
+
+func loadHtpasswd(path string) (map[string]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.New("Could not open htpasswd file: " + err.Error())
+	}
+	htpasswdFiles.Lock()
+	defer htpasswdFiles.Unlock()
+	if f, ok := htpasswdFiles.m[path]; ok && f.modTime.Equal(fi.ModTime()) {
+		return f.hashes, nil
+	}
+	hashes, err := parseHtpasswd(path)
+	if err != nil {
+		return nil, err
+	}
+	htpasswdFiles.m[path] = &htpasswdFile{modTime: fi.ModTime(), hashes: hashes}
+	return hashes, nil
+}
+
+func parseHtpasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("Could not open htpasswd file: " + err.Error())
+	}
+	defer f.Close()
+	hashes := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		pair := strings.SplitN(l, ":", 2)
+		//Only bcrypt hashes are supported
+		if len(pair) != 2 || !strings.HasPrefix(pair[1], "$2") {
+			continue
+		}
+		hashes[pair[0]] = pair[1]
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.New("Could not read htpasswd file: " + err.Error())
+	}
+	return hashes, nil
+}
@@ -0,0 +1,54 @@
+package verifier
+
+import (
+	"errors"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/jcmturner/mfaserver/ldap"
+	"net/http"
+)
+
+// PasswordVerifier checks the first factor of a user's authentication.
+type PasswordVerifier interface {
+	Verify(r *http.Request, d, u, p string) error
+}
+
+// ForIssuer returns the password verifier configured for the issuer. The application is the calling application
+// authenticated for the request, or nil if there is none.
+func ForIssuer(c *config.Config, issuer string, app *config.ApplicationConf) (PasswordVerifier, error) {
+	i := c.Issuer(issuer)
+	switch i.PasswordVerifier {
+	case config.VerifierLDAP:
+		return &LDAP{Config: c}, nil
+	case config.VerifierHtpasswd:
+		return &Htpasswd{Path: *i.HtpasswdFile}, nil
+	case config.VerifierDelegated:
+		return &Delegated{Issuer: issuer, Application: app}, nil
+	}
+	return nil, errors.New("Unknown password verifier " + i.PasswordVerifier + " for issuer " + issuer)
+}
+
+// LDAP verifies the password by binding to the LDAP directory for the user's domain.
+type LDAP struct {
+	Config *config.Config
+}
+
+func (v *LDAP) Verify(r *http.Request, d, u, p string) error {
+	return ldap.Authenticate(d, u, p, v.Config)
+}
+
+// Delegated trusts the calling application to have verified the password.
+// The application must have been authenticated and be permitted to use the issuer. No password is checked.
+type Delegated struct {
+	Issuer      string
+	Application *config.ApplicationConf
+}
+
+func (v *Delegated) Verify(r *http.Request, d, u, p string) error {
+	if v.Application == nil {
+		return errors.New("No authenticated application for delegated password verification")
+	}
+	if !v.Application.IssuerPermitted(v.Issuer) {
+		return errors.New("Application is not permitted to verify passwords for issuer " + v.Issuer)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package verifier
+
+import (
+	"fmt"
+	"github.com/jcmturner/mfaserver/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestForIssuer(t *testing.T) {
+	c := config.NewConfig()
+	f, _ := ioutil.TempFile(os.TempDir(), "htpasswd")
+	defer os.Remove(f.Name())
+	f.Close()
+	c.WithIssuerHtpasswd("htpasswdapp", f.Name())
+	c.WithIssuerDelegated("delegatedapp")
+
+	v, err := ForIssuer(c, "ldapapp", nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &LDAP{}, v, "Issuers not configured should use LDAP")
+	v, err = ForIssuer(c, "htpasswdapp", nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &Htpasswd{}, v, "Verifier for issuer not as expected")
+	a := &config.ApplicationConf{APIKeys: []string{"testkey"}, Issuers: []string{"delegatedapp"}}
+	v, err = ForIssuer(c, "delegatedapp", a)
+	assert.NoError(t, err)
+	assert.Equal(t, &Delegated{Issuer: "delegatedapp", Application: a}, v, "Verifier for issuer not as expected")
+}
+
+func TestHtpasswd_Verify(t *testing.T) {
+	h, _ := bcrypt.GenerateFromPassword([]byte("validpassword"), bcrypt.MinCost)
+	f, _ := ioutil.TempFile(os.TempDir(), "htpasswd")
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, "# Test users\nvaliduser:%s\nsha1user:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n", h)
+	f.Close()
+
+	v := &Htpasswd{Path: f.Name()}
+	var tests = []struct {
+		Username string
+		Password string
+		Valid    bool
+	}{
+		{"validuser", "validpassword", true},
+		{"validuser", "invalidpassword", false},
+		{"invaliduser", "validpassword", false},
+		{"sha1user", "password", false},
+	}
+	for _, test := range tests {
+		err := v.Verify(nil, "testdom", test.Username, test.Password)
+		if test.Valid && err != nil {
+			t.Errorf("Verification of %s/%s should have passed: %v", test.Username, test.Password, err)
+		}
+		if !test.Valid && err == nil {
+			t.Errorf("Verification of %s/%s should have failed", test.Username, test.Password)
+		}
+	}
+
+	//Check changes to the file are picked up
+	h, _ = bcrypt.GenerateFromPassword([]byte("newpassword"), bcrypt.MinCost)
+	ioutil.WriteFile(f.Name(), []byte(fmt.Sprintf("validuser:%s\n", h)), 0600)
+	os.Chtimes(f.Name(), time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	assert.NoError(t, v.Verify(nil, "testdom", "validuser", "newpassword"), "Updated htpasswd file not used")
+	assert.Error(t, v.Verify(nil, "testdom", "validuser", "validpassword"), "Old password should no longer be valid")
+
+	v = &Htpasswd{Path: f.Name() + "missing"}
+	assert.Error(t, v.Verify(nil, "testdom", "validuser", "validpassword"), "Should error when the htpasswd file does not exist")
+}
+
+func TestDelegated_Verify(t *testing.T) {
+	var tests = []struct {
+		Description string
+		Application *config.ApplicationConf
+		Valid       bool
+	}{
+		{"Application permitted for issuer", &config.ApplicationConf{Issuers: []string{"delegatedapp"}}, true},
+		{"Application permitted for all issuers", &config.ApplicationConf{Issuers: []string{"*"}}, true},
+		{"Application not permitted for issuer", &config.ApplicationConf{Issuers: []string{"otherapp"}}, false},
+		{"No authenticated application", nil, false},
+	}
+	for _, test := range tests {
+		v := &Delegated{Issuer: "delegatedapp", Application: test.Application}
+		r, _ := http.NewRequest("POST", "/validate", nil)
+		err := v.Verify(r, "testdom", "validuser", "")
+		if test.Valid && err != nil {
+			t.Errorf("%s: delegated verification should have passed: %v", test.Description, err)
+		}
+		if !test.Valid && err == nil {
+			t.Errorf("%s: delegated verification should have failed", test.Description)
+		}
+	}
+}
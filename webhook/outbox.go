@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// outbox persists the deliveries as a file of JSON lines. Each change to a delivery appends its new state, and the
+// last line for a delivery is its current state. The file is rewritten with only the current states when it grows.
+type outbox struct {
+	path  string
+	f     *os.File
+	lines int
+}
+
+// openOutbox opens the outbox file at the path, creating it if it does not exist, and returns the deliveries in it.
+// If the path is empty the deliveries are only held in memory.
+func openOutbox(path string) (*outbox, map[string]*Delivery, error) {
+	deliveries := make(map[string]*Delivery)
+	o := &outbox{path: path}
+	if path == "" {
+		return o, deliveries, nil
+	}
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, errors.New("Could not open webhook outbox: " + err.Error())
+	}
+	if err == nil {
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64*1024), 1024*1024)
+		for s.Scan() {
+			var dl Delivery
+			//A line partly written when the MFA server stopped is ignored
+			if err := json.Unmarshal(s.Bytes(), &dl); err != nil || dl.ID == "" {
+				continue
+			}
+			deliveries[dl.ID] = &dl
+		}
+		if err := s.Err(); err != nil {
+			return nil, nil, errors.New("Could not read webhook outbox: " + err.Error())
+		}
+	}
+	return o, deliveries, nil
+}
+
+func (o *outbox) append(dl *Delivery) error {
+	if o.f == nil {
+		return nil
+	}
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	if _, err := o.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	o.lines++
+	return o.f.Sync()
+}
+
+// rewrite replaces the outbox file with the current state of the deliveries. The new file is written alongside and
+// renamed over the old one so that the outbox is not lost if the MFA server stops part way through.
+func (o *outbox) rewrite(deliveries map[string]*Delivery) error {
+	if o.path == "" {
+		return nil
+	}
+	l := make([]*Delivery, 0, len(deliveries))
+	for _, dl := range deliveries {
+		l = append(l, dl)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Created.Before(l[j].Created) })
+	tmp, err := os.OpenFile(filepath.Join(filepath.Dir(o.path), "."+filepath.Base(o.path)+".tmp"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, dl := range l {
+		b, err := json.Marshal(dl)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return err
+	}
+	if o.f != nil {
+		o.f.Close()
+	}
+	o.f, err = os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	o.lines = len(l)
+	return nil
+}
+
+func (o *outbox) close() error {
+	if o.f == nil {
+		return nil
+	}
+	err := o.f.Close()
+	o.f = nil
+	return err
+}
@@ -0,0 +1,538 @@
+// Package webhook notifies other systems of MFA lifecycle events by POSTing signed JSON payloads to the URLs they
+// subscribe with. Deliveries are kept in a persistent outbox until they succeed, so that events survive restarts, and
+// are retried with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/mfaserver/version"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Types of lifecycle event
+const (
+	EventEnrolled = "mfa.enrolled"
+	EventRotated  = "mfa.rotated"
+	EventDeleted  = "mfa.deleted"
+)
+
+// EventTypes are the types of lifecycle event that can be subscribed to.
+var EventTypes = []string{EventEnrolled, EventRotated, EventDeleted}
+
+// Status of a delivery
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Headers of the webhook requests
+const (
+	HeaderEvent     = "X-MFAServer-Event"
+	HeaderDelivery  = "X-MFAServer-Delivery"
+	HeaderTimestamp = "X-MFAServer-Timestamp"
+	HeaderSignature = "X-MFAServer-Signature"
+)
+
+// Default number of attempts before a delivery fails
+const DefaultMaxAttempts = 10
+
+const (
+	// Completed deliveries kept in the delivery log
+	logSize = 1000
+	// Time allowed for a subscriber to respond
+	requestTimeout = 10 * time.Second
+	// Response body read for the delivery log
+	maxResponseBody = 256
+)
+
+// Delay before the first retry, which doubles with each attempt up to the maximum
+var (
+	initialBackoff = 30 * time.Second
+	maxBackoff     = time.Hour
+)
+
+// Event is the JSON payload of a webhook request. Admin is set if an administrator acted on the user.
+type Event struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	Time        string `json:"time"`
+	Issuer      string `json:"issuer"`
+	Domain      string `json:"domain"`
+	Username    string `json:"username"`
+	Admin       string `json:"admin,omitempty"`
+	Application string `json:"application,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+}
+
+// NewEvent returns an event of the type, with a new ID, that occurred now.
+func NewEvent(eventType string) Event {
+	return Event{
+		ID:   newID(),
+		Type: eventType,
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// Subscription is a URL that events are delivered to. If no event types are listed all events are delivered.
+type Subscription struct {
+	Name   string
+	URL    string
+	Secret []byte
+	Events []string
+}
+
+func (s *Subscription) wants(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, t := range s.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is the delivery of an event to a subscription, as recorded in the outbox and delivery log.
+type Delivery struct {
+	ID           string    `json:"id"`
+	Subscription string    `json:"subscription"`
+	Event        Event     `json:"event"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	Created      time.Time `json:"created"`
+	NextAttempt  time.Time `json:"next_attempt"`
+	LastAttempt  time.Time `json:"last_attempt"`
+	ResponseCode int       `json:"response_code,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// Filter selects deliveries from the delivery log. Empty values match all deliveries.
+type Filter struct {
+	Subscription string
+	Status       string
+	Domain       string
+	// If set only the deliveries of events for the issuers it accepts are selected
+	Issuer func(string) bool
+	Limit  int
+}
+
+// Dispatcher delivers events to the subscriptions in the background.
+type Dispatcher struct {
+	subs        []Subscription
+	maxAttempts int
+	client      *http.Client
+	mux         sync.Mutex
+	outbox      *outbox
+	deliveries  map[string]*Delivery
+	wake        chan struct{}
+	stop        chan struct{}
+	done        chan struct{}
+	ctx         context.Context
+	cancel      context.CancelFunc
+	closeOnce   sync.Once
+	// Reports errors delivering events and writing the outbox
+	OnError func(error)
+}
+
+// New creates a dispatcher for the subscriptions. Deliveries are recorded in the outbox file at the path, and those
+// still pending in it are resumed. A delivery fails once it has been attempted maxAttempts times.
+func New(path string, subs []Subscription, maxAttempts int, client *http.Client) (*Dispatcher, error) {
+	names, err := subscriptionNames(subs)
+	if err != nil {
+		return nil, err
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	if client == nil {
+		client = &http.Client{Timeout: requestTimeout}
+	}
+	o, deliveries, err := openOutbox(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, dl := range deliveries {
+		if dl.Status == StatusPending && !names[dl.Subscription] {
+			unsubscribed(dl)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		subs:        subs,
+		maxAttempts: maxAttempts,
+		client:      client,
+		outbox:      o,
+		deliveries:  deliveries,
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	if err := d.compact(); err != nil {
+		o.close()
+		return nil, err
+	}
+	go d.run()
+	return d, nil
+}
+
+// SetSubscriptions replaces the subscriptions events are delivered to and the number of attempts made. Deliveries
+// pending for subscriptions that are no longer configured fail. This allows the subscriptions to change while the
+// dispatcher keeps using its outbox.
+func (d *Dispatcher) SetSubscriptions(subs []Subscription, maxAttempts int) error {
+	names, err := subscriptionNames(subs)
+	if err != nil {
+		return err
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.outbox == nil {
+		return errors.New("Webhook dispatcher is closed")
+	}
+	d.subs = subs
+	d.maxAttempts = maxAttempts
+	for _, dl := range d.deliveries {
+		if dl.Status == StatusPending && !names[dl.Subscription] {
+			unsubscribed(dl)
+			if werr := d.record(dl); werr != nil {
+				err = werr
+			}
+		}
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// Send records a delivery of the event in the outbox for each subscription to its type. It returns once the
+// deliveries are recorded, the events are delivered in the background.
+func (d *Dispatcher) Send(e Event) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.outbox == nil {
+		return errors.New("Webhook dispatcher is closed, event not sent")
+	}
+	now := time.Now()
+	var err error
+	queued := false
+	for _, s := range d.subs {
+		if !s.wants(e.Type) {
+			continue
+		}
+		dl := &Delivery{
+			ID:           newID(),
+			Subscription: s.Name,
+			Event:        e,
+			Status:       StatusPending,
+			Created:      now,
+			NextAttempt:  now,
+		}
+		d.deliveries[dl.ID] = dl
+		queued = true
+		if werr := d.record(dl); werr != nil {
+			err = werr
+		}
+	}
+	if queued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+// Deliveries returns the deliveries in the delivery log that match the filter, the most recent first.
+func (d *Dispatcher) Deliveries(f Filter) []Delivery {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	var l []Delivery
+	for _, dl := range d.deliveries {
+		if (f.Subscription == "" || dl.Subscription == f.Subscription) && (f.Status == "" || dl.Status == f.Status) &&
+			(f.Domain == "" || dl.Event.Domain == f.Domain) && (f.Issuer == nil || f.Issuer(dl.Event.Issuer)) {
+			l = append(l, *dl)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Created.Equal(l[j].Created) {
+			return l[i].ID > l[j].ID
+		}
+		return l[i].Created.After(l[j].Created)
+	})
+	if f.Limit > 0 && len(l) > f.Limit {
+		l = l[:f.Limit]
+	}
+	return l
+}
+
+// Close stops delivering events, abandoning attempts in progress, and closes the outbox. Deliveries still pending are
+// resumed when the outbox is next opened.
+func (d *Dispatcher) Close() error {
+	var err error
+	d.closeOnce.Do(func() {
+		close(d.stop)
+		d.cancel()
+		<-d.done
+		d.mux.Lock()
+		defer d.mux.Unlock()
+		err = d.outbox.close()
+		d.outbox = nil
+	})
+	return err
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for {
+		due, wait := d.due(time.Now())
+		var wg sync.WaitGroup
+		for _, dl := range due {
+			wg.Add(1)
+			go func(dl Delivery) {
+				defer wg.Done()
+				d.attempt(dl)
+			}(dl)
+		}
+		wg.Wait()
+		if d.ctx.Err() != nil {
+			return
+		}
+		if len(due) > 0 {
+			continue
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-d.stop:
+			t.Stop()
+			return
+		case <-d.wake:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+// due returns the pending deliveries whose next attempt is due and how long until the next one is due.
+func (d *Dispatcher) due(now time.Time) ([]Delivery, time.Duration) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	var due []Delivery
+	wait := maxBackoff
+	for _, dl := range d.deliveries {
+		if dl.Status != StatusPending {
+			continue
+		}
+		if w := dl.NextAttempt.Sub(now); w > 0 {
+			if w < wait {
+				wait = w
+			}
+			continue
+		}
+		due = append(due, *dl)
+	}
+	return due, wait
+}
+
+// attempt POSTs the event to the subscription and records the outcome.
+func (d *Dispatcher) attempt(dl Delivery) {
+	var s Subscription
+	d.mux.Lock()
+	for _, sub := range d.subs {
+		if sub.Name == dl.Subscription {
+			s = sub
+		}
+	}
+	d.mux.Unlock()
+	if s.Name == "" {
+		//The subscription was removed and the delivery failed since it became due
+		return
+	}
+	code, err := d.post(s, dl)
+	if d.ctx.Err() != nil {
+		//Abandoned on shutdown, the attempt is made again when the outbox is next opened
+		return
+	}
+	now := time.Now()
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	cur, ok := d.deliveries[dl.ID]
+	if !ok || cur.Status != StatusPending {
+		return
+	}
+	cur.Attempts++
+	cur.LastAttempt = now
+	cur.ResponseCode = code
+	cur.LastError = ""
+	switch {
+	case err == nil:
+		cur.Status = StatusDelivered
+		cur.NextAttempt = time.Time{}
+	case cur.Attempts >= d.maxAttempts:
+		cur.LastError = err.Error()
+		cur.Status = StatusFailed
+		cur.NextAttempt = time.Time{}
+		d.error(errors.New(fmt.Sprintf("Webhook delivery %s of %s event %s to %s failed after %d attempts: %v", cur.ID, cur.Event.Type, cur.Event.ID, cur.Subscription, cur.Attempts, err)))
+	default:
+		cur.LastError = err.Error()
+		cur.NextAttempt = now.Add(backoff(cur.Attempts))
+	}
+	if err := d.record(cur); err != nil {
+		d.error(err)
+	}
+}
+
+func (d *Dispatcher) post(s Subscription, dl Delivery) (int, error) {
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(d.ctx)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("User-Agent", "mfaserver/"+version.Version)
+	req.Header.Set(HeaderEvent, dl.Event.Type)
+	req.Header.Set(HeaderDelivery, dl.ID)
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, Sign(s.Secret, ts, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New(fmt.Sprintf("Subscriber responded with %s: %s", resp.Status, string(b)))
+	}
+	return resp.StatusCode, nil
+}
+
+// record appends the state of the delivery to the outbox, compacting it when it has grown. The lock must be held.
+func (d *Dispatcher) record(dl *Delivery) error {
+	if err := d.outbox.append(dl); err != nil {
+		return errors.New("Could not write webhook delivery " + dl.ID + " to the outbox: " + err.Error())
+	}
+	if d.outbox.lines > 4*(logSize+len(d.deliveries)) {
+		return d.compact()
+	}
+	return nil
+}
+
+// compact removes all but the most recent completed deliveries from the delivery log and rewrites the outbox with
+// the current state of the deliveries kept. The lock must be held.
+func (d *Dispatcher) compact() error {
+	var completed []*Delivery
+	for _, dl := range d.deliveries {
+		if dl.Status != StatusPending {
+			completed = append(completed, dl)
+		}
+	}
+	if len(completed) > logSize {
+		sort.Slice(completed, func(i, j int) bool { return completed[i].Created.After(completed[j].Created) })
+		for _, dl := range completed[logSize:] {
+			delete(d.deliveries, dl.ID)
+		}
+	}
+	if err := d.outbox.rewrite(d.deliveries); err != nil {
+		return errors.New("Could not compact the webhook outbox: " + err.Error())
+	}
+	return nil
+}
+
+func (d *Dispatcher) error(err error) {
+	if d.OnError != nil {
+		d.OnError(err)
+	}
+}
+
+// subscriptionNames checks each subscription has a unique name and a URL and returns the set of names.
+func subscriptionNames(subs []Subscription) (map[string]bool, error) {
+	names := make(map[string]bool)
+	for _, s := range subs {
+		if s.Name == "" || s.URL == "" {
+			return nil, errors.New("Subscriptions must have a Name and URL")
+		}
+		if names[s.Name] {
+			return nil, errors.New("Subscription name " + s.Name + " is not unique")
+		}
+		names[s.Name] = true
+	}
+	return names, nil
+}
+
+// unsubscribed fails a pending delivery to a subscription that is no longer configured.
+func unsubscribed(dl *Delivery) {
+	dl.Status = StatusFailed
+	dl.LastError = "Subscription is no longer configured"
+	dl.NextAttempt = time.Time{}
+	dl.LastAttempt = time.Now()
+}
+
+// backoff returns the delay before the attempt after the number of attempts made.
+func backoff(attempts int) time.Duration {
+	b := initialBackoff
+	for i := 1; i < attempts && b < maxBackoff; i++ {
+		b *= 2
+	}
+	if b > maxBackoff {
+		b = maxBackoff
+	}
+	return b
+}
+
+// Sign returns the value of the signature header for the body sent at the timestamp. It is the hex encoded
+// HMAC-SHA256, keyed with the subscription's secret, of the timestamp, a full stop and the body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(timestamp + "."))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// Verify checks the signature of a webhook request's body and that its timestamp is within the tolerance of now, so
+// that it cannot be replayed later.
+func Verify(secret []byte, timestamp, signature string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("Timestamp not valid")
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return errors.New("Timestamp outside the tolerance")
+	}
+	if !hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature)) {
+		return errors.New("Signature not valid")
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
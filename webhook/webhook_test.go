@@ -0,0 +1,227 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef"
+
+// receiver is a subscriber that responds with the codes in turn, then 200, and records the events it accepts.
+type receiver struct {
+	mux    sync.Mutex
+	codes  []int
+	events []Event
+	errors []string
+}
+
+func (rv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rv.mux.Lock()
+	defer rv.mux.Unlock()
+	b, _ := ioutil.ReadAll(r.Body)
+	if err := Verify([]byte(testSecret), r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), b, time.Minute); err != nil {
+		rv.errors = append(rv.errors, err.Error())
+	}
+	if len(rv.codes) > 0 {
+		code := rv.codes[0]
+		rv.codes = rv.codes[1:]
+		w.WriteHeader(code)
+		return
+	}
+	var e Event
+	json.Unmarshal(b, &e)
+	if r.Header.Get(HeaderEvent) != e.Type || r.Header.Get(HeaderDelivery) == "" {
+		rv.errors = append(rv.errors, "Event or delivery header not as expected")
+	}
+	rv.events = append(rv.events, e)
+}
+
+func (rv *receiver) received() int {
+	rv.mux.Lock()
+	defer rv.mux.Unlock()
+	return len(rv.events)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for webhook deliveries")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testEvent(eventType, username string) Event {
+	e := NewEvent(eventType)
+	e.Issuer = "testapp"
+	e.Domain = "test.com"
+	e.Username = username
+	return e
+}
+
+func TestDispatcher(t *testing.T) {
+	initialBackoff = 10 * time.Millisecond
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-webhook")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "outbox")
+
+	all := &receiver{codes: []int{http.StatusInternalServerError, http.StatusServiceUnavailable}}
+	deletes := &receiver{}
+	s1 := httptest.NewServer(all)
+	defer s1.Close()
+	s2 := httptest.NewServer(deletes)
+	defer s2.Close()
+	subs := []Subscription{
+		{Name: "all", URL: s1.URL, Secret: []byte(testSecret)},
+		{Name: "deletes", URL: s2.URL, Secret: []byte(testSecret), Events: []string{EventDeleted}},
+	}
+	d, err := New(path, subs, 3, nil)
+	if err != nil {
+		t.Fatalf("Error creating dispatcher: %v", err)
+	}
+	d.Send(testEvent(EventEnrolled, "user1"))
+	d.Send(testEvent(EventDeleted, "user2"))
+	waitFor(t, func() bool { return all.received() == 2 && deletes.received() == 1 })
+	assert.Empty(t, all.errors, "Webhook requests not as expected")
+	assert.Empty(t, deletes.errors, "Webhook requests not as expected")
+	assert.Equal(t, "user2", deletes.events[0].Username, "Event delivered not as expected")
+
+	//Two attempts to "all" failed before being retried
+	l := d.Deliveries(Filter{Subscription: "all"})
+	assert.Len(t, l, 2, "Deliveries to the subscription not as expected")
+	assert.Equal(t, StatusDelivered, l[0].Status, "Status of delivery not as expected")
+	assert.Equal(t, StatusDelivered, l[1].Status, "Status of delivery not as expected")
+	assert.Equal(t, 4, l[0].Attempts+l[1].Attempts, "Attempts not as expected")
+	assert.Len(t, d.Deliveries(Filter{}), 3, "Deliveries not as expected")
+	assert.Len(t, d.Deliveries(Filter{Limit: 1}), 1, "Deliveries limit not applied")
+	assert.Len(t, d.Deliveries(Filter{Domain: "other.com"}), 0, "Deliveries domain filter not applied")
+	assert.Len(t, d.Deliveries(Filter{Issuer: func(i string) bool { return i == "otherapp" }}), 0, "Deliveries issuer filter not applied")
+	assert.Len(t, d.Deliveries(Filter{Issuer: func(i string) bool { return i == "testapp" }}), 3, "Deliveries issuer filter not applied")
+
+	//Deliveries fail after the maximum attempts
+	all.mux.Lock()
+	all.codes = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	all.mux.Unlock()
+	d.Send(testEvent(EventRotated, "user3"))
+	waitFor(t, func() bool { return len(d.Deliveries(Filter{Status: StatusFailed})) == 1 })
+	f := d.Deliveries(Filter{Status: StatusFailed})[0]
+	assert.Equal(t, 3, f.Attempts, "Attempts of failed delivery not as expected")
+	assert.Equal(t, http.StatusBadGateway, f.ResponseCode, "Response code of failed delivery not as expected")
+	assert.Contains(t, f.LastError, "502", "Error of failed delivery not as expected")
+	if err := d.Close(); err != nil {
+		t.Fatalf("Error closing dispatcher: %v", err)
+	}
+	assert.Error(t, d.Send(testEvent(EventEnrolled, "user4")), "Send to a closed dispatcher did not return an error")
+
+	//The delivery log survives a restart
+	d, err = New(path, subs, 3, nil)
+	if err != nil {
+		t.Fatalf("Error reopening dispatcher: %v", err)
+	}
+	assert.Len(t, d.Deliveries(Filter{}), 4, "Deliveries not as expected after reopening")
+	d.Close()
+}
+
+func TestDispatcher_Outbox(t *testing.T) {
+	initialBackoff = 10 * time.Millisecond
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-webhook")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "outbox")
+
+	//Events sent while the subscriber is unavailable are delivered once the MFA server restarts
+	subs := []Subscription{
+		{Name: "down", URL: "http://127.0.0.1:1", Secret: []byte(testSecret)},
+		{Name: "removed", URL: "http://127.0.0.1:1", Secret: []byte(testSecret)},
+	}
+	d, err := New(path, subs, 1000, nil)
+	if err != nil {
+		t.Fatalf("Error creating dispatcher: %v", err)
+	}
+	d.Send(testEvent(EventEnrolled, "user1"))
+	waitFor(t, func() bool { return d.Deliveries(Filter{Subscription: "down"})[0].Attempts > 0 })
+	d.Close()
+	//A partly written line is ignored
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"id":"abc`)
+	f.Close()
+
+	rv := &receiver{}
+	s := httptest.NewServer(rv)
+	defer s.Close()
+	d, err = New(path, []Subscription{{Name: "down", URL: s.URL, Secret: []byte(testSecret)}}, 1000, nil)
+	if err != nil {
+		t.Fatalf("Error reopening dispatcher: %v", err)
+	}
+	defer d.Close()
+	waitFor(t, func() bool { return rv.received() == 1 })
+	assert.Equal(t, "user1", rv.events[0].Username, "Event delivered after restart not as expected")
+	r := d.Deliveries(Filter{Subscription: "removed"})
+	assert.Len(t, r, 1, "Deliveries to removed subscription not as expected")
+	assert.Equal(t, StatusFailed, r[0].Status, "Delivery to removed subscription not failed")
+}
+
+func TestDispatcher_SetSubscriptions(t *testing.T) {
+	initialBackoff = 10 * time.Millisecond
+	dir, _ := ioutil.TempDir(os.TempDir(), "mfaserver-webhook")
+	defer os.RemoveAll(dir)
+	d, err := New(filepath.Join(dir, "outbox"), []Subscription{{Name: "removed", URL: "http://127.0.0.1:1", Secret: []byte(testSecret)}}, 1000, nil)
+	if err != nil {
+		t.Fatalf("Error creating dispatcher: %v", err)
+	}
+	defer d.Close()
+	d.Send(testEvent(EventEnrolled, "user1"))
+
+	rv := &receiver{}
+	s := httptest.NewServer(rv)
+	defer s.Close()
+	assert.Error(t, d.SetSubscriptions([]Subscription{{Name: "added"}}, 0), "Subscription without a URL accepted")
+	if err := d.SetSubscriptions([]Subscription{{Name: "added", URL: s.URL, Secret: []byte(testSecret)}}, 0); err != nil {
+		t.Fatalf("Error setting subscriptions: %v", err)
+	}
+	d.Send(testEvent(EventDeleted, "user2"))
+	waitFor(t, func() bool { return rv.received() == 1 })
+	assert.Equal(t, "user2", rv.events[0].Username, "Event delivered to the added subscription not as expected")
+	r := d.Deliveries(Filter{Subscription: "removed"})
+	assert.Len(t, r, 1, "Deliveries to removed subscription not as expected")
+	assert.Equal(t, StatusFailed, r[0].Status, "Delivery to removed subscription not failed")
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	var tests = []struct {
+		secret    string
+		timestamp string
+		signature string
+		body      []byte
+		valid     bool
+	}{
+		{testSecret, now, Sign([]byte(testSecret), now, body), body, true},
+		{"another secret!!", now, Sign([]byte(testSecret), now, body), body, false},
+		{testSecret, now, Sign([]byte(testSecret), now, body), []byte(`{"id":"2"}`), false},
+		{testSecret, old, Sign([]byte(testSecret), old, body), body, false},
+		{testSecret, "x", Sign([]byte(testSecret), "x", body), body, false},
+	}
+	for i, test := range tests {
+		err := Verify([]byte(test.secret), test.timestamp, test.signature, test.body, 5*time.Minute)
+		assert.Equal(t, test.valid, err == nil, "Verification not as expected for test %d: %v", i, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	initialBackoff = 30 * time.Second
+	assert.Equal(t, 30*time.Second, backoff(1), "Backoff after the first attempt not as expected")
+	assert.Equal(t, 2*time.Minute, backoff(3), "Backoff after the third attempt not as expected")
+	assert.Equal(t, time.Hour, backoff(20), "Backoff not limited to the maximum")
+}